@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/stut/s3dir/internal/config"
+	"github.com/stut/s3dir/internal/tlscert"
+	"github.com/stut/s3dir/pkg/accesslog"
 	"github.com/stut/s3dir/pkg/auth"
+	"github.com/stut/s3dir/pkg/metrics"
 	"github.com/stut/s3dir/pkg/s3"
 	"github.com/stut/s3dir/pkg/storage"
 )
@@ -31,8 +37,25 @@ func main() {
 	fmt.Printf("Version: %s\n", version)
 	fmt.Printf("Data Directory: %s\n", cfg.DataDir)
 	fmt.Printf("Listen Address: %s\n", cfg.Address())
+	if cfg.MetricsPort != 0 {
+		fmt.Printf("Metrics Address: %s\n", cfg.MetricsAddress())
+	}
+	if len(cfg.Domains) > 0 {
+		fmt.Printf("Virtual-Hosted Domains: %s\n", strings.Join(cfg.Domains, ", "))
+	}
+	fmt.Printf("TLS: %v\n", cfg.TLSEnabled())
+	if cfg.TLSEnabled() {
+		fmt.Printf("TLS Certificate: %s\n", cfg.TLSCertFile)
+	}
 	fmt.Printf("Authentication: %v\n", cfg.EnableAuth)
+	if cfg.CredentialsFile != "" {
+		fmt.Printf("Credentials File: %s\n", cfg.CredentialsFile)
+	}
 	fmt.Printf("Read-Only Mode: %v\n", cfg.ReadOnly)
+	fmt.Printf("Shutdown Timeout: %s\n", cfg.ShutdownTimeout)
+	if cfg.AccessLog != "" {
+		fmt.Printf("Access Log: %s (%s)\n", cfg.AccessLog, cfg.AccessLogFormat)
+	}
 	fmt.Printf("Verbose Logging: %v\n", cfg.Verbose)
 	fmt.Printf("========================================\n\n")
 
@@ -42,11 +65,22 @@ func main() {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 
+	// Apply bucket lifecycle rules in the background. Read-only servers
+	// never delete data
+	if !cfg.ReadOnly {
+		store.StartLifecycleWorker(time.Hour)
+	}
+
 	// Initialize S3 handler
 	handler := s3.NewHandler(store, cfg.ReadOnly, cfg.Verbose)
+	handler.SetDomains(cfg.Domains)
+	handler.SetLegacyBucketNames(cfg.LegacyBucketNames)
 
 	// Initialize authenticator
-	authenticator := auth.New(cfg.AccessKeyID, cfg.SecretAccessKey, cfg.EnableAuth)
+	authenticator, err := newAuthenticator(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load credentials: %v", err)
+	}
 
 	// Setup HTTP server with middleware
 	var httpHandler http.Handler = handler
@@ -54,8 +88,46 @@ func main() {
 		httpHandler = authenticator.Middleware(httpHandler)
 	}
 
-	// Add CORS headers for browser compatibility
-	httpHandler = corsMiddleware(httpHandler)
+	// Apply bucket CORS rules. Preflight requests carry no credentials, so
+	// this must run before authentication
+	httpHandler = handler.CORSMiddleware(httpHandler)
+
+	// Log every request, including rejected ones, and deliver the logs of
+	// buckets with logging enabled to their target buckets. Read-only
+	// servers never write them
+	accessLogger, accessLogFile, err := newAccessLogger(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open access log: %v", err)
+	}
+	deliverLogs := !cfg.ReadOnly && cfg.LogDeliveryInterval > 0
+	if deliverLogs {
+		handler.StartLogDelivery(cfg.LogDeliveryInterval)
+	}
+	if accessLogger != nil || deliverLogs {
+		httpHandler = handler.AccessLogMiddleware(accessLogger, httpHandler)
+	}
+
+	// Record metrics for every request, including rejected ones, and serve
+	// them on the admin port
+	var metricsServer *http.Server
+	if cfg.MetricsPort != 0 {
+		registry := newMetricsRegistry(store)
+		httpHandler = handler.MetricsMiddleware(s3.NewMetrics(registry), httpHandler)
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry)
+		metricsServer = &http.Server{
+			Addr:              cfg.MetricsAddress(),
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			fmt.Printf("Metrics available on %s/metrics\n", cfg.MetricsAddress())
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Metrics server failed: %v", err)
+			}
+		}()
+	}
 
 	// Create server with optimized settings for large file uploads
 	// Note: For very large files (>1GB), clients should use multipart uploads
@@ -69,44 +141,181 @@ func main() {
 		ReadTimeout:       0, // No read timeout for large uploads
 	}
 
+	// Serve TLS, over HTTP/2 where clients support it, with a certificate
+	// reloaded when its files change or on SIGHUP
+	var certs *tlscert.Reloader
+	if cfg.TLSEnabled() {
+		certs, err = newCertReloader(cfg)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		certs.StartWatching(certWatchInterval)
+		server.TLSConfig = certs.TLSConfig()
+	}
+
 	// Setup graceful shutdown
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
 	// Start server in a goroutine
 	go func() {
 		fmt.Printf("Server starting on %s\n", cfg.Address())
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if certs != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed: %v", err)
 		}
 	}()
 
-	// Wait for shutdown signal
-	<-shutdown
-	fmt.Println("\nShutting down server...")
+	// Wait for shutdown signal, reloading the certificate on SIGHUP
+	for waiting := true; waiting; {
+		select {
+		case <-reload:
+			if certs == nil {
+				continue
+			}
+			if err := certs.Reload(); err != nil {
+				log.Printf("TLS: keeping previous certificate: %v", err)
+			} else {
+				log.Printf("TLS: reloaded certificate from %s", cfg.TLSCertFile)
+			}
+		case <-shutdown:
+			waiting = false
+		}
+	}
+	fmt.Printf("\nShutting down server, waiting up to %s for in-flight requests...\n", cfg.ShutdownTimeout)
+
+	// Graceful shutdown: stop accepting connections, refuse new requests on
+	// open ones and let in-flight requests such as uploads finish. A second
+	// signal stops waiting
+	handler.Drain()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Drain incomplete, closing remaining connections: %v", err)
+		server.Close()
+	}
+
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
 
-	// Graceful shutdown
-	if err := server.Close(); err != nil {
-		log.Printf("Error during shutdown: %v", err)
+	// Deliver the bucket logs still buffered
+	handler.StopLogDelivery()
+	if accessLogFile != nil {
+		accessLogFile.Close()
+	}
+
+	// Stop storage once no requests are using it
+	if err := store.Shutdown(); err != nil {
+		log.Printf("Error during storage shutdown: %v", err)
+	}
+	if certs != nil {
+		certs.Stop()
 	}
 
 	fmt.Println("Server stopped")
 }
 
-// corsMiddleware adds CORS headers to responses
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, POST, DELETE, HEAD, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Content-Length, X-Amz-Date, X-Amz-Content-Sha256")
-		w.Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified")
-
-		// Handle preflight requests
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
+// newMetricsRegistry creates the registry for the request metrics, with
+// storage metrics already registered
+func newMetricsRegistry(store *storage.Storage) *metrics.Registry {
+	registry := metrics.NewRegistry()
+	multipart := store.Multipart()
+	registry.NewGaugeFunc("s3dir_multipart_uploads_active",
+		"Multipart uploads in progress.",
+		func() float64 { return float64(multipart.ActiveUploads()) })
+	registry.NewCounterFunc("s3dir_multipart_stale_uploads_removed_total",
+		"Multipart uploads removed by the stale upload cleanup.",
+		func() float64 { return float64(multipart.StaleUploadsRemoved()) })
+	return registry
+}
+
+// newAccessLogger opens the configured access log, which is nil when access
+// logging is disabled, along with the file to close on shutdown, if any
+func newAccessLogger(cfg *config.Config) (*accesslog.Logger, io.Closer, error) {
+	if cfg.AccessLog == "" {
+		return nil, nil, nil
+	}
+
+	format, err := accesslog.ParseFormat(cfg.AccessLogFormat)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.AccessLog == "stdout" {
+		return accesslog.New(os.Stdout, format), nil, nil
+	}
+
+	file, err := accesslog.OpenRotatingFile(cfg.AccessLog, int64(cfg.AccessLogMaxSizeMB)<<20, cfg.AccessLogMaxBackups)
+	if err != nil {
+		return nil, nil, err
+	}
+	return accesslog.New(file, format), file, nil
+}
+
+// certWatchInterval is how often the TLS certificate files are checked for
+// changes
+const certWatchInterval = 10 * time.Second
+
+// newCertReloader loads the TLS certificate, first generating a self-signed
+// one if configured to and none exists yet. Generated certificates cover
+// localhost, the bind address and the virtual-hosted domains
+func newCertReloader(cfg *config.Config) (*tlscert.Reloader, error) {
+	if cfg.TLSSelfSigned {
+		var hosts []string
+		if cfg.Host != "0.0.0.0" && cfg.Host != "::" && cfg.Host != "" {
+			hosts = append(hosts, cfg.Host)
 		}
+		if hostname, err := os.Hostname(); err == nil {
+			hosts = append(hosts, hostname)
+		}
+		for _, domain := range cfg.Domains {
+			hosts = append(hosts, domain, "*."+domain)
+		}
+
+		generated, err := tlscert.EnsureSelfSigned(cfg.TLSCertFile, cfg.TLSKeyFile, hosts)
+		if err != nil {
+			return nil, err
+		}
+		if generated {
+			fmt.Printf("Generated self-signed TLS certificate: %s\n", cfg.TLSCertFile)
+		}
+	}
+
+	return tlscert.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+}
+
+// newAuthenticator builds the authenticator from the credentials file, if
+// any, plus the single key pair from the environment, which is granted full
+// access
+func newAuthenticator(cfg *config.Config) (*auth.Authenticator, error) {
+	store, err := auth.NewCredentialStore()
+	if cfg.CredentialsFile != "" {
+		store, err = auth.LoadCredentials(cfg.CredentialsFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.AccessKeyID != "" {
+		if err := store.Add(&auth.Credential{AccessKeyID: cfg.AccessKeyID, SecretAccessKey: cfg.SecretAccessKey}); err != nil {
+			return nil, err
+		}
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	return auth.NewWithCredentials(store, cfg.EnableAuth), nil
 }
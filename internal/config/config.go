@@ -3,7 +3,10 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds the application configuration
@@ -12,14 +15,46 @@ type Config struct {
 	Host string
 	Port int
 
+	// MetricsPort is the admin port serving /metrics; 0 disables it
+	MetricsPort int
+
+	// Domains are the base domains for virtual-hosted-style requests
+	Domains []string
+
 	// Storage configuration
 	DataDir string
 
 	// Authentication configuration
 	AccessKeyID     string
 	SecretAccessKey string
+	CredentialsFile string
 	EnableAuth      bool
 
+	// TLS configuration. With TLSSelfSigned, a certificate is generated at
+	// the configured paths if none exists there
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSSelfSigned bool
+
+	// ShutdownTimeout is how long in-flight requests may take to finish on
+	// shutdown before their connections are closed
+	ShutdownTimeout time.Duration
+
+	// Access logging. AccessLog is "stdout", a file path or empty to disable
+	// it, and the file is rotated once it reaches AccessLogMaxSizeMB
+	AccessLog           string
+	AccessLogFormat     string
+	AccessLogMaxSizeMB  int
+	AccessLogMaxBackups int
+
+	// LogDeliveryInterval is how often access logs are delivered to the
+	// target buckets of buckets with logging enabled; 0 disables delivery
+	LogDeliveryInterval time.Duration
+
+	// LegacyBucketNames allows new buckets to use the relaxed legacy S3
+	// naming rules
+	LegacyBucketNames bool
+
 	// Server options
 	ReadOnly bool
 	Verbose  bool
@@ -28,14 +63,34 @@ type Config struct {
 // Load loads configuration from environment variables with defaults
 func Load() (*Config, error) {
 	cfg := &Config{
-		Host:            getEnv("S3DIR_HOST", "0.0.0.0"),
-		Port:            getEnvAsInt("S3DIR_PORT", 8000),
-		DataDir:         getEnv("S3DIR_DATA_DIR", "./data"),
-		AccessKeyID:     getEnv("S3DIR_ACCESS_KEY_ID", ""),
-		SecretAccessKey: getEnv("S3DIR_SECRET_ACCESS_KEY", ""),
-		EnableAuth:      getEnvAsBool("S3DIR_ENABLE_AUTH", false),
-		ReadOnly:        getEnvAsBool("S3DIR_READ_ONLY", false),
-		Verbose:         getEnvAsBool("S3DIR_VERBOSE", false),
+		Host:                getEnv("S3DIR_HOST", "0.0.0.0"),
+		Port:                getEnvAsInt("S3DIR_PORT", 8000),
+		MetricsPort:         getEnvAsInt("S3DIR_METRICS_PORT", 0),
+		Domains:             getEnvAsList("S3DIR_DOMAINS"),
+		DataDir:             getEnv("S3DIR_DATA_DIR", "./data"),
+		AccessKeyID:         getEnv("S3DIR_ACCESS_KEY_ID", ""),
+		SecretAccessKey:     getEnv("S3DIR_SECRET_ACCESS_KEY", ""),
+		CredentialsFile:     getEnv("S3DIR_CREDENTIALS_FILE", ""),
+		EnableAuth:          getEnvAsBool("S3DIR_ENABLE_AUTH", false),
+		TLSCertFile:         getEnv("S3DIR_TLS_CERT_FILE", ""),
+		TLSKeyFile:          getEnv("S3DIR_TLS_KEY_FILE", ""),
+		TLSSelfSigned:       getEnvAsBool("S3DIR_TLS_SELF_SIGNED", false),
+		ShutdownTimeout:     getEnvAsDuration("S3DIR_SHUTDOWN_TIMEOUT", 30*time.Second),
+		AccessLog:           getEnv("S3DIR_ACCESS_LOG", ""),
+		AccessLogFormat:     strings.ToLower(getEnv("S3DIR_ACCESS_LOG_FORMAT", "json")),
+		AccessLogMaxSizeMB:  getEnvAsInt("S3DIR_ACCESS_LOG_MAX_SIZE_MB", 100),
+		AccessLogMaxBackups: getEnvAsInt("S3DIR_ACCESS_LOG_MAX_BACKUPS", 5),
+		LogDeliveryInterval: getEnvAsDuration("S3DIR_LOG_DELIVERY_INTERVAL", 5*time.Minute),
+		LegacyBucketNames:   getEnvAsBool("S3DIR_LEGACY_BUCKET_NAMES", false),
+		ReadOnly:            getEnvAsBool("S3DIR_READ_ONLY", false),
+		Verbose:             getEnvAsBool("S3DIR_VERBOSE", false),
+	}
+
+	// Self-signed certificates live in the data directory unless placed
+	// elsewhere
+	if cfg.TLSSelfSigned && cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		cfg.TLSCertFile = filepath.Join(cfg.DataDir, ".tls", "cert.pem")
+		cfg.TLSKeyFile = filepath.Join(cfg.DataDir, ".tls", "key.pem")
 	}
 
 	// Validate configuration
@@ -52,19 +107,47 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid port: %d", c.Port)
 	}
 
+	if c.MetricsPort < 0 || c.MetricsPort > 65535 {
+		return fmt.Errorf("invalid metrics port: %d", c.MetricsPort)
+	}
+	if c.MetricsPort == c.Port {
+		return fmt.Errorf("metrics port must differ from the server port")
+	}
+
 	if c.DataDir == "" {
 		return fmt.Errorf("data directory cannot be empty")
 	}
 
 	if c.EnableAuth {
-		if c.AccessKeyID == "" {
-			return fmt.Errorf("access key ID is required when authentication is enabled")
-		}
-		if c.SecretAccessKey == "" {
-			return fmt.Errorf("secret access key is required when authentication is enabled")
+		// A credentials file may replace or supplement the single key pair
+		if c.CredentialsFile == "" || c.AccessKeyID != "" || c.SecretAccessKey != "" {
+			if c.AccessKeyID == "" {
+				return fmt.Errorf("access key ID is required when authentication is enabled")
+			}
+			if c.SecretAccessKey == "" {
+				return fmt.Errorf("secret access key is required when authentication is enabled")
+			}
 		}
 	}
 
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("invalid shutdown timeout: %s", c.ShutdownTimeout)
+	}
+
+	if c.AccessLog != "" && c.AccessLogFormat != "json" && c.AccessLogFormat != "aws" {
+		return fmt.Errorf("invalid access log format %q: must be json or aws", c.AccessLogFormat)
+	}
+	if c.AccessLogMaxSizeMB < 0 || c.AccessLogMaxBackups < 0 {
+		return fmt.Errorf("access log rotation limits cannot be negative")
+	}
+	if c.LogDeliveryInterval < 0 {
+		return fmt.Errorf("invalid log delivery interval: %s", c.LogDeliveryInterval)
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("TLS requires both a certificate file and a key file")
+	}
+
 	// Create data directory if it doesn't exist
 	if err := os.MkdirAll(c.DataDir, 0755); err != nil {
 		return fmt.Errorf("failed to create data directory: %w", err)
@@ -73,11 +156,21 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// TLSEnabled reports whether the server listens with TLS
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != ""
+}
+
 // Address returns the server address
 func (c *Config) Address() string {
 	return fmt.Sprintf("%s:%d", c.Host, c.Port)
 }
 
+// MetricsAddress returns the admin server address serving metrics
+func (c *Config) MetricsAddress() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.MetricsPort)
+}
+
 // getEnv reads an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -105,3 +198,26 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+// getEnvAsDuration reads an environment variable as a duration such as "30s"
+// or returns a default value
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+	}
+	return defaultValue
+}
+
+// getEnvAsList reads a comma-separated environment variable, ignoring empty
+// entries
+func getEnvAsList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -34,18 +35,35 @@ func TestLoad(t *testing.T) {
 	if cfg.ReadOnly != false {
 		t.Error("Expected read-only disabled by default")
 	}
+
+	if cfg.ShutdownTimeout != 30*time.Second {
+		t.Errorf("Expected default shutdown timeout 30s, got %v", cfg.ShutdownTimeout)
+	}
+
+	if cfg.AccessLog != "" || cfg.AccessLogFormat != "json" {
+		t.Errorf("Expected access logging disabled with json format by default, got %q %s", cfg.AccessLog, cfg.AccessLogFormat)
+	}
+
+	if cfg.LogDeliveryInterval != 5*time.Minute {
+		t.Errorf("Expected default log delivery interval 5m, got %v", cfg.LogDeliveryInterval)
+	}
 }
 
 func TestLoadWithEnvironment(t *testing.T) {
 	// Set environment variables
 	os.Setenv("S3DIR_HOST", "127.0.0.1")
 	os.Setenv("S3DIR_PORT", "9000")
+	os.Setenv("S3DIR_DOMAINS", "s3.local, s3.example.com")
 	os.Setenv("S3DIR_DATA_DIR", "/tmp/test-data")
 	os.Setenv("S3DIR_ACCESS_KEY_ID", "test-key")
 	os.Setenv("S3DIR_SECRET_ACCESS_KEY", "test-secret")
 	os.Setenv("S3DIR_ENABLE_AUTH", "true")
 	os.Setenv("S3DIR_READ_ONLY", "true")
 	os.Setenv("S3DIR_VERBOSE", "true")
+	os.Setenv("S3DIR_LEGACY_BUCKET_NAMES", "true")
+	os.Setenv("S3DIR_ACCESS_LOG", "/var/log/s3dir/access.log")
+	os.Setenv("S3DIR_ACCESS_LOG_FORMAT", "AWS")
+	os.Setenv("S3DIR_ACCESS_LOG_MAX_SIZE_MB", "10")
 	defer os.Clearenv()
 
 	cfg, err := Load()
@@ -61,6 +79,10 @@ func TestLoadWithEnvironment(t *testing.T) {
 		t.Errorf("Expected port 9000, got %d", cfg.Port)
 	}
 
+	if len(cfg.Domains) != 2 || cfg.Domains[0] != "s3.local" || cfg.Domains[1] != "s3.example.com" {
+		t.Errorf("Expected domains [s3.local s3.example.com], got %v", cfg.Domains)
+	}
+
 	if cfg.DataDir != "/tmp/test-data" {
 		t.Errorf("Expected data dir '/tmp/test-data', got '%s'", cfg.DataDir)
 	}
@@ -77,6 +99,10 @@ func TestLoadWithEnvironment(t *testing.T) {
 		t.Error("Expected authentication enabled")
 	}
 
+	if !cfg.LegacyBucketNames {
+		t.Error("Expected legacy bucket names allowed")
+	}
+
 	if !cfg.ReadOnly {
 		t.Error("Expected read-only enabled")
 	}
@@ -85,6 +111,14 @@ func TestLoadWithEnvironment(t *testing.T) {
 		t.Error("Expected verbose enabled")
 	}
 
+	if cfg.AccessLog != "/var/log/s3dir/access.log" || cfg.AccessLogFormat != "aws" {
+		t.Errorf("Expected aws access log at /var/log/s3dir/access.log, got %s %q", cfg.AccessLogFormat, cfg.AccessLog)
+	}
+
+	if cfg.AccessLogMaxSizeMB != 10 || cfg.AccessLogMaxBackups != 5 {
+		t.Errorf("Expected access log rotation at 10 MB keeping 5 backups, got %d MB and %d", cfg.AccessLogMaxSizeMB, cfg.AccessLogMaxBackups)
+	}
+
 	// Clean up test directory
 	os.RemoveAll("/tmp/test-data")
 }
@@ -166,6 +200,89 @@ func TestValidate(t *testing.T) {
 			},
 			wantError: false,
 		},
+		{
+			name: "auth enabled with credentials file only",
+			config: &Config{
+				Host:            "0.0.0.0",
+				Port:            8000,
+				DataDir:         "/tmp/test-s3dir-auth-file",
+				EnableAuth:      true,
+				CredentialsFile: "/etc/s3dir/credentials.json",
+			},
+			wantError: false,
+		},
+		{
+			name: "metrics port same as server port",
+			config: &Config{
+				Host:        "0.0.0.0",
+				Port:        8000,
+				MetricsPort: 8000,
+				DataDir:     "/tmp/test-s3dir",
+			},
+			wantError: true,
+		},
+		{
+			name: "metrics port",
+			config: &Config{
+				Host:        "0.0.0.0",
+				Port:        8000,
+				MetricsPort: 9090,
+				DataDir:     "/tmp/test-s3dir-metrics",
+			},
+			wantError: false,
+		},
+		{
+			name: "negative shutdown timeout",
+			config: &Config{
+				Host:            "0.0.0.0",
+				Port:            8000,
+				DataDir:         "/tmp/test-s3dir",
+				ShutdownTimeout: -time.Second,
+			},
+			wantError: true,
+		},
+		{
+			name: "unknown access log format",
+			config: &Config{
+				Host:            "0.0.0.0",
+				Port:            8000,
+				DataDir:         "/tmp/test-s3dir",
+				AccessLog:       "stdout",
+				AccessLogFormat: "xml",
+			},
+			wantError: true,
+		},
+		{
+			name: "negative log delivery interval",
+			config: &Config{
+				Host:                "0.0.0.0",
+				Port:                8000,
+				DataDir:             "/tmp/test-s3dir",
+				LogDeliveryInterval: -time.Minute,
+			},
+			wantError: true,
+		},
+		{
+			name: "TLS certificate without key",
+			config: &Config{
+				Host:        "0.0.0.0",
+				Port:        8000,
+				DataDir:     "/tmp/test-s3dir",
+				TLSCertFile: "/etc/s3dir/cert.pem",
+			},
+			wantError: true,
+		},
+		{
+			name: "TLS certificate and key",
+			config: &Config{
+				Host:        "0.0.0.0",
+				Port:        8443,
+				DataDir:     "/tmp/test-s3dir-tls",
+				TLSCertFile: "/etc/s3dir/cert.pem",
+				TLSKeyFile:  "/etc/s3dir/key.pem",
+			},
+			wantError: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -265,3 +382,64 @@ func TestGetEnvAsBool(t *testing.T) {
 
 	os.Clearenv()
 }
+
+func TestGetEnvAsList(t *testing.T) {
+	os.Clearenv()
+
+	// Test with default value
+	if val := getEnvAsList("TEST_LIST"); len(val) != 0 {
+		t.Errorf("Expected empty list, got %v", val)
+	}
+
+	// Test with entries, ignoring whitespace and empty entries
+	os.Setenv("TEST_LIST", " a.example.com,,b.example.com ,")
+	val := getEnvAsList("TEST_LIST")
+	if len(val) != 2 || val[0] != "a.example.com" || val[1] != "b.example.com" {
+		t.Errorf("Expected [a.example.com b.example.com], got %v", val)
+	}
+
+	os.Clearenv()
+}
+
+func TestLoadSelfSignedTLS(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("S3DIR_DATA_DIR", "/tmp/test-data-tls")
+	os.Setenv("S3DIR_TLS_SELF_SIGNED", "true")
+	defer os.Clearenv()
+	defer os.RemoveAll("/tmp/test-data-tls")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if !cfg.TLSEnabled() {
+		t.Error("Expected TLS enabled")
+	}
+	if cfg.TLSCertFile != "/tmp/test-data-tls/.tls/cert.pem" || cfg.TLSKeyFile != "/tmp/test-data-tls/.tls/key.pem" {
+		t.Errorf("Expected certificate in the data directory, got %s and %s", cfg.TLSCertFile, cfg.TLSKeyFile)
+	}
+}
+
+func TestGetEnvAsDuration(t *testing.T) {
+	os.Clearenv()
+
+	// Test with default value
+	if val := getEnvAsDuration("TEST_DURATION", 30*time.Second); val != 30*time.Second {
+		t.Errorf("Expected 30s, got %v", val)
+	}
+
+	// Test with valid duration
+	os.Setenv("TEST_DURATION", "2m")
+	if val := getEnvAsDuration("TEST_DURATION", 30*time.Second); val != 2*time.Minute {
+		t.Errorf("Expected 2m, got %v", val)
+	}
+
+	// Test with invalid duration
+	os.Setenv("TEST_DURATION", "invalid")
+	if val := getEnvAsDuration("TEST_DURATION", 30*time.Second); val != 30*time.Second {
+		t.Errorf("Expected 30s (default), got %v", val)
+	}
+
+	os.Clearenv()
+}
@@ -0,0 +1,155 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// leaf returns the parsed certificate the reloader currently serves
+func leaf(t *testing.T, r *Reloader) *x509.Certificate {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return parsed
+}
+
+func TestEnsureSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls", "cert.pem")
+	keyFile := filepath.Join(dir, "tls", "key.pem")
+
+	generated, err := EnsureSelfSigned(certFile, keyFile, []string{"s3.local", "*.s3.local", "10.0.0.1", ""})
+	if err != nil {
+		t.Fatalf("EnsureSelfSigned failed: %v", err)
+	}
+	if !generated {
+		t.Error("Expected a certificate to be generated")
+	}
+
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected key file with mode 0600, got %v (%v)", info, err)
+	}
+
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+	cert := leaf(t, r)
+	for _, host := range []string{"localhost", "127.0.0.1", "::1", "s3.local", "bucket.s3.local", "10.0.0.1"} {
+		if err := cert.VerifyHostname(host); err != nil {
+			t.Errorf("Certificate not valid for %s: %v", host, err)
+		}
+	}
+	if cert.VerifyHostname("example.com") == nil {
+		t.Error("Certificate should not be valid for example.com")
+	}
+
+	// Existing files are kept
+	generated, err = EnsureSelfSigned(certFile, keyFile, nil)
+	if err != nil || generated {
+		t.Errorf("Expected existing certificate to be kept, got %v, %v", generated, err)
+	}
+	if !leaf(t, r).Equal(cert) {
+		t.Error("Certificate changed without a reload")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	if _, err := EnsureSelfSigned(certFile, keyFile, nil); err != nil {
+		t.Fatalf("EnsureSelfSigned failed: %v", err)
+	}
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+	first := leaf(t, r)
+
+	// A broken key file keeps the previous certificate
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Error("Expected reload of a broken key to fail")
+	}
+	if !leaf(t, r).Equal(first) {
+		t.Error("Expected previous certificate after a failed reload")
+	}
+
+	// Replaced files are picked up by the watcher
+	os.Remove(keyFile)
+	if _, err := EnsureSelfSigned(certFile, keyFile, []string{"renewed.local"}); err != nil {
+		t.Fatalf("EnsureSelfSigned failed: %v", err)
+	}
+	later := time.Now().Add(time.Second)
+	os.Chtimes(certFile, later, later)
+
+	r.StartWatching(10 * time.Millisecond)
+	defer r.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for leaf(t, r).Equal(first) {
+		if time.Now().After(deadline) {
+			t.Fatal("Certificate was not reloaded after the files changed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := leaf(t, r).VerifyHostname("renewed.local"); err != nil {
+		t.Errorf("Expected the renewed certificate: %v", err)
+	}
+}
+
+func TestTLSConfigServesHTTP2(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if _, err := EnsureSelfSigned(certFile, keyFile, nil); err != nil {
+		t.Fatalf("EnsureSelfSigned failed: %v", err)
+	}
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &http.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}),
+		TLSConfig: r.TLSConfig(),
+	}
+	go server.ServeTLS(listener, "", "")
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf(t, r))
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		ForceAttemptHTTP2: true,
+	}}
+
+	resp, err := client.Get("https://" + listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Expected HTTP/2, got %s", resp.Proto)
+	}
+}
@@ -0,0 +1,226 @@
+// Package accesslog formats and writes request access logs as JSON lines or
+// in the Amazon S3 server access log format
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format is an access log line format
+type Format string
+
+// Supported formats
+const (
+	// FormatJSON writes one JSON object per line
+	FormatJSON Format = "json"
+	// FormatAWS writes lines in the S3 server access log format
+	FormatAWS Format = "aws"
+)
+
+// ParseFormat parses a format name
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(name)) {
+	case FormatJSON:
+		return FormatJSON, nil
+	case FormatAWS:
+		return FormatAWS, nil
+	}
+	return "", fmt.Errorf("unknown access log format %q", name)
+}
+
+// Entry is the record of one served request. Empty strings and negative
+// sizes mean the value is unknown or does not apply
+type Entry struct {
+	Time             time.Time
+	RequestID        string
+	HostID           string
+	RemoteIP         string
+	BucketOwner      string
+	Requester        string // access key ID the request was signed with
+	Operation        string // e.g. REST.GET.OBJECT
+	Bucket           string
+	Key              string
+	RequestURI       string // request line, e.g. "GET /bucket/key HTTP/1.1"
+	Status           int
+	ErrorCode        string
+	BytesSent        int64
+	ObjectSize       int64
+	TotalTime        time.Duration
+	TurnAroundTime   time.Duration
+	Referer          string
+	UserAgent        string
+	VersionID        string
+	SignatureVersion string
+	CipherSuite      string
+	AuthType         string
+	Host             string
+	TLSVersion       string
+}
+
+// jsonEntry is the JSON representation of an Entry
+type jsonEntry struct {
+	Time             string `json:"time"`
+	RequestID        string `json:"requestId,omitempty"`
+	HostID           string `json:"hostId,omitempty"`
+	RemoteIP         string `json:"remoteIp,omitempty"`
+	BucketOwner      string `json:"bucketOwner,omitempty"`
+	Requester        string `json:"requester,omitempty"`
+	Operation        string `json:"operation"`
+	Bucket           string `json:"bucket,omitempty"`
+	Key              string `json:"key,omitempty"`
+	RequestURI       string `json:"requestUri"`
+	Status           int    `json:"status"`
+	ErrorCode        string `json:"errorCode,omitempty"`
+	BytesSent        int64  `json:"bytesSent"`
+	ObjectSize       *int64 `json:"objectSize,omitempty"`
+	TotalTimeMs      int64  `json:"totalTimeMs"`
+	TurnAroundTimeMs *int64 `json:"turnAroundTimeMs,omitempty"`
+	Referer          string `json:"referer,omitempty"`
+	UserAgent        string `json:"userAgent,omitempty"`
+	VersionID        string `json:"versionId,omitempty"`
+	SignatureVersion string `json:"signatureVersion,omitempty"`
+	CipherSuite      string `json:"cipherSuite,omitempty"`
+	AuthType         string `json:"authType,omitempty"`
+	Host             string `json:"host,omitempty"`
+	TLSVersion       string `json:"tlsVersion,omitempty"`
+}
+
+// MarshalJSON encodes the entry with camelCase field names, durations in
+// milliseconds and unknown values omitted
+func (e *Entry) MarshalJSON() ([]byte, error) {
+	j := jsonEntry{
+		Time:             e.Time.UTC().Format(time.RFC3339Nano),
+		RequestID:        e.RequestID,
+		HostID:           e.HostID,
+		RemoteIP:         e.RemoteIP,
+		BucketOwner:      e.BucketOwner,
+		Requester:        e.Requester,
+		Operation:        e.Operation,
+		Bucket:           e.Bucket,
+		Key:              e.Key,
+		RequestURI:       e.RequestURI,
+		Status:           e.Status,
+		ErrorCode:        e.ErrorCode,
+		BytesSent:        e.BytesSent,
+		TotalTimeMs:      e.TotalTime.Milliseconds(),
+		Referer:          e.Referer,
+		UserAgent:        e.UserAgent,
+		VersionID:        e.VersionID,
+		SignatureVersion: e.SignatureVersion,
+		CipherSuite:      e.CipherSuite,
+		AuthType:         e.AuthType,
+		Host:             e.Host,
+		TLSVersion:       e.TLSVersion,
+	}
+	if e.ObjectSize >= 0 {
+		j.ObjectSize = &e.ObjectSize
+	}
+	if e.TurnAroundTime > 0 {
+		ms := e.TurnAroundTime.Milliseconds()
+		j.TurnAroundTimeMs = &ms
+	}
+	return json.Marshal(j)
+}
+
+// AWSLine formats the entry as an S3 server access log line, without the
+// trailing newline. Missing values are written as "-"
+func (e *Entry) AWSLine() string {
+	var b strings.Builder
+	field := func(value string) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		if value == "" {
+			value = "-"
+		}
+		b.WriteString(value)
+	}
+	quoted := func(value string) {
+		if value == "" {
+			field("-")
+			return
+		}
+		field(`"` + strings.ReplaceAll(value, `"`, `\"`) + `"`)
+	}
+
+	field(e.BucketOwner)
+	field(e.Bucket)
+	field(e.Time.Format("[02/Jan/2006:15:04:05 -0700]"))
+	field(e.RemoteIP)
+	field(e.Requester)
+	field(e.RequestID)
+	field(e.Operation)
+	field((&url.URL{Path: e.Key}).EscapedPath())
+	quoted(e.RequestURI)
+	field(strconv.Itoa(e.Status))
+	field(e.ErrorCode)
+	// S3 writes "-" for responses without a body
+	if e.BytesSent > 0 {
+		field(strconv.FormatInt(e.BytesSent, 10))
+	} else {
+		field("")
+	}
+	if e.ObjectSize >= 0 {
+		field(strconv.FormatInt(e.ObjectSize, 10))
+	} else {
+		field("")
+	}
+	field(strconv.FormatInt(e.TotalTime.Milliseconds(), 10))
+	if e.TurnAroundTime > 0 {
+		field(strconv.FormatInt(e.TurnAroundTime.Milliseconds(), 10))
+	} else {
+		field("")
+	}
+	quoted(e.Referer)
+	quoted(e.UserAgent)
+	field(e.VersionID)
+	field(e.HostID)
+	field(e.SignatureVersion)
+	field(e.CipherSuite)
+	field(e.AuthType)
+	field(e.Host)
+	field(e.TLSVersion)
+	field("") // access point ARN
+	field("") // ACL required
+	return b.String()
+}
+
+// Line formats the entry in format f, including the trailing newline
+func (e *Entry) Line(f Format) []byte {
+	if f == FormatAWS {
+		return []byte(e.AWSLine() + "\n")
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil
+	}
+	return append(data, '\n')
+}
+
+// Logger writes entries to a writer, one line each
+type Logger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format Format
+}
+
+// New creates a logger writing entries to w in the given format
+func New(w io.Writer, format Format) *Logger {
+	return &Logger{w: w, format: format}
+}
+
+// Log writes an entry. It is safe for concurrent use
+func (l *Logger) Log(e *Entry) error {
+	line := e.Line(l.format)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.w.Write(line)
+	return err
+}
@@ -0,0 +1,149 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEntry() *Entry {
+	return &Entry{
+		Time:             time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC),
+		RequestID:        "3E57427F3EXAMPLE",
+		RemoteIP:         "192.0.2.3",
+		BucketOwner:      "s3dir",
+		Requester:        "AKIDEXAMPLE",
+		Operation:        "REST.GET.OBJECT",
+		Bucket:           "photos",
+		Key:              "2024/my cat.jpg",
+		RequestURI:       "GET /photos/2024/my%20cat.jpg HTTP/1.1",
+		Status:           200,
+		BytesSent:        2662,
+		ObjectSize:       2662,
+		TotalTime:        70 * time.Millisecond,
+		TurnAroundTime:   10 * time.Millisecond,
+		UserAgent:        `curl/8.0 "quoted"`,
+		SignatureVersion: "SigV4",
+		AuthType:         "AuthHeader",
+		Host:             "localhost:8000",
+	}
+}
+
+func TestAWSLine(t *testing.T) {
+	want := `s3dir photos [05/Mar/2024:14:07:09 +0000] 192.0.2.3 AKIDEXAMPLE 3E57427F3EXAMPLE REST.GET.OBJECT 2024/my%20cat.jpg "GET /photos/2024/my%20cat.jpg HTTP/1.1" 200 - 2662 2662 70 10 - "curl/8.0 \"quoted\"" - - SigV4 - AuthHeader localhost:8000 - - -`
+	if got := testEntry().AWSLine(); got != want {
+		t.Errorf("Unexpected line:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Unknown values are written as "-"
+	entry := &Entry{Time: time.Unix(0, 0).UTC(), Operation: "REST.GET.SERVICE", RequestURI: "GET / HTTP/1.1", Status: 403, ErrorCode: "AccessDenied", ObjectSize: -1}
+	want = `- - [01/Jan/1970:00:00:00 +0000] - - - REST.GET.SERVICE - "GET / HTTP/1.1" 403 AccessDenied - - 0 - - - - - - - - - - - -`
+	if got := entry.AWSLine(); got != want {
+		t.Errorf("Unexpected line:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestJSONLine(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, FormatJSON)
+	if err := logger.Log(testEntry()); err != nil {
+		t.Fatalf("Log failed: %v", err)
+	}
+	entry := testEntry()
+	entry.ObjectSize = -1
+	logger.Log(entry)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d: %q", len(lines), buf.String())
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &decoded); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", lines[0], err)
+	}
+	for field, want := range map[string]any{
+		"time":        "2024-03-05T14:07:09Z",
+		"requestId":   "3E57427F3EXAMPLE",
+		"operation":   "REST.GET.OBJECT",
+		"key":         "2024/my cat.jpg",
+		"status":      float64(200),
+		"bytesSent":   float64(2662),
+		"objectSize":  float64(2662),
+		"totalTimeMs": float64(70),
+	} {
+		if decoded[field] != want {
+			t.Errorf("Expected %s %v, got %v", field, want, decoded[field])
+		}
+	}
+	if _, ok := decoded["errorCode"]; ok {
+		t.Error("Expected empty errorCode to be omitted")
+	}
+
+	decoded = nil
+	json.Unmarshal([]byte(lines[1]), &decoded)
+	if _, ok := decoded["objectSize"]; ok {
+		t.Error("Expected unknown objectSize to be omitted")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, want := range map[string]Format{"json": FormatJSON, "AWS": FormatAWS} {
+		if got, err := ParseFormat(name); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v", name, got, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile failed: %v", err)
+	}
+	defer f.Close()
+
+	// Ten bytes per file: "three\n", "four\n" and "six\n" each start a new
+	// one, and the file holding "one\ntwo\n" falls off the end
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		path:        "six\n",
+		path + ".1": "four\nfive\n",
+		path + ".2": "three\n",
+	} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if string(data) != want {
+			t.Errorf("Expected %s to hold %q, got %q", filepath.Base(name), want, data)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Expected backups beyond the limit to be removed")
+	}
+
+	// Reopening appends to the existing file
+	f.Close()
+	f, err = OpenRotatingFile(path, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile failed: %v", err)
+	}
+	f.Write([]byte("seven\n"))
+	f.Close()
+	if data, _ := os.ReadFile(path); string(data) != "six\nseven\n" {
+		t.Errorf("Expected reopened file to be appended to, got %q", data)
+	}
+}
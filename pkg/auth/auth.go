@@ -1,47 +1,138 @@
 package auth
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// maxClockSkew is how far a request's signing time may differ from the server
+// clock, matching S3
+const maxClockSkew = 15 * time.Minute
+
+// maxPresignExpiry is the longest X-Amz-Expires a presigned URL may request
+// (seven days, as in S3)
+const maxPresignExpiry = 7 * 24 * 60 * 60
+
+// ErrContentSHA256Mismatch is returned while reading a request body whose
+// SHA-256 does not match the signed X-Amz-Content-Sha256 header
+var ErrContentSHA256Mismatch = errors.New("the provided x-amz-content-sha256 header does not match what was computed")
+
+// Error is an authentication failure carrying the S3 error code and HTTP
+// status to report to the client
+type Error struct {
+	Code       string
+	Message    string
+	StatusCode int
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func accessDenied(message string) *Error {
+	return &Error{Code: "AccessDenied", Message: message, StatusCode: http.StatusForbidden}
+}
+
+// ErrMissingAuthorization is returned by Authenticate for unsigned requests.
+// Middleware lets them through as Anonymous, leaving bucket policies to
+// decide what they may do
+var ErrMissingAuthorization = accessDenied("missing Authorization header")
+
 // Authenticator handles AWS Signature V4 authentication
 type Authenticator struct {
-	accessKeyID     string
-	secretAccessKey string
-	enabled         bool
+	credentials *CredentialStore
+	enabled     bool
 }
 
-// New creates a new Authenticator
+// New creates a new Authenticator accepting a single credential pair with
+// access to every bucket and action
 func New(accessKeyID, secretAccessKey string, enabled bool) *Authenticator {
+	store, _ := NewCredentialStore()
+	if accessKeyID != "" {
+		store.Add(&Credential{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey})
+	}
+	return NewWithCredentials(store, enabled)
+}
+
+// NewWithCredentials creates a new Authenticator accepting every credential in
+// the store
+func NewWithCredentials(credentials *CredentialStore, enabled bool) *Authenticator {
 	return &Authenticator{
-		accessKeyID:     accessKeyID,
-		secretAccessKey: secretAccessKey,
-		enabled:         enabled,
+		credentials: credentials,
+		enabled:     enabled,
 	}
 }
 
-// Authenticate verifies the request signature
+// Authenticate verifies the request signature, given either in the
+// Authorization header or as presigned URL query parameters. When the signed
+// payload hash is a literal SHA-256, the request body is wrapped so that
+// reading it to the end fails with ErrContentSHA256Mismatch if the content
+// does not match. Unsigned requests fail with ErrMissingAuthorization
 func (a *Authenticator) Authenticate(r *http.Request) error {
+	_, _, err := a.authenticate(r)
+	return err
+}
+
+// RequestSigner returns the access key ID a request claims to be signed with
+// and how it carries the signature, "AuthHeader" or "QueryString" for
+// presigned URLs. The signature is not verified. Unsigned requests return
+// empty strings
+func RequestSigner(r *http.Request) (accessKeyID, authType string) {
+	if credential := r.URL.Query().Get("X-Amz-Credential"); credential != "" {
+		accessKeyID, _, _ = strings.Cut(credential, "/")
+		return accessKeyID, "QueryString"
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", ""
+	}
+	for _, part := range strings.Split(strings.TrimPrefix(authHeader, signingAlgorithm+" "), ",") {
+		if credential, ok := strings.CutPrefix(strings.TrimSpace(part), "Credential="); ok {
+			accessKeyID, _, _ = strings.Cut(credential, "/")
+			break
+		}
+	}
+	return accessKeyID, "AuthHeader"
+}
+
+// authenticate verifies the request and returns the credential it was signed
+// with, or nil when authentication is disabled, and the verifier for its
+// chunk signatures if it has a signed aws-chunked body
+func (a *Authenticator) authenticate(r *http.Request) (*Credential, *ChunkVerifier, error) {
 	if !a.enabled {
-		return nil
+		return nil, nil, nil
+	}
+
+	// Presigned URLs carry the signature in the query string
+	if r.URL.Query().Has("X-Amz-Algorithm") {
+		return a.authenticatePresigned(r)
 	}
 
 	// Check for Authorization header
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		return fmt.Errorf("missing Authorization header")
+		return nil, nil, ErrMissingAuthorization
 	}
 
 	// Parse AWS4-HMAC-SHA256 signature
-	if !strings.HasPrefix(authHeader, "AWS4-HMAC-SHA256 ") {
-		return fmt.Errorf("unsupported authorization type")
+	if !strings.HasPrefix(authHeader, signingAlgorithm+" ") {
+		return nil, nil, &Error{Code: "InvalidArgument", Message: "unsupported authorization type", StatusCode: http.StatusBadRequest}
 	}
 
-	// Extract credentials from authorization header
-	parts := strings.Split(strings.TrimPrefix(authHeader, "AWS4-HMAC-SHA256 "), ",")
+	// Extract the signature components from the authorization header
+	parts := strings.Split(strings.TrimPrefix(authHeader, signingAlgorithm+" "), ",")
 	authParams := make(map[string]string)
 
 	for _, part := range parts {
@@ -52,64 +143,254 @@ func (a *Authenticator) Authenticate(r *http.Request) error {
 		}
 	}
 
-	credential := authParams["Credential"]
-	if credential == "" {
-		return fmt.Errorf("missing Credential in Authorization header")
+	scope, err := parseCredential(authParams["Credential"])
+	if err != nil {
+		return nil, nil, &Error{Code: "AuthorizationHeaderMalformed", Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+	if authParams["SignedHeaders"] == "" || authParams["Signature"] == "" {
+		return nil, nil, &Error{Code: "AuthorizationHeaderMalformed", Message: "missing SignedHeaders or Signature in Authorization header", StatusCode: http.StatusBadRequest}
+	}
+
+	amzDate, err := requestTime(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		return nil, nil, &Error{Code: "InvalidRequest", Message: "missing required header for this request: x-amz-content-sha256", StatusCode: http.StatusBadRequest}
+	}
+
+	signedHeaders := strings.Split(authParams["SignedHeaders"], ";")
+	cred, err := a.verifySignature(r, scope, amzDate, signedHeaders, payloadHash, authParams["Signature"], "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := verifyPayload(r, payloadHash); err != nil {
+		return nil, nil, err
+	}
+
+	return cred, newChunkVerifier(cred, scope, amzDate, payloadHash, authParams["Signature"]), nil
+}
+
+// authenticatePresigned verifies a presigned URL, whose signature components
+// are passed as X-Amz-* query parameters, and enforces its expiry
+func (a *Authenticator) authenticatePresigned(r *http.Request) (*Credential, *ChunkVerifier, error) {
+	query := r.URL.Query()
+
+	if query.Get("X-Amz-Algorithm") != signingAlgorithm {
+		return nil, nil, &Error{Code: "InvalidArgument", Message: "unsupported X-Amz-Algorithm", StatusCode: http.StatusBadRequest}
+	}
+
+	scope, err := parseCredential(query.Get("X-Amz-Credential"))
+	if err != nil {
+		return nil, nil, &Error{Code: "AuthorizationQueryParametersError", Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+	if query.Get("X-Amz-SignedHeaders") == "" || query.Get("X-Amz-Signature") == "" {
+		return nil, nil, &Error{Code: "AuthorizationQueryParametersError", Message: "missing X-Amz-SignedHeaders or X-Amz-Signature", StatusCode: http.StatusBadRequest}
+	}
+
+	signedAt, err := time.Parse("20060102T150405Z", query.Get("X-Amz-Date"))
+	if err != nil {
+		return nil, nil, &Error{Code: "AuthorizationQueryParametersError", Message: "invalid X-Amz-Date", StatusCode: http.StatusBadRequest}
 	}
 
-	// Extract access key from credential
-	credParts := strings.Split(credential, "/")
-	if len(credParts) < 1 {
-		return fmt.Errorf("invalid Credential format")
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 1 || expires > maxPresignExpiry {
+		return nil, nil, &Error{Code: "AuthorizationQueryParametersError", Message: "X-Amz-Expires must be between 1 and 604800 seconds", StatusCode: http.StatusBadRequest}
 	}
 
-	accessKeyID := credParts[0]
-	if accessKeyID != a.accessKeyID {
-		return fmt.Errorf("invalid access key")
+	now := time.Now()
+	if signedAt.Sub(now) > maxClockSkew {
+		return nil, nil, accessDenied("request is not valid yet")
+	}
+	if now.After(signedAt.Add(time.Duration(expires) * time.Second)) {
+		return nil, nil, accessDenied("request has expired")
 	}
 
-	// For now, we'll do basic access key validation
-	// Full signature verification would require implementing the complete AWS Signature V4 algorithm
-	// This is a simplified version for demonstration
+	// Presigned requests normally leave the payload unsigned
+	payloadHash := query.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = unsignedPayload
+	}
 
+	signedHeaders := strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+	amzDate := FormatTime(signedAt)
+	cred, err := a.verifySignature(r, scope, amzDate, signedHeaders, payloadHash, query.Get("X-Amz-Signature"), "X-Amz-Signature")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := verifyPayload(r, payloadHash); err != nil {
+		return nil, nil, err
+	}
+
+	return cred, newChunkVerifier(cred, scope, amzDate, payloadHash, query.Get("X-Amz-Signature")), nil
+}
+
+// verifySignature looks up the credential scope's access key and checks the
+// scope against the request time, then recomputes the signature and compares
+// it in constant time
+func (a *Authenticator) verifySignature(r *http.Request, scope *credentialScope, amzDate string, signedHeaders []string, payloadHash, signature, excludeQuery string) (*Credential, error) {
+	cred := a.credentials.Lookup(scope.accessKeyID)
+	if cred == nil {
+		return nil, &Error{Code: "InvalidAccessKeyId", Message: "the AWS access key ID you provided does not exist in our records", StatusCode: http.StatusForbidden}
+	}
+	if scope.service != "s3" {
+		return nil, &Error{Code: "AuthorizationHeaderMalformed", Message: fmt.Sprintf("invalid service %q in credential scope", scope.service), StatusCode: http.StatusBadRequest}
+	}
+	if scope.date != amzDate[:8] {
+		return nil, &Error{Code: "AuthorizationHeaderMalformed", Message: "credential scope date does not match the request date", StatusCode: http.StatusBadRequest}
+	}
+
+	hasHost := false
+	for _, name := range signedHeaders {
+		if name == "host" {
+			hasHost = true
+			break
+		}
+	}
+	if !hasHost {
+		return nil, accessDenied("the host header must be signed")
+	}
+
+	canonical := canonicalRequest(r, signedHeaders, payloadHash, excludeQuery)
+	expected := calculateSignature(cred.SecretAccessKey, scope, stringToSign(amzDate, scope, canonical))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, &Error{Code: "SignatureDoesNotMatch", Message: "the request signature we calculated does not match the signature you provided", StatusCode: http.StatusForbidden}
+	}
+
+	return cred, nil
+}
+
+// requestTime returns the request's signing time in ISO 8601 basic format,
+// taken from X-Amz-Date or else Date, and rejects times outside the allowed
+// clock skew
+func requestTime(r *http.Request) (string, error) {
+	var t time.Time
+	var err error
+	if amzDate := r.Header.Get("X-Amz-Date"); amzDate != "" {
+		t, err = time.Parse("20060102T150405Z", amzDate)
+	} else if date := r.Header.Get("Date"); date != "" {
+		t, err = http.ParseTime(date)
+	} else {
+		return "", accessDenied("missing X-Amz-Date or Date header")
+	}
+	if err != nil {
+		return "", accessDenied("invalid request date")
+	}
+
+	skew := time.Since(t)
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		return "", &Error{Code: "RequestTimeTooSkewed", Message: "the difference between the request time and the current time is too large", StatusCode: http.StatusForbidden}
+	}
+
+	return FormatTime(t), nil
+}
+
+// verifyPayload validates the X-Amz-Content-Sha256 value and, for literal
+// hashes, wraps the body to check the content as it is read. aws-chunked
+// bodies are checked as the handler decodes them
+func verifyPayload(r *http.Request, payloadHash string) error {
+	switch payloadHash {
+	case unsignedPayload, streamingSignedPayload, streamingSignedPayloadTrailer, streamingUnsignedTrailer:
+		return nil
+	}
+	if strings.HasPrefix(payloadHash, streamingPrefix) {
+		return &Error{Code: "NotImplemented", Message: fmt.Sprintf("x-amz-content-sha256 %s is not supported", payloadHash), StatusCode: http.StatusNotImplemented}
+	}
+
+	expected, err := hex.DecodeString(payloadHash)
+	if err != nil || len(expected) != sha256.Size {
+		return &Error{Code: "InvalidArgument", Message: "x-amz-content-sha256 must be UNSIGNED-PAYLOAD, STREAMING-* or a valid sha256 value", StatusCode: http.StatusBadRequest}
+	}
+
+	if r.Body != nil {
+		r.Body = &payloadVerifier{body: r.Body, hash: sha256.New(), expected: expected}
+	}
 	return nil
 }
 
-// Middleware returns an HTTP middleware for authentication
+// payloadVerifier hashes a request body as it is read, failing the final read
+// with ErrContentSHA256Mismatch if the digest differs from the signed value
+type payloadVerifier struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	expected []byte
+}
+
+func (v *payloadVerifier) Read(p []byte) (int, error) {
+	n, err := v.body.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(v.hash.Sum(nil), v.expected) {
+		return n, ErrContentSHA256Mismatch
+	}
+	return n, err
+}
+
+func (v *payloadVerifier) Close() error {
+	return v.body.Close()
+}
+
+// errorResponse mirrors the S3 error document
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId,omitempty"`
+	HostID    string   `xml:"HostId,omitempty"`
+}
+
+// Middleware returns an HTTP middleware for authentication. Unsigned requests
+// are passed on with the Anonymous credential
 func (a *Authenticator) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if err := a.Authenticate(r); err != nil {
-			http.Error(w, "Forbidden", http.StatusForbidden)
+		cred, verifier, err := a.authenticate(r)
+		// Unsigned requests proceed as anonymous; only a bucket policy can
+		// grant them access
+		if errors.Is(err, ErrMissingAuthorization) {
+			cred, err = Anonymous, nil
+		}
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
+		if cred != nil {
+			r = r.WithContext(WithCredential(r.Context(), cred))
+		}
+		if verifier != nil {
+			r = r.WithContext(withChunkVerifier(r.Context(), verifier))
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-// Reserved for future AWS Signature V4 implementation
-// These functions will be used when full signature verification is implemented
-
-/*
-func calculateSignature(secretKey, stringToSign string) string {
-	h := hmac.New(sha256.New, []byte("AWS4"+secretKey))
-	h.Write([]byte(stringToSign))
-	return hex.EncodeToString(h.Sum(nil))
-}
+// writeError writes an authentication failure as an S3 XML error response,
+// reporting the request ID from the response headers if one has been
+// assigned
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	authErr, ok := err.(*Error)
+	if !ok {
+		authErr = accessDenied(err.Error())
+	}
 
-func getSigningKey(secretKey, dateStamp, region, service string) []byte {
-	kDate := hmacSHA256([]byte("AWS4"+secretKey), []byte(dateStamp))
-	kRegion := hmacSHA256(kDate, []byte(region))
-	kService := hmacSHA256(kRegion, []byte(service))
-	kSigning := hmacSHA256(kService, []byte("aws4_request"))
-	return kSigning
-}
+	output, marshalErr := xml.MarshalIndent(errorResponse{
+		Code:      authErr.Code,
+		Message:   authErr.Message,
+		Resource:  r.URL.Path,
+		RequestID: w.Header().Get("x-amz-request-id"),
+		HostID:    w.Header().Get("x-amz-id-2"),
+	}, "", "  ")
+	if marshalErr != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
-func hmacSHA256(key, data []byte) []byte {
-	h := hmac.New(sha256.New, key)
-	h.Write(data)
-	return h.Sum(nil)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(authErr.StatusCode)
+	w.Write([]byte(xml.Header))
+	w.Write(output)
 }
-*/
 
 // FormatTime formats time for AWS signature
 func FormatTime(t time.Time) string {
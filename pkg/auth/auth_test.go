@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const (
+	testAccessKey = "test-access-key"
+	testSecretKey = "test-secret-key"
+)
+
+// setupAuthServer starts a server behind the auth middleware whose handler
+// refuses anonymous requests, reads the request body and reports any read
+// error as a 400
+func setupAuthServer(t *testing.T) *httptest.Server {
+	authenticator := New(testAccessKey, testSecretKey, true)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if CredentialFromContext(r.Context()).IsAnonymous() {
+			http.Error(w, "AccessDenied", http.StatusForbidden)
+			return
+		}
+		if _, err := io.ReadAll(r.Body); err != nil {
+			if errors.Is(err, ErrContentSHA256Mismatch) {
+				http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := httptest.NewServer(authenticator.Middleware(handler))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// signRequest signs req the way the AWS SDK does for S3
+func signRequest(t *testing.T, req *http.Request, body, accessKey, secretKey string, signingTime time.Time) {
+	hash := sha256.Sum256([]byte(body))
+	payloadHash := hex.EncodeToString(hash[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	creds := aws.Credentials{AccessKeyID: accessKey, SecretAccessKey: secretKey}
+	signer := v4.NewSigner(func(o *v4.SignerOptions) {
+		o.DisableURIPathEscaping = true
+	})
+	if err := signer.SignHTTP(context.Background(), creds, req, payloadHash, "s3", "us-east-1", signingTime); err != nil {
+		t.Fatalf("Failed to sign request: %v", err)
+	}
+}
+
+func doRequest(t *testing.T, req *http.Request) (int, string) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestAuthenticateValidSignature(t *testing.T) {
+	server := setupAuthServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"list buckets", http.MethodGet, "/", ""},
+		{"list objects with query", http.MethodGet, "/bucket?list-type=2&prefix=a%2Fb&max-keys=10", ""},
+		{"subresource", http.MethodGet, "/bucket?uploads", ""},
+		{"prefixed parameter", http.MethodGet, "/bucket?a=2&a-b=1&x-id=GetObject&x-id2=1", ""},
+		{"encoded key", http.MethodGet, "/bucket/dir/hello%20world%2Bplus.txt", ""},
+		{"put object", http.MethodPut, "/bucket/file.txt", "hello, world"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+			signRequest(t, req, tt.body, testAccessKey, testSecretKey, time.Now())
+
+			if code, body := doRequest(t, req); code != http.StatusOK {
+				t.Errorf("Expected 200, got %d: %s", code, body)
+			}
+		})
+	}
+}
+
+func TestAuthenticateRejectsBadSignatures(t *testing.T) {
+	server := setupAuthServer(t)
+
+	tests := []struct {
+		name         string
+		prepare      func(t *testing.T, req *http.Request)
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "missing authorization",
+			prepare:      func(t *testing.T, req *http.Request) {},
+			expectedCode: http.StatusForbidden,
+			expectedBody: "AccessDenied",
+		},
+		{
+			name: "wrong secret",
+			prepare: func(t *testing.T, req *http.Request) {
+				signRequest(t, req, "", testAccessKey, "wrong-secret", time.Now())
+			},
+			expectedCode: http.StatusForbidden,
+			expectedBody: "SignatureDoesNotMatch",
+		},
+		{
+			name: "unknown access key",
+			prepare: func(t *testing.T, req *http.Request) {
+				signRequest(t, req, "", "other-key", testSecretKey, time.Now())
+			},
+			expectedCode: http.StatusForbidden,
+			expectedBody: "InvalidAccessKeyId",
+		},
+		{
+			name: "tampered query",
+			prepare: func(t *testing.T, req *http.Request) {
+				signRequest(t, req, "", testAccessKey, testSecretKey, time.Now())
+				req.URL.RawQuery = "prefix=other"
+			},
+			expectedCode: http.StatusForbidden,
+			expectedBody: "SignatureDoesNotMatch",
+		},
+		{
+			name: "tampered signed header",
+			prepare: func(t *testing.T, req *http.Request) {
+				signRequest(t, req, "", testAccessKey, testSecretKey, time.Now())
+				req.Header.Set("X-Amz-Date", FormatTime(time.Now().Add(time.Minute)))
+			},
+			expectedCode: http.StatusForbidden,
+			expectedBody: "SignatureDoesNotMatch",
+		},
+		{
+			name: "clock skew",
+			prepare: func(t *testing.T, req *http.Request) {
+				signRequest(t, req, "", testAccessKey, testSecretKey, time.Now().Add(-time.Hour))
+			},
+			expectedCode: http.StatusForbidden,
+			expectedBody: "RequestTimeTooSkewed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, server.URL+"/bucket?prefix=a", nil)
+			tt.prepare(t, req)
+
+			code, body := doRequest(t, req)
+			if code != tt.expectedCode {
+				t.Errorf("Expected status %d, got %d", tt.expectedCode, code)
+			}
+			if !strings.Contains(body, tt.expectedBody) {
+				t.Errorf("Expected %s in response, got %s", tt.expectedBody, body)
+			}
+		})
+	}
+}
+
+func TestAuthenticatePayloadHashMismatch(t *testing.T) {
+	server := setupAuthServer(t)
+
+	// Sign for one body but send another
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/bucket/file.txt", strings.NewReader("tampered body"))
+	signRequest(t, req, "original body", testAccessKey, testSecretKey, time.Now())
+
+	code, body := doRequest(t, req)
+	if code != http.StatusBadRequest || !strings.Contains(body, "XAmzContentSHA256Mismatch") {
+		t.Errorf("Expected 400 XAmzContentSHA256Mismatch, got %d: %s", code, body)
+	}
+}
+
+func TestAuthenticateDisabled(t *testing.T) {
+	authenticator := New("", "", false)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := authenticator.Authenticate(req); err != nil {
+		t.Errorf("Expected no error with auth disabled, got %v", err)
+	}
+}
+
+func TestAuthenticateUnsigned(t *testing.T) {
+	authenticator := New(testAccessKey, testSecretKey, true)
+
+	// Authenticate refuses unsigned requests, but Middleware passes them on
+	// as anonymous
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := authenticator.Authenticate(req); !errors.Is(err, ErrMissingAuthorization) {
+		t.Errorf("Expected ErrMissingAuthorization, got %v", err)
+	}
+
+	var cred *Credential
+	handler := authenticator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cred = CredentialFromContext(r.Context())
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !cred.IsAnonymous() {
+		t.Errorf("Expected the anonymous credential, got %+v", cred)
+	}
+}
+
+// presignURL returns a presigned URL for method and path, valid for expires
+// from signingTime
+func presignURL(t *testing.T, server *httptest.Server, method, path, secretKey string, signingTime time.Time, expires time.Duration) string {
+	req, _ := http.NewRequest(method, server.URL+path, nil)
+	query := req.URL.Query()
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	req.URL.RawQuery = query.Encode()
+
+	creds := aws.Credentials{AccessKeyID: testAccessKey, SecretAccessKey: secretKey}
+	signer := v4.NewSigner(func(o *v4.SignerOptions) {
+		o.DisableURIPathEscaping = true
+	})
+	signed, _, err := signer.PresignHTTP(context.Background(), creds, req, "UNSIGNED-PAYLOAD", "s3", "us-east-1", signingTime)
+	if err != nil {
+		t.Fatalf("Failed to presign request: %v", err)
+	}
+	return signed
+}
+
+func TestAuthenticatePresignedURL(t *testing.T) {
+	server := setupAuthServer(t)
+
+	// Presigned GET
+	signed := presignURL(t, server, http.MethodGet, "/bucket/dir/file%20name.txt", testSecretKey, time.Now(), 15*time.Minute)
+	req, _ := http.NewRequest(http.MethodGet, signed, nil)
+	if code, body := doRequest(t, req); code != http.StatusOK {
+		t.Errorf("Presigned GET: expected 200, got %d: %s", code, body)
+	}
+
+	// Parameters whose keys prefix one another
+	signed = presignURL(t, server, http.MethodGet, "/bucket/file.txt?a=2&a-b=1", testSecretKey, time.Now(), time.Hour)
+	req, _ = http.NewRequest(http.MethodGet, signed, nil)
+	if code, body := doRequest(t, req); code != http.StatusOK {
+		t.Errorf("Presigned GET with prefixed parameters: expected 200, got %d: %s", code, body)
+	}
+
+	// Presigned PUT with an unsigned payload
+	signed = presignURL(t, server, http.MethodPut, "/bucket/upload.txt", testSecretKey, time.Now(), time.Hour)
+	req, _ = http.NewRequest(http.MethodPut, signed, strings.NewReader("uploaded through a presigned URL"))
+	if code, body := doRequest(t, req); code != http.StatusOK {
+		t.Errorf("Presigned PUT: expected 200, got %d: %s", code, body)
+	}
+
+	// A URL signed for GET cannot be used for PUT
+	signed = presignURL(t, server, http.MethodGet, "/bucket/file.txt", testSecretKey, time.Now(), time.Hour)
+	req, _ = http.NewRequest(http.MethodPut, signed, strings.NewReader("data"))
+	if code, body := doRequest(t, req); code != http.StatusForbidden || !strings.Contains(body, "SignatureDoesNotMatch") {
+		t.Errorf("Method swap: expected 403 SignatureDoesNotMatch, got %d: %s", code, body)
+	}
+
+	// Wrong secret
+	signed = presignURL(t, server, http.MethodGet, "/bucket/file.txt", "wrong-secret", time.Now(), time.Hour)
+	req, _ = http.NewRequest(http.MethodGet, signed, nil)
+	if code, body := doRequest(t, req); code != http.StatusForbidden || !strings.Contains(body, "SignatureDoesNotMatch") {
+		t.Errorf("Wrong secret: expected 403 SignatureDoesNotMatch, got %d: %s", code, body)
+	}
+
+	// Expired
+	signed = presignURL(t, server, http.MethodGet, "/bucket/file.txt", testSecretKey, time.Now().Add(-2*time.Hour), time.Hour)
+	req, _ = http.NewRequest(http.MethodGet, signed, nil)
+	if code, body := doRequest(t, req); code != http.StatusForbidden || !strings.Contains(body, "expired") {
+		t.Errorf("Expired URL: expected 403 expired, got %d: %s", code, body)
+	}
+
+	// Tampered expiry
+	signed = presignURL(t, server, http.MethodGet, "/bucket/file.txt", testSecretKey, time.Now(), time.Minute)
+	signed = strings.Replace(signed, "X-Amz-Expires=60", "X-Amz-Expires=3600", 1)
+	req, _ = http.NewRequest(http.MethodGet, signed, nil)
+	if code, body := doRequest(t, req); code != http.StatusForbidden || !strings.Contains(body, "SignatureDoesNotMatch") {
+		t.Errorf("Tampered expiry: expected 403 SignatureDoesNotMatch, got %d: %s", code, body)
+	}
+}
+
+func TestRequestSigner(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/bucket/key", nil)
+	if key, authType := RequestSigner(req); key != "" || authType != "" {
+		t.Errorf("Expected no signer for an unsigned request, got %q %q", key, authType)
+	}
+
+	signRequest(t, req, "", testAccessKey, testSecretKey, time.Now())
+	if key, authType := RequestSigner(req); key != testAccessKey || authType != "AuthHeader" {
+		t.Errorf("Expected %q AuthHeader, got %q %q", testAccessKey, key, authType)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/bucket/key?X-Amz-Credential=presign-key%2F20240101%2Fus-east-1%2Fs3%2Faws4_request", nil)
+	if key, authType := RequestSigner(req); key != "presign-key" || authType != "QueryString" {
+		t.Errorf("Expected presign-key QueryString, got %q %q", key, authType)
+	}
+}
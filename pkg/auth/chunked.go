@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// emptySHA256 is the hex SHA-256 of no data
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// trailerSignatureHeader is the trailing header signing the other trailers
+const trailerSignatureHeader = "x-amz-trailer-signature"
+
+var (
+	// ErrChunkSignatureMismatch is returned while reading an aws-chunked body
+	// when a chunk or trailer signature does not verify
+	ErrChunkSignatureMismatch = errors.New("the chunk signature we calculated does not match the signature you provided")
+
+	// ErrMalformedChunk is returned while reading an aws-chunked body whose
+	// framing is invalid or whose length differs from
+	// x-amz-decoded-content-length
+	ErrMalformedChunk = errors.New("the aws-chunked request body is malformed or does not match x-amz-decoded-content-length")
+)
+
+// IsStreamingPayload reports whether a request's body is aws-chunked encoded
+func IsStreamingPayload(r *http.Request) bool {
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), streamingPrefix) {
+		return true
+	}
+	for _, encoding := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+		if strings.TrimSpace(encoding) == "aws-chunked" {
+			return true
+		}
+	}
+	return false
+}
+
+// ChunkVerifier checks the signatures of a signed aws-chunked request body.
+// Each chunk's signature is chained from the previous one, starting with the
+// request's own signature
+type ChunkVerifier struct {
+	key       []byte
+	amzDate   string
+	scope     string
+	signature string
+}
+
+// newChunkVerifier returns a verifier for the chunks of a request signed with
+// signature, or nil unless payloadHash says the chunks are signed
+func newChunkVerifier(cred *Credential, scope *credentialScope, amzDate, payloadHash, signature string) *ChunkVerifier {
+	if payloadHash != streamingSignedPayload && payloadHash != streamingSignedPayloadTrailer {
+		return nil
+	}
+	return &ChunkVerifier{
+		key:       getSigningKey(cred.SecretAccessKey, scope.date, scope.region, scope.service),
+		amzDate:   amzDate,
+		scope:     scope.String(),
+		signature: signature,
+	}
+}
+
+// verify checks a signature over the string to sign for algorithm ending
+// with hashes, moving the chain on if it matches
+func (v *ChunkVerifier) verify(algorithm, hashes, signature string) bool {
+	stringToSign := algorithm + "\n" + v.amzDate + "\n" + v.scope + "\n" + v.signature + "\n" + hashes
+	expected := hex.EncodeToString(hmacSHA256(v.key, []byte(stringToSign)))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return false
+	}
+	v.signature = signature
+	return true
+}
+
+func (v *ChunkVerifier) verifyChunk(chunkHash []byte, signature string) bool {
+	return v.verify(signingAlgorithm+"-PAYLOAD", emptySHA256+"\n"+hex.EncodeToString(chunkHash), signature)
+}
+
+func (v *ChunkVerifier) verifyTrailer(trailer []byte, signature string) bool {
+	trailerHash := sha256.Sum256(trailer)
+	return v.verify(signingAlgorithm+"-TRAILER", hex.EncodeToString(trailerHash[:]), signature)
+}
+
+type chunkVerifierContextKey struct{}
+
+// withChunkVerifier returns a context carrying the verifier for a request's
+// chunk signatures
+func withChunkVerifier(ctx context.Context, v *ChunkVerifier) context.Context {
+	return context.WithValue(ctx, chunkVerifierContextKey{}, v)
+}
+
+// ChunkVerifierFromContext returns the verifier for the chunk signatures of
+// an authenticated request with a signed aws-chunked body, or nil
+func ChunkVerifierFromContext(ctx context.Context) *ChunkVerifier {
+	v, _ := ctx.Value(chunkVerifierContextKey{}).(*ChunkVerifier)
+	return v
+}
+
+// chunkedReader decodes an aws-chunked body: chunks of
+// "<hex size>[;chunk-signature=<sig>]\r\n<data>\r\n" ending with a zero-size
+// chunk, optional trailing headers and a blank line
+type chunkedReader struct {
+	r        *bufio.Reader
+	body     io.Closer
+	verifier *ChunkVerifier
+	trailer  http.Header
+
+	// remaining is the unread size of the current chunk, whose data is
+	// hashed into hash to check signature
+	remaining int64
+	hash      hash.Hash
+	signature string
+
+	decoded  int64
+	expected int64
+	err      error
+}
+
+// NewChunkedReader decodes an aws-chunked request body of decodedLength
+// bytes, or any length if negative. Chunk signatures are checked when
+// verifier is not nil. Trailing headers are added to trailer once the body
+// has been read to the end, like the Trailer of an http.Request
+func NewChunkedReader(body io.ReadCloser, decodedLength int64, verifier *ChunkVerifier, trailer http.Header) io.ReadCloser {
+	return &chunkedReader{
+		r:        bufio.NewReader(body),
+		body:     body,
+		verifier: verifier,
+		trailer:  trailer,
+		hash:     sha256.New(),
+		expected: decodedLength,
+	}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	if c.remaining == 0 {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+		if c.remaining == 0 {
+			return 0, c.err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	c.remaining -= int64(n)
+	c.decoded += int64(n)
+
+	switch {
+	case err == io.EOF:
+		c.err = ErrMalformedChunk
+	case err != nil:
+		c.err = err
+	case c.remaining == 0:
+		c.err = c.endChunk()
+	}
+	return n, c.err
+}
+
+// nextChunk reads the next chunk header. The final, empty chunk is followed
+// by the trailer, after which reads return io.EOF
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+
+	sizeField, extensions, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 || c.expected >= 0 && c.decoded+size > c.expected {
+		return ErrMalformedChunk
+	}
+	c.signature = ""
+	for _, extension := range strings.Split(extensions, ";") {
+		if signature, ok := strings.CutPrefix(extension, "chunk-signature="); ok {
+			c.signature = signature
+		}
+	}
+	c.remaining = size
+	c.hash.Reset()
+
+	if size > 0 {
+		return nil
+	}
+	if err := c.checkSignature(); err != nil {
+		return err
+	}
+	if c.expected >= 0 && c.decoded != c.expected {
+		return ErrMalformedChunk
+	}
+	if err := c.readTrailer(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// endChunk checks the CRLF ending a chunk's data and its signature
+func (c *chunkedReader) endChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return ErrMalformedChunk
+	}
+	return c.checkSignature()
+}
+
+func (c *chunkedReader) checkSignature() error {
+	if c.verifier != nil && !c.verifier.verifyChunk(c.hash.Sum(nil), c.signature) {
+		return ErrChunkSignatureMismatch
+	}
+	return nil
+}
+
+// readTrailer reads the trailing headers up to the blank line ending the
+// body, checking their signature when chunks are signed
+func (c *chunkedReader) readTrailer() error {
+	var canonical bytes.Buffer
+	signature := ""
+	for {
+		line, err := c.readLine()
+		if err == ErrMalformedChunk && canonical.Len() == 0 {
+			// Some clients end the body without the blank line
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return ErrMalformedChunk
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if name == trailerSignatureHeader {
+			signature = value
+			continue
+		}
+		canonical.WriteString(name + ":" + value + "\n")
+		if c.trailer != nil {
+			c.trailer.Add(name, value)
+		}
+	}
+
+	if c.verifier != nil && canonical.Len() > 0 && !c.verifier.verifyTrailer(canonical.Bytes(), signature) {
+		return ErrChunkSignatureMismatch
+	}
+	return nil
+}
+
+// readLine reads a CRLF-terminated line of the chunk framing
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err != nil {
+		if err == io.EOF || err == bufio.ErrBufferFull {
+			return "", ErrMalformedChunk
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+func (c *chunkedReader) Close() error {
+	return c.body.Close()
+}
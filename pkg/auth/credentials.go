@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Action is a class of S3 operation a credential may be allowed to perform
+type Action string
+
+const (
+	// ActionRead covers GET/HEAD of objects, listings and configuration reads
+	ActionRead Action = "read"
+	// ActionWrite covers PutObject, CopyObject and object subresource writes
+	ActionWrite Action = "write"
+	// ActionDelete covers DeleteObject and DeleteObjects
+	ActionDelete Action = "delete"
+	// ActionMultipart covers every multipart upload operation
+	ActionMultipart Action = "multipart"
+	// ActionAdmin covers bucket creation, deletion and configuration writes
+	ActionAdmin Action = "admin"
+)
+
+// allActions is granted to credentials that do not list their actions
+var allActions = []Action{ActionRead, ActionWrite, ActionDelete, ActionMultipart, ActionAdmin}
+
+// Credential is an access key pair together with the buckets and actions it
+// is allowed to use
+type Credential struct {
+	AccessKeyID     string   `json:"accessKeyId"`
+	SecretAccessKey string   `json:"secretAccessKey"`
+	Actions         []Action `json:"actions,omitempty"`
+	// Resources lists the buckets the key may access. "*" grants every
+	// bucket, "bucket" grants the whole bucket and "bucket/prefix" grants
+	// only keys beginning with prefix. An empty list grants every bucket
+	Resources []string `json:"resources,omitempty"`
+}
+
+// Anonymous is the credential of unsigned requests when authentication is
+// enabled. It grants nothing by itself, but a bucket policy may allow access
+var Anonymous = &Credential{}
+
+// IsAnonymous reports whether the credential is the anonymous credential
+func (c *Credential) IsAnonymous() bool {
+	return c == Anonymous
+}
+
+// CredentialStore holds the credentials accepted by an Authenticator, keyed by
+// access key ID
+type CredentialStore struct {
+	credentials map[string]*Credential
+}
+
+// NewCredentialStore creates a store from the given credentials
+func NewCredentialStore(credentials ...*Credential) (*CredentialStore, error) {
+	s := &CredentialStore{credentials: make(map[string]*Credential)}
+	for _, c := range credentials {
+		if err := s.Add(c); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// LoadCredentials reads a credentials file. The file is a JSON document of
+// the form {"credentials": [{"accessKeyId": ..., "secretAccessKey": ...,
+// "actions": [...], "resources": [...]}]}
+func LoadCredentials(path string) (*CredentialStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+
+	var file struct {
+		Credentials []*Credential `json:"credentials"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file: %w", err)
+	}
+
+	return NewCredentialStore(file.Credentials...)
+}
+
+// Add validates a credential and adds it to the store
+func (s *CredentialStore) Add(c *Credential) error {
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return fmt.Errorf("credential requires both an access key ID and a secret access key")
+	}
+	if _, exists := s.credentials[c.AccessKeyID]; exists {
+		return fmt.Errorf("duplicate access key ID %q", c.AccessKeyID)
+	}
+	for _, action := range c.Actions {
+		if !validAction(action) {
+			return fmt.Errorf("unknown action %q for access key ID %q", action, c.AccessKeyID)
+		}
+	}
+	if len(c.Actions) == 0 {
+		c.Actions = allActions
+	}
+
+	s.credentials[c.AccessKeyID] = c
+	return nil
+}
+
+// Lookup returns the credential for an access key ID, or nil
+func (s *CredentialStore) Lookup(accessKeyID string) *Credential {
+	return s.credentials[accessKeyID]
+}
+
+// Len returns the number of credentials in the store
+func (s *CredentialStore) Len() int {
+	return len(s.credentials)
+}
+
+func validAction(action Action) bool {
+	for _, a := range allActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAction reports whether the credential may perform action
+func (c *Credential) hasAction(action Action) bool {
+	for _, a := range c.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the credential may perform action on key in bucket.
+// An empty key checks access to the bucket as a whole, which prefix-scoped
+// resources do not grant
+func (c *Credential) Allows(action Action, bucket, key string) bool {
+	if !c.hasAction(action) {
+		return false
+	}
+	if len(c.Resources) == 0 {
+		return true
+	}
+
+	for _, resource := range c.Resources {
+		resBucket, resPrefix, _ := strings.Cut(resource, "/")
+		if resBucket != "*" && resBucket != bucket {
+			continue
+		}
+		if resPrefix == "" || (key != "" && strings.HasPrefix(key, resPrefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsPrefix reports whether the credential may perform action on every key
+// under prefix in bucket, as needed to list it
+func (c *Credential) AllowsPrefix(action Action, bucket, prefix string) bool {
+	if !c.hasAction(action) {
+		return false
+	}
+	if len(c.Resources) == 0 {
+		return true
+	}
+
+	for _, resource := range c.Resources {
+		resBucket, resPrefix, _ := strings.Cut(resource, "/")
+		if resBucket != "*" && resBucket != bucket {
+			continue
+		}
+		if strings.HasPrefix(prefix, resPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAnyKey reports whether the credential may perform action on at least
+// part of bucket, as needed for HeadBucket and to show the bucket in
+// ListBuckets results
+func (c *Credential) AllowsAnyKey(action Action, bucket string) bool {
+	if !c.hasAction(action) {
+		return false
+	}
+	if len(c.Resources) == 0 {
+		return true
+	}
+	for _, resource := range c.Resources {
+		resBucket, _, _ := strings.Cut(resource, "/")
+		if resBucket == "*" || resBucket == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+type credentialContextKey struct{}
+
+// WithCredential returns a context carrying the authenticated credential
+func WithCredential(ctx context.Context, c *Credential) context.Context {
+	return context.WithValue(ctx, credentialContextKey{}, c)
+}
+
+// CredentialFromContext returns the credential a request was authenticated
+// with, or nil when authentication is disabled
+func CredentialFromContext(ctx context.Context) *Credential {
+	c, _ := ctx.Value(credentialContextKey{}).(*Credential)
+	return c
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	data := `{"credentials": [
+		{"accessKeyId": "admin", "secretAccessKey": "admin-secret"},
+		{"accessKeyId": "ci", "secretAccessKey": "ci-secret", "actions": ["read", "write", "multipart"], "resources": ["builds", "cache/ci/"]},
+		{"accessKeyId": "dashboard", "secretAccessKey": "dash-secret", "actions": ["read"], "resources": ["*"]}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write credentials file: %v", err)
+	}
+
+	store, err := LoadCredentials(path)
+	if err != nil {
+		t.Fatalf("Failed to load credentials: %v", err)
+	}
+	if store.Len() != 3 {
+		t.Fatalf("Expected 3 credentials, got %d", store.Len())
+	}
+
+	admin := store.Lookup("admin")
+	ci := store.Lookup("ci")
+	dashboard := store.Lookup("dashboard")
+	if admin == nil || ci == nil || dashboard == nil || store.Lookup("nobody") != nil {
+		t.Fatal("Lookup returned unexpected results")
+	}
+
+	tests := []struct {
+		name   string
+		cred   *Credential
+		action Action
+		bucket string
+		key    string
+		want   bool
+	}{
+		{"admin can do anything", admin, ActionAdmin, "any-bucket", "", true},
+		{"ci writes builds", ci, ActionWrite, "builds", "artifact.tar", true},
+		{"ci writes its cache prefix", ci, ActionWrite, "cache", "ci/deps.tar", true},
+		{"ci cannot write outside its prefix", ci, ActionWrite, "cache", "dev/deps.tar", false},
+		{"ci cannot use other buckets", ci, ActionRead, "secrets", "key", false},
+		{"ci cannot delete", ci, ActionDelete, "builds", "artifact.tar", false},
+		{"ci cannot administer", ci, ActionAdmin, "builds", "", false},
+		{"prefix grant is not a bucket grant", ci, ActionRead, "cache", "", false},
+		{"dashboard reads everything", dashboard, ActionRead, "secrets", "key", true},
+		{"dashboard cannot write", dashboard, ActionWrite, "builds", "x", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cred.Allows(tt.action, tt.bucket, tt.key); got != tt.want {
+				t.Errorf("Allows(%s, %s, %s) = %v, want %v", tt.action, tt.bucket, tt.key, got, tt.want)
+			}
+		})
+	}
+
+	if !ci.AllowsPrefix(ActionRead, "cache", "ci/sub/") || ci.AllowsPrefix(ActionRead, "cache", "") {
+		t.Error("AllowsPrefix did not respect the ci/ prefix grant")
+	}
+	if !ci.AllowsAnyKey(ActionRead, "cache") || ci.AllowsAnyKey(ActionRead, "secrets") {
+		t.Error("AllowsAnyKey did not respect bucket grants")
+	}
+}
+
+func TestLoadCredentialsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"malformed json", `{"credentials": [`},
+		{"missing secret", `{"credentials": [{"accessKeyId": "a"}]}`},
+		{"duplicate key", `{"credentials": [{"accessKeyId": "a", "secretAccessKey": "s"}, {"accessKeyId": "a", "secretAccessKey": "t"}]}`},
+		{"unknown action", `{"credentials": [{"accessKeyId": "a", "secretAccessKey": "s", "actions": ["fly"]}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "credentials.json")
+			os.WriteFile(path, []byte(tt.data), 0644)
+			if _, err := LoadCredentials(path); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+
+	if _, err := LoadCredentials(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
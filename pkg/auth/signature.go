@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// signingAlgorithm is the only signature algorithm s3dir accepts
+const signingAlgorithm = "AWS4-HMAC-SHA256"
+
+// Special X-Amz-Content-Sha256 values that stand in for a payload hash. The
+// STREAMING-* values mark aws-chunked bodies; signed ones carry a signature
+// per chunk and the -TRAILER ones end with trailing headers such as checksums
+const (
+	unsignedPayload               = "UNSIGNED-PAYLOAD"
+	streamingPrefix               = "STREAMING-"
+	streamingSignedPayload        = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingSignedPayloadTrailer = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrailer      = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+// credentialScope is the parsed Credential component of a SigV4 signature:
+// <access key>/<date>/<region>/<service>/aws4_request
+type credentialScope struct {
+	accessKeyID string
+	date        string
+	region      string
+	service     string
+}
+
+// parseCredential parses a SigV4 Credential value
+func parseCredential(credential string) (*credentialScope, error) {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[0] == "" || parts[4] != "aws4_request" {
+		return nil, fmt.Errorf("invalid Credential format")
+	}
+	return &credentialScope{
+		accessKeyID: parts[0],
+		date:        parts[1],
+		region:      parts[2],
+		service:     parts[3],
+	}, nil
+}
+
+// String returns the scope without the access key, as used in the string to sign
+func (c *credentialScope) String() string {
+	return c.date + "/" + c.region + "/" + c.service + "/aws4_request"
+}
+
+// canonicalRequest builds the SigV4 canonical request for r. excludeQuery
+// names a query parameter to leave out (X-Amz-Signature for presigned URLs)
+func canonicalRequest(r *http.Request, signedHeaders []string, payloadHash, excludeQuery string) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte('\n')
+	b.WriteString(canonicalURI(r.URL.Path))
+	b.WriteByte('\n')
+	b.WriteString(canonicalQueryString(r.URL.RawQuery, excludeQuery))
+	b.WriteByte('\n')
+	for _, name := range signedHeaders {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(canonicalHeaderValue(r, name))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	b.WriteString(strings.Join(signedHeaders, ";"))
+	b.WriteByte('\n')
+	b.WriteString(payloadHash)
+	return b.String()
+}
+
+// canonicalURI encodes the decoded request path the way S3 clients do when
+// signing: every byte except unreserved characters and '/' is percent-encoded
+func canonicalURI(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return uriEncode(path, false)
+}
+
+// canonicalQueryString sorts and re-encodes the query parameters
+func canonicalQueryString(rawQuery, exclude string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return ""
+	}
+
+	// Parameters sort by encoded key and then value, which joining them
+	// first would not give where one key is a prefix of another
+	type pair struct{ key, value string }
+	var pairs []pair
+	for key, vals := range values {
+		if key == exclude {
+			continue
+		}
+		encodedKey := uriEncode(key, true)
+		for _, v := range vals {
+			pairs = append(pairs, pair{encodedKey, uriEncode(v, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].key != pairs[j].key {
+			return pairs[i].key < pairs[j].key
+		}
+		return pairs[i].value < pairs[j].value
+	})
+
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p.key + "=" + p.value
+	}
+	return strings.Join(encoded, "&")
+}
+
+// canonicalHeaderValue returns the trimmed, space-collapsed value of a signed
+// header. Go's server moves Host out of the header map, so it is special-cased
+func canonicalHeaderValue(r *http.Request, name string) string {
+	var values []string
+	if name == "host" {
+		values = []string{r.Host}
+	} else {
+		values = r.Header.Values(name)
+	}
+
+	for i, v := range values {
+		values[i] = strings.Join(strings.Fields(v), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+// uriEncode percent-encodes s per the SigV4 rules. '/' is left alone unless
+// encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
+
+// stringToSign builds the SigV4 string to sign for a canonical request
+func stringToSign(amzDate string, scope *credentialScope, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	return signingAlgorithm + "\n" + amzDate + "\n" + scope.String() + "\n" + hex.EncodeToString(hash[:])
+}
+
+// calculateSignature signs stringToSign with the key derived for scope
+func calculateSignature(secretKey string, scope *credentialScope, stringToSign string) string {
+	key := getSigningKey(secretKey, scope.date, scope.region, scope.service)
+	return hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+}
+
+func getSigningKey(secretKey, dateStamp, region, service string) []byte {
+	kDate := hmacSHA256([]byte("AWS4"+secretKey), []byte(dateStamp))
+	kRegion := hmacSHA256(kDate, []byte(region))
+	kService := hmacSHA256(kRegion, []byte(service))
+	kSigning := hmacSHA256(kService, []byte("aws4_request"))
+	return kSigning
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,408 @@
+// Package lifecycle parses and evaluates S3 bucket lifecycle configurations
+package lifecycle
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Rule statuses
+const (
+	StatusEnabled  = "Enabled"
+	StatusDisabled = "Disabled"
+)
+
+// maxRules is the maximum number of rules in a configuration
+const maxRules = 1000
+
+// day is the unit of lifecycle ages
+const day = 24 * time.Hour
+
+// Configuration is a bucket lifecycle configuration document
+type Configuration struct {
+	XMLName xml.Name `xml:"LifecycleConfiguration"`
+	Rules   []Rule   `xml:"Rule"`
+}
+
+// Rule is a single lifecycle rule
+type Rule struct {
+	ID     string  `xml:"ID,omitempty"`
+	Status string  `xml:"Status"`
+	Filter *Filter `xml:"Filter,omitempty"`
+	// Prefix is the deprecated form of Filter.Prefix
+	Prefix                         *string                         `xml:"Prefix,omitempty"`
+	Expiration                     *Expiration                     `xml:"Expiration,omitempty"`
+	NoncurrentVersionExpiration    *NoncurrentVersionExpiration    `xml:"NoncurrentVersionExpiration,omitempty"`
+	AbortIncompleteMultipartUpload *AbortIncompleteMultipartUpload `xml:"AbortIncompleteMultipartUpload,omitempty"`
+}
+
+// Filter selects the objects a rule applies to. At most one of its fields
+// may be set
+type Filter struct {
+	Prefix                *string `xml:"Prefix,omitempty"`
+	Tag                   *Tag    `xml:"Tag,omitempty"`
+	ObjectSizeGreaterThan *int64  `xml:"ObjectSizeGreaterThan,omitempty"`
+	ObjectSizeLessThan    *int64  `xml:"ObjectSizeLessThan,omitempty"`
+	And                   *And    `xml:"And,omitempty"`
+}
+
+// And combines several filter conditions, all of which must match
+type And struct {
+	Prefix                string `xml:"Prefix,omitempty"`
+	Tags                  []Tag  `xml:"Tag,omitempty"`
+	ObjectSizeGreaterThan int64  `xml:"ObjectSizeGreaterThan,omitempty"`
+	ObjectSizeLessThan    int64  `xml:"ObjectSizeLessThan,omitempty"`
+}
+
+// Tag is an object tag a filter requires
+type Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// Expiration expires current object versions
+type Expiration struct {
+	Days int    `xml:"Days,omitempty"`
+	Date string `xml:"Date,omitempty"`
+	// ExpiredObjectDeleteMarker removes delete markers that have no
+	// noncurrent versions left behind them
+	ExpiredObjectDeleteMarker bool `xml:"ExpiredObjectDeleteMarker,omitempty"`
+
+	date time.Time
+}
+
+// NoncurrentVersionExpiration permanently removes noncurrent versions
+type NoncurrentVersionExpiration struct {
+	NoncurrentDays int `xml:"NoncurrentDays"`
+	// NewerNoncurrentVersions is the number of newer noncurrent versions to
+	// retain regardless of age
+	NewerNoncurrentVersions int `xml:"NewerNoncurrentVersions,omitempty"`
+}
+
+// AbortIncompleteMultipartUpload aborts multipart uploads that have not been
+// completed within a number of days of being initiated
+type AbortIncompleteMultipartUpload struct {
+	DaysAfterInitiation int `xml:"DaysAfterInitiation"`
+}
+
+// Object describes an object version being evaluated against the rules
+type Object struct {
+	Key  string
+	Size int64
+	Tags map[string]string
+}
+
+// Parse parses and validates a lifecycle configuration document
+func Parse(data []byte) (*Configuration, error) {
+	var c Configuration
+	if err := xml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid lifecycle configuration: %w", err)
+	}
+
+	if len(c.Rules) == 0 {
+		return nil, fmt.Errorf("lifecycle configuration must contain at least one rule")
+	}
+	if len(c.Rules) > maxRules {
+		return nil, fmt.Errorf("lifecycle configuration cannot contain more than %d rules", maxRules)
+	}
+
+	ids := make(map[string]bool)
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if err := rule.validate(); err != nil {
+			if rule.ID != "" {
+				return nil, fmt.Errorf("rule %q: %w", rule.ID, err)
+			}
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if rule.ID != "" {
+			if ids[rule.ID] {
+				return nil, fmt.Errorf("rule ID %q is not unique", rule.ID)
+			}
+			ids[rule.ID] = true
+		}
+	}
+
+	return &c, nil
+}
+
+func (r *Rule) validate() error {
+	if len(r.ID) > 255 {
+		return fmt.Errorf("ID cannot be longer than 255 characters")
+	}
+	if r.Status != StatusEnabled && r.Status != StatusDisabled {
+		return fmt.Errorf("status must be Enabled or Disabled")
+	}
+	if r.Filter != nil && r.Prefix != nil {
+		return fmt.Errorf("only one of Filter or Prefix may be specified")
+	}
+	if r.Filter != nil {
+		if err := r.Filter.validate(); err != nil {
+			return err
+		}
+	}
+
+	if r.Expiration == nil && r.NoncurrentVersionExpiration == nil && r.AbortIncompleteMultipartUpload == nil {
+		return fmt.Errorf("at least one action must be specified")
+	}
+
+	if e := r.Expiration; e != nil {
+		set := 0
+		if e.Days != 0 {
+			set++
+		}
+		if e.Date != "" {
+			set++
+		}
+		if e.ExpiredObjectDeleteMarker {
+			set++
+		}
+		if set != 1 {
+			return fmt.Errorf("expiration must specify exactly one of Days, Date or ExpiredObjectDeleteMarker")
+		}
+		if e.Days < 0 {
+			return fmt.Errorf("expiration days must be a positive integer")
+		}
+		if e.Date != "" {
+			date, err := parseDate(e.Date)
+			if err != nil {
+				return err
+			}
+			e.date = date
+		}
+		if e.ExpiredObjectDeleteMarker && r.hasTagFilter() {
+			return fmt.Errorf("ExpiredObjectDeleteMarker cannot be used with a tag filter")
+		}
+	}
+
+	if n := r.NoncurrentVersionExpiration; n != nil {
+		if n.NoncurrentDays <= 0 {
+			return fmt.Errorf("noncurrent days must be a positive integer")
+		}
+		if n.NewerNoncurrentVersions < 0 {
+			return fmt.Errorf("newer noncurrent versions must be a positive integer")
+		}
+	}
+
+	if a := r.AbortIncompleteMultipartUpload; a != nil {
+		if a.DaysAfterInitiation <= 0 {
+			return fmt.Errorf("days after initiation must be a positive integer")
+		}
+		if r.hasTagFilter() {
+			return fmt.Errorf("AbortIncompleteMultipartUpload cannot be used with a tag filter")
+		}
+	}
+
+	return nil
+}
+
+func (f *Filter) validate() error {
+	set := 0
+	if f.Prefix != nil {
+		set++
+	}
+	if f.Tag != nil {
+		set++
+	}
+	if f.ObjectSizeGreaterThan != nil {
+		set++
+	}
+	if f.ObjectSizeLessThan != nil {
+		set++
+	}
+	if f.And != nil {
+		set++
+	}
+	if set > 1 {
+		return fmt.Errorf("filter must specify at most one condition; use And to combine them")
+	}
+
+	if f.And != nil {
+		keys := make(map[string]bool)
+		for _, tag := range f.And.Tags {
+			if keys[tag.Key] {
+				return fmt.Errorf("duplicate tag key %q in filter", tag.Key)
+			}
+			keys[tag.Key] = true
+		}
+		if f.And.ObjectSizeLessThan != 0 && f.And.ObjectSizeLessThan <= f.And.ObjectSizeGreaterThan {
+			return fmt.Errorf("ObjectSizeLessThan must be greater than ObjectSizeGreaterThan")
+		}
+	}
+	return nil
+}
+
+// parseDate parses an expiration date, which must fall at midnight UTC
+func parseDate(value string) (time.Time, error) {
+	var date time.Time
+	var err error
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if date, err = time.Parse(layout, value); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiration date %q", value)
+	}
+
+	date = date.UTC()
+	if !date.Equal(date.Truncate(day)) {
+		return time.Time{}, fmt.Errorf("expiration date must be at midnight UTC")
+	}
+	return date, nil
+}
+
+func (r *Rule) hasTagFilter() bool {
+	return r.Filter != nil && (r.Filter.Tag != nil || (r.Filter.And != nil && len(r.Filter.And.Tags) > 0))
+}
+
+// prefix returns the key prefix the rule applies to
+func (r *Rule) prefix() string {
+	switch {
+	case r.Prefix != nil:
+		return *r.Prefix
+	case r.Filter == nil:
+		return ""
+	case r.Filter.Prefix != nil:
+		return *r.Filter.Prefix
+	case r.Filter.And != nil:
+		return r.Filter.And.Prefix
+	}
+	return ""
+}
+
+// Matches reports whether an enabled rule applies to the object
+func (r *Rule) Matches(obj Object) bool {
+	if r.Status != StatusEnabled || !strings.HasPrefix(obj.Key, r.prefix()) {
+		return false
+	}
+	if r.Filter == nil {
+		return true
+	}
+
+	f := r.Filter
+	switch {
+	case f.Tag != nil:
+		return hasTag(obj.Tags, *f.Tag)
+	case f.ObjectSizeGreaterThan != nil:
+		return obj.Size > *f.ObjectSizeGreaterThan
+	case f.ObjectSizeLessThan != nil:
+		return obj.Size < *f.ObjectSizeLessThan
+	case f.And != nil:
+		for _, tag := range f.And.Tags {
+			if !hasTag(obj.Tags, tag) {
+				return false
+			}
+		}
+		if f.And.ObjectSizeGreaterThan != 0 && obj.Size <= f.And.ObjectSizeGreaterThan {
+			return false
+		}
+		if f.And.ObjectSizeLessThan != 0 && obj.Size >= f.And.ObjectSizeLessThan {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesKey reports whether an enabled rule applies to every object under
+// key, for targets such as delete markers and multipart uploads that have no
+// tags or size
+func (r *Rule) matchesKey(key string) bool {
+	if r.Status != StatusEnabled || !strings.HasPrefix(key, r.prefix()) {
+		return false
+	}
+	f := r.Filter
+	if f == nil || f.And == nil {
+		return f == nil || (f.Tag == nil && f.ObjectSizeGreaterThan == nil && f.ObjectSizeLessThan == nil)
+	}
+	return len(f.And.Tags) == 0 && f.And.ObjectSizeGreaterThan == 0 && f.And.ObjectSizeLessThan == 0
+}
+
+func hasTag(tags map[string]string, tag Tag) bool {
+	value, ok := tags[tag.Key]
+	return ok && value == tag.Value
+}
+
+// expiry returns the time an object created at created becomes eligible for
+// an action taken days after creation. As in S3, the time is rounded up to
+// the following midnight UTC
+func expiry(created time.Time, days int) time.Time {
+	t := created.UTC().Add(time.Duration(days) * day)
+	if midnight := t.Truncate(day); !midnight.Equal(t) {
+		return midnight.Add(day)
+	}
+	return t
+}
+
+// ObjectExpired reports whether a current object version last modified at
+// lastModified has expired at now
+func (c *Configuration) ObjectExpired(obj Object, lastModified, now time.Time) bool {
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		e := rule.Expiration
+		if e == nil || e.ExpiredObjectDeleteMarker || !rule.Matches(obj) {
+			continue
+		}
+		if e.Days > 0 && !now.Before(expiry(lastModified, e.Days)) {
+			return true
+		}
+		if e.Date != "" && !now.Before(e.date) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveExpiredDeleteMarker reports whether a delete marker with no
+// noncurrent versions behind it should be removed
+func (c *Configuration) RemoveExpiredDeleteMarker(key string) bool {
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if rule.Expiration != nil && rule.Expiration.ExpiredObjectDeleteMarker && rule.matchesKey(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// NoncurrentVersionExpired reports whether a noncurrent version that became
+// noncurrent at noncurrentSince, and has newerNoncurrent newer noncurrent
+// versions, has expired at now
+func (c *Configuration) NoncurrentVersionExpired(obj Object, noncurrentSince time.Time, newerNoncurrent int, now time.Time) bool {
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		n := rule.NoncurrentVersionExpiration
+		if n == nil || !rule.Matches(obj) {
+			continue
+		}
+		if newerNoncurrent < n.NewerNoncurrentVersions {
+			continue
+		}
+		if !now.Before(expiry(noncurrentSince, n.NoncurrentDays)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AbortUploadAfter returns how long after initiation a multipart upload of key
+// should be aborted, and false if no rule applies to it. If several rules
+// apply the shortest period wins
+func (c *Configuration) AbortUploadAfter(key string) (time.Duration, bool) {
+	var after time.Duration
+	found := false
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		a := rule.AbortIncompleteMultipartUpload
+		if a == nil || !rule.matchesKey(key) {
+			continue
+		}
+		d := time.Duration(a.DaysAfterInitiation) * day
+		if !found || d < after {
+			after, found = d, true
+		}
+	}
+	return after, found
+}
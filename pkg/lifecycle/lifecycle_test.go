@@ -0,0 +1,131 @@
+package lifecycle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr bool
+	}{
+		{"expiration days", `<LifecycleConfiguration><Rule><ID>r</ID><Status>Enabled</Status><Filter><Prefix>tmp/</Prefix></Filter><Expiration><Days>7</Days></Expiration></Rule></LifecycleConfiguration>`, false},
+		{"legacy prefix", `<LifecycleConfiguration><Rule><Status>Enabled</Status><Prefix>tmp/</Prefix><Expiration><Date>2030-01-01T00:00:00Z</Date></Expiration></Rule></LifecycleConfiguration>`, false},
+		{"and filter", `<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter><And><Prefix>a/</Prefix><Tag><Key>k</Key><Value>v</Value></Tag></And></Filter><NoncurrentVersionExpiration><NoncurrentDays>3</NoncurrentDays></NoncurrentVersionExpiration></Rule></LifecycleConfiguration>`, false},
+		{"abort uploads", `<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter/><AbortIncompleteMultipartUpload><DaysAfterInitiation>2</DaysAfterInitiation></AbortIncompleteMultipartUpload></Rule></LifecycleConfiguration>`, false},
+		{"not xml", `{}`, true},
+		{"no rules", `<LifecycleConfiguration></LifecycleConfiguration>`, true},
+		{"bad status", `<LifecycleConfiguration><Rule><Status>On</Status><Expiration><Days>1</Days></Expiration></Rule></LifecycleConfiguration>`, true},
+		{"no action", `<LifecycleConfiguration><Rule><Status>Enabled</Status></Rule></LifecycleConfiguration>`, true},
+		{"days and date", `<LifecycleConfiguration><Rule><Status>Enabled</Status><Expiration><Days>1</Days><Date>2030-01-01T00:00:00Z</Date></Expiration></Rule></LifecycleConfiguration>`, true},
+		{"date not midnight", `<LifecycleConfiguration><Rule><Status>Enabled</Status><Expiration><Date>2030-01-01T12:00:00Z</Date></Expiration></Rule></LifecycleConfiguration>`, true},
+		{"zero noncurrent days", `<LifecycleConfiguration><Rule><Status>Enabled</Status><NoncurrentVersionExpiration><NoncurrentDays>0</NoncurrentDays></NoncurrentVersionExpiration></Rule></LifecycleConfiguration>`, true},
+		{"two filter conditions", `<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter><Prefix>a</Prefix><Tag><Key>k</Key><Value>v</Value></Tag></Filter><Expiration><Days>1</Days></Expiration></Rule></LifecycleConfiguration>`, true},
+		{"abort with tag filter", `<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter><Tag><Key>k</Key><Value>v</Value></Tag></Filter><AbortIncompleteMultipartUpload><DaysAfterInitiation>1</DaysAfterInitiation></AbortIncompleteMultipartUpload></Rule></LifecycleConfiguration>`, true},
+		{"duplicate IDs", `<LifecycleConfiguration><Rule><ID>a</ID><Status>Enabled</Status><Expiration><Days>1</Days></Expiration></Rule><Rule><ID>a</ID><Status>Enabled</Status><Expiration><Days>2</Days></Expiration></Rule></LifecycleConfiguration>`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.doc))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func mustParse(t *testing.T, doc string) *Configuration {
+	t.Helper()
+	c, err := Parse([]byte(doc))
+	if err != nil {
+		t.Fatalf("Failed to parse configuration: %v", err)
+	}
+	return c
+}
+
+func TestObjectExpired(t *testing.T) {
+	c := mustParse(t, `<LifecycleConfiguration>
+		<Rule><Status>Enabled</Status><Filter><Prefix>tmp/</Prefix></Filter><Expiration><Days>1</Days></Expiration></Rule>
+		<Rule><Status>Enabled</Status><Filter><Tag><Key>class</Key><Value>scratch</Value></Tag></Filter><Expiration><Date>2030-01-01</Date></Expiration></Rule>
+		<Rule><Status>Disabled</Status><Expiration><Days>1</Days></Expiration></Rule>
+	</LifecycleConfiguration>`)
+
+	created := time.Date(2029, 6, 1, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		obj  Object
+		now  time.Time
+		want bool
+	}{
+		// Days-based expiry rounds up to the next midnight UTC
+		{"prefix before expiry", Object{Key: "tmp/a"}, time.Date(2029, 6, 2, 23, 0, 0, 0, time.UTC), false},
+		{"prefix at expiry", Object{Key: "tmp/a"}, time.Date(2029, 6, 3, 0, 0, 0, 0, time.UTC), true},
+		{"no matching rule", Object{Key: "keep/a"}, time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"tag before date", Object{Key: "a", Tags: map[string]string{"class": "scratch"}}, time.Date(2029, 12, 31, 0, 0, 0, 0, time.UTC), false},
+		{"tag after date", Object{Key: "a", Tags: map[string]string{"class": "scratch"}}, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"tag value mismatch", Object{Key: "a", Tags: map[string]string{"class": "keep"}}, time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.ObjectExpired(tt.obj, created, tt.now); got != tt.want {
+				t.Errorf("ObjectExpired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoncurrentVersionExpired(t *testing.T) {
+	c := mustParse(t, `<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter/>
+		<NoncurrentVersionExpiration><NoncurrentDays>2</NoncurrentDays><NewerNoncurrentVersions>1</NewerNoncurrentVersions></NoncurrentVersionExpiration>
+	</Rule></LifecycleConfiguration>`)
+
+	since := time.Date(2029, 6, 1, 0, 0, 0, 0, time.UTC)
+	later := since.Add(72 * time.Hour)
+
+	if c.NoncurrentVersionExpired(Object{Key: "a"}, since, 1, since.Add(time.Hour)) {
+		t.Error("Expected version to be retained before NoncurrentDays")
+	}
+	if c.NoncurrentVersionExpired(Object{Key: "a"}, since, 0, later) {
+		t.Error("Expected newest noncurrent version to be retained")
+	}
+	if !c.NoncurrentVersionExpired(Object{Key: "a"}, since, 1, later) {
+		t.Error("Expected older noncurrent version to expire")
+	}
+}
+
+func TestAbortUploadAfter(t *testing.T) {
+	c := mustParse(t, `<LifecycleConfiguration>
+		<Rule><Status>Enabled</Status><Filter><Prefix>uploads/</Prefix></Filter><AbortIncompleteMultipartUpload><DaysAfterInitiation>3</DaysAfterInitiation></AbortIncompleteMultipartUpload></Rule>
+		<Rule><Status>Enabled</Status><Filter><Prefix>uploads/fast/</Prefix></Filter><AbortIncompleteMultipartUpload><DaysAfterInitiation>1</DaysAfterInitiation></AbortIncompleteMultipartUpload></Rule>
+	</LifecycleConfiguration>`)
+
+	if d, ok := c.AbortUploadAfter("uploads/a"); !ok || d != 72*time.Hour {
+		t.Errorf("Expected 72h, got %v, %v", d, ok)
+	}
+	if d, ok := c.AbortUploadAfter("uploads/fast/a"); !ok || d != 24*time.Hour {
+		t.Errorf("Expected shortest matching period 24h, got %v, %v", d, ok)
+	}
+	if _, ok := c.AbortUploadAfter("other"); ok {
+		t.Error("Expected no rule to apply")
+	}
+}
+
+func TestRemoveExpiredDeleteMarker(t *testing.T) {
+	c := mustParse(t, `<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter><Prefix>logs/</Prefix></Filter>
+		<Expiration><ExpiredObjectDeleteMarker>true</ExpiredObjectDeleteMarker></Expiration>
+	</Rule></LifecycleConfiguration>`)
+
+	if !c.RemoveExpiredDeleteMarker("logs/a") {
+		t.Error("Expected delete marker under logs/ to be removed")
+	}
+	if c.RemoveExpiredDeleteMarker("data/a") {
+		t.Error("Expected delete marker outside the prefix to be kept")
+	}
+	if c.ObjectExpired(Object{Key: "logs/a"}, time.Time{}, time.Now()) {
+		t.Error("ExpiredObjectDeleteMarker must not expire current objects")
+	}
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounter("test_requests_total", "Requests served.", "operation", "status")
+	requests.Inc("GetObject", "200")
+	requests.Inc("GetObject", "200")
+	requests.Add(3, "PutObject", "500")
+
+	latency := r.NewHistogram("test_duration_seconds", "Request latency.", []float64{0.1, 1}, "operation")
+	latency.Observe(0.05, "GetObject")
+	latency.Observe(0.1, "GetObject")
+	latency.Observe(0.5, "GetObject")
+	latency.Observe(5, "GetObject")
+
+	labels := r.NewCounter("test_labels_total", "Escaping.", "value")
+	labels.Inc("quote \" backslash \\ newline \n")
+
+	r.NewGaugeFunc("test_active", "Active things.", func() float64 { return 7 })
+
+	if got := requests.Value("GetObject", "200"); got != 2 {
+		t.Errorf("Expected counter value 2, got %v", got)
+	}
+	if got := latency.Count("GetObject"); got != 4 {
+		t.Errorf("Expected histogram count 4, got %d", got)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+
+	want := `# HELP test_requests_total Requests served.
+# TYPE test_requests_total counter
+test_requests_total{operation="GetObject",status="200"} 2
+test_requests_total{operation="PutObject",status="500"} 3
+# HELP test_duration_seconds Request latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{operation="GetObject",le="0.1"} 2
+test_duration_seconds_bucket{operation="GetObject",le="1"} 3
+test_duration_seconds_bucket{operation="GetObject",le="+Inf"} 4
+test_duration_seconds_sum{operation="GetObject"} 5.65
+test_duration_seconds_count{operation="GetObject"} 4
+# HELP test_labels_total Escaping.
+# TYPE test_labels_total counter
+test_labels_total{value="quote \" backslash \\ newline \n"} 1
+# HELP test_active Active things.
+# TYPE test_active gauge
+test_active 7
+`
+	if got := w.Body.String(); got != want {
+		t.Errorf("Unexpected exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering a name twice to panic")
+		}
+	}()
+	r.NewGaugeFunc("test_total", "Test.", func() float64 { return 0 })
+}
@@ -0,0 +1,432 @@
+// Package policy parses and evaluates S3 bucket policy documents
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Decision is the outcome of evaluating a policy against a request
+type Decision int
+
+const (
+	// NotApplicable means no statement matched the request
+	NotApplicable Decision = iota
+	// Allow means an Allow statement matched and no Deny statement did
+	Allow
+	// Deny means a Deny statement matched
+	Deny
+)
+
+// ResourceARNPrefix is the ARN prefix of S3 buckets and objects
+const ResourceARNPrefix = "arn:aws:s3:::"
+
+// Policy is a parsed bucket policy document
+type Policy struct {
+	Version   string      `json:"Version,omitempty"`
+	ID        string      `json:"Id,omitempty"`
+	Statement []Statement `json:"Statement"`
+}
+
+// Statement is a single policy statement
+type Statement struct {
+	Sid          string                `json:"Sid,omitempty"`
+	Effect       string                `json:"Effect"`
+	Principal    *Principal            `json:"Principal,omitempty"`
+	NotPrincipal *Principal            `json:"NotPrincipal,omitempty"`
+	Action       stringList            `json:"Action,omitempty"`
+	NotAction    stringList            `json:"NotAction,omitempty"`
+	Resource     stringList            `json:"Resource,omitempty"`
+	NotResource  stringList            `json:"NotResource,omitempty"`
+	Condition    map[string]conditions `json:"Condition,omitempty"`
+}
+
+// conditions maps condition keys to the values they are compared against
+type conditions map[string]stringList
+
+// Principal is either "*" or a set of AWS principals
+type Principal struct {
+	Any bool
+	AWS stringList
+}
+
+// UnmarshalJSON accepts "*" or {"AWS": ...}
+func (p *Principal) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if s != "*" {
+			return fmt.Errorf("invalid principal %q", s)
+		}
+		p.Any = true
+		return nil
+	}
+
+	var m map[string]stringList
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("invalid principal")
+	}
+	p.AWS = m["AWS"]
+	if len(p.AWS) == 0 {
+		return fmt.Errorf("principal must name AWS principals")
+	}
+	return nil
+}
+
+// MarshalJSON writes the principal back in its policy form
+func (p Principal) MarshalJSON() ([]byte, error) {
+	if p.Any {
+		return json.Marshal("*")
+	}
+	return json.Marshal(map[string]stringList{"AWS": p.AWS})
+}
+
+// stringList is a JSON value that may be a single string or an array
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		// Condition values may also be numbers or booleans
+		var raw []json.RawMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			raw = []json.RawMessage{data}
+		}
+		for _, r := range raw {
+			list = append(list, strings.Trim(string(r), "\""))
+		}
+	}
+	*l = list
+	return nil
+}
+
+// Request describes the request being authorized
+type Request struct {
+	// Principal is the access key ID, or "" for anonymous requests
+	Principal string
+	// Action is the S3 action name, e.g. "s3:GetObject"
+	Action string
+	// Bucket and Key identify the resource; Key is empty for bucket-level
+	// actions
+	Bucket string
+	Key    string
+	// Conditions holds the request's condition key values, e.g.
+	// "aws:SourceIp" and "s3:prefix"
+	Conditions map[string]string
+}
+
+// Resource returns the ARN of the resource the request addresses
+func (r *Request) Resource() string {
+	if r.Key == "" {
+		return ResourceARNPrefix + r.Bucket
+	}
+	return ResourceARNPrefix + r.Bucket + "/" + r.Key
+}
+
+// Parse parses and validates a policy document for bucket. Every resource
+// must refer to the bucket or objects within it
+func Parse(data []byte, bucket string) (*Policy, error) {
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		// A single statement may be given as an object rather than an array
+		var single struct {
+			Version   string    `json:"Version,omitempty"`
+			ID        string    `json:"Id,omitempty"`
+			Statement Statement `json:"Statement"`
+		}
+		if err2 := json.Unmarshal(data, &single); err2 != nil {
+			return nil, fmt.Errorf("invalid policy document: %w", err)
+		}
+		p = Policy{Version: single.Version, ID: single.ID, Statement: []Statement{single.Statement}}
+	}
+
+	if len(p.Statement) == 0 {
+		return nil, fmt.Errorf("policy must contain at least one statement")
+	}
+
+	for i, st := range p.Statement {
+		if st.Effect != "Allow" && st.Effect != "Deny" {
+			return nil, fmt.Errorf("statement %d: invalid effect %q", i, st.Effect)
+		}
+		if (st.Principal == nil) == (st.NotPrincipal == nil) {
+			return nil, fmt.Errorf("statement %d: exactly one of Principal or NotPrincipal is required", i)
+		}
+		if (len(st.Action) == 0) == (len(st.NotAction) == 0) {
+			return nil, fmt.Errorf("statement %d: exactly one of Action or NotAction is required", i)
+		}
+		if (len(st.Resource) == 0) == (len(st.NotResource) == 0) {
+			return nil, fmt.Errorf("statement %d: exactly one of Resource or NotResource is required", i)
+		}
+		for _, res := range append(append(stringList{}, st.Resource...), st.NotResource...) {
+			if !resourceInBucket(res, bucket) {
+				return nil, fmt.Errorf("statement %d: policy has invalid resource %q", i, res)
+			}
+		}
+		for op := range st.Condition {
+			if _, ok := conditionOperator(op); !ok {
+				return nil, fmt.Errorf("statement %d: unsupported condition operator %q", i, op)
+			}
+		}
+	}
+
+	return &p, nil
+}
+
+// resourceInBucket reports whether a resource ARN pattern is confined to
+// bucket
+func resourceInBucket(resource, bucket string) bool {
+	rest, ok := strings.CutPrefix(resource, ResourceARNPrefix)
+	if !ok {
+		return false
+	}
+	name, _, _ := strings.Cut(rest, "/")
+	return wildcardMatch(name, bucket, false)
+}
+
+// Evaluate evaluates the policy for a request. An explicit Deny overrides any
+// Allow
+func (p *Policy) Evaluate(req *Request) Decision {
+	decision := NotApplicable
+	for _, st := range p.Statement {
+		if !st.matches(req) {
+			continue
+		}
+		if st.Effect == "Deny" {
+			return Deny
+		}
+		decision = Allow
+	}
+	return decision
+}
+
+func (st *Statement) matches(req *Request) bool {
+	if st.Principal != nil && !st.Principal.matches(req.Principal) {
+		return false
+	}
+	if st.NotPrincipal != nil && st.NotPrincipal.matches(req.Principal) {
+		return false
+	}
+
+	if len(st.Action) > 0 && !matchAny(st.Action, req.Action, true) {
+		return false
+	}
+	if len(st.NotAction) > 0 && matchAny(st.NotAction, req.Action, true) {
+		return false
+	}
+
+	resource := req.Resource()
+	if len(st.Resource) > 0 && !matchAny(st.Resource, resource, false) {
+		return false
+	}
+	if len(st.NotResource) > 0 && matchAny(st.NotResource, resource, false) {
+		return false
+	}
+
+	for op, conds := range st.Condition {
+		for key, values := range conds {
+			if !evaluateCondition(op, req.Conditions, key, values) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// matches reports whether the principal covers the request's access key.
+// Anonymous requests only match "*"
+func (p *Principal) matches(accessKeyID string) bool {
+	if p.Any {
+		return true
+	}
+	for _, v := range p.AWS {
+		if v == "*" {
+			return true
+		}
+		if accessKeyID == "" {
+			continue
+		}
+		// Accept the bare access key ID or an IAM user ARN ending in it
+		if v == accessKeyID || strings.HasSuffix(v, ":user/"+accessKeyID) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, value string, ignoreCase bool) bool {
+	for _, p := range patterns {
+		if wildcardMatch(p, value, ignoreCase) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardMatch matches value against a pattern in which '*' matches any
+// sequence of characters and '?' any single character
+func wildcardMatch(pattern, value string, ignoreCase bool) bool {
+	if ignoreCase {
+		pattern = strings.ToLower(pattern)
+		value = strings.ToLower(value)
+	}
+
+	// Iterative glob match with backtracking on the last '*'
+	p, v := 0, 0
+	star, match := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, v
+			p++
+		case star != -1:
+			p = star + 1
+			match++
+			v = match
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// conditionFunc compares a request value against a policy value
+type conditionFunc func(requestValue, policyValue string) bool
+
+// conditionOperator returns the comparison for a condition operator name,
+// ignoring any IfExists suffix
+func conditionOperator(op string) (conditionFunc, bool) {
+	switch strings.TrimSuffix(op, "IfExists") {
+	case "StringEquals":
+		return func(a, b string) bool { return a == b }, true
+	case "StringNotEquals":
+		return func(a, b string) bool { return a != b }, true
+	case "StringEqualsIgnoreCase":
+		return strings.EqualFold, true
+	case "StringNotEqualsIgnoreCase":
+		return func(a, b string) bool { return !strings.EqualFold(a, b) }, true
+	case "StringLike":
+		return func(a, b string) bool { return wildcardMatch(b, a, false) }, true
+	case "StringNotLike":
+		return func(a, b string) bool { return !wildcardMatch(b, a, false) }, true
+	case "IpAddress":
+		return ipInRange, true
+	case "NotIpAddress":
+		return func(a, b string) bool { return !ipInRange(a, b) }, true
+	case "Bool":
+		return func(a, b string) bool { return strings.EqualFold(a, b) }, true
+	case "NumericEquals", "NumericNotEquals", "NumericLessThan", "NumericLessThanEquals",
+		"NumericGreaterThan", "NumericGreaterThanEquals":
+		return numericCompare(strings.TrimSuffix(op, "IfExists")), true
+	case "DateLessThan", "DateGreaterThan":
+		return dateCompare(strings.TrimSuffix(op, "IfExists")), true
+	}
+	return nil, false
+}
+
+// evaluateCondition applies one operator/key pair. Negated operators must
+// hold for every policy value; the others for at least one. A missing request
+// key fails the condition unless the operator has the IfExists suffix
+func evaluateCondition(op string, requestConditions map[string]string, key string, values []string) bool {
+	compare, ok := conditionOperator(op)
+	if !ok {
+		return false
+	}
+
+	// As in IAM, a missing key fails positive operators and satisfies
+	// negated ones unless the operator ends in IfExists
+	negated := strings.Contains(op, "Not")
+	requestValue, present := lookupCondition(requestConditions, key)
+	if !present {
+		return negated || strings.HasSuffix(op, "IfExists")
+	}
+
+	for _, v := range values {
+		result := compare(requestValue, v)
+		if negated && !result {
+			return false
+		}
+		if !negated && result {
+			return true
+		}
+	}
+	return negated
+}
+
+// lookupCondition finds a condition key case-insensitively, as IAM does
+func lookupCondition(conditions map[string]string, key string) (string, bool) {
+	if v, ok := conditions[key]; ok {
+		return v, true
+	}
+	for k, v := range conditions {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func ipInRange(ip, cidr string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	if !strings.Contains(cidr, "/") {
+		return addr.Equal(net.ParseIP(cidr))
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	return err == nil && network.Contains(addr)
+}
+
+func numericCompare(op string) conditionFunc {
+	return func(a, b string) bool {
+		x, err1 := strconv.ParseFloat(a, 64)
+		y, err2 := strconv.ParseFloat(b, 64)
+		if err1 != nil || err2 != nil {
+			return false
+		}
+		switch op {
+		case "NumericEquals":
+			return x == y
+		case "NumericNotEquals":
+			return x != y
+		case "NumericLessThan":
+			return x < y
+		case "NumericLessThanEquals":
+			return x <= y
+		case "NumericGreaterThan":
+			return x > y
+		default:
+			return x >= y
+		}
+	}
+}
+
+func dateCompare(op string) conditionFunc {
+	return func(a, b string) bool {
+		x, err1 := time.Parse(time.RFC3339, a)
+		y, err2 := time.Parse(time.RFC3339, b)
+		if err1 != nil || err2 != nil {
+			return false
+		}
+		if op == "DateLessThan" {
+			return x.Before(y)
+		}
+		return x.After(y)
+	}
+}
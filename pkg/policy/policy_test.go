@@ -0,0 +1,127 @@
+package policy
+
+import "testing"
+
+const testPolicy = `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Sid": "PublicRead",
+			"Effect": "Allow",
+			"Principal": "*",
+			"Action": "s3:GetObject",
+			"Resource": "arn:aws:s3:::photos/public/*"
+		},
+		{
+			"Sid": "CIWrites",
+			"Effect": "Allow",
+			"Principal": {"AWS": ["arn:aws:iam::123456789012:user/ci"]},
+			"Action": ["s3:Put*", "s3:List*"],
+			"Resource": ["arn:aws:s3:::photos", "arn:aws:s3:::photos/*"]
+		},
+		{
+			"Sid": "OfficeOnly",
+			"Effect": "Deny",
+			"Principal": "*",
+			"Action": "s3:*",
+			"Resource": "arn:aws:s3:::photos/private/*",
+			"Condition": {"NotIpAddress": {"aws:SourceIp": ["10.0.0.0/8", "192.168.1.1"]}}
+		},
+		{
+			"Sid": "HomePrefixOnly",
+			"Effect": "Deny",
+			"Principal": {"AWS": "ci"},
+			"Action": "s3:ListBucket",
+			"Resource": "arn:aws:s3:::photos",
+			"Condition": {"StringNotLike": {"s3:prefix": ["ci/*"]}}
+		}
+	]
+}`
+
+func TestParse(t *testing.T) {
+	if _, err := Parse([]byte(testPolicy), "photos"); err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+
+	// A single statement object is accepted
+	single := `{"Statement": {"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"}}`
+	if p, err := Parse([]byte(single), "photos"); err != nil || len(p.Statement) != 1 {
+		t.Fatalf("Failed to parse single-statement policy: %v", err)
+	}
+
+	invalid := map[string]string{
+		"not json":           `{`,
+		"no statements":      `{"Statement": []}`,
+		"bad effect":         `{"Statement": [{"Effect": "Maybe", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::photos"}]}`,
+		"missing principal":  `{"Statement": [{"Effect": "Allow", "Action": "s3:*", "Resource": "arn:aws:s3:::photos"}]}`,
+		"missing action":     `{"Statement": [{"Effect": "Allow", "Principal": "*", "Resource": "arn:aws:s3:::photos"}]}`,
+		"other bucket":       `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::other/*"}]}`,
+		"unknown operator":   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::photos", "Condition": {"Fuzzy": {"a": "b"}}}]}`,
+		"bad principal type": `{"Statement": [{"Effect": "Allow", "Principal": "someone", "Action": "s3:*", "Resource": "arn:aws:s3:::photos"}]}`,
+	}
+	for name, doc := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse([]byte(doc), "photos"); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]byte(testPolicy), "photos")
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+
+	office := map[string]string{"aws:SourceIp": "10.1.2.3"}
+	home := map[string]string{"aws:SourceIp": "203.0.113.7"}
+
+	tests := []struct {
+		name string
+		req  Request
+		want Decision
+	}{
+		{"anonymous public read", Request{Action: "s3:GetObject", Bucket: "photos", Key: "public/cat.jpg", Conditions: home}, Allow},
+		{"anonymous read elsewhere", Request{Action: "s3:GetObject", Bucket: "photos", Key: "drafts/cat.jpg", Conditions: home}, NotApplicable},
+		{"action case insensitive", Request{Action: "S3:getobject", Bucket: "photos", Key: "public/cat.jpg", Conditions: home}, Allow},
+		{"anonymous write", Request{Action: "s3:PutObject", Bucket: "photos", Key: "public/cat.jpg", Conditions: home}, NotApplicable},
+		{"ci write by ARN", Request{Principal: "ci", Action: "s3:PutObject", Bucket: "photos", Key: "ci/build.zip", Conditions: home}, Allow},
+		{"ci list own prefix", Request{Principal: "ci", Action: "s3:ListBucket", Bucket: "photos", Conditions: map[string]string{"s3:prefix": "ci/logs/"}}, Allow},
+		{"ci list other prefix", Request{Principal: "ci", Action: "s3:ListBucket", Bucket: "photos", Conditions: map[string]string{"s3:prefix": "other/"}}, Deny},
+		{"ci list without prefix", Request{Principal: "ci", Action: "s3:ListBucket", Bucket: "photos", Conditions: map[string]string{}}, Deny},
+		{"private from office", Request{Principal: "ci", Action: "s3:PutObject", Bucket: "photos", Key: "private/x", Conditions: office}, Allow},
+		{"private from single allowed ip", Request{Principal: "ci", Action: "s3:PutObject", Bucket: "photos", Key: "private/x", Conditions: map[string]string{"aws:SourceIp": "192.168.1.1"}}, Allow},
+		{"private from home", Request{Principal: "ci", Action: "s3:PutObject", Bucket: "photos", Key: "private/x", Conditions: home}, Deny},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Evaluate(&tt.req); got != tt.want {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		want           bool
+	}{
+		{"*", "anything", true},
+		{"s3:Get*", "s3:GetObject", true},
+		{"s3:Get*", "s3:PutObject", false},
+		{"arn:aws:s3:::b/*.jpg", "arn:aws:s3:::b/dir/x.jpg", true},
+		{"arn:aws:s3:::b/?.jpg", "arn:aws:s3:::b/x.jpg", true},
+		{"arn:aws:s3:::b/?.jpg", "arn:aws:s3:::b/xy.jpg", false},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+	}
+
+	for _, tt := range tests {
+		if got := wildcardMatch(tt.pattern, tt.value, false); got != tt.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
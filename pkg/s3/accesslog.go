@@ -0,0 +1,359 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/xml"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stut/s3dir/pkg/accesslog"
+	"github.com/stut/s3dir/pkg/auth"
+	"github.com/stut/s3dir/pkg/storage"
+)
+
+// bucketLoggingConfig is the bucket configuration document holding the
+// bucket's logging status XML
+const bucketLoggingConfig = "logging.xml"
+
+// maxLoggingConfigSize bounds logging configuration documents
+const maxLoggingConfigSize = 64 * 1024
+
+// maxPendingLogSize is how much log data is buffered for a target before it
+// is delivered without waiting for the next interval
+const maxPendingLogSize = 4 << 20
+
+// AccessLogMiddleware records every request in logger, which may be nil,
+// and queues it for delivery to the bucket's logging target once
+// StartLogDelivery has been called. It should wrap all other middleware so
+// rejected requests are logged too
+func (h *Handler) AccessLogMiddleware(logger *accesslog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accesslog.Entry{
+			Time:       start,
+			RequestID:  requestID(w),
+			HostID:     w.Header().Get(hostIDHeader),
+			RemoteIP:   remoteIP(r),
+			Operation:  h.logOperationName(r),
+			RequestURI: r.Method + " " + r.RequestURI + " " + r.Proto,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			Host:       r.Host,
+		}
+		entry.Bucket, entry.Key = h.parseRequest(r)
+		if entry.Bucket != "" {
+			entry.BucketOwner = "s3dir"
+		}
+		entry.Requester, entry.AuthType = auth.RequestSigner(r)
+		if entry.AuthType != "" {
+			entry.SignatureVersion = "SigV4"
+		}
+		if r.TLS != nil {
+			entry.CipherSuite = tls.CipherSuiteName(r.TLS.CipherSuite)
+			entry.TLSVersion = "TLSv" + strings.TrimPrefix(tls.VersionName(r.TLS.Version), "TLS ")
+		}
+
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		rw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rw, r)
+
+		entry.TotalTime = time.Since(start)
+		if !rw.headerTime.IsZero() {
+			entry.TurnAroundTime = rw.headerTime.Sub(start)
+		}
+		entry.Status = rw.status
+		entry.ErrorCode = rw.errorCode()
+		entry.BytesSent = rw.written
+		entry.ObjectSize = objectSize(entry.Operation, rw, body.n)
+		entry.VersionID = rw.Header().Get("x-amz-version-id")
+
+		if logger != nil {
+			if err := logger.Log(entry); err != nil {
+				log.Printf("Access log: %v", err)
+			}
+		}
+		if h.logDelivery != nil && entry.Bucket != "" {
+			h.queueLog(entry)
+		}
+	})
+}
+
+// remoteIP returns the address of the client a request came from
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// objectSize returns the total size of the object a successful request read
+// or wrote, or -1 if it did not address an object's content
+func objectSize(operation string, rw *statusRecorder, received int64) int64 {
+	if rw.status >= http.StatusMultipleChoices {
+		return -1
+	}
+
+	switch operation {
+	case "REST.GET.OBJECT", "REST.HEAD.OBJECT":
+		// Ranged responses carry the full size in Content-Range
+		if _, total, found := strings.Cut(rw.Header().Get("Content-Range"), "/"); found {
+			if size, err := strconv.ParseInt(total, 10, 64); err == nil {
+				return size
+			}
+		}
+		if size, err := strconv.ParseInt(rw.Header().Get("Content-Length"), 10, 64); err == nil {
+			return size
+		}
+	case "REST.PUT.OBJECT", "REST.PUT.PART":
+		return received
+	}
+	return -1
+}
+
+// bucketLogging returns where a bucket's access logs are delivered, or nil
+// if logging is not enabled for it
+func (h *Handler) bucketLogging(bucket string) (*LoggingEnabled, error) {
+	data, err := h.storage.GetBucketConfig(bucket, bucketLoggingConfig)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var status BucketLoggingStatus
+	if err := xml.Unmarshal(data, &status); err != nil {
+		return nil, err
+	}
+	return status.LoggingEnabled, nil
+}
+
+// getBucketLogging returns the bucket's logging status, which is empty when
+// logging is disabled
+func (h *Handler) getBucketLogging(w http.ResponseWriter, r *http.Request, bucket string) {
+	target, err := h.bucketLogging(bucket)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	writeXML(w, BucketLoggingStatus{LoggingEnabled: target}, http.StatusOK)
+}
+
+// putBucketLogging enables logging to the target bucket named in the request,
+// or disables logging when it names none. The caller must be allowed to write
+// to the target under the target prefix, by its grants or the target's policy
+func (h *Handler) putBucketLogging(w http.ResponseWriter, r *http.Request, bucket string) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxLoggingConfigSize+1))
+	if err != nil {
+		writeError(w, r, "IncompleteBody", "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxLoggingConfigSize {
+		writeError(w, r, "MalformedXML", "The logging configuration is too large", http.StatusBadRequest)
+		return
+	}
+
+	var status BucketLoggingStatus
+	if err := xml.Unmarshal(data, &status); err != nil {
+		writeError(w, r, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema", http.StatusBadRequest)
+		return
+	}
+
+	if status.LoggingEnabled == nil {
+		h.deleteBucketLogging(w, r, bucket)
+		return
+	}
+
+	target := status.LoggingEnabled
+	if target.TargetBucket == "" {
+		writeError(w, r, "MalformedXML", "The logging configuration must specify a TargetBucket", http.StatusBadRequest)
+		return
+	}
+	if err := h.storage.HeadBucket(target.TargetBucket); err != nil {
+		writeError(w, r, "InvalidTargetBucketForLogging", "The target bucket for logging does not exist", http.StatusBadRequest)
+		return
+	}
+	cred := auth.CredentialFromContext(r.Context())
+	if !h.permitted(r, cred == nil || cred.AllowsPrefix(auth.ActionWrite, target.TargetBucket, target.TargetPrefix), "s3:PutObject", target.TargetBucket, target.TargetPrefix) {
+		writeError(w, r, "InvalidTargetBucketForLogging", "You must have write access to the target bucket for logging", http.StatusBadRequest)
+		return
+	}
+
+	config, err := xml.Marshal(BucketLoggingStatus{LoggingEnabled: target})
+	if err != nil {
+		writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.storage.PutBucketConfig(bucket, bucketLoggingConfig, config); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	h.forgetLoggingTarget(bucket)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteBucketLogging disables logging for the bucket
+func (h *Handler) deleteBucketLogging(w http.ResponseWriter, r *http.Request, bucket string) {
+	if err := h.storage.DeleteBucketConfig(bucket, bucketLoggingConfig); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	h.forgetLoggingTarget(bucket)
+
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// logDelivery buffers the access logs of buckets with logging enabled until
+// they are written to their target buckets
+type logDelivery struct {
+	mu      sync.Mutex
+	pending map[LoggingEnabled]*bytes.Buffer
+	stop    chan struct{}
+
+	// targets caches the logging target of each bucket requests have been
+	// logged for, nil where logging is disabled. generation counts changes
+	// to it, so a target read while one is made is not cached
+	targets    map[string]*LoggingEnabled
+	generation uint64
+}
+
+// StartLogDelivery starts delivering access logs, recorded by
+// AccessLogMiddleware, to the target buckets of buckets with logging enabled
+// every interval, as S3 server access log objects. It must be called before
+// the handler serves requests, and delivery continues until StopLogDelivery
+// is called
+func (h *Handler) StartLogDelivery(interval time.Duration) {
+	stop := make(chan struct{})
+	h.logDelivery = &logDelivery{
+		pending: make(map[LoggingEnabled]*bytes.Buffer),
+		stop:    stop,
+		targets: make(map[string]*LoggingEnabled),
+	}
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.deliverLogs(false)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopLogDelivery stops the delivery worker and delivers the logs still
+// buffered. Call it once no more requests are being served
+func (h *Handler) StopLogDelivery() {
+	d := h.logDelivery
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	if d.stop != nil {
+		close(d.stop)
+		d.stop = nil
+	}
+	d.mu.Unlock()
+	h.deliverLogs(false)
+}
+
+// queueLog buffers an entry for delivery if its bucket has logging enabled
+func (h *Handler) queueLog(entry *accesslog.Entry) {
+	target := h.loggingTarget(entry.Bucket)
+	if target == nil {
+		return
+	}
+
+	d := h.logDelivery
+	d.mu.Lock()
+	buf, ok := d.pending[*target]
+	if !ok {
+		buf = &bytes.Buffer{}
+		d.pending[*target] = buf
+	}
+	buf.Write(entry.Line(accesslog.FormatAWS))
+	full := buf.Len() >= maxPendingLogSize
+	d.mu.Unlock()
+
+	if full {
+		h.deliverLogs(true)
+	}
+}
+
+// loggingTarget returns where a bucket's access logs are delivered, reading
+// its configuration only the first time it is asked for
+func (h *Handler) loggingTarget(bucket string) *LoggingEnabled {
+	d := h.logDelivery
+	d.mu.Lock()
+	target, ok := d.targets[bucket]
+	generation := d.generation
+	d.mu.Unlock()
+	if ok {
+		return target
+	}
+
+	// Missing buckets are not cached, so requests naming them cannot grow
+	// the cache
+	target, err := h.bucketLogging(bucket)
+	if err != nil {
+		return nil
+	}
+	d.mu.Lock()
+	if d.generation == generation {
+		d.targets[bucket] = target
+	}
+	d.mu.Unlock()
+	return target
+}
+
+// forgetLoggingTarget drops a bucket's cached logging target once its
+// logging configuration has changed or the bucket has been deleted
+func (h *Handler) forgetLoggingTarget(bucket string) {
+	d := h.logDelivery
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	delete(d.targets, bucket)
+	d.generation++
+	d.mu.Unlock()
+}
+
+// deliverLogs writes the buffered logs of each target as a new object named
+// <TargetPrefix>YYYY-mm-DD-HH-MM-SS-<unique>. With onlyFull set, only
+// targets that have reached maxPendingLogSize are delivered
+func (h *Handler) deliverLogs(onlyFull bool) {
+	d := h.logDelivery
+	d.mu.Lock()
+	batches := make(map[LoggingEnabled]*bytes.Buffer)
+	for target, buf := range d.pending {
+		if !onlyFull || buf.Len() >= maxPendingLogSize {
+			batches[target] = buf
+			delete(d.pending, target)
+		}
+	}
+	d.mu.Unlock()
+
+	for target, buf := range batches {
+		key := target.TargetPrefix + time.Now().UTC().Format("2006-01-02-15-04-05") + "-" + newRequestID()
+		metadata := storage.ObjectMetadata{ContentType: "text/plain"}
+		if _, err := h.storage.PutObjectWithMetadata(target.TargetBucket, key, buf, int64(buf.Len()), metadata); err != nil {
+			log.Printf("Access log: failed to deliver logs to %s/%s: %v", target.TargetBucket, key, err)
+		}
+	}
+}
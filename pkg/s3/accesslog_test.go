@@ -0,0 +1,212 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stut/s3dir/pkg/accesslog"
+	"github.com/stut/s3dir/pkg/auth"
+)
+
+func TestLogOperationName(t *testing.T) {
+	handler := &Handler{}
+
+	tests := []struct {
+		method string
+		target string
+		want   string
+	}{
+		{http.MethodGet, "/", "REST.GET.SERVICE"},
+		{http.MethodGet, "/bucket?list-type=2", "REST.GET.BUCKET"},
+		{http.MethodPut, "/bucket", "REST.PUT.BUCKET"},
+		{http.MethodPost, "/bucket?delete", "REST.POST.MULTI_OBJECT_DELETE"},
+		{http.MethodGet, "/bucket?versions", "REST.GET.BUCKETVERSIONS"},
+		{http.MethodPut, "/bucket?lifecycle", "REST.PUT.LIFECYCLE"},
+		{http.MethodGet, "/bucket?policy", "REST.GET.BUCKETPOLICY"},
+		{http.MethodPut, "/bucket?logging", "REST.PUT.LOGGING_STATUS"},
+		{http.MethodGet, "/bucket?object-lock", "REST.GET.OBJECT_LOCK_CONFIGURATION"},
+		{http.MethodGet, "/bucket/key", "REST.GET.OBJECT"},
+		{http.MethodHead, "/bucket/key", "REST.HEAD.OBJECT"},
+		{http.MethodPut, "/bucket/key?partNumber=1&uploadId=u", "REST.PUT.PART"},
+		{http.MethodPost, "/bucket/key?uploads", "REST.POST.UPLOADS"},
+		{http.MethodGet, "/bucket/key?tagging", "REST.GET.OBJECT_TAGGING"},
+		{http.MethodPut, "/bucket/key?acl", "REST.PUT.ACL"},
+		{http.MethodPatch, "/bucket/key", "REST.PATCH.UNKNOWN"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if got := handler.logOperationName(req); got != tt.want {
+			t.Errorf("%s %s: got %s, want %s", tt.method, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+	store.CreateBucket("bucket")
+
+	var buf bytes.Buffer
+	server := handler.AccessLogMiddleware(accesslog.New(&buf, accesslog.FormatJSON), handler)
+
+	do := func(method, target, body string, header http.Header) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	do(http.MethodPut, "/bucket/key", "hello world", http.Header{
+		"Authorization": {"AWS4-HMAC-SHA256 Credential=AKID/20240101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=abc"},
+		"User-Agent":    {"test-agent"},
+	})
+	do(http.MethodGet, "/bucket/key", "", http.Header{"Range": {"bytes=0-4"}})
+	do(http.MethodGet, "/bucket/missing", "", nil)
+
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+
+	expect := func(i int, fields map[string]any) {
+		t.Helper()
+		for field, want := range fields {
+			if entries[i][field] != want {
+				t.Errorf("Entry %d: expected %s %v, got %v", i, field, want, entries[i][field])
+			}
+		}
+	}
+	expect(0, map[string]any{
+		"operation":  "REST.PUT.OBJECT",
+		"bucket":     "bucket",
+		"key":        "key",
+		"status":     float64(200),
+		"requester":  "AKID",
+		"authType":   "AuthHeader",
+		"objectSize": float64(11),
+		"userAgent":  "test-agent",
+		"remoteIp":   "192.0.2.1",
+		"requestUri": "PUT /bucket/key HTTP/1.1",
+	})
+	expect(1, map[string]any{
+		"operation":  "REST.GET.OBJECT",
+		"status":     float64(206),
+		"bytesSent":  float64(5),
+		"objectSize": float64(11),
+	})
+	expect(2, map[string]any{
+		"operation": "REST.GET.OBJECT",
+		"key":       "missing",
+		"status":    float64(404),
+		"errorCode": "NoSuchKey",
+	})
+	if _, ok := entries[2]["objectSize"]; ok {
+		t.Error("Expected no object size for a failed request")
+	}
+	if entries[0]["requestId"] == "" || entries[0]["requestId"] == entries[1]["requestId"] {
+		t.Error("Expected a unique request ID per entry")
+	}
+}
+
+func TestBucketLogging(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+	store.CreateBucket("source")
+	store.CreateBucket("logs")
+
+	w := serve(handler, http.MethodGet, "/source?logging", "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "LoggingEnabled") {
+		t.Errorf("Expected an empty logging status, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = serve(handler, http.MethodPut, "/source?logging", `<BucketLoggingStatus><LoggingEnabled><TargetBucket>missing</TargetBucket><TargetPrefix>access/</TargetPrefix></LoggingEnabled></BucketLoggingStatus>`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "InvalidTargetBucketForLogging") {
+		t.Errorf("Expected InvalidTargetBucketForLogging, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Anonymous callers the source's policy lets configure logging still
+	// need the target's permission to write to it
+	policy := `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:PutBucketLogging", "Resource": "arn:aws:s3:::source"}]}`
+	if w := serve(handler, http.MethodPut, "/source?policy", policy); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT policy: expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	req := httptest.NewRequest(http.MethodPut, "/source?logging", strings.NewReader(`<BucketLoggingStatus><LoggingEnabled><TargetBucket>logs</TargetBucket><TargetPrefix>access/</TargetPrefix></LoggingEnabled></BucketLoggingStatus>`))
+	req = req.WithContext(auth.WithCredential(req.Context(), auth.Anonymous))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "InvalidTargetBucketForLogging") {
+		t.Errorf("Anonymous caller: expected InvalidTargetBucketForLogging, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = serve(handler, http.MethodPut, "/source?logging", `<BucketLoggingStatus xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><LoggingEnabled><TargetBucket>logs</TargetBucket><TargetPrefix>access/</TargetPrefix></LoggingEnabled></BucketLoggingStatus>`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PutBucketLogging failed: %d %s", w.Code, w.Body.String())
+	}
+	w = serve(handler, http.MethodGet, "/source?logging", "")
+	if !strings.Contains(w.Body.String(), "<TargetBucket>logs</TargetBucket>") || !strings.Contains(w.Body.String(), "<TargetPrefix>access/</TargetPrefix>") {
+		t.Errorf("Unexpected logging status: %s", w.Body.String())
+	}
+
+	handler.StartLogDelivery(time.Hour)
+	server := handler.AccessLogMiddleware(nil, handler)
+	for _, target := range []string{"/source/a", "/source/b"} {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, target, strings.NewReader("data")))
+	}
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/logs", nil))
+	handler.StopLogDelivery()
+
+	// Each bucket's target is read once, including that it has none
+	if target, ok := handler.logDelivery.targets["source"]; !ok || target == nil || target.TargetBucket != "logs" {
+		t.Errorf("Expected the source bucket's target to be cached, got %+v", target)
+	}
+	if target, ok := handler.logDelivery.targets["logs"]; !ok || target != nil {
+		t.Errorf("Expected the logs bucket to be cached without a target, got %+v", target)
+	}
+
+	objects, _, _, _, err := store.ListObjectsPage("logs", "access/", "", "", 1000)
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("Expected one delivered log object, got %d (%v)", len(objects), err)
+	}
+	reader, _, err := store.GetObjectVersion("logs", objects[0].Key, "")
+	if err != nil {
+		t.Fatalf("Failed to read log object: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines for the source bucket only, got %q", data)
+	}
+	if !strings.HasPrefix(lines[0], "s3dir source [") || !strings.Contains(lines[0], " REST.PUT.OBJECT a ") {
+		t.Errorf("Unexpected log line %q", lines[0])
+	}
+
+	// An empty status disables logging
+	w = serve(handler, http.MethodPut, "/source?logging", `<BucketLoggingStatus/>`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Disabling logging failed: %d %s", w.Code, w.Body.String())
+	}
+	if target, _ := handler.bucketLogging("source"); target != nil {
+		t.Errorf("Expected logging to be disabled, got %+v", target)
+	}
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/source/c", strings.NewReader("data")))
+	if len(handler.logDelivery.pending) != 0 {
+		t.Errorf("Expected no logs queued once logging is disabled, got %d targets", len(handler.logDelivery.pending))
+	}
+}
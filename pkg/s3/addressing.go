@@ -0,0 +1,73 @@
+package s3
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/stut/s3dir/pkg/storage"
+)
+
+// SetDomains configures the base domains for virtual-hosted-style requests.
+// A request whose Host is "<bucket>.<domain>" addresses that bucket, with
+// the whole path as the key; any other request is treated as path-style.
+// Domains are host names without a port, e.g. "s3.local"
+func (h *Handler) SetDomains(domains []string) {
+	h.domains = nil
+	for _, domain := range domains {
+		domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain != "" {
+			h.domains = append(h.domains, domain)
+		}
+	}
+}
+
+// hostBucket returns the bucket named by a virtual-hosted-style Host header,
+// if host is a subdomain of a configured base domain
+func (h *Handler) hostBucket(host string) (string, bool) {
+	if len(h.domains) == 0 {
+		return "", false
+	}
+
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	for _, domain := range h.domains {
+		if bucket, ok := strings.CutSuffix(host, "."+domain); ok && bucket != "" {
+			return bucket, true
+		}
+	}
+	return "", false
+}
+
+// parseRequest returns the bucket and key a request addresses, taking the
+// bucket from the Host header for virtual-hosted-style requests and from the
+// path otherwise
+func (h *Handler) parseRequest(r *http.Request) (bucket, key string) {
+	if bucket, ok := h.hostBucket(r.Host); ok {
+		return bucket, strings.TrimPrefix(r.URL.Path, "/")
+	}
+	return h.parsePath(r.URL.Path)
+}
+
+// copySource parses the request's x-amz-copy-source header. The source
+// bucket may also be given in virtual-hosted form, "<bucket>.<domain>/key"
+func (h *Handler) copySource(r *http.Request) (bucket, key, versionID string, err error) {
+	bucket, key, versionID, err = parseCopySource(r.Header.Get("x-amz-copy-source"))
+	if err != nil {
+		return "", "", "", err
+	}
+	if hostBucket, ok := h.hostBucket(bucket); ok {
+		bucket = hostBucket
+	}
+	if !storage.ValidBucketName(bucket) {
+		return "", "", "", fmt.Errorf("invalid copy source bucket %q", bucket)
+	}
+	if err := storage.ValidKey(key); err != nil {
+		return "", "", "", fmt.Errorf("invalid copy source key: %w", err)
+	}
+	return bucket, key, versionID, nil
+}
@@ -0,0 +1,125 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseRequest(t *testing.T) {
+	handler := &Handler{}
+	handler.SetDomains([]string{"s3.local", " .S3.Example.com. ", ""})
+
+	tests := []struct {
+		host       string
+		path       string
+		wantBucket string
+		wantKey    string
+	}{
+		{"bucket.s3.local", "/", "bucket", ""},
+		{"bucket.s3.local:8000", "/key", "bucket", "key"},
+		{"bucket.s3.local", "/path/to/key/", "bucket", "path/to/key/"},
+		{"Bucket.S3.Local.", "/key", "bucket", "key"},
+		{"my.dotted.bucket.s3.example.com", "/key", "my.dotted.bucket", "key"},
+		// Anything else is path-style
+		{"s3.local:8000", "/", "", ""},
+		{"s3.local", "/bucket/key", "bucket", "key"},
+		{"localhost:8000", "/bucket/key", "bucket", "key"},
+		{"127.0.0.1:8000", "/bucket", "bucket", ""},
+		{"[::1]:8000", "/bucket/key", "bucket", "key"},
+		{"bucket.other.local", "/bucket/key", "bucket", "key"},
+		{"bucket.nots3.local", "/bucket/key", "bucket", "key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Host = tt.host
+			bucket, key := handler.parseRequest(req)
+			if bucket != tt.wantBucket || key != tt.wantKey {
+				t.Errorf("parseRequest(%s%s) = %q, %q, want %q, %q", tt.host, tt.path, bucket, key, tt.wantBucket, tt.wantKey)
+			}
+		})
+	}
+
+	// Without configured domains every request is path-style
+	req := httptest.NewRequest(http.MethodGet, "/bucket/key", nil)
+	req.Host = "other.s3.local"
+	if bucket, key := (&Handler{}).parseRequest(req); bucket != "bucket" || key != "key" {
+		t.Errorf("Without domains: got %q, %q", bucket, key)
+	}
+}
+
+func TestVirtualHostedStyle(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+	handler.SetDomains([]string{"s3.local"})
+
+	serveHost := func(method, host, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Host = host
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler.CORSMiddleware(handler).ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serveHost(http.MethodPut, "photos.s3.local:8000", "/", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("Create bucket: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveHost(http.MethodPut, "photos.s3.local:8000", "/cats/tom.jpg", "meow", nil); w.Code != http.StatusOK {
+		t.Fatalf("Put object: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The same object is reachable path-style
+	if w := serveHost(http.MethodGet, "s3.local:8000", "/photos/cats/tom.jpg", "", nil); w.Body.String() != "meow" {
+		t.Errorf("Path-style get: got %d %q", w.Code, w.Body.String())
+	}
+	if w := serveHost(http.MethodGet, "photos.s3.local", "/cats/tom.jpg", "", nil); w.Body.String() != "meow" {
+		t.Errorf("Virtual-hosted get: got %d %q", w.Code, w.Body.String())
+	}
+
+	// Copy sources may be path-style or virtual-hosted
+	for _, source := range []string{"/photos/cats/tom.jpg", "photos/cats/tom.jpg", "photos.s3.local/cats/tom.jpg"} {
+		w := serveHost(http.MethodPut, "photos.s3.local", "/copy.jpg", "", map[string]string{"x-amz-copy-source": source})
+		if w.Code != http.StatusOK {
+			t.Errorf("Copy from %s: expected 200, got %d: %s", source, w.Code, w.Body.String())
+			continue
+		}
+		if w := serveHost(http.MethodGet, "photos.s3.local", "/copy.jpg", "", nil); w.Body.String() != "meow" {
+			t.Errorf("Copy from %s: got %q", source, w.Body.String())
+		}
+	}
+
+	w := serveHost(http.MethodGet, "photos.s3.local", "/?list-type=2&prefix=cats/", "", nil)
+	var list ListObjectsV2Response
+	if err := xml.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to parse listing: %v", err)
+	}
+	if list.Name != "photos" || len(list.Contents) != 1 || list.Contents[0].Key != "cats/tom.jpg" {
+		t.Errorf("List: got %s", w.Body.String())
+	}
+
+	// Bucket subresources and preflights resolve the bucket from the host
+	cors := `<CORSConfiguration><CORSRule><AllowedOrigin>*</AllowedOrigin><AllowedMethod>GET</AllowedMethod></CORSRule></CORSConfiguration>`
+	if w := serveHost(http.MethodPut, "photos.s3.local", "/?cors", cors, nil); w.Code != http.StatusOK {
+		t.Fatalf("Put CORS: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = serveHost(http.MethodOptions, "photos.s3.local", "/cats/tom.jpg", "", map[string]string{
+		"Origin":                        "http://example.com",
+		"Access-Control-Request-Method": "GET",
+	})
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("Preflight: got %d %v", w.Code, w.Header())
+	}
+
+	// The base domain itself lists buckets
+	w = serveHost(http.MethodGet, "s3.local", "/", "", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<Name>photos</Name>") {
+		t.Errorf("List buckets: got %d %s", w.Code, w.Body.String())
+	}
+}
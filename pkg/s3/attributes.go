@@ -0,0 +1,135 @@
+package s3
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/stut/s3dir/pkg/auth"
+)
+
+// objectAttributes are the names x-amz-object-attributes may select
+var objectAttributes = map[string]bool{
+	"ETag":         true,
+	"Checksum":     true,
+	"ObjectParts":  true,
+	"StorageClass": true,
+	"ObjectSize":   true,
+}
+
+// getObjectAttributes returns the attributes of an object selected by
+// x-amz-object-attributes, without its content. ObjectParts is only reported
+// for objects completed from a multipart upload, paged by x-amz-max-parts and
+// x-amz-part-number-marker
+func (h *Handler) getObjectAttributes(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	op := "s3:GetObjectAttributes"
+	if r.URL.Query().Get("versionId") != "" {
+		op = "s3:GetObjectVersionAttributes"
+	}
+	if !h.authorize(w, r, auth.ActionRead, op, bucket, key) {
+		return
+	}
+
+	selected := make(map[string]bool)
+	for _, value := range r.Header.Values("X-Amz-Object-Attributes") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if !objectAttributes[name] {
+				writeError(w, r, "InvalidArgument", "Invalid attribute name specified.", http.StatusBadRequest)
+				return
+			}
+			selected[name] = true
+		}
+	}
+	if len(selected) == 0 {
+		writeError(w, r, "InvalidRequest", "The x-amz-object-attributes header specifying the attributes to be retrieved is either missing or empty", http.StatusBadRequest)
+		return
+	}
+
+	maxParts, marker, ok := partsPage(w, r)
+	if !ok {
+		return
+	}
+
+	info, ok := h.headObjectVersion(w, r, bucket, key)
+	if !ok {
+		return
+	}
+
+	var response GetObjectAttributesResponse
+	if selected["ETag"] {
+		response.ETag = strings.Trim(info.ETag, "\"")
+	}
+	if selected["Checksum"] && info.Checksum != nil {
+		response.Checksum = &ObjectChecksum{
+			Checksums:    checksumElements(info.Checksum),
+			ChecksumType: info.Checksum.Type,
+		}
+	}
+	if selected["ObjectParts"] && len(info.Parts) > 0 {
+		parts := &ObjectParts{
+			TotalPartsCount:  len(info.Parts),
+			PartNumberMarker: marker,
+			MaxParts:         maxParts,
+		}
+		for _, p := range info.Parts {
+			if p.PartNumber <= marker {
+				continue
+			}
+			if len(parts.Parts) == maxParts {
+				parts.IsTruncated = true
+				break
+			}
+			parts.Parts = append(parts.Parts, ObjectPart{
+				PartNumber: p.PartNumber,
+				Size:       p.Size,
+				Checksums:  checksumElements(p.Checksum),
+			})
+			parts.NextPartNumberMarker = p.PartNumber
+		}
+		response.ObjectParts = parts
+	}
+	if selected["StorageClass"] {
+		response.StorageClass = "STANDARD"
+	}
+	if selected["ObjectSize"] {
+		response.ObjectSize = &info.Size
+	}
+
+	w.Header().Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	if info.VersionID != "" {
+		w.Header().Set("x-amz-version-id", info.VersionID)
+	}
+	writeXML(w, response, http.StatusOK)
+}
+
+// partsPage reads the x-amz-max-parts and x-amz-part-number-marker headers
+// paging the parts of a GetObjectAttributes response
+func partsPage(w http.ResponseWriter, r *http.Request) (maxParts, marker int, ok bool) {
+	maxParts = 1000
+	if value := r.Header.Get("X-Amz-Max-Parts"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, r, "InvalidArgument", "x-amz-max-parts must be a non-negative integer", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		maxParts = min(n, 1000)
+	}
+	if value := r.Header.Get("X-Amz-Part-Number-Marker"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, r, "InvalidArgument", "x-amz-part-number-marker must be a non-negative integer", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		marker = n
+	}
+	return maxParts, marker, true
+}
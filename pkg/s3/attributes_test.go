@@ -0,0 +1,105 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stut/s3dir/pkg/storage"
+)
+
+func TestGetObjectAttributes(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+
+	uploadID, _ := store.InitiateMultipartUpload("test-bucket", "multipart.bin")
+	var parts []storage.CompletePart
+	for i, content := range []string{"part-one-", "part-two-", "three"} {
+		etag, err := store.UploadPart(uploadID, i+1, strings.NewReader(content), int64(len(content)))
+		if err != nil {
+			t.Fatalf("Failed to upload part %d: %v", i+1, err)
+		}
+		parts = append(parts, storage.CompletePart{PartNumber: i + 1, ETag: etag})
+	}
+	info, err := store.CompleteMultipartUpload(uploadID, parts)
+	if err != nil {
+		t.Fatalf("Failed to complete upload: %v", err)
+	}
+	store.PutObject("test-bucket", "single.txt", strings.NewReader("Hello"), 5)
+
+	attributes := func(key, selected string, headers map[string]string) (*httptest.ResponseRecorder, GetObjectAttributesResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/test-bucket/"+key+"?attributes", nil)
+		if selected != "" {
+			req.Header.Set("x-amz-object-attributes", selected)
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		var response GetObjectAttributesResponse
+		if w.Code == http.StatusOK {
+			if err := xml.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+		}
+		return w, response
+	}
+
+	w, response := attributes("multipart.bin", "ETag,Checksum,ObjectParts,StorageClass,ObjectSize", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if response.ETag != strings.Trim(info.ETag, "\"") {
+		t.Errorf("Expected unquoted ETag %s, got %s", info.ETag, response.ETag)
+	}
+	if response.ObjectSize == nil || *response.ObjectSize != 23 {
+		t.Errorf("Expected ObjectSize 23, got %v", response.ObjectSize)
+	}
+	if response.StorageClass != "STANDARD" {
+		t.Errorf("Expected StorageClass STANDARD, got %q", response.StorageClass)
+	}
+	if response.Checksum == nil || response.Checksum.ChecksumCRC64NVME == "" || response.Checksum.ChecksumType != "FULL_OBJECT" {
+		t.Errorf("Expected the object's CRC64NVME checksum, got %+v", response.Checksum)
+	}
+	if response.ObjectParts == nil || response.ObjectParts.TotalPartsCount != 3 || len(response.ObjectParts.Parts) != 3 {
+		t.Fatalf("Expected 3 object parts, got %+v", response.ObjectParts)
+	}
+	if p := response.ObjectParts.Parts[2]; p.PartNumber != 3 || p.Size != 5 {
+		t.Errorf("Expected part 3 of 5 bytes, got %+v", p)
+	}
+
+	// Only the selected attributes are returned, and parts are paged
+	_, response = attributes("multipart.bin", "ObjectParts", map[string]string{
+		"x-amz-max-parts":          "1",
+		"x-amz-part-number-marker": "1",
+	})
+	if response.ETag != "" || response.ObjectSize != nil || response.Checksum != nil {
+		t.Errorf("Expected only ObjectParts, got %+v", response)
+	}
+	page := response.ObjectParts
+	if page == nil || len(page.Parts) != 1 || page.Parts[0].PartNumber != 2 || !page.IsTruncated || page.NextPartNumberMarker != 2 {
+		t.Errorf("Expected a truncated page holding part 2, got %+v", page)
+	}
+
+	// Objects written in one piece have no parts
+	_, response = attributes("single.txt", "ObjectParts,ObjectSize", nil)
+	if response.ObjectParts != nil || response.ObjectSize == nil || *response.ObjectSize != 5 {
+		t.Errorf("Expected a size and no parts for a single PUT, got %+v", response)
+	}
+
+	if w, _ := attributes("single.txt", "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Missing x-amz-object-attributes: expected 400, got %d", w.Code)
+	}
+	if w, _ := attributes("single.txt", "ETag,Owner", nil); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "InvalidArgument") {
+		t.Errorf("Unknown attribute: expected 400 InvalidArgument, got %d", w.Code)
+	}
+	if w, _ := attributes("missing.txt", "ETag", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Missing object: expected 404, got %d", w.Code)
+	}
+}
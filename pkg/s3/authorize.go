@@ -0,0 +1,195 @@
+package s3
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stut/s3dir/pkg/auth"
+	"github.com/stut/s3dir/pkg/policy"
+)
+
+// bucketPolicyConfig is the bucket configuration document holding the
+// bucket policy JSON
+const bucketPolicyConfig = "policy.json"
+
+// authorize reports whether the request may perform the S3 action op on key
+// in bucket (an empty key meaning the bucket itself), writing an AccessDenied
+// response if not. class is the credential action the operation falls under
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, class auth.Action, op, bucket, key string) bool {
+	if h.readOnly && class != auth.ActionRead {
+		writeError(w, r, "AccessDenied", "Read-only mode", http.StatusForbidden)
+		return false
+	}
+
+	cred := auth.CredentialFromContext(r.Context())
+	if !h.permitted(r, cred == nil || cred.Allows(class, bucket, key), op, bucket, key) {
+		writeError(w, r, "AccessDenied", "Access Denied", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// permitted combines the identity-based decision (the credential's grants,
+// or true when authentication is disabled) with the bucket policy. An
+// explicit Deny in the policy always wins, and an Allow grants access the
+// credential alone would not, e.g. anonymous public reads
+func (h *Handler) permitted(r *http.Request, identityAllowed bool, op, bucket, key string) bool {
+	// Callers allowed to manage the policy can always do so, so a bad policy
+	// cannot lock everyone out of the bucket
+	if identityAllowed && strings.HasSuffix(op, "BucketPolicy") {
+		return true
+	}
+
+	bucketPolicy := h.bucketPolicy(bucket)
+	if bucketPolicy == nil {
+		return identityAllowed
+	}
+
+	switch bucketPolicy.Evaluate(policyRequest(r, op, bucket, key)) {
+	case policy.Deny:
+		return false
+	case policy.Allow:
+		return true
+	default:
+		return identityAllowed
+	}
+}
+
+// bucketPolicy loads and parses the stored policy for a bucket, returning nil
+// if the bucket has none
+func (h *Handler) bucketPolicy(bucket string) *policy.Policy {
+	data, err := h.storage.GetBucketConfig(bucket, bucketPolicyConfig)
+	if err != nil || data == nil {
+		return nil
+	}
+
+	// Policies are validated when stored, so a parse failure means the file
+	// was edited by hand; fail closed rather than ignore it
+	p, err := policy.Parse(data, bucket)
+	if err != nil {
+		return &policy.Policy{Statement: []policy.Statement{denyAll}}
+	}
+	return p
+}
+
+// denyAll is the statement applied in place of an unparseable policy
+var denyAll = policy.Statement{
+	Effect:    "Deny",
+	Principal: &policy.Principal{Any: true},
+	Action:    []string{"*"},
+	Resource:  []string{"*"},
+}
+
+// policyRequest describes r for policy evaluation, including the global and
+// S3-specific condition keys s3dir can supply
+func policyRequest(r *http.Request, op, bucket, key string) *policy.Request {
+	req := &policy.Request{
+		Action: op,
+		Bucket: bucket,
+		Key:    key,
+		Conditions: map[string]string{
+			"aws:SecureTransport": "false",
+			"aws:CurrentTime":     time.Now().UTC().Format(time.RFC3339),
+			"aws:EpochTime":       strconv.FormatInt(time.Now().Unix(), 10),
+		},
+	}
+
+	if cred := auth.CredentialFromContext(r.Context()); cred != nil && !cred.IsAnonymous() {
+		req.Principal = cred.AccessKeyID
+		req.Conditions["aws:username"] = cred.AccessKeyID
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		req.Conditions["aws:SourceIp"] = host
+	} else {
+		req.Conditions["aws:SourceIp"] = r.RemoteAddr
+	}
+	if r.TLS != nil {
+		req.Conditions["aws:SecureTransport"] = "true"
+	}
+	if ua := r.UserAgent(); ua != "" {
+		req.Conditions["aws:UserAgent"] = ua
+	}
+	if referer := r.Referer(); referer != "" {
+		req.Conditions["aws:Referer"] = referer
+	}
+
+	// Listing condition keys only exist for ListBucket
+	if op == "s3:ListBucket" {
+		query := r.URL.Query()
+		for _, name := range []string{"prefix", "delimiter", "max-keys"} {
+			if query.Has(name) {
+				req.Conditions["s3:"+name] = query.Get(name)
+			}
+		}
+	}
+
+	return req
+}
+
+// bucketSubresourceActions maps bucket subresources to the S3 actions used to
+// read and write them. Deleting a configuration uses the write action, as in
+// S3, except for the policy
+var bucketSubresourceActions = map[string][2]string{
+	"accelerate":     {"s3:GetAccelerateConfiguration", "s3:PutAccelerateConfiguration"},
+	"acl":            {"s3:GetBucketAcl", "s3:PutBucketAcl"},
+	"cors":           {"s3:GetBucketCORS", "s3:PutBucketCORS"},
+	"encryption":     {"s3:GetEncryptionConfiguration", "s3:PutEncryptionConfiguration"},
+	"lifecycle":      {"s3:GetLifecycleConfiguration", "s3:PutLifecycleConfiguration"},
+	"location":       {"s3:GetBucketLocation", "s3:GetBucketLocation"},
+	"logging":        {"s3:GetBucketLogging", "s3:PutBucketLogging"},
+	"notification":   {"s3:GetBucketNotification", "s3:PutBucketNotification"},
+	"object-lock":    {"s3:GetBucketObjectLockConfiguration", "s3:PutBucketObjectLockConfiguration"},
+	"policy":         {"s3:GetBucketPolicy", "s3:PutBucketPolicy"},
+	"replication":    {"s3:GetReplicationConfiguration", "s3:PutReplicationConfiguration"},
+	"requestPayment": {"s3:GetBucketRequestPayment", "s3:PutBucketRequestPayment"},
+	"tagging":        {"s3:GetBucketTagging", "s3:PutBucketTagging"},
+	"versioning":     {"s3:GetBucketVersioning", "s3:PutBucketVersioning"},
+	"website":        {"s3:GetBucketWebsite", "s3:PutBucketWebsite"},
+}
+
+// bucketSubresourceAction returns the S3 action for a request to a bucket
+// subresource
+func bucketSubresourceAction(r *http.Request) string {
+	query := r.URL.Query()
+	for _, sub := range bucketSubresources {
+		if !query.Has(sub) {
+			continue
+		}
+		actions := bucketSubresourceActions[sub]
+		switch {
+		case r.Method == http.MethodGet:
+			return actions[0]
+		case r.Method == http.MethodDelete && sub == "policy":
+			return "s3:DeleteBucketPolicy"
+		default:
+			return actions[1]
+		}
+	}
+	return ""
+}
+
+// objectSubresourceAction returns the S3 action for a request to an object
+// subresource (?acl or ?tagging)
+func objectSubresourceAction(r *http.Request) string {
+	name := "Tagging"
+	if r.URL.Query().Has("acl") {
+		name = "Acl"
+	}
+	if r.URL.Query().Get("versionId") != "" {
+		name = "Version" + name
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		return "s3:GetObject" + name
+	case http.MethodDelete:
+		return "s3:DeleteObject" + name
+	default:
+		return "s3:PutObject" + name
+	}
+}
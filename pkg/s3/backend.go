@@ -0,0 +1,60 @@
+package s3
+
+import (
+	"io"
+
+	"github.com/stut/s3dir/pkg/storage"
+)
+
+// Backend is the storage the handler serves requests from. Failures are
+// reported with the storage.Err* errors, possibly wrapped, which
+// writeStorageError maps to S3 errors
+type Backend interface {
+	// Buckets
+	ListBuckets() ([]string, error)
+	CreateBucket(bucket string) error
+	DeleteBucket(bucket string) error
+	HeadBucket(bucket string) error
+
+	// Bucket configuration. GetBucketConfig returns nil for documents that
+	// have not been stored
+	GetBucketConfig(bucket, name string) ([]byte, error)
+	PutBucketConfig(bucket, name string, data []byte) error
+	DeleteBucketConfig(bucket, name string) error
+	GetBucketVersioning(bucket string) (string, error)
+	PutBucketVersioning(bucket, status string) error
+	GetBucketLifecycle(bucket string) ([]byte, error)
+	PutBucketLifecycle(bucket string, data []byte) error
+	DeleteBucketLifecycle(bucket string) error
+
+	// Objects. An empty versionID addresses the current version
+	PutObjectWithMetadata(bucket, key string, reader io.Reader, size int64, metadata storage.ObjectMetadata) (*storage.ObjectInfo, error)
+	GetObjectVersion(bucket, key, versionID string) (io.ReadCloser, *storage.ObjectInfo, error)
+	GetObjectRangeVersion(bucket, key, versionID string, start, length int64) (io.ReadCloser, *storage.ObjectInfo, error)
+	HeadObject(bucket, key string) (*storage.ObjectInfo, error)
+	HeadObjectVersion(bucket, key, versionID string) (*storage.ObjectInfo, error)
+	CopyObjectWithMetadata(srcBucket, srcKey, srcVersionID, dstBucket, dstKey string, replaceMetadata, replaceTags bool, metadata storage.ObjectMetadata) (*storage.ObjectInfo, error)
+	DeleteObjectVersion(bucket, key, versionID string) (string, bool, error)
+	GetObjectTagging(bucket, key, versionID string) (map[string]string, string, error)
+	PutObjectTagging(bucket, key, versionID string, tags map[string]string) (string, error)
+
+	// Listing
+	ListObjectsPage(bucket, prefix, delimiter, marker string, maxKeys int) ([]storage.ObjectInfo, []string, bool, string, error)
+	ListObjectVersions(bucket, prefix, delimiter, keyMarker, versionIDMarker string, maxKeys int) (*storage.VersionListing, error)
+
+	// Multipart uploads
+	InitiateMultipartUploadWithMetadata(bucket, key string, metadata storage.ObjectMetadata) (string, error)
+	GetMultipartUpload(uploadID string) (*storage.MultipartUpload, error)
+	UploadPartWithDigests(uploadID string, partNumber int, reader io.Reader, size int64, checksumAlgorithm string, digests storage.Digests) (*storage.UploadPart, error)
+	UploadPartCopy(uploadID string, partNumber int, srcBucket, srcKey, srcVersionID string, rangeStart, rangeEnd int64) (string, error)
+	CompleteMultipartUploadWithConditions(uploadID string, parts []storage.CompletePart, conditions storage.WriteConditions) (*storage.ObjectInfo, error)
+	AbortMultipartUpload(uploadID string) error
+	ListMultipartUploadParts(uploadID string) ([]*storage.UploadPart, error)
+	ListMultipartUploads(bucket string) []*storage.MultipartUpload
+}
+
+// The filesystem and in-memory storage both implement Backend
+var (
+	_ Backend = (*storage.Storage)(nil)
+	_ Backend = (*storage.Memory)(nil)
+)
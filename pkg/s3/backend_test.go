@@ -0,0 +1,143 @@
+package s3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stut/s3dir/pkg/storage"
+)
+
+// testBackends returns a fresh instance of each storage backend
+func testBackends(t *testing.T) map[string]Backend {
+	t.Helper()
+	tmpDir, err := os.MkdirTemp("", "s3dir-backend-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	fs, err := storage.New(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	return map[string]Backend{
+		"filesystem": fs,
+		"memory":     storage.NewMemory(),
+	}
+}
+
+// TestBackendConformance runs the same requests through the handler against
+// every backend
+func TestBackendConformance(t *testing.T) {
+	for name, backend := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			handler := NewHandler(backend, false, false)
+
+			expect := func(w *httptest.ResponseRecorder, code int, what string) {
+				t.Helper()
+				if w.Code != code {
+					t.Fatalf("%s: expected %d, got %d: %s", what, code, w.Code, w.Body.String())
+				}
+			}
+
+			expect(serve(handler, http.MethodPut, "/bucket", ""), http.StatusOK, "create bucket")
+			expect(serve(handler, http.MethodPut, "/bucket", ""), http.StatusConflict, "create existing bucket")
+
+			for _, key := range []string{"a.txt", "dir/b.txt", "dir/c.txt", "dir/sub/d.txt"} {
+				expect(serve(handler, http.MethodPut, "/bucket/"+key, "content of "+key), http.StatusOK, "put "+key)
+			}
+
+			w := serve(handler, http.MethodGet, "/bucket/a.txt", "")
+			expect(w, http.StatusOK, "get")
+			if w.Body.String() != "content of a.txt" || w.Header().Get("ETag") == "" {
+				t.Errorf("get: got %q (ETag %q)", w.Body.String(), w.Header().Get("ETag"))
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/bucket/a.txt", nil)
+			req.Header.Set("Range", "bytes=0-6")
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			expect(w, http.StatusPartialContent, "range get")
+			if w.Body.String() != "content" {
+				t.Errorf("range get: got %q", w.Body.String())
+			}
+
+			w = serve(handler, http.MethodGet, "/bucket?list-type=2&prefix=dir/&delimiter=/", "")
+			expect(w, http.StatusOK, "list")
+			var list ListObjectsV2Response
+			if err := xml.Unmarshal(w.Body.Bytes(), &list); err != nil {
+				t.Fatalf("Failed to parse listing: %v", err)
+			}
+			if len(list.Contents) != 2 || list.Contents[0].Key != "dir/b.txt" || len(list.CommonPrefixes) != 1 || list.CommonPrefixes[0].Prefix != "dir/sub/" {
+				t.Errorf("list: got %+v", list)
+			}
+
+			req = httptest.NewRequest(http.MethodPut, "/bucket/copy.txt", nil)
+			req.Header.Set("x-amz-copy-source", "/bucket/a.txt")
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			expect(w, http.StatusOK, "copy")
+			if w = serve(handler, http.MethodGet, "/bucket/copy.txt", ""); w.Body.String() != "content of a.txt" {
+				t.Errorf("copy: got %q", w.Body.String())
+			}
+
+			expect(serve(handler, http.MethodPut, "/bucket/a.txt?tagging", `<Tagging><TagSet><Tag><Key>k</Key><Value>v</Value></Tag></TagSet></Tagging>`), http.StatusOK, "put tagging")
+			if w = serve(handler, http.MethodGet, "/bucket/a.txt?tagging", ""); !strings.Contains(w.Body.String(), "<Key>k</Key>") {
+				t.Errorf("get tagging: got %s", w.Body.String())
+			}
+
+			// Versioning keeps overwritten and deleted versions
+			expect(serve(handler, http.MethodPut, "/bucket?versioning", `<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`), http.StatusOK, "enable versioning")
+			w = serve(handler, http.MethodPut, "/bucket/a.txt", "second")
+			v2 := w.Header().Get("x-amz-version-id")
+			w = serve(handler, http.MethodDelete, "/bucket/a.txt", "")
+			if w.Header().Get("x-amz-delete-marker") != "true" {
+				t.Errorf("delete: expected a delete marker")
+			}
+			expect(serve(handler, http.MethodGet, "/bucket/a.txt", ""), http.StatusNotFound, "get deleted")
+			if w = serve(handler, http.MethodGet, "/bucket/a.txt?versionId=null", ""); w.Body.String() != "content of a.txt" {
+				t.Errorf("get null version: got %d %q", w.Code, w.Body.String())
+			}
+			if w = serve(handler, http.MethodGet, "/bucket/a.txt?versionId="+v2, ""); w.Body.String() != "second" {
+				t.Errorf("get v2: got %d %q", w.Code, w.Body.String())
+			}
+
+			w = serve(handler, http.MethodGet, "/bucket?versions&prefix=a.txt", "")
+			var versions ListVersionsResult
+			if err := xml.Unmarshal(w.Body.Bytes(), &versions); err != nil {
+				t.Fatalf("Failed to parse version listing: %v", err)
+			}
+			if len(versions.Versions) != 2 || len(versions.DeleteMarkers) != 1 || !versions.DeleteMarkers[0].IsLatest {
+				t.Errorf("list versions: got %s", w.Body.String())
+			}
+
+			// Multipart upload
+			w = serve(handler, http.MethodPost, "/bucket/big.bin?uploads", "")
+			expect(w, http.StatusOK, "initiate")
+			var initiated InitiateMultipartUploadResult
+			xml.Unmarshal(w.Body.Bytes(), &initiated)
+			var complete strings.Builder
+			complete.WriteString("<CompleteMultipartUpload>")
+			for i, part := range []string{"part one,", "part two"} {
+				w = serve(handler, http.MethodPut, fmt.Sprintf("/bucket/big.bin?partNumber=%d&uploadId=%s", i+1, initiated.UploadID), part)
+				expect(w, http.StatusOK, "upload part")
+				fmt.Fprintf(&complete, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, w.Header().Get("ETag"))
+			}
+			complete.WriteString("</CompleteMultipartUpload>")
+			expect(serve(handler, http.MethodPost, "/bucket/big.bin?uploadId="+initiated.UploadID, complete.String()), http.StatusOK, "complete")
+			w = serve(handler, http.MethodGet, "/bucket/big.bin", "")
+			if w.Body.String() != "part one,part two" || !strings.HasSuffix(w.Header().Get("ETag"), "-2\"") {
+				t.Errorf("multipart object: got %q (ETag %s)", w.Body.String(), w.Header().Get("ETag"))
+			}
+
+			expect(serve(handler, http.MethodDelete, "/bucket", ""), http.StatusConflict, "delete non-empty bucket")
+			expect(serve(handler, http.MethodGet, "/missing/a.txt", ""), http.StatusNotFound, "get from missing bucket")
+		})
+	}
+}
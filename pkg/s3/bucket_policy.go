@@ -0,0 +1,63 @@
+package s3
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/stut/s3dir/pkg/policy"
+)
+
+// maxBucketPolicySize is the largest bucket policy S3 accepts (20 KB)
+const maxBucketPolicySize = 20 * 1024
+
+// getBucketPolicy returns the bucket policy JSON as stored
+func (h *Handler) getBucketPolicy(w http.ResponseWriter, r *http.Request, bucket string) {
+	data, err := h.storage.GetBucketConfig(bucket, bucketPolicyConfig)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if data == nil {
+		writeError(w, r, "NoSuchBucketPolicy", "The bucket policy does not exist", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// putBucketPolicy validates and stores a bucket policy
+func (h *Handler) putBucketPolicy(w http.ResponseWriter, r *http.Request, bucket string) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBucketPolicySize+1))
+	if err != nil {
+		writeError(w, r, "IncompleteBody", "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxBucketPolicySize {
+		writeError(w, r, "PolicyTooLarge", "Policies must be no more than 20 KB", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := policy.Parse(data, bucket); err != nil {
+		writeError(w, r, "MalformedPolicy", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storage.PutBucketConfig(bucket, bucketPolicyConfig, data); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteBucketPolicy removes the bucket policy, if any
+func (h *Handler) deleteBucketPolicy(w http.ResponseWriter, r *http.Request, bucket string) {
+	if err := h.storage.DeleteBucketConfig(bucket, bucketPolicyConfig); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,180 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stut/s3dir/pkg/auth"
+)
+
+func TestBucketPolicyAPI(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+
+	policy := `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::test-bucket/*"}]}`
+
+	req := httptest.NewRequest(http.MethodPut, "/test-bucket?policy", strings.NewReader(policy))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("PUT policy: expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/test-bucket?policy", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != policy {
+		t.Errorf("GET policy: expected stored policy, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Invalid policies are rejected and leave the stored policy alone
+	for _, doc := range []string{`{`, `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::other-bucket/*"}]}`} {
+		req = httptest.NewRequest(http.MethodPut, "/test-bucket?policy", strings.NewReader(doc))
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "MalformedPolicy") {
+			t.Errorf("PUT %s: expected 400 MalformedPolicy, got %d: %s", doc, w.Code, w.Body.String())
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/test-bucket?policy", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("DELETE policy: expected 204, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/test-bucket?policy", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchBucketPolicy") {
+		t.Errorf("GET deleted policy: expected 404 NoSuchBucketPolicy, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestBucketPolicyEnforcement(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("site")
+	putTestObject(t, handler, "site", "public/index.html", "hello")
+	putTestObject(t, handler, "site", "private/notes.txt", "secret")
+
+	policy := `{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::site/public/*"},
+			{"Effect": "Deny", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::site/private/*",
+			 "Condition": {"NotIpAddress": {"aws:SourceIp": "10.0.0.0/8"}}},
+			{"Effect": "Deny", "Principal": {"AWS": "ci"}, "Action": "s3:ListBucket", "Resource": "arn:aws:s3:::site",
+			 "Condition": {"StringNotLike": {"s3:prefix": "public/*"}}}
+		]
+	}`
+	req := httptest.NewRequest(http.MethodPut, "/site?policy", strings.NewReader(policy))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("PUT policy: expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	ci, err := auth.NewCredentialStore(&auth.Credential{AccessKeyID: "ci", SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create credential: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		cred         *auth.Credential
+		remoteAddr   string
+		method       string
+		target       string
+		expectedCode int
+	}{
+		{"anonymous public read", auth.Anonymous, "203.0.113.1:1234", http.MethodGet, "/site/public/index.html", http.StatusOK},
+		{"anonymous private read", auth.Anonymous, "10.0.0.1:1234", http.MethodGet, "/site/private/notes.txt", http.StatusForbidden},
+		{"anonymous write", auth.Anonymous, "203.0.113.1:1234", http.MethodPut, "/site/public/index.html", http.StatusForbidden},
+		{"key private read from inside", ci.Lookup("ci"), "10.0.0.1:1234", http.MethodGet, "/site/private/notes.txt", http.StatusOK},
+		{"key private read from outside", ci.Lookup("ci"), "203.0.113.1:1234", http.MethodGet, "/site/private/notes.txt", http.StatusForbidden},
+		{"key list allowed prefix", ci.Lookup("ci"), "10.0.0.1:1234", http.MethodGet, "/site?prefix=public/", http.StatusOK},
+		{"key list other prefix", ci.Lookup("ci"), "10.0.0.1:1234", http.MethodGet, "/site?prefix=private/", http.StatusForbidden},
+		{"key manages policy from outside", ci.Lookup("ci"), "203.0.113.1:1234", http.MethodGet, "/site?policy", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader("data"))
+			req.RemoteAddr = tt.remoteAddr
+			req = req.WithContext(auth.WithCredential(req.Context(), tt.cred))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.expectedCode {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedCode, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBucketPolicyMultipartUpload(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("prod")
+	store.CreateBucket("scratch")
+	putTestObject(t, handler, "prod", "victim", "original")
+
+	policy := `{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Effect": "Deny", "Principal": {"AWS": "ci"}, "Action": "s3:PutObject", "Resource": "arn:aws:s3:::prod/*"}
+		]
+	}`
+	if w := serve(handler, http.MethodPut, "/prod?policy", policy); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT policy: expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	uploadID, _ := store.InitiateMultipartUpload("prod", "victim")
+	etag, err := store.UploadPart(uploadID, 1, strings.NewReader("overwritten"), 11)
+	if err != nil {
+		t.Fatalf("Failed to upload part: %v", err)
+	}
+	body, _ := xml.Marshal(CompleteMultipartUpload{Parts: []CompletePart{{PartNumber: 1, ETag: etag}}})
+
+	ci, err := auth.NewCredentialStore(&auth.Credential{AccessKeyID: "ci", SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create credential: %v", err)
+	}
+
+	// The upload is subject to its own bucket's policy, however it is addressed
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		body         string
+		expectedCode int
+	}{
+		{"upload part through another bucket", http.MethodPut, "/scratch/x?partNumber=2&uploadId=" + uploadID, "data", http.StatusNotFound},
+		{"complete through another bucket", http.MethodPost, "/scratch/x?uploadId=" + uploadID, string(body), http.StatusNotFound},
+		{"upload part", http.MethodPut, "/prod/victim?partNumber=2&uploadId=" + uploadID, "data", http.StatusForbidden},
+		{"complete", http.MethodPost, "/prod/victim?uploadId=" + uploadID, string(body), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req = req.WithContext(auth.WithCredential(req.Context(), ci.Lookup("ci")))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.expectedCode {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedCode, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if w := serve(handler, http.MethodGet, "/prod/victim", ""); w.Body.String() != "original" {
+		t.Errorf("Expected prod/victim to be untouched, got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,188 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/stut/s3dir/pkg/storage"
+)
+
+// checksumHeader returns the header carrying a checksum computed with
+// algorithm, such as x-amz-checksum-crc32
+func checksumHeader(algorithm string) string {
+	return "x-amz-checksum-" + strings.ToLower(algorithm)
+}
+
+// requestDigests reads the Content-MD5 and additional checksum sent with an
+// uploaded body, returning the checksum's algorithm and the digests to check
+// the content against. The checksum is either a header or a trailing header
+// announced in x-amz-trailer, and x-amz-sdk-checksum-algorithm alone chooses
+// the algorithm. Malformed or conflicting values are reported and return
+// false
+func requestDigests(w http.ResponseWriter, r *http.Request) (string, storage.Digests, bool) {
+	var digests storage.Digests
+	if contentMD5 := r.Header.Get("Content-Md5"); contentMD5 != "" {
+		sum, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(sum) != md5.Size {
+			writeError(w, r, "InvalidDigest", "The Content-MD5 you specified was invalid", http.StatusBadRequest)
+			return "", digests, false
+		}
+		digests.ContentMD5 = sum
+	}
+
+	algorithm, value, trailing := "", "", false
+	for _, candidate := range storage.ChecksumAlgorithms {
+		v := r.Header.Get(checksumHeader(candidate))
+		if v == "" {
+			continue
+		}
+		if algorithm != "" {
+			writeError(w, r, "InvalidRequest", "Expecting a single x-amz-checksum- header. Multiple checksum Types are not allowed.", http.StatusBadRequest)
+			return "", digests, false
+		}
+		if !storage.ValidChecksum(candidate, v) {
+			writeError(w, r, "InvalidRequest", fmt.Sprintf("Value for %s header is invalid.", checksumHeader(candidate)), http.StatusBadRequest)
+			return "", digests, false
+		}
+		algorithm, value = candidate, v
+	}
+
+	for _, name := range strings.Split(r.Header.Get("X-Amz-Trailer"), ",") {
+		candidate, ok := strings.CutPrefix(strings.ToLower(strings.TrimSpace(name)), "x-amz-checksum-")
+		if !ok {
+			continue
+		}
+		candidate = strings.ToUpper(candidate)
+		if algorithm != "" || !storage.ValidChecksumAlgorithm(candidate) {
+			writeError(w, r, "InvalidRequest", "The value specified in the x-amz-trailer header is not supported", http.StatusBadRequest)
+			return "", digests, false
+		}
+		algorithm, trailing = candidate, true
+	}
+
+	if sdkAlgorithm := strings.ToUpper(r.Header.Get("X-Amz-Sdk-Checksum-Algorithm")); sdkAlgorithm != "" {
+		if !storage.ValidChecksumAlgorithm(sdkAlgorithm) || algorithm != "" && algorithm != sdkAlgorithm {
+			writeError(w, r, "InvalidRequest", "Value for x-amz-sdk-checksum-algorithm header is invalid.", http.StatusBadRequest)
+			return "", digests, false
+		}
+		algorithm = sdkAlgorithm
+	}
+
+	switch {
+	case trailing:
+		// Trailing headers are only known once the body has been read
+		digests.Checksum = func() string {
+			return r.Trailer.Get(checksumHeader(algorithm))
+		}
+	case value != "":
+		digests.Checksum = func() string {
+			return value
+		}
+	}
+	return algorithm, digests, true
+}
+
+// requestChecksumAlgorithm reads the x-amz-checksum-algorithm header chosen
+// for a copy or multipart upload, reporting unsupported values and
+// returning false
+func requestChecksumAlgorithm(w http.ResponseWriter, r *http.Request) (string, bool) {
+	algorithm := strings.ToUpper(r.Header.Get("X-Amz-Checksum-Algorithm"))
+	if algorithm != "" && !storage.ValidChecksumAlgorithm(algorithm) {
+		writeError(w, r, "InvalidRequest", "Checksum algorithm provided is unsupported.", http.StatusBadRequest)
+		return "", false
+	}
+	return algorithm, true
+}
+
+// uploadChecksum reads the checksum algorithm and type chosen for a
+// multipart upload. Full object checksums are limited to the CRC algorithms,
+// and CRC64NVME has no composite form
+func uploadChecksum(w http.ResponseWriter, r *http.Request) (algorithm, checksumType string, ok bool) {
+	algorithm, ok = requestChecksumAlgorithm(w, r)
+	if !ok {
+		return "", "", false
+	}
+	checksumType = strings.ToUpper(r.Header.Get("X-Amz-Checksum-Type"))
+	if algorithm == "" {
+		if checksumType != "" {
+			writeError(w, r, "InvalidRequest", "The x-amz-checksum-type header can only be used with the x-amz-checksum-algorithm header.", http.StatusBadRequest)
+			return "", "", false
+		}
+		return "", "", true
+	}
+	if checksumType == "" {
+		checksumType = storage.DefaultChecksumType(algorithm)
+	}
+
+	switch checksumType {
+	case storage.ChecksumTypeFullObject:
+		ok = algorithm == storage.ChecksumCRC32 || algorithm == storage.ChecksumCRC32C || algorithm == storage.ChecksumCRC64NVME
+	case storage.ChecksumTypeComposite:
+		ok = algorithm != storage.ChecksumCRC64NVME
+	}
+	if !ok {
+		writeError(w, r, "InvalidRequest", fmt.Sprintf("The %s checksum type cannot be used with the %s checksum algorithm.", checksumType, algorithm), http.StatusBadRequest)
+		return "", "", false
+	}
+	return algorithm, checksumType, true
+}
+
+// checksumModeEnabled reports whether a GET or HEAD asks for the object's
+// checksum with x-amz-checksum-mode
+func checksumModeEnabled(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("X-Amz-Checksum-Mode"), "ENABLED")
+}
+
+// setChecksumHeaders reports an object's checksum and its type, if it has
+// one
+func setChecksumHeaders(w http.ResponseWriter, checksum *storage.Checksum) {
+	if checksum == nil {
+		return
+	}
+	w.Header().Set(checksumHeader(checksum.Algorithm), checksum.Value)
+	w.Header().Set("x-amz-checksum-type", checksum.Type)
+}
+
+// checksumElements returns a checksum as the XML element named after its
+// algorithm
+func checksumElements(checksum *storage.Checksum) Checksums {
+	var c Checksums
+	if checksum == nil {
+		return c
+	}
+	switch checksum.Algorithm {
+	case storage.ChecksumCRC32:
+		c.ChecksumCRC32 = checksum.Value
+	case storage.ChecksumCRC32C:
+		c.ChecksumCRC32C = checksum.Value
+	case storage.ChecksumCRC64NVME:
+		c.ChecksumCRC64NVME = checksum.Value
+	case storage.ChecksumSHA1:
+		c.ChecksumSHA1 = checksum.Value
+	case storage.ChecksumSHA256:
+		c.ChecksumSHA256 = checksum.Value
+	}
+	return c
+}
+
+// value returns whichever checksum is set
+func (c Checksums) value() string {
+	for _, v := range []string{c.ChecksumCRC32, c.ChecksumCRC32C, c.ChecksumCRC64NVME, c.ChecksumSHA1, c.ChecksumSHA256} {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// checksumType returns the type of a checksum for an XML response, or "" if
+// there is none
+func checksumType(checksum *storage.Checksum) string {
+	if checksum == nil {
+		return ""
+	}
+	return checksum.Type
+}
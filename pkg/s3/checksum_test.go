@@ -0,0 +1,152 @@
+package s3
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPutObjectChecksumHeaders(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+
+	content := "Hello, S3Dir!"
+	sum := sha256.Sum256([]byte(content))
+	checksum := base64.StdEncoding.EncodeToString(sum[:])
+	md5Sum := md5.Sum([]byte(content))
+
+	put := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/test-bucket/test.txt", strings.NewReader(content))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := put(map[string]string{
+		"x-amz-checksum-sha256": checksum,
+		"Content-MD5":           base64.StdEncoding.EncodeToString(md5Sum[:]),
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("x-amz-checksum-sha256"); got != checksum {
+		t.Errorf("Expected x-amz-checksum-sha256 %s, got %q", checksum, got)
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		code    string
+	}{
+		{"checksum mismatch", map[string]string{"x-amz-checksum-sha256": base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))}, "BadDigest"},
+		{"Content-MD5 mismatch", map[string]string{"Content-MD5": base64.StdEncoding.EncodeToString(make([]byte, md5.Size))}, "BadDigest"},
+		{"malformed Content-MD5", map[string]string{"Content-MD5": "not-an-md5"}, "InvalidDigest"},
+		{"malformed checksum", map[string]string{"x-amz-checksum-crc32": checksum}, "InvalidRequest"},
+		{"multiple checksums", map[string]string{"x-amz-checksum-sha256": checksum, "x-amz-checksum-crc32": "AAAAAA=="}, "InvalidRequest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := put(tt.headers)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.code) {
+				t.Errorf("Expected 400 %s, got %d: %s", tt.code, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// Checksums are only returned by GET and HEAD when asked for
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		w := serve(handler, method, "/test-bucket/test.txt", "")
+		if got := w.Header().Get("x-amz-checksum-sha256"); got != "" {
+			t.Errorf("%s: expected no checksum without x-amz-checksum-mode, got %q", method, got)
+		}
+
+		req := httptest.NewRequest(method, "/test-bucket/test.txt", nil)
+		req.Header.Set("x-amz-checksum-mode", "ENABLED")
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if got := w.Header().Get("x-amz-checksum-sha256"); got != checksum {
+			t.Errorf("%s: expected x-amz-checksum-sha256 %s, got %q", method, checksum, got)
+		}
+		if got := w.Header().Get("x-amz-checksum-type"); got != "FULL_OBJECT" {
+			t.Errorf("%s: expected checksum type FULL_OBJECT, got %q", method, got)
+		}
+	}
+}
+
+func TestMultipartCompositeChecksum(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+
+	req := httptest.NewRequest(http.MethodPost, "/test-bucket/object.txt?uploads", nil)
+	req.Header.Set("x-amz-checksum-algorithm", "SHA256")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Initiate: expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("x-amz-checksum-type"); got != "COMPOSITE" {
+		t.Errorf("Expected SHA256 uploads to default to COMPOSITE, got %q", got)
+	}
+	var initiate InitiateMultipartUploadResult
+	xml.Unmarshal(w.Body.Bytes(), &initiate)
+
+	var parts []CompletePart
+	var raw []byte
+	for i, content := range []string{"part1-content-", "part2-content"} {
+		sum := sha256.Sum256([]byte(content))
+		raw = append(raw, sum[:]...)
+		target := fmt.Sprintf("/test-bucket/object.txt?partNumber=%d&uploadId=%s", i+1, initiate.UploadID)
+		w := serve(handler, http.MethodPut, target, content)
+		checksum := w.Header().Get("x-amz-checksum-sha256")
+		if w.Code != http.StatusOK || checksum != base64.StdEncoding.EncodeToString(sum[:]) {
+			t.Fatalf("UploadPart %d: expected 200 with its SHA256, got %d %q", i+1, w.Code, checksum)
+		}
+		parts = append(parts, CompletePart{PartNumber: i + 1, ETag: w.Header().Get("ETag"), Checksums: Checksums{ChecksumSHA256: checksum}})
+	}
+
+	w = serve(handler, http.MethodGet, "/test-bucket/object.txt?uploadId="+initiate.UploadID, "")
+	var listing ListPartsResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatalf("Failed to parse ListParts: %v", err)
+	}
+	if listing.ChecksumAlgorithm != "SHA256" || listing.ChecksumType != "COMPOSITE" {
+		t.Errorf("Expected ListParts to report SHA256 COMPOSITE, got %q %q", listing.ChecksumAlgorithm, listing.ChecksumType)
+	}
+	if len(listing.Parts) != 2 || listing.Parts[1].ChecksumSHA256 != parts[1].ChecksumSHA256 {
+		t.Errorf("Expected ListParts to include part checksums, got %+v", listing.Parts)
+	}
+
+	body, _ := xml.Marshal(CompleteMultipartUpload{Parts: parts})
+	w = serve(handler, http.MethodPost, "/test-bucket/object.txt?uploadId="+initiate.UploadID, string(body))
+	var result CompleteMultipartUploadResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse CompleteMultipartUpload: %v", w.Body.String())
+	}
+	composite := sha256.Sum256(raw)
+	expected := base64.StdEncoding.EncodeToString(composite[:]) + "-2"
+	if result.ChecksumSHA256 != expected || result.ChecksumType != "COMPOSITE" {
+		t.Errorf("Expected composite checksum %s, got %q %q", expected, result.ChecksumSHA256, result.ChecksumType)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/test-bucket/object.txt?uploads", nil)
+	req.Header.Set("x-amz-checksum-algorithm", "SHA1")
+	req.Header.Set("x-amz-checksum-type", "FULL_OBJECT")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected full object SHA1 to be rejected, got %d", w.Code)
+	}
+}
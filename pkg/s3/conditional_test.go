@@ -0,0 +1,103 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConditionalPutObject(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+
+	put := func(content string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/test-bucket/test.txt", strings.NewReader(content))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := put("first", map[string]string{"If-None-Match": "*"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Create-only write: expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		code    string
+	}{
+		{"If-None-Match on an existing object", map[string]string{"If-None-Match": "*"}, http.StatusPreconditionFailed, "PreconditionFailed"},
+		{"If-Match with another ETag", map[string]string{"If-Match": `"0123"`}, http.StatusPreconditionFailed, "PreconditionFailed"},
+		{"If-None-Match with an ETag", map[string]string{"If-None-Match": etag}, http.StatusNotImplemented, "NotImplemented"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := put("second", tt.headers)
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.code) {
+				t.Errorf("Expected %d %s, got %d: %s", tt.status, tt.code, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if w := put("second", map[string]string{"If-Match": etag}); w.Code != http.StatusOK {
+		t.Errorf("If-Match with the current ETag: expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(handler, http.MethodGet, "/test-bucket/test.txt", ""); w.Body.String() != "second" {
+		t.Errorf("Expected the If-Match write to replace the object, got %q", w.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/test-bucket/missing.txt", strings.NewReader("content"))
+	req.Header.Set("If-Match", "*")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchKey") {
+		t.Errorf("If-Match on a missing object: expected 404 NoSuchKey, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestConditionalCompleteMultipartUpload(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+	store.PutObject("test-bucket", "object.txt", strings.NewReader("existing"), 8)
+
+	w := serve(handler, http.MethodPost, "/test-bucket/object.txt?uploads", "")
+	var initiate InitiateMultipartUploadResult
+	xml.Unmarshal(w.Body.Bytes(), &initiate)
+
+	w = serve(handler, http.MethodPut, "/test-bucket/object.txt?partNumber=1&uploadId="+initiate.UploadID, "replacement")
+	body, _ := xml.Marshal(CompleteMultipartUpload{Parts: []CompletePart{{PartNumber: 1, ETag: w.Header().Get("ETag")}}})
+
+	complete := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/test-bucket/object.txt?uploadId="+initiate.UploadID, strings.NewReader(string(body)))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := complete(map[string]string{"If-None-Match": "*"}); w.Code != http.StatusPreconditionFailed || !strings.Contains(w.Body.String(), "PreconditionFailed") {
+		t.Errorf("Create-only completion over an existing object: expected 412, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The upload is kept, so completing without conditions still works
+	if w := complete(nil); w.Code != http.StatusOK {
+		t.Errorf("Expected completion to succeed after a failed condition, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(handler, http.MethodGet, "/test-bucket/object.txt", ""); w.Body.String() != "replacement" {
+		t.Errorf("Expected the completed upload's content, got %q", w.Body.String())
+	}
+}
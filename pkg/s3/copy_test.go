@@ -9,6 +9,8 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/stut/s3dir/pkg/storage"
 )
 
 func putTestObject(t *testing.T, handler *Handler, bucket, key, content string) {
@@ -451,6 +453,30 @@ func TestDeleteObjects(t *testing.T) {
 	}
 }
 
+func TestDeleteObjectsKeyErrors(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+
+	// Per-key failures carry the same S3 error codes as single requests
+	body := "<Delete>" +
+		"<Object><Key>" + strings.Repeat("k", storage.MaxKeyLength+1) + "</Key></Object>" +
+		"</Delete>"
+	w := serve(handler, http.MethodPost, "/test-bucket?delete", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var result DeleteResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Code != "KeyTooLongError" {
+		t.Errorf("Expected a KeyTooLongError, got %+v", result.Errors)
+	}
+}
+
 func TestDeleteObjectsQuietMode(t *testing.T) {
 	handler, store, cleanup := setupTestHandler(t)
 	defer cleanup()
@@ -0,0 +1,300 @@
+package s3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// bucketCORSConfig is the bucket configuration document holding the CORS
+// configuration XML
+const bucketCORSConfig = "cors.xml"
+
+// S3 CORS configuration limits
+const (
+	maxCORSRules      = 100
+	maxCORSConfigSize = 64 * 1024
+)
+
+// corsMethods are the methods a CORS rule may allow
+var corsMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPut:    true,
+	http.MethodPost:   true,
+	http.MethodDelete: true,
+	http.MethodHead:   true,
+}
+
+// parseCORSConfiguration parses and validates a CORS configuration document,
+// returning the S3 error code and message describing any problem
+func parseCORSConfiguration(data []byte) (*CORSConfiguration, string, error) {
+	var config CORSConfiguration
+	if err := xml.Unmarshal(data, &config); err != nil {
+		return nil, "MalformedXML", fmt.Errorf("The XML you provided was not well-formed or did not validate against our published schema")
+	}
+
+	if len(config.CORSRules) == 0 {
+		return nil, "MalformedXML", fmt.Errorf("The CORS configuration must contain at least one rule")
+	}
+	if len(config.CORSRules) > maxCORSRules {
+		return nil, "MalformedXML", fmt.Errorf("The CORS configuration cannot contain more than %d rules", maxCORSRules)
+	}
+
+	for _, rule := range config.CORSRules {
+		if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 {
+			return nil, "MalformedXML", fmt.Errorf("Each CORS rule must specify at least one AllowedOrigin and AllowedMethod")
+		}
+		for _, method := range rule.AllowedMethods {
+			if !corsMethods[method] {
+				return nil, "InvalidRequest", fmt.Errorf("Found unsupported HTTP method in CORS config. Unsupported method is %s", method)
+			}
+		}
+		for _, origin := range rule.AllowedOrigins {
+			if strings.Count(origin, "*") > 1 {
+				return nil, "InvalidRequest", fmt.Errorf("AllowedOrigin %q can not have more than one wildcard", origin)
+			}
+		}
+		for _, header := range rule.AllowedHeaders {
+			if strings.Count(header, "*") > 1 {
+				return nil, "InvalidRequest", fmt.Errorf("AllowedHeader %q can not have more than one wildcard", header)
+			}
+		}
+		if rule.MaxAgeSeconds != nil && *rule.MaxAgeSeconds < 0 {
+			return nil, "MalformedXML", fmt.Errorf("MaxAgeSeconds must not be negative")
+		}
+	}
+
+	return &config, "", nil
+}
+
+// bucketCORS returns a bucket's CORS configuration, or nil if it has none
+func (h *Handler) bucketCORS(bucket string) (*CORSConfiguration, error) {
+	data, err := h.storage.GetBucketConfig(bucket, bucketCORSConfig)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	config, _, err := parseCORSConfiguration(data)
+	if err != nil {
+		return nil, fmt.Errorf("stored CORS configuration is invalid: %w", err)
+	}
+	return config, nil
+}
+
+// getBucketCORS returns the bucket's CORS configuration as stored
+func (h *Handler) getBucketCORS(w http.ResponseWriter, r *http.Request, bucket string) {
+	data, err := h.storage.GetBucketConfig(bucket, bucketCORSConfig)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if data == nil {
+		writeError(w, r, "NoSuchCORSConfiguration", "The CORS configuration does not exist", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// putBucketCORS validates and stores the bucket's CORS configuration
+func (h *Handler) putBucketCORS(w http.ResponseWriter, r *http.Request, bucket string) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxCORSConfigSize+1))
+	if err != nil {
+		writeError(w, r, "IncompleteBody", "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxCORSConfigSize {
+		writeError(w, r, "MalformedXML", "The CORS configuration is too large", http.StatusBadRequest)
+		return
+	}
+
+	if _, code, err := parseCORSConfiguration(data); err != nil {
+		writeError(w, r, code, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storage.PutBucketConfig(bucket, bucketCORSConfig, data); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteBucketCORS removes the bucket's CORS configuration, if any
+func (h *Handler) deleteBucketCORS(w http.ResponseWriter, r *http.Request, bucket string) {
+	if err := h.storage.DeleteBucketConfig(bucket, bucketCORSConfig); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// corsMatch matches value against a CORS pattern containing at most one '*'
+func corsMatch(pattern, value string) bool {
+	before, after, found := strings.Cut(pattern, "*")
+	if !found {
+		return pattern == value
+	}
+	return len(value) >= len(before)+len(after) && strings.HasPrefix(value, before) && strings.HasSuffix(value, after)
+}
+
+// allowsOrigin returns the AllowedOrigin pattern of the rule matching origin
+func (rule *CORSRule) allowsOrigin(origin string) (string, bool) {
+	for _, pattern := range rule.AllowedOrigins {
+		if corsMatch(pattern, origin) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+func (rule *CORSRule) allowsMethod(method string) bool {
+	for _, m := range rule.AllowedMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders reports whether every header is matched by an AllowedHeader
+// pattern. Header names compare case-insensitively
+func (rule *CORSRule) allowsHeaders(headers []string) bool {
+	for _, header := range headers {
+		allowed := false
+		for _, pattern := range rule.AllowedHeaders {
+			if corsMatch(strings.ToLower(pattern), header) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// match returns the first rule allowing a request from origin using method
+// and sending headers, along with the matching origin pattern
+func (c *CORSConfiguration) match(origin, method string, headers []string) (*CORSRule, string) {
+	for i := range c.CORSRules {
+		rule := &c.CORSRules[i]
+		pattern, ok := rule.allowsOrigin(origin)
+		if ok && rule.allowsMethod(method) && rule.allowsHeaders(headers) {
+			return rule, pattern
+		}
+	}
+	return nil, ""
+}
+
+// parseRequestHeaders splits an Access-Control-Request-Headers value into
+// lower-case header names
+func parseRequestHeaders(value string) []string {
+	var headers []string
+	for _, header := range strings.Split(value, ",") {
+		if header = strings.ToLower(strings.TrimSpace(header)); header != "" {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
+
+// setCORSHeaders writes the CORS response headers for a request allowed by
+// rule. A rule allowing any origin answers with "*" and does not allow
+// credentials; otherwise the origin is echoed and credentials are allowed
+func setCORSHeaders(w http.ResponseWriter, rule *CORSRule, pattern, origin string) {
+	header := w.Header()
+	if pattern == "*" {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+	if len(rule.ExposeHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+	}
+	if rule.MaxAgeSeconds != nil {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(*rule.MaxAgeSeconds))
+	}
+}
+
+// CORSMiddleware applies each bucket's CORS configuration. Preflight OPTIONS
+// requests are answered here without authentication and rejected unless a
+// rule allows them. Other requests carrying an Origin header are passed on,
+// with CORS headers added if a rule allows them; as in S3, a request no rule
+// allows is still served, but without the headers browsers require
+func (h *Handler) CORSMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Assign the request ID before authentication, whose error responses
+		// report it
+		requestID(w)
+
+		bucket, _ := h.parseRequest(r)
+		if bucket == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions {
+			h.corsPreflight(w, r, bucket)
+			return
+		}
+
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if config, err := h.bucketCORS(bucket); err == nil && config != nil {
+				w.Header().Add("Vary", "Origin, Access-Control-Request-Headers, Access-Control-Request-Method")
+				if rule, pattern := config.match(origin, r.Method, nil); rule != nil {
+					setCORSHeaders(w, rule, pattern, origin)
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsPreflight answers a CORS preflight request against the bucket's rules
+func (h *Handler) corsPreflight(w http.ResponseWriter, r *http.Request, bucket string) {
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	if origin == "" {
+		writeError(w, r, "BadRequest", "Insufficient information. Origin request header needed.", http.StatusBadRequest)
+		return
+	}
+	if method == "" {
+		writeError(w, r, "BadRequest", "Invalid Access-Control-Request-Method: null", http.StatusBadRequest)
+		return
+	}
+
+	config, err := h.bucketCORS(bucket)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if config == nil {
+		writeError(w, r, "AccessForbidden", "CORSResponse: CORS is not enabled for this bucket.", http.StatusForbidden)
+		return
+	}
+
+	requestHeaders := parseRequestHeaders(r.Header.Get("Access-Control-Request-Headers"))
+	rule, pattern := config.match(origin, method, requestHeaders)
+	if rule == nil {
+		writeError(w, r, "AccessForbidden", "CORSResponse: This CORS request is not allowed. This is usually because the evalution of Origin, request method / Access-Control-Request-Method or Access-Control-Request-Headers are not whitelisted by the resource's CORS spec.", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Add("Vary", "Origin, Access-Control-Request-Headers, Access-Control-Request-Method")
+	setCORSHeaders(w, rule, pattern, origin)
+	if len(requestHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(requestHeaders, ", "))
+	}
+	w.WriteHeader(http.StatusOK)
+}
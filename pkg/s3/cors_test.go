@@ -0,0 +1,183 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testCORSConfig = `<CORSConfiguration>
+  <CORSRule>
+    <AllowedOrigin>http://app.example.com</AllowedOrigin>
+    <AllowedOrigin>http://*.dev.example.com</AllowedOrigin>
+    <AllowedMethod>GET</AllowedMethod>
+    <AllowedMethod>PUT</AllowedMethod>
+    <AllowedHeader>Content-*</AllowedHeader>
+    <AllowedHeader>x-amz-date</AllowedHeader>
+    <ExposeHeader>ETag</ExposeHeader>
+    <MaxAgeSeconds>600</MaxAgeSeconds>
+  </CORSRule>
+  <CORSRule>
+    <AllowedOrigin>*</AllowedOrigin>
+    <AllowedMethod>HEAD</AllowedMethod>
+  </CORSRule>
+</CORSConfiguration>`
+
+func TestBucketCORSAPI(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+
+	tests := []struct {
+		name string
+		doc  string
+		code string
+	}{
+		{"not xml", "cors", "MalformedXML"},
+		{"no rules", "<CORSConfiguration/>", "MalformedXML"},
+		{"no origin", "<CORSConfiguration><CORSRule><AllowedMethod>GET</AllowedMethod></CORSRule></CORSConfiguration>", "MalformedXML"},
+		{"bad method", "<CORSConfiguration><CORSRule><AllowedOrigin>*</AllowedOrigin><AllowedMethod>PATCH</AllowedMethod></CORSRule></CORSConfiguration>", "InvalidRequest"},
+		{"two wildcards", "<CORSConfiguration><CORSRule><AllowedOrigin>*.*</AllowedOrigin><AllowedMethod>GET</AllowedMethod></CORSRule></CORSConfiguration>", "InvalidRequest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(handler, http.MethodPut, "/test-bucket?cors", tt.doc)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.code) {
+				t.Errorf("Expected 400 %s, got %d: %s", tt.code, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	w := serve(handler, http.MethodPut, "/test-bucket?cors", testCORSConfig)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT cors: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = serve(handler, http.MethodGet, "/test-bucket?cors", "")
+	if w.Code != http.StatusOK || w.Body.String() != testCORSConfig {
+		t.Errorf("GET cors: expected stored configuration, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = serve(handler, http.MethodDelete, "/test-bucket?cors", "")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("DELETE cors: expected 204, got %d", w.Code)
+	}
+	w = serve(handler, http.MethodGet, "/test-bucket?cors", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET after delete: expected 404, got %d", w.Code)
+	}
+}
+
+func corsRequest(handler http.Handler, method, target string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORSPreflight(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+	cors := handler.CORSMiddleware(handler)
+
+	preflight := map[string]string{
+		"Origin":                        "http://app.example.com",
+		"Access-Control-Request-Method": "PUT",
+	}
+
+	w := corsRequest(cors, http.MethodOptions, "/test-bucket/file.txt", preflight)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "CORS is not enabled") {
+		t.Errorf("Preflight without configuration: expected 403, got %d: %s", w.Code, w.Body.String())
+	}
+
+	serve(handler, http.MethodPut, "/test-bucket?cors", testCORSConfig)
+
+	w = corsRequest(cors, http.MethodOptions, "/test-bucket/file.txt", map[string]string{"Origin": "http://app.example.com"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Preflight without request method: expected 400, got %d", w.Code)
+	}
+
+	tests := []struct {
+		name       string
+		origin     string
+		method     string
+		headers    string
+		wantCode   int
+		wantOrigin string
+	}{
+		{"exact origin", "http://app.example.com", "PUT", "Content-Type, X-Amz-Date", http.StatusOK, "http://app.example.com"},
+		{"wildcard origin", "http://feature.dev.example.com", "GET", "", http.StatusOK, "http://feature.dev.example.com"},
+		{"any origin", "http://elsewhere.com", "HEAD", "", http.StatusOK, "*"},
+		{"origin not allowed", "http://evil.com", "PUT", "", http.StatusForbidden, ""},
+		{"method not allowed", "http://app.example.com", "DELETE", "", http.StatusForbidden, ""},
+		{"header not allowed", "http://app.example.com", "PUT", "authorization", http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{"Origin": tt.origin, "Access-Control-Request-Method": tt.method}
+			if tt.headers != "" {
+				headers["Access-Control-Request-Headers"] = tt.headers
+			}
+			w := corsRequest(cors, http.MethodOptions, "/test-bucket/file.txt", headers)
+			if w.Code != tt.wantCode {
+				t.Fatalf("Expected %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+		})
+	}
+
+	w = corsRequest(cors, http.MethodOptions, "/test-bucket/file.txt", map[string]string{
+		"Origin":                         "http://app.example.com",
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "content-type",
+	})
+	if w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Error("Expected credentials to be allowed for a specific origin")
+	}
+	if w.Header().Get("Access-Control-Allow-Headers") != "content-type" {
+		t.Errorf("Access-Control-Allow-Headers = %q", w.Header().Get("Access-Control-Allow-Headers"))
+	}
+	if w.Header().Get("Access-Control-Max-Age") != "600" || w.Header().Get("Access-Control-Expose-Headers") != "ETag" {
+		t.Errorf("Expected max age and exposed headers, got %v", w.Header())
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+	serve(handler, http.MethodPut, "/test-bucket/file.txt", "data")
+	serve(handler, http.MethodPut, "/test-bucket?cors", testCORSConfig)
+	cors := handler.CORSMiddleware(handler)
+
+	w := corsRequest(cors, http.MethodGet, "/test-bucket/file.txt", map[string]string{"Origin": "http://app.example.com"})
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "http://app.example.com" {
+		t.Errorf("Allowed origin: got %d, headers %v", w.Code, w.Header())
+	}
+	if w.Header().Get("Access-Control-Expose-Headers") != "ETag" {
+		t.Errorf("Expected ETag to be exposed, got %q", w.Header().Get("Access-Control-Expose-Headers"))
+	}
+
+	// Requests no rule allows are served without CORS headers
+	w = corsRequest(cors, http.MethodGet, "/test-bucket/file.txt", map[string]string{"Origin": "http://evil.com"})
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Disallowed origin: got %d, headers %v", w.Code, w.Header())
+	}
+
+	// Same-origin requests get no CORS headers
+	w = corsRequest(cors, http.MethodGet, "/test-bucket/file.txt", nil)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Request without Origin: unexpected CORS headers %v", w.Header())
+	}
+}
@@ -0,0 +1,61 @@
+package s3
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/stut/s3dir/pkg/auth"
+	"github.com/stut/s3dir/pkg/storage"
+)
+
+// storageErrors maps the errors storage backends return to the S3 error
+// reported for them. An empty message reports the error's own message
+var storageErrors = []struct {
+	err     error
+	code    string
+	message string
+	status  int
+}{
+	{storage.ErrNoSuchBucket, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound},
+	{storage.ErrBucketExists, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it", http.StatusConflict},
+	{storage.ErrBucketNotEmpty, "BucketNotEmpty", "The bucket you tried to delete is not empty", http.StatusConflict},
+	{storage.ErrNoSuchKey, "NoSuchKey", "The specified key does not exist", http.StatusNotFound},
+	{storage.ErrNoSuchVersion, "NoSuchVersion", "The specified version does not exist", http.StatusNotFound},
+	{storage.ErrNoSuchUpload, "NoSuchUpload", "The specified upload does not exist", http.StatusNotFound},
+	{storage.ErrInvalidPart, "InvalidPart", "", http.StatusBadRequest},
+	{storage.ErrBadDigest, "BadDigest", "", http.StatusBadRequest},
+	{storage.ErrMissingChecksum, "InvalidRequest", "The checksum announced in x-amz-trailer was not sent", http.StatusBadRequest},
+	{storage.ErrChecksumAlgorithmMismatch, "InvalidRequest", "", http.StatusBadRequest},
+	{storage.ErrPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed},
+	{storage.ErrConditionalRequestConflict, "ConditionalRequestConflict", "A conflicting operation occurred while this request was in progress. Retry the request", http.StatusConflict},
+	{storage.ErrInvalidBucketName, "InvalidBucketName", "The specified bucket is not valid", http.StatusBadRequest},
+	{storage.ErrKeyTooLong, "KeyTooLongError", "Your key is too long", http.StatusBadRequest},
+	{storage.ErrInvalidKey, "InvalidArgument", "Object keys must be UTF-8", http.StatusBadRequest},
+	{storage.ErrInvalidRange, "InvalidRange", "The requested range is not satisfiable", http.StatusRequestedRangeNotSatisfiable},
+	{storage.ErrInvalidVersioningStatus, "IllegalVersioningConfigurationException", "The versioning status must be Enabled or Suspended", http.StatusBadRequest},
+	{auth.ErrContentSHA256Mismatch, "XAmzContentSHA256Mismatch", "", http.StatusBadRequest},
+	{auth.ErrChunkSignatureMismatch, "SignatureDoesNotMatch", "", http.StatusForbidden},
+	{auth.ErrMalformedChunk, "IncompleteBody", "", http.StatusBadRequest},
+}
+
+// writeStorageError writes the S3 error for a failed storage operation.
+// Failures with no S3 equivalent are reported as InternalError
+func writeStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	code, message, status := storageError(err)
+	writeError(w, r, code, message, status)
+}
+
+// storageError returns the S3 error code, message and status for a failed
+// storage operation
+func storageError(err error) (code, message string, status int) {
+	for _, e := range storageErrors {
+		if errors.Is(err, e.err) {
+			message := e.message
+			if message == "" {
+				message = err.Error()
+			}
+			return e.code, message, e.status
+		}
+	}
+	return "InternalError", err.Error(), http.StatusInternalServerError
+}
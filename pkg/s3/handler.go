@@ -9,20 +9,31 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/stut/s3dir/pkg/auth"
 	"github.com/stut/s3dir/pkg/storage"
 )
 
 // Handler handles S3 API requests
 type Handler struct {
-	storage  *storage.Storage
+	storage  Backend
 	readOnly bool
 	verbose  bool
+
+	// domains are the base domains for virtual-hosted-style requests
+	domains []string
+	// legacyBucketNames relaxes the naming rules for new buckets
+	legacyBucketNames bool
+	// draining is set once the server is shutting down
+	draining atomic.Bool
+	// logDelivery buffers access logs for target buckets, once started
+	logDelivery *logDelivery
 }
 
-// NewHandler creates a new S3 handler
-func NewHandler(storage *storage.Storage, readOnly, verbose bool) *Handler {
+// NewHandler creates a new S3 handler serving requests from a storage backend
+func NewHandler(storage Backend, readOnly, verbose bool) *Handler {
 	return &Handler{
 		storage:  storage,
 		readOnly: readOnly,
@@ -30,14 +41,32 @@ func NewHandler(storage *storage.Storage, readOnly, verbose bool) *Handler {
 	}
 }
 
+// Drain makes the handler refuse new requests with 503 ServiceUnavailable,
+// asking clients to retry elsewhere, while requests already being served
+// finish. It is used during graceful shutdown
+func (h *Handler) Drain() {
+	h.draining.Store(true)
+}
+
 // ServeHTTP handles HTTP requests and routes them to appropriate handlers
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := requestID(w)
 	if h.verbose {
-		fmt.Printf("%s %s\n", r.Method, r.URL.RequestURI())
+		fmt.Printf("%s %s %s\n", id, r.Method, r.URL.RequestURI())
 	}
 
-	// Parse bucket and key from path
-	bucket, key := h.parsePath(r.URL.Path)
+	if h.draining.Load() {
+		w.Header().Set("Connection", "close")
+		w.Header().Set("Retry-After", "1")
+		writeError(w, r, "ServiceUnavailable", "The server is shutting down. Please retry your request.", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Parse bucket and key from the Host header or path
+	bucket, key := h.parseRequest(r)
+	if !checkNames(w, r, bucket, key) {
+		return
+	}
 
 	// Route to appropriate handler
 	if bucket == "" {
@@ -62,15 +91,15 @@ func (h *Handler) handleServiceOperation(w http.ResponseWriter, r *http.Request)
 	case http.MethodGet:
 		h.listBuckets(w, r)
 	default:
-		writeError(w, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 // bucketSubresources are the bucket configuration subresources s3dir
-// recognises but does not implement. GETs receive a stub or the S3 error code
-// a real bucket without that configuration would return; PUTs and DELETEs are
-// accepted as no-ops so clients cannot accidentally create or delete the
-// bucket itself through them
+// recognises. Those not implemented receive a stub or the S3 error code a
+// real bucket without that configuration would return on GET, and PUTs and
+// DELETEs are accepted as no-ops so clients cannot accidentally create or
+// delete the bucket itself through them
 var bucketSubresources = []string{
 	"accelerate", "acl", "cors", "encryption", "lifecycle", "location",
 	"logging", "notification", "object-lock", "policy", "replication",
@@ -94,14 +123,23 @@ func (h *Handler) handleBucketOperation(w http.ResponseWriter, r *http.Request,
 
 	// Check for multipart uploads listing
 	if r.Method == http.MethodGet && query.Has("uploads") {
+		if !h.authorize(w, r, auth.ActionMultipart, "s3:ListBucketMultipartUploads", bucket, "") {
+			return
+		}
 		h.listMultipartUploads(w, r, bucket)
 		return
 	}
 
+	// Check for object versions listing
+	if r.Method == http.MethodGet && query.Has("versions") {
+		h.listObjectVersions(w, r, bucket)
+		return
+	}
+
 	// Check for batch delete
 	if r.Method == http.MethodPost && query.Has("delete") {
 		if h.readOnly {
-			writeError(w, "AccessDenied", "Read-only mode", http.StatusForbidden)
+			writeError(w, r, "AccessDenied", "Read-only mode", http.StatusForbidden)
 			return
 		}
 		h.deleteObjects(w, r, bucket)
@@ -118,21 +156,24 @@ func (h *Handler) handleBucketOperation(w http.ResponseWriter, r *http.Request,
 	case http.MethodGet:
 		h.listObjects(w, r, bucket)
 	case http.MethodHead:
+		cred := auth.CredentialFromContext(r.Context())
+		if !h.permitted(r, cred == nil || cred.AllowsAnyKey(auth.ActionRead, bucket), "s3:ListBucket", bucket, "") {
+			writeError(w, r, "AccessDenied", "Access Denied", http.StatusForbidden)
+			return
+		}
 		h.headBucket(w, r, bucket)
 	case http.MethodPut:
-		if h.readOnly {
-			writeError(w, "AccessDenied", "Read-only mode", http.StatusForbidden)
+		if !h.authorize(w, r, auth.ActionAdmin, "s3:CreateBucket", bucket, "") {
 			return
 		}
 		h.createBucket(w, r, bucket)
 	case http.MethodDelete:
-		if h.readOnly {
-			writeError(w, "AccessDenied", "Read-only mode", http.StatusForbidden)
+		if !h.authorize(w, r, auth.ActionAdmin, "s3:DeleteBucket", bucket, "") {
 			return
 		}
 		h.deleteBucket(w, r, bucket)
 	default:
-		writeError(w, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
@@ -142,8 +183,16 @@ func (h *Handler) handleObjectOperation(w http.ResponseWriter, r *http.Request,
 
 	// Handle multipart upload operations
 	if uploadID := query.Get("uploadId"); uploadID != "" {
-		if h.readOnly {
-			writeError(w, "AccessDenied", "Read-only mode", http.StatusForbidden)
+		op := "s3:PutObject"
+		switch r.Method {
+		case http.MethodGet:
+			op = "s3:ListMultipartUploadParts"
+		case http.MethodDelete:
+			op = "s3:AbortMultipartUpload"
+		}
+		// Grants and the bucket policy are checked against the path, which
+		// multipartUpload then requires to be the upload's own bucket and key
+		if !h.authorize(w, r, auth.ActionMultipart, op, bucket, key) {
 			return
 		}
 
@@ -160,7 +209,7 @@ func (h *Handler) handleObjectOperation(w http.ResponseWriter, r *http.Request,
 					h.uploadPart(w, r, bucket, key, uploadID, partNumber)
 				}
 			} else {
-				writeError(w, "InvalidRequest", "partNumber is required", http.StatusBadRequest)
+				writeError(w, r, "InvalidRequest", "partNumber is required", http.StatusBadRequest)
 			}
 		case http.MethodPost:
 			// Complete multipart upload
@@ -169,41 +218,59 @@ func (h *Handler) handleObjectOperation(w http.ResponseWriter, r *http.Request,
 			// Abort multipart upload
 			h.abortMultipartUpload(w, r, bucket, key, uploadID)
 		default:
-			writeError(w, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
 		}
 		return
 	}
 
 	// Initiate multipart upload
 	if query.Has("uploads") {
-		if h.readOnly {
-			writeError(w, "AccessDenied", "Read-only mode", http.StatusForbidden)
+		if !h.authorize(w, r, auth.ActionMultipart, "s3:PutObject", bucket, key) {
 			return
 		}
 		if r.Method == http.MethodPost {
 			h.initiateMultipartUpload(w, r, bucket, key)
 		} else {
-			writeError(w, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
 		}
 		return
 	}
 
-	// Object subresources (?acl, ?tagging): served as stubs so clients don't
-	// corrupt object data through the plain PUT path
-	if query.Has("acl") || query.Has("tagging") {
+	// Object subresources: tags are stored, ACLs served as stubs so clients
+	// don't corrupt object data through the plain PUT path
+	if query.Has("tagging") {
+		h.handleObjectTagging(w, r, bucket, key)
+		return
+	}
+	if query.Has("acl") {
 		h.handleObjectSubresource(w, r, bucket, key)
 		return
 	}
+	if query.Has("attributes") {
+		h.getObjectAttributes(w, r, bucket, key)
+		return
+	}
+
+	// Requests naming a version need the version-specific S3 actions
+	getOp, deleteOp := "s3:GetObject", "s3:DeleteObject"
+	if query.Get("versionId") != "" {
+		getOp, deleteOp = "s3:GetObjectVersion", "s3:DeleteObjectVersion"
+	}
 
 	// Standard object operations
 	switch r.Method {
 	case http.MethodGet:
+		if !h.authorize(w, r, auth.ActionRead, getOp, bucket, key) {
+			return
+		}
 		h.getObject(w, r, bucket, key)
 	case http.MethodHead:
+		if !h.authorize(w, r, auth.ActionRead, getOp, bucket, key) {
+			return
+		}
 		h.headObject(w, r, bucket, key)
 	case http.MethodPut:
-		if h.readOnly {
-			writeError(w, "AccessDenied", "Read-only mode", http.StatusForbidden)
+		if !h.authorize(w, r, auth.ActionWrite, "s3:PutObject", bucket, key) {
 			return
 		}
 		if r.Header.Get("x-amz-copy-source") != "" {
@@ -212,21 +279,30 @@ func (h *Handler) handleObjectOperation(w http.ResponseWriter, r *http.Request,
 		}
 		h.putObject(w, r, bucket, key)
 	case http.MethodDelete:
-		if h.readOnly {
-			writeError(w, "AccessDenied", "Read-only mode", http.StatusForbidden)
+		if !h.authorize(w, r, auth.ActionDelete, deleteOp, bucket, key) {
 			return
 		}
 		h.deleteObject(w, r, bucket, key)
 	default:
-		writeError(w, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 // handleBucketSubresource handles requests addressing bucket configuration
 // subresources (?location, ?versioning, ?acl, ?lifecycle, ...)
 func (h *Handler) handleBucketSubresource(w http.ResponseWriter, r *http.Request, bucket string) {
+	// Authorize before looking the bucket up, so that callers without access
+	// can't learn which buckets exist
+	class := auth.ActionAdmin
+	if r.Method == http.MethodGet {
+		class = auth.ActionRead
+	}
+	if !h.authorize(w, r, class, bucketSubresourceAction(r), bucket, "") {
+		return
+	}
+
 	if err := h.storage.HeadBucket(bucket); err != nil {
-		writeError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
 		return
 	}
 
@@ -239,40 +315,66 @@ func (h *Handler) handleBucketSubresource(w http.ResponseWriter, r *http.Request
 			// Empty value means us-east-1, matching AWS
 			writeXML(w, LocationConstraint{}, http.StatusOK)
 		case query.Has("versioning"):
-			writeXML(w, VersioningConfiguration{}, http.StatusOK)
+			h.getBucketVersioning(w, r, bucket)
 		case query.Has("acl"):
 			writeXML(w, ownerFullControlACL(), http.StatusOK)
 		case query.Has("tagging"):
-			writeError(w, "NoSuchTagSet", "The TagSet does not exist", http.StatusNotFound)
+			writeError(w, r, "NoSuchTagSet", "The TagSet does not exist", http.StatusNotFound)
 		case query.Has("lifecycle"):
-			writeError(w, "NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist", http.StatusNotFound)
+			h.getBucketLifecycle(w, r, bucket)
 		case query.Has("cors"):
-			writeError(w, "NoSuchCORSConfiguration", "The CORS configuration does not exist", http.StatusNotFound)
+			h.getBucketCORS(w, r, bucket)
 		case query.Has("policy"):
-			writeError(w, "NoSuchBucketPolicy", "The bucket policy does not exist", http.StatusNotFound)
+			h.getBucketPolicy(w, r, bucket)
+		case query.Has("logging"):
+			h.getBucketLogging(w, r, bucket)
 		case query.Has("encryption"):
-			writeError(w, "ServerSideEncryptionConfigurationNotFoundError", "The server side encryption configuration was not found", http.StatusNotFound)
+			writeError(w, r, "ServerSideEncryptionConfigurationNotFoundError", "The server side encryption configuration was not found", http.StatusNotFound)
 		case query.Has("object-lock"):
-			writeError(w, "ObjectLockConfigurationNotFoundError", "Object Lock configuration does not exist for this bucket", http.StatusNotFound)
+			writeError(w, r, "ObjectLockConfigurationNotFoundError", "Object Lock configuration does not exist for this bucket", http.StatusNotFound)
 		default:
-			writeError(w, "NotImplemented", "This bucket subresource is not implemented", http.StatusNotImplemented)
+			writeError(w, r, "NotImplemented", "This bucket subresource is not implemented", http.StatusNotImplemented)
 		}
 	case http.MethodPut:
-		if h.readOnly {
-			writeError(w, "AccessDenied", "Read-only mode", http.StatusForbidden)
+		switch {
+		case query.Has("policy"):
+			h.putBucketPolicy(w, r, bucket)
+			return
+		case query.Has("versioning"):
+			h.putBucketVersioning(w, r, bucket)
+			return
+		case query.Has("lifecycle"):
+			h.putBucketLifecycle(w, r, bucket)
+			return
+		case query.Has("cors"):
+			h.putBucketCORS(w, r, bucket)
+			return
+		case query.Has("logging"):
+			h.putBucketLogging(w, r, bucket)
 			return
 		}
-		// Accept configuration writes as no-ops
+		// Accept other configuration writes as no-ops
 		w.WriteHeader(http.StatusOK)
 	case http.MethodDelete:
-		if h.readOnly {
-			writeError(w, "AccessDenied", "Read-only mode", http.StatusForbidden)
+		switch {
+		case query.Has("policy"):
+			h.deleteBucketPolicy(w, r, bucket)
+			return
+		case query.Has("lifecycle"):
+			h.deleteBucketLifecycle(w, r, bucket)
+			return
+		case query.Has("cors"):
+			h.deleteBucketCORS(w, r, bucket)
+			return
+		case query.Has("logging"):
+			h.deleteBucketLogging(w, r, bucket)
 			return
 		}
-		// Accept configuration deletes as no-ops (this must not delete the bucket)
+		// Accept other configuration deletes as no-ops (this must not delete
+		// the bucket)
 		w.WriteHeader(http.StatusNoContent)
 	default:
-		writeError(w, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
@@ -298,12 +400,17 @@ func ownerFullControlACL() AccessControlPolicy {
 func (h *Handler) listBuckets(w http.ResponseWriter, r *http.Request) {
 	buckets, err := h.storage.ListBuckets()
 	if err != nil {
-		writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, r, err)
 		return
 	}
 
+	cred := auth.CredentialFromContext(r.Context())
+
 	var bucketList []Bucket
 	for _, name := range buckets {
+		if cred != nil && !cred.AllowsAnyKey(auth.ActionRead, name) {
+			continue
+		}
 		bucketList = append(bucketList, Bucket{
 			Name:         name,
 			CreationDate: time.Now().UTC().Format(time.RFC3339),
@@ -327,6 +434,14 @@ func (h *Handler) listObjects(w http.ResponseWriter, r *http.Request, bucket str
 	query := r.URL.Query()
 	prefix := query.Get("prefix")
 	delimiter := query.Get("delimiter")
+
+	// A listing is allowed when every key under the prefix is readable
+	cred := auth.CredentialFromContext(r.Context())
+	if !h.permitted(r, cred == nil || cred.AllowsPrefix(auth.ActionRead, bucket, prefix), "s3:ListBucket", bucket, "") {
+		writeError(w, r, "AccessDenied", "Access Denied", http.StatusForbidden)
+		return
+	}
+
 	maxKeys := 1000
 	if mk := query.Get("max-keys"); mk != "" {
 		if n, err := strconv.Atoi(mk); err == nil && n >= 0 {
@@ -346,7 +461,7 @@ func (h *Handler) listObjects(w http.ResponseWriter, r *http.Request, bucket str
 		if continuationToken != "" {
 			decoded, err := base64.StdEncoding.DecodeString(continuationToken)
 			if err != nil {
-				writeError(w, "InvalidArgument", "The continuation token provided is incorrect", http.StatusBadRequest)
+				writeError(w, r, "InvalidArgument", "The continuation token provided is incorrect", http.StatusBadRequest)
 				return
 			}
 			marker = string(decoded)
@@ -355,11 +470,7 @@ func (h *Handler) listObjects(w http.ResponseWriter, r *http.Request, bucket str
 
 	objects, commonPrefixes, truncated, nextMarker, err := h.storage.ListObjectsPage(bucket, prefix, delimiter, marker, maxKeys)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
-		} else {
-			writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+		writeStorageError(w, r, err)
 		return
 	}
 
@@ -424,6 +535,12 @@ func setObjectHeaders(w http.ResponseWriter, info *storage.ObjectInfo) {
 	w.Header().Set("ETag", info.ETag)
 	w.Header().Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
 	w.Header().Set("Accept-Ranges", "bytes")
+	if info.VersionID != "" {
+		w.Header().Set("x-amz-version-id", info.VersionID)
+	}
+	if len(info.Tags) > 0 {
+		w.Header().Set("x-amz-tagging-count", strconv.Itoa(len(info.Tags)))
+	}
 	for name, value := range info.UserMetadata {
 		w.Header().Set("x-amz-meta-"+name, value)
 	}
@@ -456,6 +573,22 @@ func checkNotModified(r *http.Request, info *storage.ObjectInfo) bool {
 	return false
 }
 
+// requestWriteConditions reads the If-Match and If-None-Match headers of a
+// PUT or CompleteMultipartUpload. If-None-Match only supports "*", creating
+// the object only if it does not exist; other values are reported and return
+// false
+func requestWriteConditions(w http.ResponseWriter, r *http.Request) (storage.WriteConditions, bool) {
+	conditions := storage.WriteConditions{IfMatch: r.Header.Get("If-Match")}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if strings.TrimSpace(inm) != "*" {
+			writeError(w, r, "NotImplemented", "A header you provided implies functionality that is not implemented", http.StatusNotImplemented)
+			return conditions, false
+		}
+		conditions.IfNoneMatch = true
+	}
+	return conditions, true
+}
+
 // parseRangeHeader parses a single Range request header against an object of
 // the given size. valid is false for headers that should be ignored (malformed
 // or multi-range); satisfiable is false when a valid range lies outside the
@@ -509,18 +642,38 @@ func parseRangeHeader(value string, size int64) (start, length int64, valid, sat
 	return first, last - first + 1, true, true
 }
 
-// getObject retrieves an object, honouring Range and conditional request
-// headers
-func (h *Handler) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
-	info, err := h.storage.HeadObject(bucket, key)
+// headObjectVersion looks up the version of an object a GET or HEAD request
+// addresses, writing the error response if it cannot be served
+func (h *Handler) headObjectVersion(w http.ResponseWriter, r *http.Request, bucket, key string) (*storage.ObjectInfo, bool) {
+	versionID := r.URL.Query().Get("versionId")
+
+	info, err := h.storage.HeadObjectVersion(bucket, key, versionID)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
+		writeStorageError(w, r, err)
+		return nil, false
+	}
+
+	if info.DeleteMarker {
+		setDeleteMarkerHeaders(w, info)
+		if versionID != "" {
+			writeError(w, r, "MethodNotAllowed", "The specified method is not allowed against this resource", http.StatusMethodNotAllowed)
 		} else {
-			writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
+			writeError(w, r, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
 		}
+		return nil, false
+	}
+
+	return info, true
+}
+
+// getObject retrieves an object, honouring Range and conditional request
+// headers
+func (h *Handler) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	info, ok := h.headObjectVersion(w, r, bucket, key)
+	if !ok {
 		return
 	}
+	versionID := r.URL.Query().Get("versionId")
 
 	if checkNotModified(r, info) {
 		w.Header().Set("ETag", info.ETag)
@@ -529,45 +682,45 @@ func (h *Handler) getObject(w http.ResponseWriter, r *http.Request, bucket, key
 		return
 	}
 
+	// Part read: one of the parts a multipart object was completed from
+	if r.URL.Query().Has("partNumber") {
+		part, ok := requestedPart(w, r, info)
+		if !ok {
+			return
+		}
+		if part != nil {
+			h.writeRange(w, r, info, bucket, key, versionID, part.Offset, part.Size)
+			return
+		}
+	}
+
 	// Ranged read
 	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
 		start, length, valid, satisfiable := parseRangeHeader(rangeHeader, info.Size)
 		if valid {
 			if !satisfiable {
 				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
-				writeError(w, "InvalidRange", "The requested range is not satisfiable", http.StatusRequestedRangeNotSatisfiable)
-				return
-			}
-
-			reader, _, err := h.storage.GetObjectRange(bucket, key, start, length)
-			if err != nil {
-				writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
+				writeError(w, r, "InvalidRange", "The requested range is not satisfiable", http.StatusRequestedRangeNotSatisfiable)
 				return
 			}
-			defer reader.Close()
 
-			setObjectHeaders(w, info)
-			w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
-			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, info.Size))
-			w.WriteHeader(http.StatusPartialContent)
-
-			if _, err := io.Copy(w, reader); err != nil {
-				// Error writing to response - headers are already sent
-				return
-			}
+			h.writeRange(w, r, info, bucket, key, versionID, start, length)
 			return
 		}
 		// Malformed range headers are ignored and the full object returned
 	}
 
-	reader, _, err := h.storage.GetObject(bucket, key)
+	reader, _, err := h.storage.GetObjectVersion(bucket, key, versionID)
 	if err != nil {
-		writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, r, err)
 		return
 	}
 	defer reader.Close()
 
 	setObjectHeaders(w, info)
+	if checksumModeEnabled(r) {
+		setChecksumHeaders(w, info.Checksum)
+	}
 	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
 
 	// Copy object data to response
@@ -578,15 +731,68 @@ func (h *Handler) getObject(w http.ResponseWriter, r *http.Request, bucket, key
 	}
 }
 
+// writeRange sends length bytes of an object starting at start as a 206
+// Partial Content response
+func (h *Handler) writeRange(w http.ResponseWriter, r *http.Request, info *storage.ObjectInfo, bucket, key, versionID string, start, length int64) {
+	reader, _, err := h.storage.GetObjectRangeVersion(bucket, key, versionID, start, length)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	defer reader.Close()
+
+	writePartialHeader(w, info, start, length)
+
+	if _, err := io.Copy(w, reader); err != nil {
+		// Error writing to response - headers are already sent
+		return
+	}
+}
+
+// writePartialHeader writes the headers of a 206 Partial Content response
+// sending length bytes of an object starting at start
+func writePartialHeader(w http.ResponseWriter, info *storage.ObjectInfo, start, length int64) {
+	setObjectHeaders(w, info)
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, info.Size))
+	w.WriteHeader(http.StatusPartialContent)
+}
+
+// requestedPart returns the part of an object a GET or HEAD with
+// ?partNumber=N addresses, setting x-amz-mp-parts-count. Objects written in
+// one piece are their own single part. Empty parts have no byte range to
+// send and return nil, so the whole, empty object is served. Invalid or
+// missing parts are reported and return false
+func requestedPart(w http.ResponseWriter, r *http.Request, info *storage.ObjectInfo) (*storage.ObjectPart, bool) {
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || number < 1 || number > 10000 {
+		writeError(w, r, "InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive", http.StatusBadRequest)
+		return nil, false
+	}
+	if r.Header.Get("Range") != "" {
+		writeError(w, r, "InvalidRequest", "Cannot specify both Range header and partNumber query parameter", http.StatusBadRequest)
+		return nil, false
+	}
+
+	parts := info.Parts
+	if len(parts) == 0 {
+		parts = []storage.ObjectPart{{PartNumber: 1, Size: info.Size}}
+	}
+	if number > len(parts) {
+		writeError(w, r, "InvalidPartNumber", "The requested partnumber is not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return nil, false
+	}
+	w.Header().Set("x-amz-mp-parts-count", strconv.Itoa(len(parts)))
+	if parts[number-1].Size == 0 {
+		return nil, true
+	}
+	return &parts[number-1], true
+}
+
 // headObject retrieves object metadata
 func (h *Handler) headObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
-	info, err := h.storage.HeadObject(bucket, key)
-	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
-		} else {
-			writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+	info, ok := h.headObjectVersion(w, r, bucket, key)
+	if !ok {
 		return
 	}
 
@@ -597,7 +803,21 @@ func (h *Handler) headObject(w http.ResponseWriter, r *http.Request, bucket, key
 		return
 	}
 
+	if r.URL.Query().Has("partNumber") {
+		part, ok := requestedPart(w, r, info)
+		if !ok {
+			return
+		}
+		if part != nil {
+			writePartialHeader(w, info, part.Offset, part.Size)
+			return
+		}
+	}
+
 	setObjectHeaders(w, info)
+	if checksumModeEnabled(r) {
+		setChecksumHeaders(w, info.Checksum)
+	}
 	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
 	w.WriteHeader(http.StatusOK)
 }
@@ -617,43 +837,100 @@ func userMetadataFromHeader(header http.Header) map[string]string {
 	return meta
 }
 
+// requestBody returns the content uploaded by a PUT or UploadPart request and
+// its length. aws-chunked bodies are decoded, checking chunk signatures if
+// the request was authenticated with them, and their trailing headers are
+// added to r.Trailer once the content has been read. A missing length is
+// reported and returns false
+func requestBody(w http.ResponseWriter, r *http.Request) (io.Reader, int64, bool) {
+	if !auth.IsStreamingPayload(r) {
+		if r.ContentLength < 0 {
+			writeError(w, r, "MissingContentLength", "Content-Length header is required", http.StatusLengthRequired)
+			return nil, 0, false
+		}
+		return r.Body, r.ContentLength, true
+	}
+
+	decodedLength, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+	if err != nil || decodedLength < 0 {
+		writeError(w, r, "MissingContentLength", "x-amz-decoded-content-length header is required for aws-chunked uploads", http.StatusLengthRequired)
+		return nil, 0, false
+	}
+	if r.Trailer == nil {
+		r.Trailer = make(http.Header)
+	}
+	return auth.NewChunkedReader(r.Body, decodedLength, auth.ChunkVerifierFromContext(r.Context()), r.Trailer), decodedLength, true
+}
+
 // putObject stores an object
 func (h *Handler) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
-	contentLength := r.ContentLength
-	if contentLength < 0 {
-		writeError(w, "MissingContentLength", "Content-Length header is required", http.StatusLengthRequired)
+	body, contentLength, ok := requestBody(w, r)
+	if !ok {
+		return
+	}
+
+	tags, ok := tagsFromHeader(w, r)
+	if !ok {
+		return
+	}
+
+	checksumAlgorithm, digests, ok := requestDigests(w, r)
+	if !ok {
 		return
 	}
 
-	etag, err := h.storage.PutObjectWithMetadata(bucket, key, r.Body, contentLength,
-		r.Header.Get("Content-Type"), userMetadataFromHeader(r.Header))
+	conditions, ok := requestWriteConditions(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := h.storage.PutObjectWithMetadata(bucket, key, body, contentLength, storage.ObjectMetadata{
+		ContentType:       r.Header.Get("Content-Type"),
+		UserMetadata:      userMetadataFromHeader(r.Header),
+		Tags:              tags,
+		ChecksumAlgorithm: checksumAlgorithm,
+		Digests:           digests,
+		Conditions:        conditions,
+	})
 	if err != nil {
-		writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, r, err)
 		return
 	}
 
-	w.Header().Set("ETag", etag)
+	w.Header().Set("ETag", info.ETag)
+	setChecksumHeaders(w, info.Checksum)
+	if info.VersionID != "" {
+		w.Header().Set("x-amz-version-id", info.VersionID)
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
-// deleteObject deletes an object
+// deleteObject deletes an object, or the version named by ?versionId
 func (h *Handler) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
-	if err := h.storage.DeleteObject(bucket, key); err != nil {
-		writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
+	versionID, deleteMarker, err := h.storage.DeleteObjectVersion(bucket, key, r.URL.Query().Get("versionId"))
+	if err != nil {
+		writeStorageError(w, r, err)
 		return
 	}
 
+	if versionID != "" {
+		w.Header().Set("x-amz-version-id", versionID)
+	}
+	if deleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // createBucket creates a new bucket
 func (h *Handler) createBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !validBucketName(bucket, h.legacyBucketNames) {
+		writeStorageError(w, r, storage.ErrInvalidBucketName)
+		return
+	}
+
 	if err := h.storage.CreateBucket(bucket); err != nil {
-		if strings.Contains(err.Error(), "already exists") {
-			writeError(w, "BucketAlreadyExists", "The bucket already exists", http.StatusConflict)
-		} else {
-			writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+		writeStorageError(w, r, err)
 		return
 	}
 
@@ -663,15 +940,10 @@ func (h *Handler) createBucket(w http.ResponseWriter, r *http.Request, bucket st
 // deleteBucket deletes a bucket
 func (h *Handler) deleteBucket(w http.ResponseWriter, r *http.Request, bucket string) {
 	if err := h.storage.DeleteBucket(bucket); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
-		} else if strings.Contains(err.Error(), "not empty") {
-			writeError(w, "BucketNotEmpty", "The bucket is not empty", http.StatusConflict)
-		} else {
-			writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+		writeStorageError(w, r, err)
 		return
 	}
+	h.forgetLoggingTarget(bucket)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -679,11 +951,7 @@ func (h *Handler) deleteBucket(w http.ResponseWriter, r *http.Request, bucket st
 // headBucket checks if a bucket exists
 func (h *Handler) headBucket(w http.ResponseWriter, r *http.Request, bucket string) {
 	if err := h.storage.HeadBucket(bucket); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
-		} else {
-			writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+		writeStorageError(w, r, err)
 		return
 	}
 
@@ -731,75 +999,82 @@ func writeXML(w http.ResponseWriter, data interface{}, statusCode int) {
 }
 
 // writeError writes an S3 error response
-func writeError(w http.ResponseWriter, code, message string, statusCode int) {
+func writeError(w http.ResponseWriter, r *http.Request, code, message string, statusCode int) {
 	errorResponse := ErrorResponse{
-		Code:    code,
-		Message: message,
+		Code:      code,
+		Message:   message,
+		Resource:  r.URL.Path,
+		RequestID: requestID(w),
+		HostID:    w.Header().Get(hostIDHeader),
 	}
 
 	writeXML(w, errorResponse, statusCode)
 }
 
-// handleObjectSubresource handles requests addressing object subresources
-// (?acl, ?tagging)
+// handleObjectSubresource handles requests addressing the object ?acl
+// subresource
 func (h *Handler) handleObjectSubresource(w http.ResponseWriter, r *http.Request, bucket, key string) {
-	if _, err := h.storage.HeadObject(bucket, key); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
-		} else {
-			writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+	// Authorize before looking the object up, so that callers without access
+	// can't learn which keys exist
+	class := auth.ActionWrite
+	if r.Method == http.MethodGet {
+		class = auth.ActionRead
+	}
+	if !h.authorize(w, r, class, objectSubresourceAction(r), bucket, key) {
 		return
 	}
 
-	query := r.URL.Query()
+	if _, err := h.storage.HeadObject(bucket, key); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
-		if query.Has("acl") {
-			writeXML(w, ownerFullControlACL(), http.StatusOK)
-		} else {
-			// Objects have no tags; an empty TagSet is the AWS response
-			writeXML(w, Tagging{}, http.StatusOK)
-		}
+		writeXML(w, ownerFullControlACL(), http.StatusOK)
 	case http.MethodPut:
-		if h.readOnly {
-			writeError(w, "AccessDenied", "Read-only mode", http.StatusForbidden)
-			return
-		}
-		// Accept ACL and tagging writes as no-ops
+		// Accept ACL writes as no-ops
 		w.WriteHeader(http.StatusOK)
 	case http.MethodDelete:
-		if h.readOnly {
-			writeError(w, "AccessDenied", "Read-only mode", http.StatusForbidden)
-			return
-		}
 		w.WriteHeader(http.StatusNoContent)
 	default:
-		writeError(w, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-// parseCopySource parses an x-amz-copy-source header value into bucket and key.
-// The value is "/{bucket}/{key}" or "{bucket}/{key}" and may be URL-encoded
-func parseCopySource(source string) (bucket, key string, err error) {
-	// Strip any query string (e.g. ?versionId=...)
-	if idx := strings.Index(source, "?"); idx != -1 {
-		source = source[:idx]
+// parseCopySource parses an x-amz-copy-source header value into bucket, key
+// and version ID. The value is "/{bucket}/{key}" or "{bucket}/{key}", may be
+// URL-encoded and may end in "?versionId={id}"
+func parseCopySource(source string) (bucket, key, versionID string, err error) {
+	source, rawQuery, _ := strings.Cut(source, "?")
+	if rawQuery != "" {
+		query, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return "", "", "", fmt.Errorf("invalid copy source query")
+		}
+		versionID = query.Get("versionId")
 	}
 
 	decoded, err := url.PathUnescape(source)
 	if err != nil {
-		return "", "", fmt.Errorf("invalid copy source encoding")
+		return "", "", "", fmt.Errorf("invalid copy source encoding")
 	}
 
 	decoded = strings.TrimPrefix(decoded, "/")
 	parts := strings.SplitN(decoded, "/", 2)
 	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-		return "", "", fmt.Errorf("copy source must be of the form /bucket/key")
+		return "", "", "", fmt.Errorf("copy source must be of the form /bucket/key")
 	}
 
-	return parts[0], parts[1], nil
+	return parts[0], parts[1], versionID, nil
+}
+
+// copySourceAction returns the S3 action needed to read a copy source
+func copySourceAction(versionID string) string {
+	if versionID != "" {
+		return "s3:GetObjectVersion"
+	}
+	return "s3:GetObject"
 }
 
 // parseCopySourceRange parses an x-amz-copy-source-range header value of the
@@ -830,43 +1105,86 @@ func parseCopySourceRange(value string) (start, end int64, err error) {
 
 // copyObject copies an object server-side
 func (h *Handler) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
-	srcBucket, srcKey, err := parseCopySource(r.Header.Get("x-amz-copy-source"))
+	srcBucket, srcKey, srcVersionID, err := h.copySource(r)
 	if err != nil {
-		writeError(w, "InvalidArgument", err.Error(), http.StatusBadRequest)
+		writeError(w, r, "InvalidArgument", err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.authorize(w, r, auth.ActionRead, copySourceAction(srcVersionID), srcBucket, srcKey) {
+		return
+	}
+
+	tags, ok := tagsFromHeader(w, r)
+	if !ok {
+		return
+	}
+
+	checksumAlgorithm, ok := requestChecksumAlgorithm(w, r)
+	if !ok {
 		return
 	}
 
 	replaceMetadata := strings.EqualFold(r.Header.Get("x-amz-metadata-directive"), "REPLACE")
-	info, err := h.storage.CopyObjectWithMetadata(srcBucket, srcKey, bucket, key,
-		replaceMetadata, r.Header.Get("Content-Type"), userMetadataFromHeader(r.Header))
+	replaceTags := strings.EqualFold(r.Header.Get("x-amz-tagging-directive"), "REPLACE")
+	info, err := h.storage.CopyObjectWithMetadata(srcBucket, srcKey, srcVersionID, bucket, key,
+		replaceMetadata, replaceTags, storage.ObjectMetadata{
+			ContentType:       r.Header.Get("Content-Type"),
+			UserMetadata:      userMetadataFromHeader(r.Header),
+			Tags:              tags,
+			ChecksumAlgorithm: checksumAlgorithm,
+		})
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
-		} else {
-			writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+		writeStorageError(w, r, err)
 		return
 	}
 
+	if srcVersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", srcVersionID)
+	}
+	if info.VersionID != "" {
+		w.Header().Set("x-amz-version-id", info.VersionID)
+	}
+
 	response := CopyObjectResult{
 		LastModified: info.LastModified.UTC().Format(time.RFC3339),
 		ETag:         info.ETag,
+		Checksums:    checksumElements(info.Checksum),
+		ChecksumType: checksumType(info.Checksum),
 	}
 
 	writeXML(w, response, http.StatusOK)
 }
 
+// multipartUpload returns the upload a request addresses, writing a
+// NoSuchUpload response if it does not exist or belongs to another bucket or
+// key. Uploads are found by ID alone, so one reached through another bucket's
+// path would escape the grants and policy checked for the request
+func (h *Handler) multipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) (*storage.MultipartUpload, bool) {
+	upload, err := h.storage.GetMultipartUpload(uploadID)
+	if err == nil && (upload.Bucket != bucket || upload.Key != key) {
+		err = storage.ErrNoSuchUpload
+	}
+	if err != nil {
+		writeStorageError(w, r, err)
+		return nil, false
+	}
+	return upload, true
+}
+
 // uploadPartCopy copies data from an existing object into a part of a multipart upload
 func (h *Handler) uploadPartCopy(w http.ResponseWriter, r *http.Request, bucket, key, uploadID, partNumberStr string) {
 	partNumber, err := strconv.Atoi(partNumberStr)
 	if err != nil || partNumber < 1 || partNumber > 10000 {
-		writeError(w, "InvalidArgument", "Invalid part number", http.StatusBadRequest)
+		writeError(w, r, "InvalidArgument", "Invalid part number", http.StatusBadRequest)
 		return
 	}
 
-	srcBucket, srcKey, err := parseCopySource(r.Header.Get("x-amz-copy-source"))
+	srcBucket, srcKey, srcVersionID, err := h.copySource(r)
 	if err != nil {
-		writeError(w, "InvalidArgument", err.Error(), http.StatusBadRequest)
+		writeError(w, r, "InvalidArgument", err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.authorize(w, r, auth.ActionRead, copySourceAction(srcVersionID), srcBucket, srcKey) {
 		return
 	}
 
@@ -875,25 +1193,25 @@ func (h *Handler) uploadPartCopy(w http.ResponseWriter, r *http.Request, bucket,
 	if rangeHeader := r.Header.Get("x-amz-copy-source-range"); rangeHeader != "" {
 		rangeStart, rangeEnd, err = parseCopySourceRange(rangeHeader)
 		if err != nil {
-			writeError(w, "InvalidArgument", err.Error(), http.StatusBadRequest)
+			writeError(w, r, "InvalidArgument", err.Error(), http.StatusBadRequest)
 			return
 		}
 	}
 
-	etag, err := h.storage.UploadPartCopy(uploadID, partNumber, srcBucket, srcKey, rangeStart, rangeEnd)
+	if _, ok := h.multipartUpload(w, r, bucket, key, uploadID); !ok {
+		return
+	}
+
+	etag, err := h.storage.UploadPartCopy(uploadID, partNumber, srcBucket, srcKey, srcVersionID, rangeStart, rangeEnd)
 	if err != nil {
-		if strings.Contains(err.Error(), "upload not found") {
-			writeError(w, "NoSuchUpload", "The specified upload does not exist", http.StatusNotFound)
-		} else if strings.Contains(err.Error(), "not found") {
-			writeError(w, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
-		} else if strings.Contains(err.Error(), "invalid range") {
-			writeError(w, "InvalidRange", "The requested range is not satisfiable", http.StatusRequestedRangeNotSatisfiable)
-		} else {
-			writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+		writeStorageError(w, r, err)
 		return
 	}
 
+	if srcVersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", srcVersionID)
+	}
+
 	response := CopyPartResult{
 		LastModified: time.Now().UTC().Format(time.RFC3339),
 		ETag:         etag,
@@ -906,23 +1224,45 @@ func (h *Handler) uploadPartCopy(w http.ResponseWriter, r *http.Request, bucket,
 func (h *Handler) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) {
 	var deleteRequest Delete
 	if err := xml.NewDecoder(r.Body).Decode(&deleteRequest); err != nil {
-		writeError(w, "MalformedXML", "Invalid XML", http.StatusBadRequest)
+		writeError(w, r, "MalformedXML", "Invalid XML", http.StatusBadRequest)
 		return
 	}
 
+	cred := auth.CredentialFromContext(r.Context())
+
 	var response DeleteResult
 	for _, obj := range deleteRequest.Objects {
+		op := "s3:DeleteObject"
+		if obj.VersionID != "" {
+			op = "s3:DeleteObjectVersion"
+		}
+		if !h.permitted(r, cred == nil || cred.Allows(auth.ActionDelete, bucket, obj.Key), op, bucket, obj.Key) {
+			response.Errors = append(response.Errors, DeleteError{
+				Key:       obj.Key,
+				VersionID: obj.VersionID,
+				Code:      "AccessDenied",
+				Message:   "Access Denied",
+			})
+			continue
+		}
 		// Deleting a nonexistent key counts as success (AWS semantics)
-		if err := h.storage.DeleteObject(bucket, obj.Key); err != nil {
+		versionID, deleteMarker, err := h.storage.DeleteObjectVersion(bucket, obj.Key, obj.VersionID)
+		if err != nil {
+			code, message, _ := storageError(err)
 			response.Errors = append(response.Errors, DeleteError{
-				Key:     obj.Key,
-				Code:    "InternalError",
-				Message: err.Error(),
+				Key:       obj.Key,
+				VersionID: obj.VersionID,
+				Code:      code,
+				Message:   message,
 			})
 			continue
 		}
 		if !deleteRequest.Quiet {
-			response.Deleted = append(response.Deleted, DeletedObject(obj))
+			deleted := DeletedObject{Key: obj.Key, VersionID: obj.VersionID, DeleteMarker: deleteMarker}
+			if deleteMarker && obj.VersionID == "" {
+				deleted.DeleteMarkerVersionID = versionID
+			}
+			response.Deleted = append(response.Deleted, deleted)
 		}
 	}
 
@@ -931,13 +1271,33 @@ func (h *Handler) deleteObjects(w http.ResponseWriter, r *http.Request, bucket s
 
 // initiateMultipartUpload initiates a multipart upload
 func (h *Handler) initiateMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
-	uploadID, err := h.storage.InitiateMultipartUploadWithMetadata(bucket, key,
-		r.Header.Get("Content-Type"), userMetadataFromHeader(r.Header))
+	tags, ok := tagsFromHeader(w, r)
+	if !ok {
+		return
+	}
+
+	checksumAlgorithm, checksumType, ok := uploadChecksum(w, r)
+	if !ok {
+		return
+	}
+
+	uploadID, err := h.storage.InitiateMultipartUploadWithMetadata(bucket, key, storage.ObjectMetadata{
+		ContentType:       r.Header.Get("Content-Type"),
+		UserMetadata:      userMetadataFromHeader(r.Header),
+		Tags:              tags,
+		ChecksumAlgorithm: checksumAlgorithm,
+		ChecksumType:      checksumType,
+	})
 	if err != nil {
-		writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, r, err)
 		return
 	}
 
+	if checksumAlgorithm != "" {
+		w.Header().Set("x-amz-checksum-algorithm", checksumAlgorithm)
+		w.Header().Set("x-amz-checksum-type", checksumType)
+	}
+
 	response := InitiateMultipartUploadResult{
 		Bucket:   bucket,
 		Key:      key,
@@ -951,27 +1311,34 @@ func (h *Handler) initiateMultipartUpload(w http.ResponseWriter, r *http.Request
 func (h *Handler) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key, uploadID, partNumberStr string) {
 	partNumber, err := strconv.Atoi(partNumberStr)
 	if err != nil || partNumber < 1 || partNumber > 10000 {
-		writeError(w, "InvalidArgument", "Invalid part number", http.StatusBadRequest)
+		writeError(w, r, "InvalidArgument", "Invalid part number", http.StatusBadRequest)
+		return
+	}
+
+	body, contentLength, ok := requestBody(w, r)
+	if !ok {
 		return
 	}
 
-	contentLength := r.ContentLength
-	if contentLength < 0 {
-		writeError(w, "MissingContentLength", "Content-Length header is required", http.StatusLengthRequired)
+	checksumAlgorithm, digests, ok := requestDigests(w, r)
+	if !ok {
 		return
 	}
 
-	etag, err := h.storage.UploadPart(uploadID, partNumber, r.Body, contentLength)
+	if _, ok := h.multipartUpload(w, r, bucket, key, uploadID); !ok {
+		return
+	}
+
+	part, err := h.storage.UploadPartWithDigests(uploadID, partNumber, body, contentLength, checksumAlgorithm, digests)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, "NoSuchUpload", "The specified upload does not exist", http.StatusNotFound)
-		} else {
-			writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+		writeStorageError(w, r, err)
 		return
 	}
 
-	w.Header().Set("ETag", etag)
+	w.Header().Set("ETag", part.ETag)
+	if part.Checksum != nil {
+		w.Header().Set(checksumHeader(part.Checksum.Algorithm), part.Checksum.Value)
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -980,7 +1347,7 @@ func (h *Handler) completeMultipartUpload(w http.ResponseWriter, r *http.Request
 	// Parse the request body
 	var complete CompleteMultipartUpload
 	if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
-		writeError(w, "MalformedXML", "Invalid XML", http.StatusBadRequest)
+		writeError(w, r, "MalformedXML", "Invalid XML", http.StatusBadRequest)
 		return
 	}
 
@@ -990,26 +1357,37 @@ func (h *Handler) completeMultipartUpload(w http.ResponseWriter, r *http.Request
 		parts[i] = storage.CompletePart{
 			PartNumber: part.PartNumber,
 			ETag:       part.ETag,
+			Checksum:   part.Checksums.value(),
 		}
 	}
 
-	etag, err := h.storage.CompleteMultipartUpload(uploadID, parts)
+	conditions, ok := requestWriteConditions(w, r)
+	if !ok {
+		return
+	}
+
+	upload, ok := h.multipartUpload(w, r, bucket, key, uploadID)
+	if !ok {
+		return
+	}
+
+	info, err := h.storage.CompleteMultipartUploadWithConditions(uploadID, parts, conditions)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, "NoSuchUpload", "The specified upload does not exist", http.StatusNotFound)
-		} else if strings.Contains(err.Error(), "part") {
-			writeError(w, "InvalidPart", err.Error(), http.StatusBadRequest)
-		} else {
-			writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+		writeStorageError(w, r, err)
 		return
 	}
 
+	if info.VersionID != "" {
+		w.Header().Set("x-amz-version-id", info.VersionID)
+	}
+
 	response := CompleteMultipartUploadResult{
-		Location: fmt.Sprintf("/%s/%s", bucket, key),
-		Bucket:   bucket,
-		Key:      key,
-		ETag:     etag,
+		Location:     fmt.Sprintf("/%s/%s", upload.Bucket, upload.Key),
+		Bucket:       upload.Bucket,
+		Key:          upload.Key,
+		ETag:         info.ETag,
+		Checksums:    checksumElements(info.Checksum),
+		ChecksumType: checksumType(info.Checksum),
 	}
 
 	writeXML(w, response, http.StatusOK)
@@ -1017,12 +1395,12 @@ func (h *Handler) completeMultipartUpload(w http.ResponseWriter, r *http.Request
 
 // abortMultipartUpload aborts a multipart upload
 func (h *Handler) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	if _, ok := h.multipartUpload(w, r, bucket, key, uploadID); !ok {
+		return
+	}
+
 	if err := h.storage.AbortMultipartUpload(uploadID); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, "NoSuchUpload", "The specified upload does not exist", http.StatusNotFound)
-		} else {
-			writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+		writeStorageError(w, r, err)
 		return
 	}
 
@@ -1031,13 +1409,14 @@ func (h *Handler) abortMultipartUpload(w http.ResponseWriter, r *http.Request, b
 
 // listParts lists the parts of a multipart upload
 func (h *Handler) listParts(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	upload, ok := h.multipartUpload(w, r, bucket, key, uploadID)
+	if !ok {
+		return
+	}
+
 	parts, err := h.storage.ListMultipartUploadParts(uploadID)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, "NoSuchUpload", "The specified upload does not exist", http.StatusNotFound)
-		} else {
-			writeError(w, "InternalError", err.Error(), http.StatusInternalServerError)
-		}
+		writeStorageError(w, r, err)
 		return
 	}
 
@@ -1048,12 +1427,13 @@ func (h *Handler) listParts(w http.ResponseWriter, r *http.Request, bucket, key,
 			LastModified: p.LastModified.UTC().Format(time.RFC3339),
 			ETag:         p.ETag,
 			Size:         p.Size,
+			Checksums:    checksumElements(p.Checksum),
 		})
 	}
 
 	response := ListPartsResult{
-		Bucket:   bucket,
-		Key:      key,
+		Bucket:   upload.Bucket,
+		Key:      upload.Key,
 		UploadID: uploadID,
 		Initiator: Initiator{
 			ID:          "s3dir",
@@ -1068,6 +1448,8 @@ func (h *Handler) listParts(w http.ResponseWriter, r *http.Request, bucket, key,
 		NextPartNumberMarker: 0,
 		MaxParts:             1000,
 		IsTruncated:          false,
+		ChecksumAlgorithm:    upload.ChecksumAlgorithm,
+		ChecksumType:         upload.ChecksumType,
 		Parts:                partsList,
 	}
 
@@ -1091,8 +1473,10 @@ func (h *Handler) listMultipartUploads(w http.ResponseWriter, r *http.Request, b
 				ID:          "s3dir",
 				DisplayName: "s3dir",
 			},
-			StorageClass: "STANDARD",
-			Initiated:    u.Initiated.UTC().Format(time.RFC3339),
+			StorageClass:      "STANDARD",
+			Initiated:         u.Initiated.UTC().Format(time.RFC3339),
+			ChecksumAlgorithm: u.ChecksumAlgorithm,
+			ChecksumType:      u.ChecksumType,
 		})
 	}
 
@@ -2,12 +2,17 @@ package s3
 
 import (
 	"bytes"
+	"crypto/md5"
 	"encoding/xml"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/stut/s3dir/pkg/auth"
 	"github.com/stut/s3dir/pkg/storage"
 )
 
@@ -183,6 +188,70 @@ func TestPutObjectWithoutContentLength(t *testing.T) {
 	}
 }
 
+func TestPutObjectAWSChunked(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+
+	chunked := func(method, target, body, decodedLength string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Encoding", "aws-chunked")
+		req.Header.Set("X-Amz-Content-Sha256", "STREAMING-UNSIGNED-PAYLOAD-TRAILER")
+		req.Header.Set("X-Amz-Trailer", "x-amz-checksum-crc32")
+		if decodedLength != "" {
+			req.Header.Set("X-Amz-Decoded-Content-Length", decodedLength)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	body := "7\r\nHello, \r\n6\r\nS3Dir!\r\n0\r\nx-amz-checksum-crc32:RStzTQ==\r\n\r\n"
+
+	w := chunked(http.MethodPut, "/test-bucket/chunked.txt", body, "13")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	reader, info, err := store.GetObject("test-bucket", "chunked.txt")
+	if err != nil {
+		t.Fatalf("Failed to get stored object: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "Hello, S3Dir!" || info.Size != 13 {
+		t.Errorf("Expected decoded content 'Hello, S3Dir!', got %q (%d bytes)", data, info.Size)
+	}
+
+	if w := chunked(http.MethodPut, "/test-bucket/chunked.txt", body, ""); w.Code != http.StatusLengthRequired {
+		t.Errorf("Missing decoded length: expected status 411, got %d", w.Code)
+	}
+	if w := chunked(http.MethodPut, "/test-bucket/chunked.txt", body, "20"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "IncompleteBody") {
+		t.Errorf("Wrong decoded length: expected 400 IncompleteBody, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// An announced trailer that never arrives fails rather than skipping
+	// verification
+	untrailed := "7\r\nHello, \r\n6\r\nS3Dir!\r\n0\r\n\r\n"
+	if w := chunked(http.MethodPut, "/test-bucket/untrailed.txt", untrailed, "13"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "InvalidRequest") {
+		t.Errorf("Missing trailer: expected 400 InvalidRequest, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := store.HeadObject("test-bucket", "untrailed.txt"); err == nil {
+		t.Error("Expected an object missing its trailer not to be stored")
+	}
+
+	uploadID, err := store.InitiateMultipartUpload("test-bucket", "multipart.txt")
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %v", err)
+	}
+	w = chunked(http.MethodPut, "/test-bucket/multipart.txt?partNumber=1&uploadId="+uploadID, body, "13")
+	if w.Code != http.StatusOK {
+		t.Fatalf("UploadPart: expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if etag := w.Header().Get("ETag"); etag != fmt.Sprintf("\"%x\"", md5.Sum([]byte("Hello, S3Dir!"))) {
+		t.Errorf("Expected the part ETag to be the MD5 of the decoded content, got %s", etag)
+	}
+}
+
 func TestGetObject(t *testing.T) {
 	handler, store, cleanup := setupTestHandler(t)
 	defer cleanup()
@@ -449,3 +518,132 @@ func TestParsePath(t *testing.T) {
 		})
 	}
 }
+
+func TestCredentialPermissions(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("builds")
+	store.CreateBucket("secrets")
+	putTestObject(t, handler, "builds", "shared/app.tar", "app")
+	putTestObject(t, handler, "secrets", "token", "hunter2")
+
+	ci := &auth.Credential{
+		AccessKeyID: "ci",
+		Actions:     []auth.Action{auth.ActionRead, auth.ActionWrite},
+		Resources:   []string{"builds/shared/"},
+	}
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req = req.WithContext(auth.WithCredential(req.Context(), ci))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		expectedCode int
+	}{
+		{"read granted prefix", http.MethodGet, "/builds/shared/app.tar", http.StatusOK},
+		{"write granted prefix", http.MethodPut, "/builds/shared/new.tar", http.StatusOK},
+		{"list granted prefix", http.MethodGet, "/builds?prefix=shared/", http.StatusOK},
+		{"head bucket with prefix grant", http.MethodHead, "/builds", http.StatusOK},
+		{"list whole bucket", http.MethodGet, "/builds", http.StatusForbidden},
+		{"write outside prefix", http.MethodPut, "/builds/other.tar", http.StatusForbidden},
+		{"delete without action", http.MethodDelete, "/builds/shared/app.tar", http.StatusForbidden},
+		{"read other bucket", http.MethodGet, "/secrets/token", http.StatusForbidden},
+		{"create bucket without admin", http.MethodPut, "/new-bucket", http.StatusForbidden},
+		{"multipart without action", http.MethodPost, "/builds/shared/big.bin?uploads", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.target, "data")
+			if w.Code != tt.expectedCode {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedCode, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// Copying requires read access to the source as well as write access to
+	// the destination
+	req := httptest.NewRequest(http.MethodPut, "/builds/shared/stolen", nil)
+	req.Header.Set("x-amz-copy-source", "/secrets/token")
+	req = req.WithContext(auth.WithCredential(req.Context(), ci))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Copy from unreadable source: expected 403, got %d", w.Code)
+	}
+
+	// ListBuckets only shows buckets the key can read
+	w = do(http.MethodGet, "/", "")
+	var response ListBucketsResponse
+	if err := xml.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(response.Buckets.Buckets) != 1 || response.Buckets.Buckets[0].Name != "builds" {
+		t.Errorf("Expected only builds bucket, got %+v", response.Buckets.Buckets)
+	}
+}
+
+func TestSubresourceAccessDeniedBeforeLookup(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("builds")
+	ci := &auth.Credential{
+		AccessKeyID: "ci",
+		Actions:     []auth.Action{auth.ActionRead},
+		Resources:   []string{"builds"},
+	}
+
+	// Callers without access get AccessDenied whether or not the bucket or
+	// key exists, so they can't probe for either
+	for _, cred := range []*auth.Credential{auth.Anonymous, ci} {
+		for _, target := range []string{"/missing?versioning", "/missing?acl", "/missing/key?acl", "/secrets?policy"} {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req = req.WithContext(auth.WithCredential(req.Context(), cred))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "AccessDenied") {
+				t.Errorf("%s as %q: expected 403 AccessDenied, got %d: %s", target, cred.AccessKeyID, w.Code, w.Body.String())
+			}
+		}
+	}
+
+	// Callers with access still learn that it is missing
+	req := httptest.NewRequest(http.MethodGet, "/builds/missing?acl", nil)
+	req = req.WithContext(auth.WithCredential(req.Context(), ci))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Missing key with access: expected 404, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDrain(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("bucket")
+	if w := serve(handler, http.MethodPut, "/bucket/key", "data"); w.Code != http.StatusOK {
+		t.Fatalf("Put before drain: expected 200, got %d", w.Code)
+	}
+
+	handler.Drain()
+
+	for _, method := range []string{http.MethodGet, http.MethodPut} {
+		w := serve(handler, method, "/bucket/key", "data")
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s during drain: expected 503, got %d", method, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "<Code>ServiceUnavailable</Code>") || w.Header().Get("Connection") != "close" {
+			t.Errorf("%s during drain: got %v %s", method, w.Header(), w.Body.String())
+		}
+	}
+}
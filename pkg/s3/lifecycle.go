@@ -0,0 +1,65 @@
+package s3
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/stut/s3dir/pkg/lifecycle"
+)
+
+// maxLifecycleConfigSize bounds the lifecycle configuration documents
+// accepted; 1000 rules fit comfortably
+const maxLifecycleConfigSize = 1 << 20
+
+// getBucketLifecycle returns the bucket's lifecycle configuration as stored
+func (h *Handler) getBucketLifecycle(w http.ResponseWriter, r *http.Request, bucket string) {
+	data, err := h.storage.GetBucketLifecycle(bucket)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if data == nil {
+		writeError(w, r, "NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// putBucketLifecycle validates and stores the bucket's lifecycle
+// configuration, replacing any existing one
+func (h *Handler) putBucketLifecycle(w http.ResponseWriter, r *http.Request, bucket string) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxLifecycleConfigSize+1))
+	if err != nil {
+		writeError(w, r, "IncompleteBody", "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxLifecycleConfigSize {
+		writeError(w, r, "MalformedXML", "The lifecycle configuration is too large", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := lifecycle.Parse(data); err != nil {
+		writeError(w, r, "MalformedXML", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storage.PutBucketLifecycle(bucket, data); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteBucketLifecycle removes the bucket's lifecycle configuration, if any
+func (h *Handler) deleteBucketLifecycle(w http.ResponseWriter, r *http.Request, bucket string) {
+	if err := h.storage.DeleteBucketLifecycle(bucket); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,41 @@
+package s3
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBucketLifecycleAPI(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+
+	doc := `<LifecycleConfiguration><Rule><ID>scratch</ID><Status>Enabled</Status><Filter><Prefix>tmp/</Prefix></Filter><Expiration><Days>7</Days></Expiration></Rule></LifecycleConfiguration>`
+
+	w := serve(handler, http.MethodPut, "/test-bucket?lifecycle", `<LifecycleConfiguration><Rule><Status>Enabled</Status></Rule></LifecycleConfiguration>`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "MalformedXML") {
+		t.Errorf("Rule without action: expected 400 MalformedXML, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = serve(handler, http.MethodPut, "/test-bucket?lifecycle", doc)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT lifecycle: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = serve(handler, http.MethodGet, "/test-bucket?lifecycle", "")
+	if w.Code != http.StatusOK || w.Body.String() != doc {
+		t.Errorf("GET lifecycle: expected stored configuration, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = serve(handler, http.MethodDelete, "/test-bucket?lifecycle", "")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("DELETE lifecycle: expected 204, got %d", w.Code)
+	}
+
+	w = serve(handler, http.MethodGet, "/test-bucket?lifecycle", "")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchLifecycleConfiguration") {
+		t.Errorf("GET after delete: expected NoSuchLifecycleConfiguration, got %d: %s", w.Code, w.Body.String())
+	}
+}
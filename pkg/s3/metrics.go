@@ -0,0 +1,64 @@
+package s3
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/stut/s3dir/pkg/metrics"
+)
+
+// Metrics are the request metrics recorded by MetricsMiddleware
+type Metrics struct {
+	requests      *metrics.Counter
+	duration      *metrics.Histogram
+	bytesReceived *metrics.Counter
+	bytesSent     *metrics.Counter
+	storageErrors *metrics.Counter
+}
+
+// NewMetrics registers the request metrics with registry
+func NewMetrics(registry *metrics.Registry) *Metrics {
+	return &Metrics{
+		requests: registry.NewCounter("s3dir_requests_total",
+			"S3 requests served, by operation and HTTP status code.", "operation", "status"),
+		duration: registry.NewHistogram("s3dir_request_duration_seconds",
+			"Time taken to serve S3 requests, by operation and HTTP status code.", metrics.DefaultBuckets, "operation", "status"),
+		bytesReceived: registry.NewCounter("s3dir_bytes_received_total",
+			"Request body bytes received, by bucket.", "bucket"),
+		bytesSent: registry.NewCounter("s3dir_bytes_sent_total",
+			"Response body bytes sent, by bucket.", "bucket"),
+		storageErrors: registry.NewCounter("s3dir_storage_errors_total",
+			"Requests that failed with an internal storage error, by operation.", "operation"),
+	}
+}
+
+// MetricsMiddleware records every request in m. It should wrap all other
+// middleware so rejected requests are counted too
+func (h *Handler) MetricsMiddleware(m *Metrics, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		operation := h.operationName(r)
+		bucket, _ := h.parseRequest(r)
+
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		rw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rw, r)
+
+		status := strconv.Itoa(rw.status)
+		m.requests.Inc(operation, status)
+		m.duration.Observe(time.Since(start).Seconds(), operation, status)
+		if rw.status == http.StatusInternalServerError {
+			m.storageErrors.Inc(operation)
+		}
+
+		// Only count bytes for buckets that exist, so requests naming
+		// arbitrary buckets cannot create unbounded series
+		if bucket != "" && h.storage.HeadBucket(bucket) == nil {
+			m.bytesReceived.Add(float64(body.n), bucket)
+			m.bytesSent.Add(float64(rw.written), bucket)
+		}
+	})
+}
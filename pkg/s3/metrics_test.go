@@ -0,0 +1,115 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stut/s3dir/pkg/metrics"
+)
+
+func TestOperationName(t *testing.T) {
+	handler := &Handler{}
+
+	tests := []struct {
+		method string
+		target string
+		copy   bool
+		want   string
+	}{
+		{http.MethodGet, "/", false, "ListBuckets"},
+		{http.MethodPost, "/", false, "Unknown"},
+		{http.MethodOptions, "/bucket/key", false, "Preflight"},
+		{http.MethodGet, "/bucket", false, "ListObjects"},
+		{http.MethodGet, "/bucket?list-type=2", false, "ListObjectsV2"},
+		{http.MethodHead, "/bucket", false, "HeadBucket"},
+		{http.MethodPut, "/bucket", false, "CreateBucket"},
+		{http.MethodDelete, "/bucket", false, "DeleteBucket"},
+		{http.MethodGet, "/bucket?uploads", false, "ListMultipartUploads"},
+		{http.MethodGet, "/bucket?versions", false, "ListObjectVersions"},
+		{http.MethodPost, "/bucket?delete", false, "DeleteObjects"},
+		{http.MethodGet, "/bucket?versioning", false, "GetBucketVersioning"},
+		{http.MethodPut, "/bucket?lifecycle", false, "PutBucketLifecycle"},
+		{http.MethodDelete, "/bucket?cors", false, "DeleteBucketCors"},
+		{http.MethodGet, "/bucket?object-lock", false, "GetBucketObjectLock"},
+		{http.MethodGet, "/bucket/key", false, "GetObject"},
+		{http.MethodHead, "/bucket/key", false, "HeadObject"},
+		{http.MethodPut, "/bucket/key", false, "PutObject"},
+		{http.MethodPut, "/bucket/key", true, "CopyObject"},
+		{http.MethodDelete, "/bucket/key", false, "DeleteObject"},
+		{http.MethodPatch, "/bucket/key", false, "Unknown"},
+		{http.MethodPost, "/bucket/key?uploads", false, "CreateMultipartUpload"},
+		{http.MethodPut, "/bucket/key?partNumber=1&uploadId=u", false, "UploadPart"},
+		{http.MethodPut, "/bucket/key?partNumber=1&uploadId=u", true, "UploadPartCopy"},
+		{http.MethodGet, "/bucket/key?uploadId=u", false, "ListParts"},
+		{http.MethodPost, "/bucket/key?uploadId=u", false, "CompleteMultipartUpload"},
+		{http.MethodDelete, "/bucket/key?uploadId=u", false, "AbortMultipartUpload"},
+		{http.MethodGet, "/bucket/key?tagging", false, "GetObjectTagging"},
+		{http.MethodDelete, "/bucket/key?tagging", false, "DeleteObjectTagging"},
+		{http.MethodPut, "/bucket/key?acl", false, "PutObjectAcl"},
+		{http.MethodGet, "/bucket/key?attributes", false, "GetObjectAttributes"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.copy {
+			req.Header.Set("x-amz-copy-source", "/bucket/source")
+		}
+		if got := handler.operationName(req); got != tt.want {
+			t.Errorf("%s %s (copy %v): got %s, want %s", tt.method, tt.target, tt.copy, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+	store.CreateBucket("bucket")
+
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry)
+	server := handler.MetricsMiddleware(m, handler)
+
+	do := func(method, target, body string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	do(http.MethodPut, "/bucket/key", "hello world")
+	do(http.MethodGet, "/bucket/key", "")
+	do(http.MethodGet, "/bucket/key", "")
+	do(http.MethodGet, "/bucket/missing", "")
+	do(http.MethodGet, "/nonexistent/key", "")
+
+	if got := m.requests.Value("PutObject", "200"); got != 1 {
+		t.Errorf("PutObject 200: got %v", got)
+	}
+	if got := m.requests.Value("GetObject", "200"); got != 2 {
+		t.Errorf("GetObject 200: got %v", got)
+	}
+	if got := m.requests.Value("GetObject", "404"); got != 2 {
+		t.Errorf("GetObject 404: got %v", got)
+	}
+	if got := m.duration.Count("GetObject", "200"); got != 2 {
+		t.Errorf("GetObject latency count: got %d", got)
+	}
+	if got := m.bytesReceived.Value("bucket"); got != 11 {
+		t.Errorf("Bytes received: got %v", got)
+	}
+	if got := m.bytesSent.Value("bucket"); got < 22 {
+		t.Errorf("Bytes sent: got %v", got)
+	}
+
+	// Buckets that don't exist get no byte series
+	var out strings.Builder
+	registry.Write(&out)
+	if strings.Contains(out.String(), `bucket="nonexistent"`) {
+		t.Errorf("Unexpected series for a missing bucket:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), `s3dir_requests_total{operation="PutObject",status="200"} 1`) {
+		t.Errorf("Missing request count in exposition:\n%s", out.String())
+	}
+}
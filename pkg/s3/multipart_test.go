@@ -11,6 +11,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/stut/s3dir/pkg/auth"
 	"github.com/stut/s3dir/pkg/storage"
 )
 
@@ -303,8 +304,8 @@ func TestMultipartUploadAPIErrors(t *testing.T) {
 		w := httptest.NewRecorder()
 		handler.ServeHTTP(w, req)
 
-		if w.Code != http.StatusInternalServerError {
-			t.Errorf("Expected 500, got %d", w.Code)
+		if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchBucket") {
+			t.Errorf("Expected 404 NoSuchBucket, got %d", w.Code)
 		}
 	})
 
@@ -377,8 +378,39 @@ func TestMultipartUploadAPIErrors(t *testing.T) {
 		w := httptest.NewRecorder()
 		handler.ServeHTTP(w, req)
 
-		if w.Code != http.StatusNotFound {
-			t.Errorf("Expected 404, got %d", w.Code)
+		if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchUpload") {
+			t.Errorf("Expected 404 NoSuchUpload, got %d", w.Code)
+		}
+	})
+
+	t.Run("complete with missing part", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/key?uploads", bucket), nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var initResp InitiateMultipartUploadResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &initResp); err != nil {
+			t.Fatalf("Failed to initiate upload: %v", err)
+		}
+
+		completeXML, _ := xml.Marshal(CompleteMultipartUpload{
+			Parts: []CompletePart{{PartNumber: 3, ETag: "test"}},
+		})
+		req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/key?uploadId=%s", bucket, initResp.UploadID), bytes.NewReader(completeXML))
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "InvalidPart") {
+			t.Errorf("Expected 400 InvalidPart, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("create existing bucket", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/"+bucket, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "BucketAlreadyOwnedByYou") {
+			t.Errorf("Expected 409 BucketAlreadyOwnedByYou, got %d: %s", w.Code, w.Body.String())
 		}
 	})
 }
@@ -442,6 +474,73 @@ func TestMultipartUploadReadOnlyMode(t *testing.T) {
 	})
 }
 
+func TestMultipartUploadOwnership(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("prod")
+	store.CreateBucket("scratch")
+	store.PutObject("prod", "victim", strings.NewReader("original"), 8)
+
+	uploadID, _ := store.InitiateMultipartUpload("prod", "victim")
+	etag, err := store.UploadPart(uploadID, 1, strings.NewReader("overwritten"), 11)
+	if err != nil {
+		t.Fatalf("Failed to upload part: %v", err)
+	}
+	body, _ := xml.Marshal(CompleteMultipartUpload{Parts: []CompletePart{{PartNumber: 1, ETag: etag}}})
+
+	scratch := &auth.Credential{
+		AccessKeyID: "scratch",
+		Actions:     []auth.Action{auth.ActionMultipart},
+		Resources:   []string{"scratch"},
+	}
+
+	// The upload can't be reached through a bucket or key it doesn't belong to
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{"upload part", http.MethodPut, "/scratch/x?partNumber=1&uploadId=" + uploadID, "data"},
+		{"complete", http.MethodPost, "/scratch/x?uploadId=" + uploadID, string(body)},
+		{"list parts", http.MethodGet, "/scratch/x?uploadId=" + uploadID, ""},
+		{"abort", http.MethodDelete, "/scratch/x?uploadId=" + uploadID, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req = req.WithContext(auth.WithCredential(req.Context(), scratch))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchUpload") {
+				t.Errorf("Expected 404 NoSuchUpload, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// Nor through another key of its own bucket
+	if w := serve(handler, http.MethodPost, "/prod/other?uploadId="+uploadID, string(body)); w.Code != http.StatusNotFound {
+		t.Errorf("Complete through another key: expected 404, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := serve(handler, http.MethodGet, "/prod/victim", ""); w.Body.String() != "original" {
+		t.Errorf("Expected prod/victim to be untouched, got %q", w.Body.String())
+	}
+	if parts, err := store.ListMultipartUploadParts(uploadID); err != nil || len(parts) != 1 {
+		t.Errorf("Expected the upload to keep its one part, got %d parts: %v", len(parts), err)
+	}
+
+	// Through its own bucket and key the upload completes as usual
+	w := serve(handler, http.MethodPost, "/prod/victim?uploadId="+uploadID, string(body))
+	var result CompleteMultipartUploadResult
+	xml.Unmarshal(w.Body.Bytes(), &result)
+	if w.Code != http.StatusOK || result.Bucket != "prod" || result.Key != "victim" {
+		t.Errorf("Expected prod/victim to complete, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
 func TestMultipartUploadLargeFile(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "s3dir-api-multipart-test-*")
 	if err != nil {
@@ -540,3 +639,89 @@ func TestMultipartUploadLargeFile(t *testing.T) {
 		t.Errorf("Expected %d bytes, got %d", partSize*numParts, len(data))
 	}
 }
+
+func TestGetObjectPartNumber(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+
+	contents := []string{"first-part-", "second-part-", "third"}
+	uploadID, _ := store.InitiateMultipartUpload("test-bucket", "multipart.bin")
+	var parts []storage.CompletePart
+	for i, content := range contents {
+		etag, err := store.UploadPart(uploadID, i+1, strings.NewReader(content), int64(len(content)))
+		if err != nil {
+			t.Fatalf("Failed to upload part %d: %v", i+1, err)
+		}
+		parts = append(parts, storage.CompletePart{PartNumber: i + 1, ETag: etag})
+	}
+	info, err := store.CompleteMultipartUpload(uploadID, parts)
+	if err != nil {
+		t.Fatalf("Failed to complete upload: %v", err)
+	}
+	store.PutObject("test-bucket", "single.txt", strings.NewReader("Hello"), 5)
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		w := serve(handler, method, "/test-bucket/multipart.bin?partNumber=2", "")
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("%s part 2: expected status 206, got %d: %s", method, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("Content-Range"); got != "bytes 11-22/28" {
+			t.Errorf("%s part 2: expected Content-Range bytes 11-22/28, got %q", method, got)
+		}
+		if got := w.Header().Get("Content-Length"); got != "12" {
+			t.Errorf("%s part 2: expected Content-Length 12, got %q", method, got)
+		}
+		if got := w.Header().Get("x-amz-mp-parts-count"); got != "3" {
+			t.Errorf("%s part 2: expected x-amz-mp-parts-count 3, got %q", method, got)
+		}
+		if got := w.Header().Get("ETag"); got != info.ETag {
+			t.Errorf("%s part 2: expected the object's ETag %s, got %s", method, info.ETag, got)
+		}
+		if method == http.MethodGet && w.Body.String() != contents[1] {
+			t.Errorf("Expected part 2 content %q, got %q", contents[1], w.Body.String())
+		}
+	}
+
+	// An object written in one piece is its own single part, answered the
+	// same way by GET and HEAD
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		w := serve(handler, method, "/test-bucket/single.txt?partNumber=1", "")
+		if w.Code != http.StatusPartialContent || w.Header().Get("Content-Range") != "bytes 0-4/5" || w.Header().Get("x-amz-mp-parts-count") != "1" {
+			t.Errorf("%s single PUT part 1: expected 206 for bytes 0-4/5 of 1 part, got %d %v", method, w.Code, w.Header())
+		}
+		if method == http.MethodGet && w.Body.String() != "Hello" {
+			t.Errorf("Single PUT part 1: expected the whole object, got %q", w.Body.String())
+		}
+	}
+
+	// Empty objects have no range to send and are returned whole
+	store.PutObject("test-bucket", "empty.txt", strings.NewReader(""), 0)
+	if w := serve(handler, http.MethodHead, "/test-bucket/empty.txt?partNumber=1", ""); w.Code != http.StatusOK || w.Header().Get("x-amz-mp-parts-count") != "1" {
+		t.Errorf("Empty object part 1: expected 200 with 1 part, got %d %v", w.Code, w.Header())
+	}
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/test-bucket/multipart.bin?partNumber=4", http.StatusRequestedRangeNotSatisfiable},
+		{"/test-bucket/single.txt?partNumber=2", http.StatusRequestedRangeNotSatisfiable},
+		{"/test-bucket/multipart.bin?partNumber=0", http.StatusBadRequest},
+		{"/test-bucket/multipart.bin?partNumber=one", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := serve(handler, http.MethodGet, tt.target, ""); w.Code != tt.status {
+			t.Errorf("GET %s: expected status %d, got %d", tt.target, tt.status, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/test-bucket/multipart.bin?partNumber=1", nil)
+	req.Header.Set("Range", "bytes=0-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("partNumber with Range: expected status 400, got %d", w.Code)
+	}
+}
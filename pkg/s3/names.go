@@ -0,0 +1,74 @@
+package s3
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/stut/s3dir/pkg/storage"
+)
+
+// reservedBucketPrefixes and reservedBucketSuffixes are reserved by S3 for
+// other kinds of bucket and access point
+var (
+	reservedBucketPrefixes = []string{"xn--", "sthree-", "amzn-s3-demo-"}
+	reservedBucketSuffixes = []string{"-s3alias", "--ol-s3", ".mrap", "--x-s3", "--table-s3"}
+)
+
+// SetLegacyBucketNames allows new buckets to be named under the relaxed
+// rules S3 once applied in us-east-1: up to 255 characters including
+// uppercase letters and underscores
+func (h *Handler) SetLegacyBucketNames(legacy bool) {
+	h.legacyBucketNames = legacy
+}
+
+// validBucketName reports whether a bucket may be created with the given
+// name under the S3 bucket naming rules, or the legacy rules if legacy is set
+func validBucketName(bucket string, legacy bool) bool {
+	if !storage.ValidBucketName(bucket) || len(bucket) < 3 {
+		return false
+	}
+	if legacy {
+		return true
+	}
+
+	if len(bucket) > 63 || strings.Contains(bucket, "..") || net.ParseIP(bucket) != nil {
+		return false
+	}
+	for i := 0; i < len(bucket); i++ {
+		c := bucket[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-') {
+			return false
+		}
+	}
+	if last := bucket[len(bucket)-1]; last == '.' || last == '-' {
+		return false
+	}
+	for _, prefix := range reservedBucketPrefixes {
+		if strings.HasPrefix(bucket, prefix) {
+			return false
+		}
+	}
+	for _, suffix := range reservedBucketSuffixes {
+		if strings.HasSuffix(bucket, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkNames rejects requests addressing a bucket or key that cannot be
+// stored safely, returning false once an error has been written
+func checkNames(w http.ResponseWriter, r *http.Request, bucket, key string) bool {
+	if bucket != "" && !storage.ValidBucketName(bucket) {
+		writeStorageError(w, r, storage.ErrInvalidBucketName)
+		return false
+	}
+	if key != "" {
+		if err := storage.ValidKey(key); err != nil {
+			writeStorageError(w, r, err)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidBucketName(t *testing.T) {
+	tests := []struct {
+		name   string
+		strict bool
+		legacy bool
+	}{
+		{"my-bucket", true, true},
+		{"my.bucket.1", true, true},
+		{"abc", true, true},
+		{"ab", false, false},
+		{strings.Repeat("a", 63), true, true},
+		{strings.Repeat("a", 64), false, true},
+		{strings.Repeat("a", 256), false, false},
+		{"My_Bucket", false, true},
+		{"bucket-", false, true},
+		{"my..bucket", false, true},
+		{"192.168.1.1", false, true},
+		{"xn--bucket", false, true},
+		{"bucket-s3alias", false, true},
+		{".metadata", false, false},
+		{"-bucket", false, false},
+		{"a/b", false, false},
+		{"..", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := validBucketName(tt.name, false); got != tt.strict {
+			t.Errorf("validBucketName(%q, false) = %v, want %v", tt.name, got, tt.strict)
+		}
+		if got := validBucketName(tt.name, true); got != tt.legacy {
+			t.Errorf("validBucketName(%q, true) = %v, want %v", tt.name, got, tt.legacy)
+		}
+	}
+}
+
+func TestNameValidation(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+	store.CreateBucket("bucket")
+	store.PutObject("bucket", "key", strings.NewReader("data"), 4)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		code   string
+	}{
+		{"uppercase bucket", http.MethodPut, "/My_Bucket", "InvalidBucketName"},
+		{"short bucket", http.MethodPut, "/ab", "InvalidBucketName"},
+		{"internal directory", http.MethodGet, "/.metadata", "InvalidBucketName"},
+		{"invalid UTF-8", http.MethodPut, "/bucket/%FF", "InvalidArgument"},
+		{"long key", http.MethodPut, "/bucket/" + strings.Repeat("k", 1025), "KeyTooLongError"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(handler, tt.method, tt.target, "data")
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.code) {
+				t.Errorf("Expected 400 %s, got %d: %s", tt.code, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/bucket/copy", nil)
+	req.Header.Set("x-amz-copy-source", "/bucket/../.metadata/bucket/key.json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected a copy source with parent segments to stay in its bucket, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Keys the filesystem cannot hold as they are are stored escaped
+	for _, key := range []string{"../escape", "a/./b", "dir/", "dir//x", "key/sub"} {
+		if w := serve(handler, http.MethodPut, "/bucket/"+key, key); w.Code != http.StatusOK {
+			t.Fatalf("Put %q failed: %d %s", key, w.Code, w.Body.String())
+		}
+	}
+	for _, key := range []string{"../escape", "a/./b", "dir/", "dir//x", "key", "key/sub"} {
+		w := serve(handler, http.MethodGet, "/bucket/"+key, "")
+		if w.Code != http.StatusOK {
+			t.Errorf("Get %q failed: %d %s", key, w.Code, w.Body.String())
+		}
+	}
+
+	handler.SetLegacyBucketNames(true)
+	if w := serve(handler, http.MethodPut, "/My_Bucket", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected a legacy bucket name to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(handler, http.MethodPut, "/My_Bucket/key", "data"); w.Code != http.StatusOK {
+		t.Errorf("Expected writes to a legacy bucket to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+}
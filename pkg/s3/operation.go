@@ -0,0 +1,217 @@
+package s3
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// bucketSubresourceNames are the names bucket subresources take in S3
+// operation names, e.g. GetBucketLifecycle
+var bucketSubresourceNames = map[string]string{
+	"accelerate":     "Accelerate",
+	"acl":            "Acl",
+	"cors":           "Cors",
+	"encryption":     "Encryption",
+	"lifecycle":      "Lifecycle",
+	"location":       "Location",
+	"logging":        "Logging",
+	"notification":   "Notification",
+	"object-lock":    "ObjectLock",
+	"policy":         "Policy",
+	"replication":    "Replication",
+	"requestPayment": "RequestPayment",
+	"tagging":        "Tagging",
+	"versioning":     "Versioning",
+	"website":        "Website",
+}
+
+// methodVerbs are the verbs operations on subresources are named with
+var methodVerbs = map[string]string{
+	http.MethodGet:    "Get",
+	http.MethodHead:   "Get",
+	http.MethodPut:    "Put",
+	http.MethodPost:   "Put",
+	http.MethodDelete: "Delete",
+}
+
+// operationName returns the S3 API operation a request invokes, e.g.
+// "GetObject" or "ListObjectsV2", following the same routing as ServeHTTP.
+// Requests matching no operation are "Unknown"
+func (h *Handler) operationName(r *http.Request) string {
+	if r.Method == http.MethodOptions {
+		return "Preflight"
+	}
+
+	bucket, key := h.parseRequest(r)
+	query := r.URL.Query()
+
+	switch {
+	case bucket == "":
+		if r.Method == http.MethodGet {
+			return "ListBuckets"
+		}
+	case key == "":
+		return bucketOperationName(r.Method, query)
+	default:
+		return objectOperationName(r, query)
+	}
+	return "Unknown"
+}
+
+func bucketOperationName(method string, query url.Values) string {
+	switch {
+	case method == http.MethodGet && query.Has("uploads"):
+		return "ListMultipartUploads"
+	case method == http.MethodGet && query.Has("versions"):
+		return "ListObjectVersions"
+	case method == http.MethodPost && query.Has("delete"):
+		return "DeleteObjects"
+	}
+
+	for _, sub := range bucketSubresources {
+		if query.Has(sub) {
+			if verb, ok := methodVerbs[method]; ok {
+				return verb + "Bucket" + bucketSubresourceNames[sub]
+			}
+			return "Unknown"
+		}
+	}
+
+	switch method {
+	case http.MethodGet:
+		if query.Get("list-type") == "2" {
+			return "ListObjectsV2"
+		}
+		return "ListObjects"
+	case http.MethodHead:
+		return "HeadBucket"
+	case http.MethodPut:
+		return "CreateBucket"
+	case http.MethodDelete:
+		return "DeleteBucket"
+	}
+	return "Unknown"
+}
+
+func objectOperationName(r *http.Request, query url.Values) string {
+	copying := r.Header.Get("x-amz-copy-source") != ""
+
+	switch {
+	case query.Get("uploadId") != "":
+		switch r.Method {
+		case http.MethodGet:
+			return "ListParts"
+		case http.MethodPut:
+			if copying {
+				return "UploadPartCopy"
+			}
+			return "UploadPart"
+		case http.MethodPost:
+			return "CompleteMultipartUpload"
+		case http.MethodDelete:
+			return "AbortMultipartUpload"
+		}
+		return "Unknown"
+	case query.Has("uploads"):
+		if r.Method == http.MethodPost {
+			return "CreateMultipartUpload"
+		}
+		return "Unknown"
+	case query.Has("tagging"), query.Has("acl"):
+		name := "Tagging"
+		if !query.Has("tagging") {
+			name = "Acl"
+		}
+		if verb, ok := methodVerbs[r.Method]; ok {
+			return verb + "Object" + name
+		}
+		return "Unknown"
+	case query.Has("attributes"):
+		if r.Method == http.MethodGet {
+			return "GetObjectAttributes"
+		}
+		return "Unknown"
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		return "GetObject"
+	case http.MethodHead:
+		return "HeadObject"
+	case http.MethodPut:
+		if copying {
+			return "CopyObject"
+		}
+		return "PutObject"
+	case http.MethodDelete:
+		return "DeleteObject"
+	}
+	return "Unknown"
+}
+
+// logOperationNames are the access log names of operations that do not
+// follow the REST.<method>.<resource> pattern of the rest
+var logOperationNames = map[string]string{
+	"ListBuckets":             "REST.GET.SERVICE",
+	"ListObjects":             "REST.GET.BUCKET",
+	"ListObjectsV2":           "REST.GET.BUCKET",
+	"HeadBucket":              "REST.HEAD.BUCKET",
+	"CreateBucket":            "REST.PUT.BUCKET",
+	"DeleteBucket":            "REST.DELETE.BUCKET",
+	"ListObjectVersions":      "REST.GET.BUCKETVERSIONS",
+	"ListMultipartUploads":    "REST.GET.UPLOADS",
+	"DeleteObjects":           "REST.POST.MULTI_OBJECT_DELETE",
+	"GetObject":               "REST.GET.OBJECT",
+	"HeadObject":              "REST.HEAD.OBJECT",
+	"PutObject":               "REST.PUT.OBJECT",
+	"CopyObject":              "REST.COPY.OBJECT",
+	"DeleteObject":            "REST.DELETE.OBJECT",
+	"CreateMultipartUpload":   "REST.POST.UPLOADS",
+	"UploadPart":              "REST.PUT.PART",
+	"UploadPartCopy":          "REST.COPY.PART",
+	"CompleteMultipartUpload": "REST.POST.UPLOAD",
+	"ListParts":               "REST.GET.UPLOAD",
+	"AbortMultipartUpload":    "REST.DELETE.UPLOAD",
+	"Preflight":               "REST.OPTIONS.PREFLIGHT",
+}
+
+// logSubresourceNames are the access log resource names of subresources
+// whose name is not simply the upper-cased query parameter
+var logSubresourceNames = map[string]string{
+	"logging":        "LOGGING_STATUS",
+	"object-lock":    "OBJECT_LOCK_CONFIGURATION",
+	"policy":         "BUCKETPOLICY",
+	"requestPayment": "REQUEST_PAYMENT",
+}
+
+// logOperationName returns the operation name S3 server access logs use for
+// a request, e.g. "REST.GET.OBJECT" or "REST.PUT.LIFECYCLE" for bucket
+// subresources
+func (h *Handler) logOperationName(r *http.Request) string {
+	if name, ok := logOperationNames[h.operationName(r)]; ok {
+		return name
+	}
+
+	_, key := h.parseRequest(r)
+	query := r.URL.Query()
+	if key != "" {
+		switch {
+		case query.Has("tagging"):
+			return "REST." + r.Method + ".OBJECT_TAGGING"
+		case query.Has("acl"):
+			return "REST." + r.Method + ".ACL"
+		}
+		return "REST." + r.Method + ".UNKNOWN"
+	}
+
+	for _, sub := range bucketSubresources {
+		if query.Has(sub) {
+			if name, ok := logSubresourceNames[sub]; ok {
+				return "REST." + r.Method + "." + name
+			}
+			return "REST." + r.Method + "." + strings.ToUpper(sub)
+		}
+	}
+	return "REST." + r.Method + ".UNKNOWN"
+}
@@ -0,0 +1,84 @@
+package s3
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// maxErrorBody bounds how much of an error response statusRecorder keeps
+const maxErrorBody = 1024
+
+// statusRecorder records the status code and body size of a response, when
+// it started, and the start of error response bodies
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	written     int64
+	wroteHeader bool
+	headerTime  time.Time
+	errorBody   []byte
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+		s.headerTime = time.Now()
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	if s.status >= 400 && len(s.errorBody) < maxErrorBody {
+		s.errorBody = append(s.errorBody, p[:min(len(p), maxErrorBody-len(s.errorBody))]...)
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.written += int64(n)
+	return n, err
+}
+
+// errorCode returns the S3 error code of an XML error response
+func (s *statusRecorder) errorCode() string {
+	_, code, found := strings.Cut(string(s.errorBody), "<Code>")
+	if !found {
+		return ""
+	}
+	code, _, found = strings.Cut(code, "</Code>")
+	if !found {
+		return ""
+	}
+	return code
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// ReadFrom keeps the underlying writer's optimized copying, such as
+// sendfile for object bodies
+func (s *statusRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	n, err := io.Copy(s.ResponseWriter, src)
+	s.written += n
+	return n, err
+}
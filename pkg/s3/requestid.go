@@ -0,0 +1,40 @@
+package s3
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Response headers identifying a request, as set by S3. Clients log them
+// with failures so requests can be traced through the access logs
+const (
+	requestIDHeader = "x-amz-request-id"
+	hostIDHeader    = "x-amz-id-2"
+)
+
+// requestID returns the ID of the request being answered through w,
+// assigning one on first use by setting the x-amz-request-id and x-amz-id-2
+// response headers. Every middleware and handler serving the request then
+// reports the same ID
+func requestID(w http.ResponseWriter) string {
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		return id
+	}
+
+	id := newRequestID()
+	hostID := make([]byte, 32)
+	rand.Read(hostID)
+	w.Header().Set(requestIDHeader, id)
+	w.Header().Set(hostIDHeader, base64.StdEncoding.EncodeToString(hostID))
+	return id
+}
+
+// newRequestID returns a random 16 character request ID in the style of S3's
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
@@ -0,0 +1,73 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stut/s3dir/pkg/accesslog"
+	"github.com/stut/s3dir/pkg/auth"
+)
+
+func TestRequestIDs(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+	store.CreateBucket("bucket")
+
+	var logs bytes.Buffer
+	server := handler.AccessLogMiddleware(accesslog.New(&logs, accesslog.FormatJSON),
+		handler.CORSMiddleware(auth.New("key", "secret", true).Middleware(handler)))
+
+	check := func(req *http.Request, wantCode, wantResource string) string {
+		t.Helper()
+		logs.Reset()
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		id := w.Header().Get("x-amz-request-id")
+		if len(id) != 16 || w.Header().Get("x-amz-id-2") == "" {
+			t.Fatalf("Expected request ID headers, got %q and %q", id, w.Header().Get("x-amz-id-2"))
+		}
+
+		var body ErrorResponse
+		if err := xml.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Invalid error response %q: %v", w.Body.String(), err)
+		}
+		if body.Code != wantCode || body.Resource != wantResource {
+			t.Errorf("Expected %s for %s, got %s for %s", wantCode, wantResource, body.Code, body.Resource)
+		}
+		if body.RequestID != id || body.HostID != w.Header().Get("x-amz-id-2") {
+			t.Errorf("Expected error to report request %s, got %s", id, body.RequestID)
+		}
+
+		var entry map[string]any
+		if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid access log %q: %v", logs.String(), err)
+		}
+		if entry["requestId"] != id {
+			t.Errorf("Expected access log to record request %s, got %v", id, entry["requestId"])
+		}
+		return id
+	}
+
+	// Errors from the handler and from authentication
+	first := check(httptest.NewRequest(http.MethodGet, "/bucket/missing", nil), "AccessDenied", "/bucket/missing")
+	req := httptest.NewRequest(http.MethodGet, "/bucket/missing", nil)
+	req.Header.Set("Authorization", "Basic Zm9vOmJhcg==")
+	second := check(req, "InvalidArgument", "/bucket/missing")
+
+	if first == second {
+		t.Error("Expected each request to get a new ID")
+	}
+
+	// Without middleware the handler assigns the ID itself
+	w := serve(handler, http.MethodGet, "/bucket/missing", "")
+	var body ErrorResponse
+	xml.Unmarshal(w.Body.Bytes(), &body)
+	if body.Code != "NoSuchKey" || body.RequestID == "" || body.RequestID != w.Header().Get("x-amz-request-id") {
+		t.Errorf("Expected NoSuchKey reporting the request ID, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,159 @@
+package s3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/stut/s3dir/pkg/auth"
+)
+
+// S3 object tagging limits
+const (
+	maxObjectTags     = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// validateTags checks a tag set against the S3 tagging limits
+func validateTags(tags map[string]string) error {
+	if len(tags) > maxObjectTags {
+		return fmt.Errorf("object tags cannot be greater than %d", maxObjectTags)
+	}
+	for key, value := range tags {
+		if key == "" || utf8.RuneCountInString(key) > maxTagKeyLength {
+			return fmt.Errorf("the tag key must be between 1 and %d characters", maxTagKeyLength)
+		}
+		if utf8.RuneCountInString(value) > maxTagValueLength {
+			return fmt.Errorf("the tag value cannot be longer than %d characters", maxTagValueLength)
+		}
+		if strings.HasPrefix(strings.ToLower(key), "aws:") {
+			return fmt.Errorf("the aws: prefix is reserved for tag keys")
+		}
+	}
+	return nil
+}
+
+// parseTaggingHeader parses an x-amz-tagging header, a URL-encoded query
+// string of tags such as "team=web&env=prod"
+func parseTaggingHeader(value string) (map[string]string, error) {
+	values, err := url.ParseQuery(value)
+	if err != nil {
+		return nil, fmt.Errorf("the x-amz-tagging header is not a valid query string")
+	}
+
+	tags := make(map[string]string, len(values))
+	for key, v := range values {
+		if len(v) > 1 {
+			return nil, fmt.Errorf("cannot provide multiple tags with the same key")
+		}
+		tags[key] = v[0]
+	}
+
+	if err := validateTags(tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// tagsFromHeader returns the tags of an x-amz-tagging request header, or nil
+// if it is absent, writing an InvalidTag response if it is malformed
+func tagsFromHeader(w http.ResponseWriter, r *http.Request) (map[string]string, bool) {
+	value := r.Header.Get("x-amz-tagging")
+	if value == "" {
+		return nil, true
+	}
+
+	tags, err := parseTaggingHeader(value)
+	if err != nil {
+		writeError(w, r, "InvalidTag", err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return tags, true
+}
+
+// tagsToTagging converts a tag map into a Tagging document with tags sorted
+// by key
+func tagsToTagging(tags map[string]string) Tagging {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var tagging Tagging
+	for _, key := range keys {
+		tagging.TagSet.Tags = append(tagging.TagSet.Tags, Tag{Key: key, Value: tags[key]})
+	}
+	return tagging
+}
+
+// handleObjectTagging handles GET, PUT and DELETE of an object's ?tagging
+// subresource
+func (h *Handler) handleObjectTagging(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	class := auth.ActionWrite
+	if r.Method == http.MethodGet {
+		class = auth.ActionRead
+	}
+	if !h.authorize(w, r, class, objectSubresourceAction(r), bucket, key) {
+		return
+	}
+
+	versionID := r.URL.Query().Get("versionId")
+
+	switch r.Method {
+	case http.MethodGet:
+		tags, resolvedVersion, err := h.storage.GetObjectTagging(bucket, key, versionID)
+		if err != nil {
+			writeStorageError(w, r, err)
+			return
+		}
+		if resolvedVersion != "" {
+			w.Header().Set("x-amz-version-id", resolvedVersion)
+		}
+		writeXML(w, tagsToTagging(tags), http.StatusOK)
+	case http.MethodPut:
+		var tagging Tagging
+		if err := xml.NewDecoder(r.Body).Decode(&tagging); err != nil {
+			writeError(w, r, "MalformedXML", "Invalid XML", http.StatusBadRequest)
+			return
+		}
+
+		tags := make(map[string]string, len(tagging.TagSet.Tags))
+		for _, tag := range tagging.TagSet.Tags {
+			if _, exists := tags[tag.Key]; exists {
+				writeError(w, r, "InvalidTag", "Cannot provide multiple tags with the same key", http.StatusBadRequest)
+				return
+			}
+			tags[tag.Key] = tag.Value
+		}
+		if err := validateTags(tags); err != nil {
+			writeError(w, r, "InvalidTag", err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		h.putObjectTagging(w, r, bucket, key, versionID, tags, http.StatusOK)
+	case http.MethodDelete:
+		h.putObjectTagging(w, r, bucket, key, versionID, nil, http.StatusNoContent)
+	default:
+		writeError(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// putObjectTagging stores an object's tags and writes the success response
+func (h *Handler) putObjectTagging(w http.ResponseWriter, r *http.Request, bucket, key, versionID string, tags map[string]string, statusCode int) {
+	resolvedVersion, err := h.storage.PutObjectTagging(bucket, key, versionID, tags)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	if resolvedVersion != "" {
+		w.Header().Set("x-amz-version-id", resolvedVersion)
+	}
+	w.WriteHeader(statusCode)
+}
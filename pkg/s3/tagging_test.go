@@ -0,0 +1,130 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestObjectTaggingAPI(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+
+	req := httptest.NewRequest(http.MethodPut, "/test-bucket/doc.txt", strings.NewReader("data"))
+	req.Header.Set("x-amz-tagging", "team=web&env=prod%20eu")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT with tagging: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = serve(handler, http.MethodHead, "/test-bucket/doc.txt", "")
+	if w.Header().Get("x-amz-tagging-count") != "2" {
+		t.Errorf("Expected x-amz-tagging-count 2, got %q", w.Header().Get("x-amz-tagging-count"))
+	}
+
+	w = serve(handler, http.MethodGet, "/test-bucket/doc.txt?tagging", "")
+	var tagging Tagging
+	if err := xml.Unmarshal(w.Body.Bytes(), &tagging); err != nil {
+		t.Fatalf("Failed to parse tagging: %v", err)
+	}
+	expected := []Tag{{Key: "env", Value: "prod eu"}, {Key: "team", Value: "web"}}
+	if len(tagging.TagSet.Tags) != 2 || tagging.TagSet.Tags[0] != expected[0] || tagging.TagSet.Tags[1] != expected[1] {
+		t.Errorf("Expected %v, got %v", expected, tagging.TagSet.Tags)
+	}
+
+	w = serve(handler, http.MethodPut, "/test-bucket/doc.txt?tagging", `<Tagging><TagSet><Tag><Key>stage</Key><Value>raw</Value></Tag></TagSet></Tagging>`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT ?tagging: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if code, body := getTestObject(t, handler, "test-bucket", "doc.txt"); code != http.StatusOK || body != "data" {
+		t.Errorf("Object changed by PUT ?tagging: %d %q", code, body)
+	}
+
+	invalid := []string{
+		`<Tagging><TagSet><Tag><Key>a</Key><Value>1</Value></Tag><Tag><Key>a</Key><Value>2</Value></Tag></TagSet></Tagging>`,
+		`<Tagging><TagSet><Tag><Key>aws:reserved</Key><Value>1</Value></Tag></TagSet></Tagging>`,
+		`<Tagging><TagSet><Tag><Key></Key><Value>1</Value></Tag></TagSet></Tagging>`,
+	}
+	for _, body := range invalid {
+		w = serve(handler, http.MethodPut, "/test-bucket/doc.txt?tagging", body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "InvalidTag") {
+			t.Errorf("PUT %s: expected 400 InvalidTag, got %d", body, w.Code)
+		}
+	}
+
+	// Copies keep source tags by default and take the header with REPLACE
+	req = httptest.NewRequest(http.MethodPut, "/test-bucket/copy.txt", nil)
+	req.Header.Set("x-amz-copy-source", "/test-bucket/doc.txt")
+	req.Header.Set("x-amz-tagging", "ignored=yes")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if tags, _, _ := store.GetObjectTagging("test-bucket", "copy.txt", ""); len(tags) != 1 || tags["stage"] != "raw" {
+		t.Errorf("Expected copied tags, got %v", tags)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/test-bucket/copy.txt", nil)
+	req.Header.Set("x-amz-copy-source", "/test-bucket/doc.txt")
+	req.Header.Set("x-amz-tagging-directive", "REPLACE")
+	req.Header.Set("x-amz-tagging", "stage=copied")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if tags, _, _ := store.GetObjectTagging("test-bucket", "copy.txt", ""); tags["stage"] != "copied" {
+		t.Errorf("Expected replaced tags, got %v", tags)
+	}
+
+	w = serve(handler, http.MethodDelete, "/test-bucket/doc.txt?tagging", "")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("DELETE ?tagging: expected 204, got %d", w.Code)
+	}
+	w = serve(handler, http.MethodHead, "/test-bucket/doc.txt", "")
+	if w.Code != http.StatusOK || w.Header().Get("x-amz-tagging-count") != "" {
+		t.Errorf("Expected untagged object, got %d with count %q", w.Code, w.Header().Get("x-amz-tagging-count"))
+	}
+
+	w = serve(handler, http.MethodGet, "/test-bucket/missing.txt?tagging", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET ?tagging on missing key: expected 404, got %d", w.Code)
+	}
+}
+
+func TestMultipartUploadTagging(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+
+	req := httptest.NewRequest(http.MethodPost, "/test-bucket/big.bin?uploads", nil)
+	req.Header.Set("x-amz-tagging", "kind=archive")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var initiated InitiateMultipartUploadResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &initiated); err != nil {
+		t.Fatalf("Failed to initiate upload: %s", w.Body.String())
+	}
+
+	w = serve(handler, http.MethodPut, "/test-bucket/big.bin?partNumber=1&uploadId="+initiated.UploadID, "part")
+	etag := w.Header().Get("ETag")
+
+	w = serve(handler, http.MethodPost, "/test-bucket/big.bin?uploadId="+initiated.UploadID,
+		`<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>`+etag+`</ETag></Part></CompleteMultipartUpload>`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Complete: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if tags, _, _ := store.GetObjectTagging("test-bucket", "big.bin", ""); tags["kind"] != "archive" {
+		t.Errorf("Expected kind=archive, got %v", tags)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/test-bucket/big.bin?uploads", nil)
+	req.Header.Set("x-amz-tagging", "a=1&a=2")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Duplicate header tags: expected 400, got %d", w.Code)
+	}
+}
@@ -70,11 +70,15 @@ type CommonPrefix struct {
 	Prefix string `xml:"Prefix"`
 }
 
-// ErrorResponse is the response for errors
+// ErrorResponse is the response for errors. Resource is the path of the
+// request that failed
 type ErrorResponse struct {
-	XMLName xml.Name `xml:"Error"`
-	Code    string   `xml:"Code"`
-	Message string   `xml:"Message"`
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId"`
+	HostID    string   `xml:"HostId"`
 }
 
 // InitiateMultipartUploadResult is the response for InitiateMultipartUpload
@@ -92,6 +96,8 @@ type CompleteMultipartUploadResult struct {
 	Bucket   string   `xml:"Bucket"`
 	Key      string   `xml:"Key"`
 	ETag     string   `xml:"ETag"`
+	Checksums
+	ChecksumType string `xml:"ChecksumType,omitempty"`
 }
 
 // CompleteMultipartUpload is the request body for CompleteMultipartUpload
@@ -104,6 +110,17 @@ type CompleteMultipartUpload struct {
 type CompletePart struct {
 	PartNumber int    `xml:"PartNumber"`
 	ETag       string `xml:"ETag"`
+	Checksums
+}
+
+// Checksums holds the additional checksum of an object or part, in the
+// element named after its algorithm. At most one is set
+type Checksums struct {
+	ChecksumCRC32     string `xml:"ChecksumCRC32,omitempty"`
+	ChecksumCRC32C    string `xml:"ChecksumCRC32C,omitempty"`
+	ChecksumCRC64NVME string `xml:"ChecksumCRC64NVME,omitempty"`
+	ChecksumSHA1      string `xml:"ChecksumSHA1,omitempty"`
+	ChecksumSHA256    string `xml:"ChecksumSHA256,omitempty"`
 }
 
 // ListPartsResult is the response for ListParts
@@ -119,6 +136,8 @@ type ListPartsResult struct {
 	NextPartNumberMarker int       `xml:"NextPartNumberMarker"`
 	MaxParts             int       `xml:"MaxParts"`
 	IsTruncated          bool      `xml:"IsTruncated"`
+	ChecksumAlgorithm    string    `xml:"ChecksumAlgorithm,omitempty"`
+	ChecksumType         string    `xml:"ChecksumType,omitempty"`
 	Parts                []Part    `xml:"Part"`
 }
 
@@ -134,6 +153,7 @@ type Part struct {
 	LastModified string `xml:"LastModified"`
 	ETag         string `xml:"ETag"`
 	Size         int64  `xml:"Size"`
+	Checksums
 }
 
 // ListMultipartUploadsResult is the response for ListMultipartUploads
@@ -156,10 +176,52 @@ type LocationConstraint struct {
 	Value   string   `xml:",chardata"`
 }
 
-// VersioningConfiguration is the response for GetBucketVersioning. An empty
-// configuration means versioning has never been enabled
+// VersioningConfiguration is the request body for PutBucketVersioning and the
+// response for GetBucketVersioning. An empty status means versioning has
+// never been enabled
 type VersioningConfiguration struct {
 	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+// ListVersionsResult is the response for ListObjectVersions
+type ListVersionsResult struct {
+	XMLName             xml.Name            `xml:"ListVersionsResult"`
+	Name                string              `xml:"Name"`
+	Prefix              string              `xml:"Prefix"`
+	Delimiter           string              `xml:"Delimiter,omitempty"`
+	KeyMarker           string              `xml:"KeyMarker"`
+	VersionIDMarker     string              `xml:"VersionIdMarker"`
+	NextKeyMarker       string              `xml:"NextKeyMarker,omitempty"`
+	NextVersionIDMarker string              `xml:"NextVersionIdMarker,omitempty"`
+	MaxKeys             int                 `xml:"MaxKeys"`
+	IsTruncated         bool                `xml:"IsTruncated"`
+	Versions            []ObjectVersion     `xml:"Version"`
+	DeleteMarkers       []DeleteMarkerEntry `xml:"DeleteMarker"`
+	CommonPrefixes      []CommonPrefix      `xml:"CommonPrefixes,omitempty"`
+}
+
+// ObjectVersion represents a version of an object in a ListObjectVersions
+// response
+type ObjectVersion struct {
+	Key          string `xml:"Key"`
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+	Owner        Owner  `xml:"Owner"`
+}
+
+// DeleteMarkerEntry represents a delete marker in a ListObjectVersions
+// response
+type DeleteMarkerEntry struct {
+	Key          string `xml:"Key"`
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+	Owner        Owner  `xml:"Owner"`
 }
 
 // AccessControlPolicy is the response for GetBucketAcl and GetObjectAcl
@@ -205,11 +267,44 @@ type Tag struct {
 	Value string `xml:"Value"`
 }
 
+// CORSConfiguration is the request body for PutBucketCors
+type CORSConfiguration struct {
+	XMLName   xml.Name   `xml:"CORSConfiguration"`
+	CORSRules []CORSRule `xml:"CORSRule"`
+}
+
+// CORSRule describes the cross-origin requests a bucket allows
+type CORSRule struct {
+	ID             string   `xml:"ID,omitempty"`
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedHeaders []string `xml:"AllowedHeader,omitempty"`
+	ExposeHeaders  []string `xml:"ExposeHeader,omitempty"`
+	MaxAgeSeconds  *int     `xml:"MaxAgeSeconds,omitempty"`
+}
+
+// BucketLoggingStatus is the request body for PutBucketLogging and the
+// response for GetBucketLogging. Logging is disabled when LoggingEnabled is
+// absent
+type BucketLoggingStatus struct {
+	XMLName        xml.Name        `xml:"BucketLoggingStatus"`
+	LoggingEnabled *LoggingEnabled `xml:"LoggingEnabled,omitempty"`
+}
+
+// LoggingEnabled names the bucket and key prefix a bucket's access logs are
+// delivered to
+type LoggingEnabled struct {
+	TargetBucket string `xml:"TargetBucket"`
+	TargetPrefix string `xml:"TargetPrefix"`
+}
+
 // CopyObjectResult is the response for CopyObject
 type CopyObjectResult struct {
 	XMLName      xml.Name `xml:"CopyObjectResult"`
 	LastModified string   `xml:"LastModified"`
 	ETag         string   `xml:"ETag"`
+	Checksums
+	ChecksumType string `xml:"ChecksumType,omitempty"`
 }
 
 // CopyPartResult is the response for UploadPartCopy
@@ -228,7 +323,8 @@ type Delete struct {
 
 // ObjectIdentifier identifies an object in a DeleteObjects request
 type ObjectIdentifier struct {
-	Key string `xml:"Key"`
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId,omitempty"`
 }
 
 // DeleteResult is the response for DeleteObjects
@@ -240,22 +336,63 @@ type DeleteResult struct {
 
 // DeletedObject represents a successfully deleted object
 type DeletedObject struct {
-	Key string `xml:"Key"`
+	Key                   string `xml:"Key"`
+	VersionID             string `xml:"VersionId,omitempty"`
+	DeleteMarker          bool   `xml:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionID string `xml:"DeleteMarkerVersionId,omitempty"`
 }
 
 // DeleteError represents a failed deletion in a DeleteObjects response
 type DeleteError struct {
-	Key     string `xml:"Key"`
-	Code    string `xml:"Code"`
-	Message string `xml:"Message"`
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId,omitempty"`
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
 }
 
 // Upload represents a multipart upload in progress
 type Upload struct {
-	Key          string    `xml:"Key"`
-	UploadID     string    `xml:"UploadId"`
-	Initiator    Initiator `xml:"Initiator"`
-	Owner        Owner     `xml:"Owner"`
-	StorageClass string    `xml:"StorageClass"`
-	Initiated    string    `xml:"Initiated"`
+	Key               string    `xml:"Key"`
+	UploadID          string    `xml:"UploadId"`
+	Initiator         Initiator `xml:"Initiator"`
+	Owner             Owner     `xml:"Owner"`
+	StorageClass      string    `xml:"StorageClass"`
+	Initiated         string    `xml:"Initiated"`
+	ChecksumAlgorithm string    `xml:"ChecksumAlgorithm,omitempty"`
+	ChecksumType      string    `xml:"ChecksumType,omitempty"`
+}
+
+// GetObjectAttributesResponse is the response for GetObjectAttributes. Only
+// the attributes selected by x-amz-object-attributes are included
+type GetObjectAttributesResponse struct {
+	XMLName      xml.Name        `xml:"GetObjectAttributesResponse"`
+	ETag         string          `xml:"ETag,omitempty"`
+	Checksum     *ObjectChecksum `xml:"Checksum,omitempty"`
+	ObjectParts  *ObjectParts    `xml:"ObjectParts,omitempty"`
+	StorageClass string          `xml:"StorageClass,omitempty"`
+	ObjectSize   *int64          `xml:"ObjectSize,omitempty"`
+}
+
+// ObjectChecksum is an object's additional checksum and its type
+type ObjectChecksum struct {
+	Checksums
+	ChecksumType string `xml:"ChecksumType"`
+}
+
+// ObjectParts lists a page of the parts a multipart object was completed
+// from
+type ObjectParts struct {
+	TotalPartsCount      int          `xml:"PartsCount"`
+	PartNumberMarker     int          `xml:"PartNumberMarker"`
+	NextPartNumberMarker int          `xml:"NextPartNumberMarker"`
+	MaxParts             int          `xml:"MaxParts"`
+	IsTruncated          bool         `xml:"IsTruncated"`
+	Parts                []ObjectPart `xml:"Part"`
+}
+
+// ObjectPart is a part in a GetObjectAttributes response
+type ObjectPart struct {
+	PartNumber int   `xml:"PartNumber"`
+	Size       int64 `xml:"Size"`
+	Checksums
 }
@@ -0,0 +1,126 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/stut/s3dir/pkg/auth"
+	"github.com/stut/s3dir/pkg/storage"
+)
+
+// getBucketVersioning returns the bucket's versioning state
+func (h *Handler) getBucketVersioning(w http.ResponseWriter, r *http.Request, bucket string) {
+	status, err := h.storage.GetBucketVersioning(bucket)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	writeXML(w, VersioningConfiguration{Status: status}, http.StatusOK)
+}
+
+// putBucketVersioning enables or suspends versioning on the bucket
+func (h *Handler) putBucketVersioning(w http.ResponseWriter, r *http.Request, bucket string) {
+	var config VersioningConfiguration
+	if err := xml.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeError(w, r, "MalformedXML", "Invalid XML", http.StatusBadRequest)
+		return
+	}
+
+	// A configuration without a status leaves the bucket unchanged
+	if config.Status == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if config.Status != storage.VersioningEnabled && config.Status != storage.VersioningSuspended {
+		writeError(w, r, "IllegalVersioningConfigurationException", "The versioning status must be Enabled or Suspended", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storage.PutBucketVersioning(bucket, config.Status); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// listObjectVersions lists every version and delete marker in a bucket
+func (h *Handler) listObjectVersions(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	keyMarker := query.Get("key-marker")
+	versionIDMarker := query.Get("version-id-marker")
+
+	cred := auth.CredentialFromContext(r.Context())
+	if !h.permitted(r, cred == nil || cred.AllowsPrefix(auth.ActionRead, bucket, prefix), "s3:ListBucketVersions", bucket, "") {
+		writeError(w, r, "AccessDenied", "Access Denied", http.StatusForbidden)
+		return
+	}
+
+	maxKeys := 1000
+	if mk := query.Get("max-keys"); mk != "" {
+		if n, err := strconv.Atoi(mk); err == nil && n >= 0 {
+			maxKeys = n
+		}
+	}
+
+	listing, err := h.storage.ListObjectVersions(bucket, prefix, delimiter, keyMarker, versionIDMarker, maxKeys)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	owner := Owner{ID: "s3dir", DisplayName: "s3dir"}
+	response := ListVersionsResult{
+		Name:                bucket,
+		Prefix:              prefix,
+		Delimiter:           delimiter,
+		KeyMarker:           keyMarker,
+		VersionIDMarker:     versionIDMarker,
+		NextKeyMarker:       listing.NextKeyMarker,
+		NextVersionIDMarker: listing.NextVersionIDMarker,
+		MaxKeys:             maxKeys,
+		IsTruncated:         listing.IsTruncated,
+	}
+
+	for _, v := range listing.Versions {
+		lastModified := v.LastModified.UTC().Format(time.RFC3339)
+		if v.DeleteMarker {
+			response.DeleteMarkers = append(response.DeleteMarkers, DeleteMarkerEntry{
+				Key:          v.Key,
+				VersionID:    v.VersionID,
+				IsLatest:     v.IsLatest,
+				LastModified: lastModified,
+				Owner:        owner,
+			})
+			continue
+		}
+		response.Versions = append(response.Versions, ObjectVersion{
+			Key:          v.Key,
+			VersionID:    v.VersionID,
+			IsLatest:     v.IsLatest,
+			LastModified: lastModified,
+			ETag:         v.ETag,
+			Size:         v.Size,
+			StorageClass: "STANDARD",
+			Owner:        owner,
+		})
+	}
+
+	for _, cp := range listing.CommonPrefixes {
+		response.CommonPrefixes = append(response.CommonPrefixes, CommonPrefix{Prefix: cp})
+	}
+
+	writeXML(w, response, http.StatusOK)
+}
+
+// setDeleteMarkerHeaders marks a response as referring to a delete marker
+func setDeleteMarkerHeaders(w http.ResponseWriter, info *storage.ObjectInfo) {
+	w.Header().Set("x-amz-delete-marker", "true")
+	w.Header().Set("x-amz-version-id", info.VersionID)
+}
@@ -0,0 +1,139 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serve(handler *Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestVersioningAPI(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+
+	w := serve(handler, http.MethodPut, "/test-bucket?versioning", `<VersioningConfiguration><Status>Sometimes</Status></VersioningConfiguration>`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid status: expected 400, got %d", w.Code)
+	}
+
+	w = serve(handler, http.MethodPut, "/test-bucket?versioning", `<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT versioning: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = serve(handler, http.MethodGet, "/test-bucket?versioning", "")
+	var config VersioningConfiguration
+	if err := xml.Unmarshal(w.Body.Bytes(), &config); err != nil || config.Status != "Enabled" {
+		t.Fatalf("GET versioning: expected Enabled, got %s", w.Body.String())
+	}
+
+	w = serve(handler, http.MethodPut, "/test-bucket/doc.txt", "first")
+	v1 := w.Header().Get("x-amz-version-id")
+	w = serve(handler, http.MethodPut, "/test-bucket/doc.txt", "second")
+	v2 := w.Header().Get("x-amz-version-id")
+	if v1 == "" || v2 == "" || v1 == v2 {
+		t.Fatalf("Expected distinct version IDs, got %q and %q", v1, v2)
+	}
+
+	w = serve(handler, http.MethodGet, "/test-bucket/doc.txt?versionId="+v1, "")
+	if w.Code != http.StatusOK || w.Body.String() != "first" || w.Header().Get("x-amz-version-id") != v1 {
+		t.Errorf("GET v1: got %d %q (version %q)", w.Code, w.Body.String(), w.Header().Get("x-amz-version-id"))
+	}
+
+	w = serve(handler, http.MethodHead, "/test-bucket/doc.txt?versionId=nonexistent", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("HEAD nonexistent version: expected 404, got %d", w.Code)
+	}
+
+	// Copy from an old version
+	req := httptest.NewRequest(http.MethodPut, "/test-bucket/copy.txt", nil)
+	req.Header.Set("x-amz-copy-source", "/test-bucket/doc.txt?versionId="+v1)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("x-amz-copy-source-version-id") != v1 {
+		t.Fatalf("Copy from version: got %d (source version %q): %s", w.Code, w.Header().Get("x-amz-copy-source-version-id"), w.Body.String())
+	}
+	if code, body := getTestObject(t, handler, "test-bucket", "copy.txt"); code != http.StatusOK || body != "first" {
+		t.Errorf("Expected copy of v1, got %d %q", code, body)
+	}
+
+	// Delete creates a delete marker
+	w = serve(handler, http.MethodDelete, "/test-bucket/doc.txt", "")
+	marker := w.Header().Get("x-amz-version-id")
+	if w.Code != http.StatusNoContent || w.Header().Get("x-amz-delete-marker") != "true" || marker == "" {
+		t.Fatalf("DELETE: expected delete marker, got %d %v", w.Code, w.Header())
+	}
+
+	w = serve(handler, http.MethodGet, "/test-bucket/doc.txt", "")
+	if w.Code != http.StatusNotFound || w.Header().Get("x-amz-delete-marker") != "true" {
+		t.Errorf("GET deleted: expected 404 with delete marker, got %d %v", w.Code, w.Header())
+	}
+	w = serve(handler, http.MethodGet, "/test-bucket/doc.txt?versionId="+marker, "")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET delete marker version: expected 405, got %d", w.Code)
+	}
+
+	w = serve(handler, http.MethodGet, "/test-bucket?versions&prefix=doc", "")
+	var listing ListVersionsResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatalf("Failed to parse versions listing: %v", err)
+	}
+	if len(listing.Versions) != 2 || len(listing.DeleteMarkers) != 1 {
+		t.Fatalf("Expected 2 versions and 1 delete marker, got %s", w.Body.String())
+	}
+	if !listing.DeleteMarkers[0].IsLatest || listing.DeleteMarkers[0].VersionID != marker {
+		t.Errorf("Expected latest delete marker %s, got %+v", marker, listing.DeleteMarkers[0])
+	}
+	if listing.Versions[0].VersionID != v2 || listing.Versions[1].VersionID != v1 {
+		t.Errorf("Expected versions newest first, got %+v", listing.Versions)
+	}
+
+	// Removing the delete marker brings the object back
+	w = serve(handler, http.MethodDelete, "/test-bucket/doc.txt?versionId="+marker, "")
+	if w.Code != http.StatusNoContent || w.Header().Get("x-amz-delete-marker") != "true" {
+		t.Fatalf("DELETE marker: got %d %v", w.Code, w.Header())
+	}
+	if code, body := getTestObject(t, handler, "test-bucket", "doc.txt"); code != http.StatusOK || body != "second" {
+		t.Errorf("Expected restored object, got %d %q", code, body)
+	}
+
+	// Batch delete of a specific version
+	w = serve(handler, http.MethodPost, "/test-bucket?delete", `<Delete><Object><Key>doc.txt</Key><VersionId>`+v2+`</VersionId></Object></Delete>`)
+	var result DeleteResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil || len(result.Deleted) != 1 || result.Deleted[0].VersionID != v2 {
+		t.Fatalf("Batch delete of version: got %s", w.Body.String())
+	}
+	if code, body := getTestObject(t, handler, "test-bucket", "doc.txt"); code != http.StatusOK || body != "first" {
+		t.Errorf("Expected v1 current after deleting v2, got %d %q", code, body)
+	}
+}
+
+func TestUnversionedBucketOmitsVersionHeaders(t *testing.T) {
+	handler, store, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	store.CreateBucket("test-bucket")
+
+	w := serve(handler, http.MethodPut, "/test-bucket/doc.txt", "data")
+	if w.Header().Get("x-amz-version-id") != "" {
+		t.Errorf("Expected no version ID on PUT, got %q", w.Header().Get("x-amz-version-id"))
+	}
+
+	w = serve(handler, http.MethodDelete, "/test-bucket/doc.txt", "")
+	if w.Header().Get("x-amz-delete-marker") != "" {
+		t.Error("Expected no delete marker in unversioned bucket")
+	}
+	if code, _ := getTestObject(t, handler, "test-bucket", "doc.txt"); code != http.StatusNotFound {
+		t.Errorf("Expected object removed, got %d", code)
+	}
+}
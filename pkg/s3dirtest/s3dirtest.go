@@ -0,0 +1,207 @@
+// Package s3dirtest runs an s3dir server for Go tests.
+//
+// New starts a server on a random local port backed by a temporary
+// directory, returns an aws-sdk-go-v2 S3 client configured to talk to it, and
+// shuts everything down when the test finishes:
+//
+//	srv := s3dirtest.New(t)
+//	srv.Seed(s3dirtest.Fixtures{"photos": {"cat.jpg": "meow"}})
+//	out, err := srv.Client.GetObject(ctx, &s3.GetObjectInput{...})
+package s3dirtest
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stut/s3dir/pkg/auth"
+	"github.com/stut/s3dir/pkg/s3"
+	"github.com/stut/s3dir/pkg/storage"
+)
+
+// Credentials the server accepts and the client signs with when
+// authentication is enabled
+const (
+	AccessKeyID     = "S3DIRTESTACCESSKEY"
+	SecretAccessKey = "s3dirtest-secret-access-key"
+)
+
+// Region is the region the client is configured for
+const Region = "us-east-1"
+
+// Server is a running s3dir server
+type Server struct {
+	// URL is the server's base URL, e.g. http://127.0.0.1:41234
+	URL string
+	// Client is an S3 client using path-style addressing against the server
+	Client *awss3.Client
+	// Backend is the storage the server serves from
+	Backend s3.Backend
+
+	t testing.TB
+}
+
+// Fixtures maps bucket names to the objects to create in them, keyed by
+// object key
+type Fixtures map[string]map[string]string
+
+type options struct {
+	memory   bool
+	noAuth   bool
+	readOnly bool
+}
+
+// Option configures a test server
+type Option func(*options)
+
+// WithMemoryBackend serves from the in-memory backend instead of a temporary
+// directory
+func WithMemoryBackend() Option {
+	return func(o *options) { o.memory = true }
+}
+
+// WithoutAuth disables authentication, so unsigned requests are accepted
+func WithoutAuth() Option {
+	return func(o *options) { o.noAuth = true }
+}
+
+// WithReadOnly starts the server in read-only mode. Seeding still works, as
+// it writes to the backend directly
+func WithReadOnly() Option {
+	return func(o *options) { o.readOnly = true }
+}
+
+// New starts a server for the duration of the test. By default it stores
+// data in a temporary directory and requires requests signed with
+// AccessKeyID and SecretAccessKey
+func New(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var backend s3.Backend
+	if o.memory {
+		backend = storage.NewMemory()
+	} else {
+		store, err := storage.New(t.TempDir())
+		if err != nil {
+			t.Fatalf("s3dirtest: failed to create storage: %v", err)
+		}
+		t.Cleanup(store.Stop)
+		backend = store
+	}
+
+	handler := s3.NewHandler(backend, o.readOnly, false)
+	authenticator := auth.New(AccessKeyID, SecretAccessKey, !o.noAuth)
+
+	var httpHandler http.Handler = handler
+	httpHandler = authenticator.Middleware(httpHandler)
+	httpHandler = handler.CORSMiddleware(httpHandler)
+
+	httpServer := httptest.NewServer(httpHandler)
+	t.Cleanup(httpServer.Close)
+
+	client := awss3.New(awss3.Options{
+		Region:       Region,
+		BaseEndpoint: aws.String(httpServer.URL),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider(AccessKeyID, SecretAccessKey, ""),
+		HTTPClient:   httpServer.Client(),
+	})
+
+	return &Server{
+		URL:     httpServer.URL,
+		Client:  client,
+		Backend: backend,
+		t:       t,
+	}
+}
+
+// CreateBucket creates a bucket, failing the test on error
+func (s *Server) CreateBucket(bucket string) {
+	s.t.Helper()
+	if err := s.Backend.CreateBucket(bucket); err != nil {
+		s.t.Fatalf("s3dirtest: failed to create bucket %s: %v", bucket, err)
+	}
+}
+
+// PutObject stores an object, failing the test on error
+func (s *Server) PutObject(bucket, key, body string) {
+	s.t.Helper()
+	s.putObject(bucket, key, strings.NewReader(body), int64(len(body)))
+}
+
+func (s *Server) putObject(bucket, key string, reader io.Reader, size int64) {
+	s.t.Helper()
+	if _, err := s.Backend.PutObjectWithMetadata(bucket, key, reader, size, storage.ObjectMetadata{}); err != nil {
+		s.t.Fatalf("s3dirtest: failed to put %s/%s: %v", bucket, key, err)
+	}
+}
+
+// Seed creates each bucket in fixtures that does not exist yet and stores its
+// objects
+func (s *Server) Seed(fixtures Fixtures) {
+	s.t.Helper()
+	for bucket, objects := range fixtures {
+		s.ensureBucket(bucket)
+		for key, body := range objects {
+			s.PutObject(bucket, key, body)
+		}
+	}
+}
+
+// SeedFS creates bucket if it does not exist yet and stores every file in
+// fsys as an object keyed by its slash-separated path, e.g. from
+// os.DirFS("testdata/photos") or an embed.FS
+func (s *Server) SeedFS(bucket string, fsys fs.FS) {
+	s.t.Helper()
+	s.ensureBucket(bucket)
+
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		s.putObject(bucket, path, bytes.NewReader(data), int64(len(data)))
+		return nil
+	})
+	if err != nil {
+		s.t.Fatalf("s3dirtest: failed to seed bucket %s: %v", bucket, err)
+	}
+}
+
+// ReadObject returns the content of an object, failing the test on error
+func (s *Server) ReadObject(bucket, key string) string {
+	s.t.Helper()
+	reader, _, err := s.Backend.GetObjectVersion(bucket, key, "")
+	if err != nil {
+		s.t.Fatalf("s3dirtest: failed to get %s/%s: %v", bucket, key, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		s.t.Fatalf("s3dirtest: failed to read %s/%s: %v", bucket, key, err)
+	}
+	return string(data)
+}
+
+func (s *Server) ensureBucket(bucket string) {
+	s.t.Helper()
+	if s.Backend.HeadBucket(bucket) != nil {
+		s.CreateBucket(bucket)
+	}
+}
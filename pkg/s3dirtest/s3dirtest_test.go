@@ -0,0 +1,141 @@
+package s3dirtest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func getObject(t *testing.T, client *awss3.Client, bucket, key string) string {
+	t.Helper()
+	out, err := client.GetObject(context.Background(), &awss3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		t.Fatalf("GetObject %s/%s failed: %v", bucket, key, err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		t.Fatalf("Failed to read %s/%s: %v", bucket, key, err)
+	}
+	return string(data)
+}
+
+func TestClient(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"filesystem": nil,
+		"memory":     {WithMemoryBackend()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := New(t, opts...)
+			ctx := context.Background()
+
+			if _, err := srv.Client.CreateBucket(ctx, &awss3.CreateBucketInput{Bucket: aws.String("bucket")}); err != nil {
+				t.Fatalf("CreateBucket failed: %v", err)
+			}
+			_, err := srv.Client.PutObject(ctx, &awss3.PutObjectInput{
+				Bucket: aws.String("bucket"),
+				Key:    aws.String("dir/hello.txt"),
+				Body:   strings.NewReader("hello"),
+			})
+			if err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+
+			if got := getObject(t, srv.Client, "bucket", "dir/hello.txt"); got != "hello" {
+				t.Errorf("GetObject: got %q", got)
+			}
+			if got := srv.ReadObject("bucket", "dir/hello.txt"); got != "hello" {
+				t.Errorf("ReadObject: got %q", got)
+			}
+
+			list, err := srv.Client.ListObjectsV2(ctx, &awss3.ListObjectsV2Input{Bucket: aws.String("bucket")})
+			if err != nil {
+				t.Fatalf("ListObjectsV2 failed: %v", err)
+			}
+			if len(list.Contents) != 1 || aws.ToString(list.Contents[0].Key) != "dir/hello.txt" {
+				t.Errorf("ListObjectsV2: got %+v", list.Contents)
+			}
+		})
+	}
+}
+
+func TestAuth(t *testing.T) {
+	srv := New(t)
+	srv.CreateBucket("bucket")
+
+	resp, err := http.Get(srv.URL + "/bucket")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Unsigned request: expected 403, got %d", resp.StatusCode)
+	}
+
+	open := New(t, WithoutAuth())
+	open.CreateBucket("bucket")
+
+	resp, err = http.Get(open.URL + "/bucket")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Unsigned request without auth: expected 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	srv := New(t, WithReadOnly())
+
+	srv.Seed(Fixtures{
+		"photos": {"cat.jpg": "meow", "dogs/rex.jpg": "woof"},
+		"empty":  {},
+	})
+	srv.SeedFS("docs", fstest.MapFS{
+		"readme.md":      {Data: []byte("# docs")},
+		"guide/intro.md": {Data: []byte("intro")},
+	})
+	// Seeding an existing bucket adds to it
+	srv.Seed(Fixtures{"photos": {"bird.jpg": "tweet"}})
+
+	for _, tc := range []struct{ bucket, key, want string }{
+		{"photos", "cat.jpg", "meow"},
+		{"photos", "dogs/rex.jpg", "woof"},
+		{"photos", "bird.jpg", "tweet"},
+		{"docs", "readme.md", "# docs"},
+		{"docs", "guide/intro.md", "intro"},
+	} {
+		if got := getObject(t, srv.Client, tc.bucket, tc.key); got != tc.want {
+			t.Errorf("%s/%s: got %q, want %q", tc.bucket, tc.key, got, tc.want)
+		}
+	}
+
+	buckets, err := srv.Client.ListBuckets(context.Background(), &awss3.ListBucketsInput{})
+	if err != nil {
+		t.Fatalf("ListBuckets failed: %v", err)
+	}
+	if len(buckets.Buckets) != 3 {
+		t.Errorf("Expected 3 buckets, got %d", len(buckets.Buckets))
+	}
+
+	// The server itself is read-only
+	_, err = srv.Client.PutObject(context.Background(), &awss3.PutObjectInput{
+		Bucket: aws.String("photos"),
+		Key:    aws.String("new.jpg"),
+		Body:   strings.NewReader("new"),
+	})
+	if err == nil {
+		t.Error("Expected PutObject to fail on a read-only server")
+	}
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// bucketConfigDirName is the directory under baseDir holding per-bucket
+// configuration documents (policy, lifecycle, CORS, ...), one subdirectory
+// per bucket
+const bucketConfigDirName = ".config"
+
+func (s *Storage) bucketConfigPath(bucket, name string) string {
+	return filepath.Join(s.baseDir, bucketConfigDirName, bucket, name)
+}
+
+// GetBucketConfig returns the named configuration document for a bucket, or
+// nil if none has been stored
+func (s *Storage) GetBucketConfig(bucket, name string) ([]byte, error) {
+	if err := s.HeadBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(s.bucketConfigPath(bucket, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read bucket configuration: %w", err)
+	}
+
+	return data, nil
+}
+
+// PutBucketConfig stores the named configuration document for a bucket,
+// replacing any previous version
+func (s *Storage) PutBucketConfig(bucket, name string, data []byte) error {
+	if err := s.HeadBucket(bucket); err != nil {
+		return err
+	}
+
+	path := s.bucketConfigPath(bucket, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Write through a temporary file so readers never see a partial document
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), tempFilePrefix+"tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	_, err = tmpFile.Write(data)
+	closeErr := tmpFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write bucket configuration: %w", err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to close temporary file: %w", closeErr)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to move bucket configuration: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteBucketConfig removes the named configuration document for a bucket,
+// if any
+func (s *Storage) DeleteBucketConfig(bucket, name string) error {
+	if err := s.HeadBucket(bucket); err != nil {
+		return err
+	}
+
+	err := os.Remove(s.bucketConfigPath(bucket, name))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete bucket configuration: %w", err)
+	}
+
+	return nil
+}
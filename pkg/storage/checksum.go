@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"slices"
+)
+
+// Additional checksum algorithms, named as in x-amz-checksum-algorithm
+const (
+	ChecksumCRC32     = "CRC32"
+	ChecksumCRC32C    = "CRC32C"
+	ChecksumCRC64NVME = "CRC64NVME"
+	ChecksumSHA1      = "SHA1"
+	ChecksumSHA256    = "SHA256"
+)
+
+// ChecksumAlgorithms lists the supported additional checksum algorithms
+var ChecksumAlgorithms = []string{ChecksumCRC32, ChecksumCRC32C, ChecksumCRC64NVME, ChecksumSHA1, ChecksumSHA256}
+
+// DefaultChecksumAlgorithm is the checksum stored with objects uploaded
+// without one, as in S3
+const DefaultChecksumAlgorithm = ChecksumCRC64NVME
+
+// Checksum types. A full object checksum is computed over the whole content;
+// a composite checksum of a multipart object is computed over the checksums
+// of its parts
+const (
+	ChecksumTypeFullObject = "FULL_OBJECT"
+	ChecksumTypeComposite  = "COMPOSITE"
+)
+
+// crc64NVME is the CRC-64/NVME polynomial in reversed form
+var crc64NVME = crc64.MakeTable(0x9a6c9329ac4bc9b5)
+
+// Checksum is an additional checksum of an object or part. Value is base64
+// encoded, and composite checksums end in "-<part count>"
+type Checksum struct {
+	Algorithm string `json:"algorithm"`
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+}
+
+// Digests are digests a client sent along with uploaded content. Content
+// that does not match them is not stored, failing the write with a
+// DigestError
+type Digests struct {
+	// ContentMD5 is the MD5 from a Content-MD5 header
+	ContentMD5 []byte
+	// Checksum returns the expected base64 checksum, computed with the
+	// algorithm chosen for the write, and is nil if none was announced. It is
+	// called once the content has been read, so the value can come from a
+	// trailing header; "" means the announced checksum never arrived
+	Checksum func() string
+}
+
+// DefaultChecksumType returns the checksum type of multipart uploads that
+// choose algorithm without a type: full object for CRC64NVME, which has no
+// composite form, and composite otherwise
+func DefaultChecksumType(algorithm string) string {
+	if algorithm == ChecksumCRC64NVME {
+		return ChecksumTypeFullObject
+	}
+	return ChecksumTypeComposite
+}
+
+// ValidChecksumAlgorithm reports whether algorithm is supported
+func ValidChecksumAlgorithm(algorithm string) bool {
+	return slices.Contains(ChecksumAlgorithms, algorithm)
+}
+
+// ValidChecksum reports whether value is a well-formed base64 checksum for
+// algorithm
+func ValidChecksum(algorithm, value string) bool {
+	h := newChecksumHash(algorithm)
+	sum, err := base64.StdEncoding.DecodeString(value)
+	return h != nil && err == nil && len(sum) == h.Size()
+}
+
+// newChecksumHash returns a hash computing algorithm, or nil if it is not
+// supported
+func newChecksumHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case ChecksumCRC32:
+		return crc32.NewIEEE()
+	case ChecksumCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case ChecksumCRC64NVME:
+		return crc64.New(crc64NVME)
+	case ChecksumSHA1:
+		return sha1.New()
+	case ChecksumSHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+// contentHash computes the MD5 and an additional checksum of content
+// written to it in one pass
+type contentHash struct {
+	md5       hash.Hash
+	checksum  hash.Hash
+	algorithm string
+}
+
+// newContentHash returns a contentHash computing algorithm, or only the MD5
+// if algorithm is empty
+func newContentHash(algorithm string) *contentHash {
+	return &contentHash{md5: md5.New(), checksum: newChecksumHash(algorithm), algorithm: algorithm}
+}
+
+func (h *contentHash) Write(p []byte) (int, error) {
+	h.md5.Write(p)
+	if h.checksum != nil {
+		h.checksum.Write(p)
+	}
+	return len(p), nil
+}
+
+// etag returns the hex MD5 of the content
+func (h *contentHash) etag() string {
+	return hex.EncodeToString(h.md5.Sum(nil))
+}
+
+// sum returns the full object checksum of the content, or nil if no
+// algorithm was chosen
+func (h *contentHash) sum() *Checksum {
+	if h.checksum == nil {
+		return nil
+	}
+	return fullObjectChecksum(h.algorithm, h.checksum)
+}
+
+// verify checks the content against the digests sent with it
+func (h *contentHash) verify(digests Digests) error {
+	if digests.ContentMD5 != nil && !bytes.Equal(h.md5.Sum(nil), digests.ContentMD5) {
+		return &DigestError{Digest: "Content-MD5"}
+	}
+	if digests.Checksum == nil || h.checksum == nil {
+		return nil
+	}
+	expected := digests.Checksum()
+	if expected == "" {
+		return ErrMissingChecksum
+	}
+	if expected != h.sum().Value {
+		return &DigestError{Digest: h.algorithm}
+	}
+	return nil
+}
+
+// fullObjectChecksum returns the checksum computed by h
+func fullObjectChecksum(algorithm string, h hash.Hash) *Checksum {
+	return &Checksum{
+		Algorithm: algorithm,
+		Type:      ChecksumTypeFullObject,
+		Value:     base64.StdEncoding.EncodeToString(h.Sum(nil)),
+	}
+}
+
+// compositeChecksum returns the composite checksum of a multipart object
+// from its parts' checksums, or nil if a part has none
+func compositeChecksum(algorithm string, parts []*Checksum) *Checksum {
+	h := newChecksumHash(algorithm)
+	if h == nil {
+		return nil
+	}
+	for _, part := range parts {
+		if part == nil || part.Algorithm != algorithm {
+			return nil
+		}
+		sum, err := base64.StdEncoding.DecodeString(part.Value)
+		if err != nil {
+			return nil
+		}
+		h.Write(sum)
+	}
+	return &Checksum{
+		Algorithm: algorithm,
+		Type:      ChecksumTypeComposite,
+		Value:     fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(h.Sum(nil)), len(parts)),
+	}
+}
+
+// copyChecksumAlgorithm returns the checksum to compute for a copy of an
+// object: the requested algorithm, or else that of the source's full object
+// checksum, or else the default
+func copyChecksumAlgorithm(src *ObjectInfo, requested string) string {
+	if requested != "" {
+		return requested
+	}
+	if src.Checksum != nil && src.Checksum.Type == ChecksumTypeFullObject {
+		return src.Checksum.Algorithm
+	}
+	return DefaultChecksumAlgorithm
+}
+
+// objectChecksum returns the algorithm and type of the checksum stored with
+// the object a multipart upload completes to
+func objectChecksum(upload *MultipartUpload) (algorithm, checksumType string) {
+	if upload.ChecksumAlgorithm == "" {
+		return DefaultChecksumAlgorithm, ChecksumTypeFullObject
+	}
+	if upload.ChecksumType == "" {
+		return upload.ChecksumAlgorithm, DefaultChecksumType(upload.ChecksumAlgorithm)
+	}
+	return upload.ChecksumAlgorithm, upload.ChecksumType
+}
+
+// partChecksumAlgorithm returns the checksum to compute for a part of
+// upload, given the algorithm of the checksum the client sent with it, if
+// any
+func partChecksumAlgorithm(upload *MultipartUpload, sent string) (string, error) {
+	if upload.ChecksumAlgorithm == "" {
+		return sent, nil
+	}
+	if sent != "" && sent != upload.ChecksumAlgorithm {
+		return "", fmt.Errorf("%w: the upload uses %s, not %s", ErrChecksumAlgorithmMismatch, upload.ChecksumAlgorithm, sent)
+	}
+	return upload.ChecksumAlgorithm, nil
+}
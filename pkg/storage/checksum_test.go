@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestChecksumAlgorithms(t *testing.T) {
+	// Check values of each CRC for "123456789"
+	tests := []struct {
+		algorithm string
+		expected  string
+	}{
+		{ChecksumCRC32, "cbf43926"},
+		{ChecksumCRC32C, "e3069283"},
+		{ChecksumCRC64NVME, "ae8b14860a799888"},
+	}
+
+	for _, tt := range tests {
+		h := newChecksumHash(tt.algorithm)
+		h.Write([]byte("123456789"))
+		if got := hex.EncodeToString(h.Sum(nil)); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.algorithm, tt.expected, got)
+		}
+	}
+
+	if !ValidChecksum(ChecksumCRC32, "AAAAAA==") {
+		t.Error("Expected a 4 byte CRC32 to be valid")
+	}
+	if ValidChecksum(ChecksumCRC32, "AAAAAAAA") || ValidChecksum(ChecksumSHA1, "AAAAAA==") || ValidChecksum("MD5", "AAAAAA==") {
+		t.Error("Expected checksums of the wrong size or algorithm to be invalid")
+	}
+}
+
+func TestPutObjectChecksum(t *testing.T) {
+	storage, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.CreateBucket("test-bucket")
+
+	content := []byte("Hello, S3Dir!")
+	put := func(key string, metadata ObjectMetadata) (*ObjectInfo, error) {
+		return storage.PutObjectWithMetadata("test-bucket", key, bytes.NewReader(content), int64(len(content)), metadata)
+	}
+
+	// Objects without a checksum get the default one
+	if _, err := put("default.txt", ObjectMetadata{}); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	info, err := storage.HeadObject("test-bucket", "default.txt")
+	if err != nil {
+		t.Fatalf("Failed to head object: %v", err)
+	}
+	if info.Checksum == nil || info.Checksum.Algorithm != ChecksumCRC64NVME || info.Checksum.Type != ChecksumTypeFullObject {
+		t.Errorf("Expected a full object CRC64NVME checksum, got %+v", info.Checksum)
+	}
+
+	sum := sha256.Sum256(content)
+	expected := base64.StdEncoding.EncodeToString(sum[:])
+	md5Sum := md5.Sum(content)
+	info, err = put("sha256.txt", ObjectMetadata{
+		ChecksumAlgorithm: ChecksumSHA256,
+		Digests: Digests{
+			ContentMD5: md5Sum[:],
+			Checksum:   func() string { return expected },
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to put object with matching digests: %v", err)
+	}
+	if info.Checksum.Value != expected {
+		t.Errorf("Expected SHA256 checksum %s, got %s", expected, info.Checksum.Value)
+	}
+
+	mismatches := map[string]Digests{
+		"Content-MD5": {ContentMD5: make([]byte, md5.Size)},
+		"checksum":    {Checksum: func() string { return base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)) }},
+	}
+	for name, digests := range mismatches {
+		_, err := put("bad.txt", ObjectMetadata{ChecksumAlgorithm: ChecksumSHA256, Digests: digests})
+		if !errors.Is(err, ErrBadDigest) {
+			t.Errorf("%s mismatch: expected ErrBadDigest, got %v", name, err)
+		}
+	}
+	missing := Digests{Checksum: func() string { return "" }}
+	if _, err := put("bad.txt", ObjectMetadata{ChecksumAlgorithm: ChecksumSHA256, Digests: missing}); !errors.Is(err, ErrMissingChecksum) {
+		t.Errorf("Announced checksum never sent: expected ErrMissingChecksum, got %v", err)
+	}
+	if _, err := storage.HeadObject("test-bucket", "bad.txt"); !errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("Expected an object failing its digests not to be stored, got %v", err)
+	}
+}
+
+func TestMultipartChecksum(t *testing.T) {
+	// The multipart operations both backends share
+	type multipartBackend interface {
+		CreateBucket(bucket string) error
+		InitiateMultipartUploadWithMetadata(bucket, key string, metadata ObjectMetadata) (string, error)
+		UploadPartWithDigests(uploadID string, partNumber int, reader io.Reader, size int64, checksumAlgorithm string, digests Digests) (*UploadPart, error)
+		CompleteMultipartUpload(uploadID string, parts []CompletePart) (*ObjectInfo, error)
+	}
+	disk, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	backends := map[string]multipartBackend{"disk": disk, "memory": NewMemory()}
+
+	parts := [][]byte{[]byte("part1-content-"), []byte("part2-content")}
+
+	for name, b := range backends {
+		t.Run(name, func(t *testing.T) {
+			b.CreateBucket("test-bucket")
+
+			upload := func(checksumType string) (string, []CompletePart) {
+				uploadID, err := b.InitiateMultipartUploadWithMetadata("test-bucket", "object.txt", ObjectMetadata{
+					ChecksumAlgorithm: ChecksumCRC32,
+					ChecksumType:      checksumType,
+				})
+				if err != nil {
+					t.Fatalf("Failed to initiate upload: %v", err)
+				}
+				var complete []CompletePart
+				for i, data := range parts {
+					part, err := b.UploadPartWithDigests(uploadID, i+1, bytes.NewReader(data), int64(len(data)), "", Digests{})
+					if err != nil {
+						t.Fatalf("Failed to upload part %d: %v", i+1, err)
+					}
+					if part.Checksum == nil || part.Checksum.Value != crc32Base64(data) {
+						t.Fatalf("Expected part %d to have CRC32 %s, got %+v", i+1, crc32Base64(data), part.Checksum)
+					}
+					complete = append(complete, CompletePart{PartNumber: i + 1, ETag: part.ETag, Checksum: part.Checksum.Value})
+				}
+				return uploadID, complete
+			}
+
+			uploadID, complete := upload("")
+			if _, err := b.UploadPartWithDigests(uploadID, 3, strings.NewReader("x"), 1, ChecksumSHA1, Digests{}); !errors.Is(err, ErrChecksumAlgorithmMismatch) {
+				t.Errorf("Expected a part with another algorithm to be rejected, got %v", err)
+			}
+			info, err := b.CompleteMultipartUpload(uploadID, complete)
+			if err != nil {
+				t.Fatalf("Failed to complete upload: %v", err)
+			}
+			raw1, _ := base64.StdEncoding.DecodeString(crc32Base64(parts[0]))
+			raw2, _ := base64.StdEncoding.DecodeString(crc32Base64(parts[1]))
+			composite := crc32Base64(append(raw1, raw2...)) + "-2"
+			if info.Checksum == nil || info.Checksum.Type != ChecksumTypeComposite || info.Checksum.Value != composite {
+				t.Errorf("Expected composite checksum %s, got %+v", composite, info.Checksum)
+			}
+
+			uploadID, complete = upload(ChecksumTypeFullObject)
+			info, err = b.CompleteMultipartUpload(uploadID, complete)
+			if err != nil {
+				t.Fatalf("Failed to complete upload: %v", err)
+			}
+			whole := crc32Base64(bytes.Join(parts, nil))
+			if info.Checksum == nil || info.Checksum.Type != ChecksumTypeFullObject || info.Checksum.Value != whole {
+				t.Errorf("Expected full object checksum %s, got %+v", whole, info.Checksum)
+			}
+
+			uploadID, complete = upload("")
+			complete[1].Checksum = "AAAAAA=="
+			if _, err := b.CompleteMultipartUpload(uploadID, complete); !errors.Is(err, ErrInvalidPart) {
+				t.Errorf("Expected a part checksum mismatch to be ErrInvalidPart, got %v", err)
+			}
+		})
+	}
+}
+
+func crc32Base64(data []byte) string {
+	h := crc32.NewIEEE()
+	h.Write(data)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// WriteConditions make a write depend on the current version of the object
+// it replaces, as the If-Match and If-None-Match headers do. Conditions are
+// checked when a write begins and again, atomically with other writes to
+// the key, when it commits
+type WriteConditions struct {
+	// IfMatch is the ETag the current object must have, or "*" for any
+	IfMatch string
+	// IfNoneMatch requires that there is no current object
+	IfNoneMatch bool
+}
+
+func (c WriteConditions) empty() bool {
+	return c.IfMatch == "" && !c.IfNoneMatch
+}
+
+// check tests the conditions against the current object, nil if there is
+// none. If-Match fails with ErrNoSuchKey when there is no object to match
+func (c WriteConditions) check(current *ObjectInfo) error {
+	if c.IfNoneMatch && current != nil {
+		return ErrPreconditionFailed
+	}
+	if c.IfMatch == "" {
+		return nil
+	}
+	if current == nil {
+		return ErrNoSuchKey
+	}
+	if c.IfMatch != "*" && strings.Trim(c.IfMatch, "\"") != strings.Trim(current.ETag, "\"") {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// conflict converts the failure of conditions checked again as a write
+// commits. They held when the write began, so a concurrent write has changed
+// the object since
+func conflict(err error) error {
+	if errors.Is(err, ErrPreconditionFailed) || errors.Is(err, ErrNoSuchKey) {
+		return ErrConditionalRequestConflict
+	}
+	return err
+}
+
+// keyLocks serializes the commits of writes to the same object so that
+// their conditions are checked against the object they replace
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	mu      sync.Mutex
+	waiters int
+}
+
+// lock locks an object's key, returning the function that unlocks it
+func (l *keyLocks) lock(bucket, key string) func() {
+	name := bucket + "/" + key
+
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*keyLock)
+	}
+	k, ok := l.locks[name]
+	if !ok {
+		k = &keyLock{}
+		l.locks[name] = k
+	}
+	k.waiters++
+	l.mu.Unlock()
+
+	k.mu.Lock()
+	return func() {
+		k.mu.Unlock()
+
+		l.mu.Lock()
+		k.waiters--
+		if k.waiters == 0 {
+			delete(l.locks, name)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// checkConditions tests a conditional write against the current version of
+// an object
+func (s *Storage) checkConditions(bucket, key string, conditions WriteConditions) error {
+	if conditions.empty() {
+		return nil
+	}
+	current, err := s.HeadObject(bucket, key)
+	if errors.Is(err, ErrNoSuchKey) {
+		current, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	return conditions.check(current)
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// conditionalBackend is what the conditional write tests use of both
+// backends
+type conditionalBackend interface {
+	CreateBucket(bucket string) error
+	PutObjectWithMetadata(bucket, key string, reader io.Reader, size int64, metadata ObjectMetadata) (*ObjectInfo, error)
+	InitiateMultipartUploadWithMetadata(bucket, key string, metadata ObjectMetadata) (string, error)
+	UploadPart(uploadID string, partNumber int, reader io.Reader, size int64) (string, error)
+	CompleteMultipartUploadWithConditions(uploadID string, parts []CompletePart, conditions WriteConditions) (*ObjectInfo, error)
+}
+
+func conditionalBackends(t *testing.T) map[string]conditionalBackend {
+	disk, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	return map[string]conditionalBackend{"disk": disk, "memory": NewMemory()}
+}
+
+// interruptingReader runs interrupt once its content has been half read
+type interruptingReader struct {
+	content   *strings.Reader
+	interrupt func()
+}
+
+func (r *interruptingReader) Read(p []byte) (int, error) {
+	if r.interrupt != nil && r.content.Len() <= int(r.content.Size())/2 {
+		r.interrupt()
+		r.interrupt = nil
+	}
+	return r.content.Read(p[:min(len(p), 2)])
+}
+
+func TestConditionalPutObject(t *testing.T) {
+	for name, b := range conditionalBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			b.CreateBucket("test-bucket")
+			put := func(content string, conditions WriteConditions) (*ObjectInfo, error) {
+				return b.PutObjectWithMetadata("test-bucket", "lock", strings.NewReader(content), int64(len(content)), ObjectMetadata{Conditions: conditions})
+			}
+
+			if _, err := put("first", WriteConditions{IfMatch: "*"}); !errors.Is(err, ErrNoSuchKey) {
+				t.Errorf("If-Match on a missing object: expected ErrNoSuchKey, got %v", err)
+			}
+			first, err := put("first", WriteConditions{IfNoneMatch: true})
+			if err != nil {
+				t.Fatalf("Create-only write of a new object failed: %v", err)
+			}
+			if _, err := put("second", WriteConditions{IfNoneMatch: true}); !errors.Is(err, ErrPreconditionFailed) {
+				t.Errorf("Create-only write of an existing object: expected ErrPreconditionFailed, got %v", err)
+			}
+
+			if _, err := put("second", WriteConditions{IfMatch: `"0123"`}); !errors.Is(err, ErrPreconditionFailed) {
+				t.Errorf("If-Match with another ETag: expected ErrPreconditionFailed, got %v", err)
+			}
+			second, err := put("second", WriteConditions{IfMatch: first.ETag})
+			if err != nil {
+				t.Fatalf("If-Match with the current ETag failed: %v", err)
+			}
+			if _, err := put("third", WriteConditions{IfMatch: first.ETag}); !errors.Is(err, ErrPreconditionFailed) {
+				t.Errorf("If-Match with a replaced ETag: expected ErrPreconditionFailed, got %v", err)
+			}
+
+			// A write landing while a conditional write's content is being
+			// read makes it conflict rather than overwrite
+			reader := &interruptingReader{content: strings.NewReader("third"), interrupt: func() {
+				if _, err := put("interloper", WriteConditions{}); err != nil {
+					t.Errorf("Concurrent write failed: %v", err)
+				}
+			}}
+			_, err = b.PutObjectWithMetadata("test-bucket", "lock", reader, 5, ObjectMetadata{Conditions: WriteConditions{IfMatch: second.ETag}})
+			if !errors.Is(err, ErrConditionalRequestConflict) {
+				t.Errorf("Write changed mid-upload: expected ErrConditionalRequestConflict, got %v", err)
+			}
+		})
+	}
+}
+
+func TestConditionalPutObjectConcurrent(t *testing.T) {
+	for name, b := range conditionalBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			b.CreateBucket("test-bucket")
+
+			// Only one of many create-only writers may win
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			created := 0
+			for range 20 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := b.PutObjectWithMetadata("test-bucket", "leader", strings.NewReader("me"), 2, ObjectMetadata{
+						Conditions: WriteConditions{IfNoneMatch: true},
+					})
+					switch {
+					case err == nil:
+						mu.Lock()
+						created++
+						mu.Unlock()
+					case !errors.Is(err, ErrPreconditionFailed) && !errors.Is(err, ErrConditionalRequestConflict):
+						t.Errorf("Unexpected error: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if created != 1 {
+				t.Errorf("Expected exactly one create-only write to succeed, got %d", created)
+			}
+		})
+	}
+}
+
+func TestConditionalCompleteMultipartUpload(t *testing.T) {
+	for name, b := range conditionalBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			b.CreateBucket("test-bucket")
+			existing, err := b.PutObjectWithMetadata("test-bucket", "object.txt", strings.NewReader("existing"), 8, ObjectMetadata{})
+			if err != nil {
+				t.Fatalf("Failed to put object: %v", err)
+			}
+
+			uploadID, _ := b.InitiateMultipartUploadWithMetadata("test-bucket", "object.txt", ObjectMetadata{})
+			etag, err := b.UploadPart(uploadID, 1, strings.NewReader("replacement"), 11)
+			if err != nil {
+				t.Fatalf("Failed to upload part: %v", err)
+			}
+			parts := []CompletePart{{PartNumber: 1, ETag: etag}}
+
+			if _, err := b.CompleteMultipartUploadWithConditions(uploadID, parts, WriteConditions{IfNoneMatch: true}); !errors.Is(err, ErrPreconditionFailed) {
+				t.Errorf("Create-only completion over an existing object: expected ErrPreconditionFailed, got %v", err)
+			}
+
+			// The upload survives a failed condition and can complete later
+			info, err := b.CompleteMultipartUploadWithConditions(uploadID, parts, WriteConditions{IfMatch: existing.ETag})
+			if err != nil {
+				t.Fatalf("Completion with the current ETag failed: %v", err)
+			}
+			if info.Size != 11 {
+				t.Errorf("Expected the 11 byte replacement, got %d bytes", info.Size)
+			}
+		})
+	}
+}
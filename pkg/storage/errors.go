@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the storage backends. Errors may wrap them with more
+// detail, so test for them with errors.Is
+var (
+	ErrNoSuchBucket   = errors.New("bucket not found")
+	ErrBucketExists   = errors.New("bucket already exists")
+	ErrBucketNotEmpty = errors.New("bucket not empty")
+	ErrNoSuchKey      = errors.New("object not found")
+	ErrNoSuchVersion  = errors.New("version not found")
+	ErrNoSuchUpload   = errors.New("upload not found")
+	ErrInvalidRange   = errors.New("invalid range")
+
+	// Names rejected by ValidBucketName and ValidKey
+	ErrInvalidBucketName = errors.New("invalid bucket name")
+	ErrInvalidKey        = errors.New("invalid object key")
+	ErrKeyTooLong        = errors.New("object key too long")
+
+	// ErrInvalidPart is matched by every PartError
+	ErrInvalidPart = errors.New("invalid part")
+
+	// ErrInvalidVersioningStatus is returned for versioning states other
+	// than Enabled and Suspended
+	ErrInvalidVersioningStatus = errors.New("invalid versioning status")
+
+	// ErrBadDigest is matched by every DigestError
+	ErrBadDigest = errors.New("bad digest")
+
+	// ErrMissingChecksum is returned when a checksum the client announced,
+	// such as a trailing header, was never sent
+	ErrMissingChecksum = errors.New("missing checksum")
+
+	// ErrChecksumAlgorithmMismatch is returned for a part sent with a
+	// checksum of another algorithm than its upload's
+	ErrChecksumAlgorithmMismatch = errors.New("checksum algorithm mismatch")
+
+	// ErrPreconditionFailed is returned for a conditional write whose
+	// conditions the current object does not meet
+	ErrPreconditionFailed = errors.New("precondition failed")
+
+	// ErrConditionalRequestConflict is returned for a conditional write whose
+	// conditions held when it began but not once it was ready to commit,
+	// because a concurrent write changed the object
+	ErrConditionalRequestConflict = errors.New("conditional request conflict")
+)
+
+// PartError reports a part named in a multipart upload completion that
+// cannot be used
+type PartError struct {
+	PartNumber int
+	// Reason is "not found", "etag mismatch" or "checksum mismatch"
+	Reason string
+}
+
+func (e *PartError) Error() string {
+	return fmt.Sprintf("part %d %s", e.PartNumber, e.Reason)
+}
+
+// Is makes PartErrors match ErrInvalidPart
+func (e *PartError) Is(target error) bool {
+	return target == ErrInvalidPart
+}
+
+// DigestError reports uploaded content that does not match a digest sent
+// with it
+type DigestError struct {
+	// Digest is "Content-MD5" or a checksum algorithm
+	Digest string
+}
+
+func (e *DigestError) Error() string {
+	if e.Digest == "Content-MD5" {
+		return "the Content-MD5 you specified did not match what we received"
+	}
+	return fmt.Sprintf("the %s you specified did not match the calculated checksum", e.Digest)
+}
+
+// Is makes DigestErrors match ErrBadDigest
+func (e *DigestError) Is(target error) bool {
+	return target == ErrBadDigest
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// errorBackend is the part of the storage API checked by TestErrors
+type errorBackend interface {
+	CreateBucket(bucket string) error
+	DeleteBucket(bucket string) error
+	HeadBucket(bucket string) error
+	PutObjectWithMetadata(bucket, key string, reader io.Reader, size int64, metadata ObjectMetadata) (*ObjectInfo, error)
+	GetObjectVersion(bucket, key, versionID string) (io.ReadCloser, *ObjectInfo, error)
+	HeadObjectVersion(bucket, key, versionID string) (*ObjectInfo, error)
+	PutBucketVersioning(bucket, status string) error
+	InitiateMultipartUploadWithMetadata(bucket, key string, metadata ObjectMetadata) (string, error)
+	UploadPart(uploadID string, partNumber int, reader io.Reader, size int64) (string, error)
+	CompleteMultipartUpload(uploadID string, parts []CompletePart) (*ObjectInfo, error)
+}
+
+func TestErrors(t *testing.T) {
+	disk, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	for name, b := range map[string]errorBackend{"storage": disk, "memory": NewMemory()} {
+		t.Run(name, func(t *testing.T) {
+			check := func(what string, err, want error) {
+				t.Helper()
+				if !errors.Is(err, want) {
+					t.Errorf("%s: expected %v, got %v", what, want, err)
+				}
+			}
+
+			check("head missing bucket", b.HeadBucket("missing"), ErrNoSuchBucket)
+			if err := b.CreateBucket("bucket"); err != nil {
+				t.Fatalf("Failed to create bucket: %v", err)
+			}
+			check("create existing bucket", b.CreateBucket("bucket"), ErrBucketExists)
+
+			_, _, err := b.GetObjectVersion("bucket", "key", "")
+			check("get missing key", err, ErrNoSuchKey)
+
+			if _, err := b.PutObjectWithMetadata("bucket", "key", strings.NewReader("data"), 4, ObjectMetadata{}); err != nil {
+				t.Fatalf("Failed to put object: %v", err)
+			}
+			check("delete non-empty bucket", b.DeleteBucket("bucket"), ErrBucketNotEmpty)
+			_, err = b.HeadObjectVersion("bucket", "key", "missing")
+			check("head missing version", err, ErrNoSuchVersion)
+			check("invalid versioning status", b.PutBucketVersioning("bucket", "On"), ErrInvalidVersioningStatus)
+
+			_, err = b.UploadPart("missing", 1, strings.NewReader("x"), 1)
+			check("upload to missing upload", err, ErrNoSuchUpload)
+			uploadID, err := b.InitiateMultipartUploadWithMetadata("bucket", "upload", ObjectMetadata{})
+			if err != nil {
+				t.Fatalf("Failed to initiate upload: %v", err)
+			}
+			_, err = b.CompleteMultipartUpload(uploadID, []CompletePart{{PartNumber: 3, ETag: "etag"}})
+			check("complete with missing part", err, ErrInvalidPart)
+			var partErr *PartError
+			if !errors.As(err, &partErr) || partErr.PartNumber != 3 {
+				t.Errorf("Expected a PartError for part 3, got %v", err)
+			}
+		})
+	}
+}
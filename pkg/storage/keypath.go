@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Keys are stored as paths below their bucket's directory, one directory per
+// "/"-separated segment, so ordinary keys are plain readable files. Segments
+// the filesystem cannot hold as they are are escaped with '%':
+//
+//   - an empty segment, as in "a//b" or the folder marker "a/", is "%"
+//   - NUL is "%00"
+//   - the leading dot of ".", ".." and of segments starting with the
+//     temporary file prefix is "%2E", as is that of any such chunk of a
+//     split segment
+//   - '%' is "%25" where it could otherwise be read as an escape: before
+//     "25", "00", "2E" or "+", or at the end of a segment
+//   - segments longer than maxSegmentLength once escaped are split across
+//     directories, each but the last chunk ending in "%+"
+//
+// Any other '%' is stored as it is. An object whose path is also a directory,
+// as "a" is once "a/b" exists, is stored inside that directory as
+// dirFileName. The same layout is used for metadata sidecars and versions
+const (
+	escapeChar       = '%'
+	emptySegment     = "%"
+	continuation     = "%+"
+	dirFileName      = "%+object"
+	maxSegmentLength = 240
+)
+
+// keyPath returns the path of key relative to its bucket's directory
+func keyPath(key string) string {
+	var path strings.Builder
+	for i, segment := range strings.Split(key, "/") {
+		if i > 0 {
+			path.WriteByte(filepath.Separator)
+		}
+		path.WriteString(encodeSegment(segment))
+	}
+	return path.String()
+}
+
+// encodeSegment escapes a key segment, splitting it into several directory
+// names if it is too long for one
+func encodeSegment(segment string) string {
+	if segment == "" {
+		return emptySegment
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		switch {
+		case c == 0:
+			b.WriteString("%00")
+		case c == '.' && i == 0 && reservedName(segment):
+			b.WriteString("%2E")
+		case c == escapeChar && escapeNeeded(segment[i+1:]):
+			b.WriteString("%25")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	encoded := b.String()
+	if len(encoded) <= maxSegmentLength {
+		return encoded
+	}
+
+	var chunks []string
+	for len(encoded) > maxSegmentLength {
+		n := maxSegmentLength
+		// Keep escapes and UTF-8 sequences whole
+		if j := strings.LastIndexByte(encoded[n-2:n], escapeChar); j >= 0 {
+			n = n - 2 + j
+		}
+		for n > 0 && encoded[n]&0xC0 == 0x80 {
+			n--
+		}
+		chunks = append(chunks, encoded[:n]+continuation)
+		encoded = encoded[n:]
+		if reservedName(encoded) {
+			encoded = "%2E" + encoded[1:]
+		}
+	}
+	chunks = append(chunks, encoded)
+	return strings.Join(chunks, string(filepath.Separator))
+}
+
+// reservedName reports whether name is one the filesystem or the storage
+// layer gives a meaning of its own, so must have its leading dot escaped
+func reservedName(name string) bool {
+	return name == "." || name == ".." || strings.HasPrefix(name, tempFilePrefix)
+}
+
+// escapeNeeded reports whether a '%' followed by rest must be escaped
+func escapeNeeded(rest string) bool {
+	return rest == "" || rest[0] == '+' ||
+		strings.HasPrefix(rest, "25") || strings.HasPrefix(rest, "00") || strings.HasPrefix(rest, "2E")
+}
+
+// decodeKeyPath returns the key stored at a slash-separated path relative to
+// its bucket's directory. For a directory's path, partial reports that it
+// holds chunks of a split segment, so the key is not followed by a "/"
+func decodeKeyPath(path string) (key string, partial bool) {
+	var segments []string
+	var chunk strings.Builder
+	for _, name := range strings.Split(path, "/") {
+		if prefix, ok := strings.CutSuffix(name, continuation); ok {
+			chunk.WriteString(prefix)
+			partial = true
+			continue
+		}
+		chunk.WriteString(name)
+		segments = append(segments, decodeSegment(chunk.String()))
+		chunk.Reset()
+		partial = false
+	}
+	if partial {
+		segments = append(segments, decodeSegment(chunk.String()))
+	}
+	return strings.Join(segments, "/"), partial
+}
+
+// decodeSegment reverses encodeSegment for a whole segment
+func decodeSegment(encoded string) string {
+	if encoded == emptySegment {
+		return ""
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(encoded); i++ {
+		c := encoded[i]
+		if c == escapeChar && i+2 < len(encoded) {
+			switch encoded[i+1 : i+3] {
+			case "25":
+				c = escapeChar
+				i += 2
+			case "00":
+				c = 0
+				i += 2
+			case "2E":
+				c = '.'
+				i += 2
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// filePath returns where the file for path is stored: path itself, or
+// dirFileName inside it once path has become a directory
+func filePath(path string) string {
+	if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+		return filepath.Join(path, dirFileName)
+	}
+	return path
+}
+
+// storedPath returns the path a walked file stands for, undoing filePath
+func storedPath(path string) string {
+	if filepath.Base(path) == dirFileName {
+		return filepath.Dir(path)
+	}
+	return path
+}
+
+// makeDirs creates a directory and any missing parents. A file in the way,
+// stored for a key that is a prefix of another, is moved into the directory
+// replacing it
+func makeDirs(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err == nil || !errors.Is(err, syscall.ENOTDIR) {
+		return err
+	}
+
+	for path := dir; path != filepath.Dir(path); path = filepath.Dir(path) {
+		stat, statErr := os.Stat(path)
+		if statErr != nil || stat.IsDir() {
+			continue
+		}
+		if err := fileToDir(path); err != nil {
+			return err
+		}
+		return makeDirs(dir)
+	}
+	return err
+}
+
+// fileToDir replaces a file with a directory holding it as dirFileName
+func fileToDir(path string) error {
+	tmpDir, err := os.MkdirTemp(filepath.Dir(path), tempFilePrefix+"dir-*")
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(path, filepath.Join(tmpDir, dirFileName)); err != nil {
+		os.Remove(tmpDir)
+		return err
+	}
+	return os.Rename(tmpDir, path)
+}
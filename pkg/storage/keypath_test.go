@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeyPath(t *testing.T) {
+	tests := []struct {
+		key  string
+		path string
+	}{
+		{"photos/2024/cat.jpg", "photos/2024/cat.jpg"},
+		{".hidden/file", ".hidden/file"},
+		{"100%.txt", "100%.txt"},
+		{"a%20b", "a%20b"},
+		{"folder/", "folder/%"},
+		{"a//b", "a/%/b"},
+		{"../x", "%2E./x"},
+		{"./x", "%2E/x"},
+		{".s3dir-tmp-1", "%2Es3dir-tmp-1"},
+		{"nul\x00", "nul%00"},
+		{"%", "%25"},
+		{"%25", "%2525"},
+		{"%+", "%25+"},
+		{"%+object", "%25+object"},
+	}
+
+	for _, tt := range tests {
+		if got := filepath.ToSlash(keyPath(tt.key)); got != tt.path {
+			t.Errorf("keyPath(%q) = %q, want %q", tt.key, got, tt.path)
+		}
+		if got, partial := decodeKeyPath(tt.path); got != tt.key || partial {
+			t.Errorf("decodeKeyPath(%q) = %q, %v, want %q", tt.path, got, partial, tt.key)
+		}
+	}
+}
+
+func TestKeyPathLongSegments(t *testing.T) {
+	keys := []string{
+		strings.Repeat("a", 1000),
+		"dir/" + strings.Repeat("é", 300) + "/file",
+		strings.Repeat("%25", 200),
+		strings.Repeat("x", maxSegmentLength-1) + "%" + strings.Repeat("y", 10),
+	}
+
+	for _, key := range keys {
+		path := filepath.ToSlash(keyPath(key))
+		for _, name := range strings.Split(path, "/") {
+			if len(name) > maxSegmentLength+len(continuation) {
+				t.Errorf("Key %.20q: name of %d bytes", key, len(name))
+			}
+		}
+		if got, _ := decodeKeyPath(path); got != key {
+			t.Errorf("Key %.20q did not round-trip: got %.20q", key, got)
+		}
+	}
+
+	// Chunks after the first are escaped as whole segments are, so a long
+	// segment cannot end in a chunk resolving to another key's path
+	long := strings.Repeat("a", maxSegmentLength)
+	for _, suffix := range []string{"..", ".", ".s3dir-x"} {
+		key := long + suffix + "/foo"
+		path := filepath.ToSlash(keyPath(key))
+		if got, _ := decodeKeyPath(path); got != key {
+			t.Errorf("Key %.20q did not round-trip: got %.20q", key, got)
+		}
+		for _, name := range strings.Split(path, "/") {
+			if reservedName(name) {
+				t.Errorf("Key with chunk %q: path %q has reserved name %q", suffix, path, name)
+			}
+		}
+		for _, shorter := range []string{"foo", long + "/foo"} {
+			if filepath.Join("bucket", keyPath(key)) == filepath.Join("bucket", keyPath(shorter)) {
+				t.Errorf("Key with chunk %q lands on the path of %.20q", suffix, shorter)
+			}
+		}
+	}
+
+	key, partial := decodeKeyPath(strings.Repeat("a", 10) + continuation)
+	if key != "aaaaaaaaaa" || !partial {
+		t.Errorf("Expected a partial key for a chunk directory, got %q %v", key, partial)
+	}
+}
+
+func TestUnusualKeys(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	storage.CreateBucket("bucket")
+
+	keys := []string{
+		"a",
+		"a/b",
+		"a/b/c",
+		"folder/",
+		"folder//x",
+		"../up",
+		"nul\x00byte",
+		"x.json/y",
+		"x",
+		strings.Repeat("long", 100) + "/z",
+	}
+	for _, key := range keys {
+		if err := storage.PutObject("bucket", key, strings.NewReader(key), int64(len(key))); err != nil {
+			t.Fatalf("Failed to put %q: %v", key, err)
+		}
+	}
+
+	// Objects written before a key below them keep their content
+	if _, err := os.Stat(filepath.Join(storage.baseDir, "bucket", "a", dirFileName)); err != nil {
+		t.Errorf("Expected a to be moved into its directory: %v", err)
+	}
+	for _, key := range keys {
+		reader, info, err := storage.GetObject("bucket", key)
+		if err != nil {
+			t.Errorf("Failed to get %q: %v", key, err)
+			continue
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(data) != key || info.Key != key {
+			t.Errorf("Key %q: got content %q and key %q", key, data, info.Key)
+		}
+	}
+
+	objects, _, err := storage.ListObjects("bucket", "", "", 0)
+	if err != nil {
+		t.Fatalf("Failed to list objects: %v", err)
+	}
+	var listed []string
+	for _, obj := range objects {
+		listed = append(listed, obj.Key)
+	}
+	want := []string{"../up", "a", "a/b", "a/b/c", "folder/", "folder//x", strings.Repeat("long", 100) + "/z", "nul\x00byte", "x", "x.json/y"}
+	if !reflect.DeepEqual(listed, want) {
+		t.Errorf("Expected keys %q, got %q", want, listed)
+	}
+
+	_, prefixes, err := storage.ListObjects("bucket", "folder/", "/", 0)
+	if err != nil || !reflect.DeepEqual(prefixes, []string{"folder//"}) {
+		t.Errorf("Expected common prefix folder//, got %q (%v)", prefixes, err)
+	}
+
+	// Deleting the object below leaves the one above readable
+	if err := storage.DeleteObject("bucket", "a/b/c"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, err := storage.HeadObject("bucket", "a/b"); err != nil {
+		t.Errorf("Expected a/b to remain: %v", err)
+	}
+}
+
+func TestUnusualKeyVersions(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	storage.CreateBucket("bucket")
+
+	// Version files are named after version IDs, so keys below a key may
+	// clash with its versions
+	storage.PutObject("bucket", "a", strings.NewReader("1"), 1)
+	storage.PutBucketVersioning("bucket", VersioningSuspended)
+	storage.PutObject("bucket", "a/null", strings.NewReader("2"), 1)
+	storage.PutBucketVersioning("bucket", VersioningEnabled)
+	storage.PutObject("bucket", "a", strings.NewReader("3"), 1)
+	storage.PutObject("bucket", "a/null", strings.NewReader("4"), 1)
+	storage.PutObject("bucket", "a/null.json", strings.NewReader("5"), 1)
+	storage.PutObject("bucket", "a/null.json", strings.NewReader("6"), 1)
+
+	listing, err := storage.ListObjectVersions("bucket", "", "", "", "", 0)
+	if err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	counts := make(map[string]int)
+	for _, v := range listing.Versions {
+		counts[v.Key]++
+	}
+	if !reflect.DeepEqual(counts, map[string]int{"a": 2, "a/null": 2, "a/null.json": 2}) {
+		t.Errorf("Unexpected versions per key: %v", counts)
+	}
+
+	reader, _, err := storage.GetObjectVersion("bucket", "a", NullVersionID)
+	if err != nil {
+		t.Fatalf("Failed to get null version: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "1" {
+		t.Errorf("Expected null version content 1, got %q", data)
+	}
+}
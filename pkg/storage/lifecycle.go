@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/stut/s3dir/pkg/lifecycle"
+)
+
+// lifecycleConfig is the bucket configuration document holding the lifecycle
+// configuration XML
+const lifecycleConfig = "lifecycle.xml"
+
+// GetBucketLifecycle returns a bucket's lifecycle configuration XML as
+// stored, or nil if none has been set
+func (s *Storage) GetBucketLifecycle(bucket string) ([]byte, error) {
+	return s.GetBucketConfig(bucket, lifecycleConfig)
+}
+
+// PutBucketLifecycle validates and stores a bucket's lifecycle configuration
+// XML
+func (s *Storage) PutBucketLifecycle(bucket string, data []byte) error {
+	if _, err := lifecycle.Parse(data); err != nil {
+		return err
+	}
+	return s.PutBucketConfig(bucket, lifecycleConfig, data)
+}
+
+// DeleteBucketLifecycle removes a bucket's lifecycle configuration, if any
+func (s *Storage) DeleteBucketLifecycle(bucket string) error {
+	return s.DeleteBucketConfig(bucket, lifecycleConfig)
+}
+
+// bucketLifecycle returns a bucket's parsed lifecycle configuration, or nil if
+// it has none or it cannot be read
+func (s *Storage) bucketLifecycle(bucket string) *lifecycle.Configuration {
+	data, err := s.GetBucketLifecycle(bucket)
+	if err != nil || data == nil {
+		return nil
+	}
+	config, err := lifecycle.Parse(data)
+	if err != nil {
+		return nil
+	}
+	return config
+}
+
+// abortUploadAfter returns how long after initiation an upload of key should
+// be aborted under the bucket's lifecycle rules
+func (s *Storage) abortUploadAfter(bucket, key string) (time.Duration, bool) {
+	config := s.bucketLifecycle(bucket)
+	if config == nil {
+		return 0, false
+	}
+	return config.AbortUploadAfter(key)
+}
+
+// StartLifecycleWorker applies bucket lifecycle rules once straight away and
+// then every interval until Stop is called. It does nothing if the worker is
+// already running
+func (s *Storage) StartLifecycleWorker(interval time.Duration) {
+	s.lifecycleMu.Lock()
+	defer s.lifecycleMu.Unlock()
+	if s.lifecycleStop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	s.lifecycleStop = stop
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		// Servers restarted more often than interval must still expire
+		// objects
+		if err := s.ApplyLifecycle(time.Now()); err != nil {
+			log.Printf("Lifecycle: %v", err)
+		}
+		for {
+			select {
+			case now := <-ticker.C:
+				if err := s.ApplyLifecycle(now); err != nil {
+					log.Printf("Lifecycle: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the lifecycle worker, if running, and the background cleanup
+// of stale multipart uploads
+func (s *Storage) Stop() {
+	s.lifecycleMu.Lock()
+	if s.lifecycleStop != nil {
+		close(s.lifecycleStop)
+		s.lifecycleStop = nil
+	}
+	s.lifecycleMu.Unlock()
+	s.multipart.Stop()
+}
+
+// Shutdown stops the background workers and then flushes the metadata of
+// in-progress multipart uploads to disk. Call it once no more requests are
+// being served
+func (s *Storage) Shutdown() error {
+	s.Stop()
+	if err := s.multipart.Flush(); err != nil {
+		return fmt.Errorf("failed to flush multipart uploads: %w", err)
+	}
+	return nil
+}
+
+// ApplyLifecycle runs every bucket's lifecycle rules as of now: expiring
+// current objects, removing expired noncurrent versions and delete markers,
+// and aborting incomplete multipart uploads
+func (s *Storage) ApplyLifecycle(now time.Time) error {
+	buckets, err := s.ListBuckets()
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, bucket := range buckets {
+		config := s.bucketLifecycle(bucket)
+		if config == nil {
+			continue
+		}
+		if err := s.applyBucketLifecycle(bucket, config, now); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("bucket %s: %w", bucket, err)
+		}
+	}
+
+	s.multipart.expireUploads(now)
+
+	return firstErr
+}
+
+// applyBucketLifecycle applies a lifecycle configuration to every object
+// version in a bucket
+func (s *Storage) applyBucketLifecycle(bucket string, config *lifecycle.Configuration, now time.Time) error {
+	listing, err := s.ListObjectVersions(bucket, "", "", "", "", 0)
+	if err != nil {
+		return err
+	}
+
+	// Versions are grouped by key, newest first
+	versions := listing.Versions
+	for start := 0; start < len(versions); {
+		end := start + 1
+		for end < len(versions) && versions[end].Key == versions[start].Key {
+			end++
+		}
+		if err := s.applyKeyLifecycle(bucket, config, versions[start:end], now); err != nil {
+			return err
+		}
+		start = end
+	}
+
+	return nil
+}
+
+// applyKeyLifecycle applies a lifecycle configuration to the versions of a
+// single key, given newest first
+func (s *Storage) applyKeyLifecycle(bucket string, config *lifecycle.Configuration, versions []ObjectInfo, now time.Time) error {
+	latest := versions[0]
+	key := latest.Key
+
+	if !latest.DeleteMarker && config.ObjectExpired(lifecycleObject(latest), latest.LastModified, now) {
+		// In a versioned bucket this leaves a delete marker, which later
+		// passes treat like any other
+		markerID, marker, err := s.DeleteObjectVersion(bucket, key, "")
+		if err != nil || !marker {
+			return err
+		}
+
+		// The versions below the marker are still checked in this pass. The
+		// expired version is noncurrent from now, but a suspended bucket's
+		// null version is replaced by the marker, and the version below it
+		// has been noncurrent since the null version was written
+		versions = s.keyVersions(bucket, key)
+		if len(versions) == 0 || versions[0].VersionID != markerID {
+			return nil
+		}
+		if len(versions) > 1 && versions[1].VersionID == latest.VersionID {
+			versions[0].LastModified = now
+		} else {
+			versions[0].LastModified = latest.LastModified
+		}
+		latest = versions[0]
+	}
+
+	// A version becomes noncurrent when the next newer version is created
+	remaining := len(versions)
+	for i := 1; i < len(versions); i++ {
+		v := versions[i]
+		if !config.NoncurrentVersionExpired(lifecycleObject(v), versions[i-1].LastModified, i-1, now) {
+			continue
+		}
+		if _, _, err := s.deleteVersion(bucket, key, v.VersionID); err != nil {
+			return err
+		}
+		remaining--
+	}
+
+	if latest.DeleteMarker && remaining == 1 && config.RemoveExpiredDeleteMarker(key) {
+		if _, _, err := s.deleteVersion(bucket, key, latest.VersionID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func lifecycleObject(info ObjectInfo) lifecycle.Object {
+	return lifecycle.Object{Key: info.Key, Size: info.Size, Tags: info.Tags}
+}
@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func putLifecycle(t *testing.T, s *Storage, bucket, doc string) {
+	t.Helper()
+	if err := s.PutBucketLifecycle(bucket, []byte(doc)); err != nil {
+		t.Fatalf("Failed to put lifecycle configuration: %v", err)
+	}
+}
+
+func TestBucketLifecycleConfig(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	storage.CreateBucket("test-bucket")
+
+	if data, err := storage.GetBucketLifecycle("test-bucket"); err != nil || data != nil {
+		t.Fatalf("Expected no lifecycle configuration, got %q, %v", data, err)
+	}
+	if err := storage.PutBucketLifecycle("test-bucket", []byte("<LifecycleConfiguration/>")); err == nil {
+		t.Error("Expected error for configuration without rules")
+	}
+
+	doc := `<LifecycleConfiguration><Rule><Status>Enabled</Status><Expiration><Days>1</Days></Expiration></Rule></LifecycleConfiguration>`
+	putLifecycle(t, storage, "test-bucket", doc)
+	if data, _ := storage.GetBucketLifecycle("test-bucket"); string(data) != doc {
+		t.Errorf("Expected stored configuration back, got %q", data)
+	}
+
+	if err := storage.DeleteBucketLifecycle("test-bucket"); err != nil {
+		t.Fatalf("Failed to delete lifecycle configuration: %v", err)
+	}
+	if data, _ := storage.GetBucketLifecycle("test-bucket"); data != nil {
+		t.Error("Expected lifecycle configuration to be removed")
+	}
+}
+
+func TestLifecycleExpiration(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	storage.CreateBucket("test-bucket")
+	putLifecycle(t, storage, "test-bucket", `<LifecycleConfiguration>
+		<Rule><Status>Enabled</Status><Filter><Prefix>tmp/</Prefix></Filter><Expiration><Days>1</Days></Expiration></Rule>
+		<Rule><Status>Enabled</Status><Filter><Tag><Key>class</Key><Value>scratch</Value></Tag></Filter><Expiration><Days>3</Days></Expiration></Rule>
+	</LifecycleConfiguration>`)
+
+	putString(t, storage, "test-bucket", "tmp/a.txt", "a")
+	putString(t, storage, "test-bucket", "keep/b.txt", "b")
+	if _, err := storage.PutObjectWithMetadata("test-bucket", "keep/c.txt", bytes.NewReader([]byte("c")), 1, ObjectMetadata{Tags: map[string]string{"class": "scratch"}}); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	exists := func(key string) bool {
+		_, err := storage.HeadObject("test-bucket", key)
+		return err == nil
+	}
+
+	if err := storage.ApplyLifecycle(time.Now()); err != nil {
+		t.Fatalf("ApplyLifecycle failed: %v", err)
+	}
+	if !exists("tmp/a.txt") || !exists("keep/b.txt") || !exists("keep/c.txt") {
+		t.Fatal("Expected no objects to expire immediately")
+	}
+
+	if err := storage.ApplyLifecycle(time.Now().Add(48 * time.Hour)); err != nil {
+		t.Fatalf("ApplyLifecycle failed: %v", err)
+	}
+	if exists("tmp/a.txt") {
+		t.Error("Expected tmp/a.txt to expire after 1 day")
+	}
+	if !exists("keep/b.txt") || !exists("keep/c.txt") {
+		t.Error("Expected keep/ objects to survive 2 days")
+	}
+
+	if err := storage.ApplyLifecycle(time.Now().Add(96 * time.Hour)); err != nil {
+		t.Fatalf("ApplyLifecycle failed: %v", err)
+	}
+	if exists("keep/c.txt") {
+		t.Error("Expected tagged keep/c.txt to expire after 3 days")
+	}
+	if !exists("keep/b.txt") {
+		t.Error("Expected untagged keep/b.txt to be kept")
+	}
+}
+
+func TestLifecycleVersionedExpiration(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	storage.CreateBucket("test-bucket")
+	storage.PutBucketVersioning("test-bucket", VersioningEnabled)
+	putLifecycle(t, storage, "test-bucket", `<LifecycleConfiguration>
+		<Rule><ID>current</ID><Status>Enabled</Status><Filter/><Expiration><Days>1</Days></Expiration>
+			<NoncurrentVersionExpiration><NoncurrentDays>1</NoncurrentDays></NoncurrentVersionExpiration></Rule>
+		<Rule><ID>markers</ID><Status>Enabled</Status><Filter/><Expiration><ExpiredObjectDeleteMarker>true</ExpiredObjectDeleteMarker></Expiration></Rule>
+	</LifecycleConfiguration>`)
+
+	putString(t, storage, "test-bucket", "doc.txt", "v1")
+	putString(t, storage, "test-bucket", "doc.txt", "v2")
+
+	versions := func() []ObjectInfo {
+		listing, err := storage.ListObjectVersions("test-bucket", "", "", "", "", 0)
+		if err != nil {
+			t.Fatalf("Failed to list versions: %v", err)
+		}
+		return listing.Versions
+	}
+
+	// The current version expires into a delete marker in the same pass as
+	// the noncurrent version, while the version it leaves is noncurrent only
+	// from then
+	later := time.Now().Add(48 * time.Hour)
+	if err := storage.ApplyLifecycle(later); err != nil {
+		t.Fatalf("ApplyLifecycle failed: %v", err)
+	}
+	v := versions()
+	if len(v) != 2 || !v[0].DeleteMarker {
+		t.Fatalf("Expected delete marker over 1 version, got %+v", v)
+	}
+	reader, _, err := storage.GetObjectVersion("test-bucket", "doc.txt", v[1].VersionID)
+	if err != nil {
+		t.Fatalf("Failed to get noncurrent version: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "v2" {
+		t.Errorf("Expected v2 to be kept as the noncurrent version, got %q", data)
+	}
+
+	// The last noncurrent version expires, leaving a lone delete marker
+	// which is then removed
+	if err := storage.ApplyLifecycle(later); err != nil {
+		t.Fatalf("ApplyLifecycle failed: %v", err)
+	}
+	if v := versions(); len(v) != 0 {
+		t.Errorf("Expected every version to be removed, got %+v", v)
+	}
+}
+
+func TestLifecycleAbortIncompleteUploads(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	storage.CreateBucket("test-bucket")
+	putLifecycle(t, storage, "test-bucket", `<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter><Prefix>big/</Prefix></Filter>
+		<AbortIncompleteMultipartUpload><DaysAfterInitiation>3</DaysAfterInitiation></AbortIncompleteMultipartUpload>
+	</Rule></LifecycleConfiguration>`)
+
+	covered, err := storage.InitiateMultipartUpload("test-bucket", "big/file")
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %v", err)
+	}
+	uncovered, err := storage.InitiateMultipartUpload("test-bucket", "small/file")
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %v", err)
+	}
+
+	hasUpload := func(uploadID string) bool {
+		_, err := storage.ListMultipartUploadParts(uploadID)
+		return err == nil
+	}
+
+	// Uploads no rule covers are removed after a day of inactivity, while
+	// the rule keeps covered ones for 3 days from initiation
+	if err := storage.ApplyLifecycle(time.Now().Add(48 * time.Hour)); err != nil {
+		t.Fatalf("ApplyLifecycle failed: %v", err)
+	}
+	if hasUpload(uncovered) {
+		t.Error("Expected uncovered upload to be removed after 24h")
+	}
+	if !hasUpload(covered) {
+		t.Error("Expected covered upload to be kept for 3 days")
+	}
+
+	if err := storage.ApplyLifecycle(time.Now().Add(73 * time.Hour)); err != nil {
+		t.Fatalf("ApplyLifecycle failed: %v", err)
+	}
+	if hasUpload(covered) {
+		t.Error("Expected covered upload to be aborted after 3 days")
+	}
+}
+
+func TestLifecycleWorker(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	storage.CreateBucket("test-bucket")
+	putLifecycle(t, storage, "test-bucket", `<LifecycleConfiguration>
+		<Rule><Status>Enabled</Status><Expiration><Date>2020-01-01T00:00:00Z</Date></Expiration></Rule>
+	</LifecycleConfiguration>`)
+	putString(t, storage, "test-bucket", "expired.txt", "a")
+
+	// The first pass runs when the worker starts, not an interval later
+	storage.StartLifecycleWorker(time.Hour)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := storage.HeadObject("test-bucket", "expired.txt"); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the object to expire as soon as the worker started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Stopping is safe to repeat, and concurrently with starting
+	done := make(chan struct{})
+	go func() {
+		storage.StartLifecycleWorker(time.Hour)
+		close(done)
+	}()
+	storage.Stop()
+	storage.Stop()
+	<-done
+	storage.Stop()
+}
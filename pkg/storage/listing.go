@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+)
+
+// listEntry is a single result of a listing: either an object key or a
+// rolled-up common prefix
+type listEntry struct {
+	name     string
+	isPrefix bool
+}
+
+// pageKeys pages a sorted list of keys, all beginning with prefix, the way
+// ListObjects does: keys containing the delimiter after the prefix are rolled
+// up into common prefixes, entries strictly after marker are returned, and at
+// most maxKeys keys and common prefixes combined (maxKeys <= 0 means
+// unlimited). It reports whether the page was truncated and the marker to
+// resume from
+func pageKeys(keys []string, prefix, delimiter, marker string, maxKeys int) ([]string, []string, bool, string) {
+	// Roll up keys containing the delimiter into common prefixes. Keys sharing
+	// a common prefix are contiguous in sorted order, so deduplicating against
+	// the previous entry is sufficient
+	var entries []listEntry
+	for _, key := range keys {
+		if delimiter != "" {
+			remainder := strings.TrimPrefix(key, prefix)
+			if idx := strings.Index(remainder, delimiter); idx != -1 {
+				commonPrefix := prefix + remainder[:idx+len(delimiter)]
+				if len(entries) > 0 && entries[len(entries)-1].isPrefix && entries[len(entries)-1].name == commonPrefix {
+					continue
+				}
+				entries = append(entries, listEntry{name: commonPrefix, isPrefix: true})
+				continue
+			}
+		}
+		entries = append(entries, listEntry{name: key})
+	}
+
+	// Resume strictly after the marker
+	if marker != "" {
+		start := sort.Search(len(entries), func(i int) bool {
+			return entries[i].name > marker
+		})
+		entries = entries[start:]
+	}
+
+	truncated := maxKeys > 0 && len(entries) > maxKeys
+	if truncated {
+		entries = entries[:maxKeys]
+	}
+
+	nextMarker := ""
+	if truncated {
+		nextMarker = entries[len(entries)-1].name
+	}
+
+	var objectKeys, commonPrefixes []string
+	for _, e := range entries {
+		if e.isPrefix {
+			commonPrefixes = append(commonPrefixes, e.name)
+		} else {
+			objectKeys = append(objectKeys, e.name)
+		}
+	}
+
+	return objectKeys, commonPrefixes, truncated, nextMarker
+}
+
+// pageVersions pages the versions of a sorted list of keys, all beginning
+// with prefix, the way ListObjectVersions does. versionsOf returns the
+// versions of a key, newest first
+func pageVersions(keys []string, prefix, delimiter, keyMarker, versionIDMarker string, maxKeys int, versionsOf func(key string) []ObjectInfo) *VersionListing {
+	listing := &VersionListing{}
+	count := 0
+	lastPrefix := ""
+	for _, key := range keys {
+		if keyMarker != "" && (key < keyMarker || (key == keyMarker && versionIDMarker == "")) {
+			continue
+		}
+
+		if delimiter != "" {
+			remainder := strings.TrimPrefix(key, prefix)
+			if idx := strings.Index(remainder, delimiter); idx != -1 {
+				commonPrefix := prefix + remainder[:idx+len(delimiter)]
+				if commonPrefix == lastPrefix || (keyMarker != "" && commonPrefix <= keyMarker) {
+					continue
+				}
+				if maxKeys > 0 && count == maxKeys {
+					listing.IsTruncated = true
+					break
+				}
+				lastPrefix = commonPrefix
+				listing.CommonPrefixes = append(listing.CommonPrefixes, commonPrefix)
+				listing.NextKeyMarker, listing.NextVersionIDMarker = commonPrefix, ""
+				count++
+				continue
+			}
+		}
+
+		versions := versionsOf(key)
+		if key == keyMarker {
+			// Resume after the marker version
+			for i, v := range versions {
+				if v.VersionID == versionIDMarker {
+					versions = versions[i+1:]
+					break
+				}
+			}
+		}
+
+		for _, v := range versions {
+			if maxKeys > 0 && count == maxKeys {
+				listing.IsTruncated = true
+				break
+			}
+			listing.Versions = append(listing.Versions, v)
+			listing.NextKeyMarker, listing.NextVersionIDMarker = key, v.VersionID
+			count++
+		}
+		if listing.IsTruncated {
+			break
+		}
+	}
+
+	if !listing.IsTruncated {
+		listing.NextKeyMarker, listing.NextVersionIDMarker = "", ""
+	}
+
+	return listing
+}
@@ -0,0 +1,835 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stut/s3dir/pkg/lifecycle"
+)
+
+// Memory is an in-memory storage backend with the same behaviour as the
+// filesystem Storage: buckets, versioning, tagging, bucket configuration and
+// multipart uploads. Everything is lost when the process exits, which makes
+// it suited to tests. Lifecycle configurations are stored but not applied
+type Memory struct {
+	mu      sync.RWMutex
+	buckets map[string]*memoryBucket
+	uploads map[string]*memoryUpload
+}
+
+type memoryBucket struct {
+	config map[string][]byte
+	// versions holds each key's versions and delete markers, newest first.
+	// A key has a current object when its newest version is not a delete
+	// marker
+	versions map[string][]*memoryVersion
+}
+
+// memoryVersion is a stored object version or delete marker. Its info's
+// VersionID is empty for objects written before versioning was configured
+type memoryVersion struct {
+	info ObjectInfo
+	data []byte
+}
+
+type memoryUpload struct {
+	upload *MultipartUpload
+	data   map[int][]byte
+}
+
+// NewMemory creates an empty in-memory storage backend
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]*memoryBucket),
+		uploads: make(map[string]*memoryUpload),
+	}
+}
+
+// bucket returns a bucket; the caller must hold m.mu
+func (m *Memory) bucket(bucket string) (*memoryBucket, error) {
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return nil, ErrNoSuchBucket
+	}
+	return b, nil
+}
+
+// ListBuckets lists all buckets in name order
+func (m *Memory) ListBuckets() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	buckets := make([]string, 0, len(m.buckets))
+	for name := range m.buckets {
+		buckets = append(buckets, name)
+	}
+	sort.Strings(buckets)
+
+	return buckets, nil
+}
+
+// CreateBucket creates a new bucket
+func (m *Memory) CreateBucket(bucket string) error {
+	if !ValidBucketName(bucket) {
+		return ErrInvalidBucketName
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.buckets[bucket]; exists {
+		return ErrBucketExists
+	}
+	m.buckets[bucket] = &memoryBucket{
+		config:   make(map[string][]byte),
+		versions: make(map[string][]*memoryVersion),
+	}
+
+	return nil
+}
+
+// DeleteBucket deletes a bucket with no object versions
+func (m *Memory) DeleteBucket(bucket string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	if len(b.versions) > 0 {
+		return ErrBucketNotEmpty
+	}
+	delete(m.buckets, bucket)
+
+	return nil
+}
+
+// HeadBucket checks if a bucket exists
+func (m *Memory) HeadBucket(bucket string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, err := m.bucket(bucket)
+	return err
+}
+
+// GetBucketConfig returns the named configuration document for a bucket, or
+// nil if none has been stored
+func (m *Memory) GetBucketConfig(bucket, name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return b.config[name], nil
+}
+
+// PutBucketConfig stores the named configuration document for a bucket
+func (m *Memory) PutBucketConfig(bucket, name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	b.config[name] = bytes.Clone(data)
+
+	return nil
+}
+
+// DeleteBucketConfig removes the named configuration document for a bucket
+func (m *Memory) DeleteBucketConfig(bucket, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	delete(b.config, name)
+
+	return nil
+}
+
+// GetBucketVersioning returns the versioning state of a bucket
+func (m *Memory) GetBucketVersioning(bucket string) (string, error) {
+	data, err := m.GetBucketConfig(bucket, versioningConfig)
+	return string(data), err
+}
+
+// PutBucketVersioning enables or suspends versioning on a bucket
+func (m *Memory) PutBucketVersioning(bucket, status string) error {
+	if status != VersioningEnabled && status != VersioningSuspended {
+		return fmt.Errorf("%w %q", ErrInvalidVersioningStatus, status)
+	}
+	return m.PutBucketConfig(bucket, versioningConfig, []byte(status))
+}
+
+// GetBucketLifecycle returns a bucket's lifecycle configuration XML, or nil
+func (m *Memory) GetBucketLifecycle(bucket string) ([]byte, error) {
+	return m.GetBucketConfig(bucket, lifecycleConfig)
+}
+
+// PutBucketLifecycle validates and stores a bucket's lifecycle configuration
+func (m *Memory) PutBucketLifecycle(bucket string, data []byte) error {
+	if _, err := lifecycle.Parse(data); err != nil {
+		return err
+	}
+	return m.PutBucketConfig(bucket, lifecycleConfig, data)
+}
+
+// DeleteBucketLifecycle removes a bucket's lifecycle configuration
+func (m *Memory) DeleteBucketLifecycle(bucket string) error {
+	return m.DeleteBucketConfig(bucket, lifecycleConfig)
+}
+
+// PutObject stores an object
+func (m *Memory) PutObject(bucket, key string, reader io.Reader, size int64) error {
+	_, err := m.PutObjectWithMetadata(bucket, key, reader, size, ObjectMetadata{})
+	return err
+}
+
+// PutObjectWithMetadata stores an object, see Storage.PutObjectWithMetadata
+func (m *Memory) PutObjectWithMetadata(bucket, key string, reader io.Reader, size int64, metadata ObjectMetadata) (*ObjectInfo, error) {
+	m.mu.RLock()
+	err := m.checkConditions(bucket, key, metadata.Conditions)
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write object: %w", err)
+	}
+
+	algorithm := metadata.ChecksumAlgorithm
+	if algorithm == "" {
+		algorithm = DefaultChecksumAlgorithm
+	}
+	hash := newContentHash(algorithm)
+	hash.Write(data)
+	if err := hash.verify(metadata.Digests); err != nil {
+		return nil, err
+	}
+
+	return m.commit(bucket, key, data, &objectMetadata{
+		ETag:         hash.etag(),
+		ContentType:  metadata.ContentType,
+		UserMetadata: metadata.UserMetadata,
+		Tags:         metadata.Tags,
+		Checksum:     hash.sum(),
+	}, metadata.Conditions)
+}
+
+// checkConditions tests a conditional write against the current version of
+// an object; the caller must hold m.mu
+func (m *Memory) checkConditions(bucket, key string, conditions WriteConditions) error {
+	if conditions.empty() {
+		return nil
+	}
+	v, _, err := m.resolve(bucket, key, "")
+	if err != nil && !errors.Is(err, ErrNoSuchKey) {
+		return err
+	}
+	if v == nil || v.info.DeleteMarker {
+		return conditions.check(nil)
+	}
+	return conditions.check(&v.info)
+}
+
+// commit stores data as the new current version of an object described by
+// meta, keeping the previous version if the bucket is versioned. Conditions
+// are checked against the version being replaced
+func (m *Memory) commit(bucket, key string, data []byte, meta *objectMetadata, conditions WriteConditions) (*ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkConditions(bucket, key, conditions); err != nil {
+		return nil, conflict(err)
+	}
+
+	v := &memoryVersion{
+		info: ObjectInfo{
+			Key:          key,
+			Size:         int64(len(data)),
+			LastModified: time.Now(),
+			ETag:         fmt.Sprintf("\"%s\"", meta.ETag),
+			ContentType:  meta.ContentType,
+			UserMetadata: meta.UserMetadata,
+			Tags:         meta.Tags,
+			Checksum:     meta.Checksum,
+			Parts:        meta.Parts,
+		},
+		data: data,
+	}
+	b.addVersion(key, v)
+
+	info := v.info
+	info.IsLatest = true
+	return &info, nil
+}
+
+// addVersion makes v the newest version of key, assigning its version ID
+// according to the bucket's versioning state
+func (b *memoryBucket) addVersion(key string, v *memoryVersion) {
+	switch string(b.config[versioningConfig]) {
+	case VersioningEnabled:
+		v.info.VersionID = newVersionID()
+		b.versions[key] = append([]*memoryVersion{v}, b.versions[key]...)
+	case VersioningSuspended:
+		// Only one null version may exist
+		v.info.VersionID = NullVersionID
+		versions := []*memoryVersion{v}
+		for _, old := range b.versions[key] {
+			if orNull(old.info.VersionID) != NullVersionID {
+				versions = append(versions, old)
+			}
+		}
+		b.versions[key] = versions
+	default:
+		b.versions[key] = []*memoryVersion{v}
+	}
+}
+
+// resolve locates a version of an object; the caller must hold m.mu. An empty
+// versionID means the latest version, which may be a delete marker
+func (m *Memory) resolve(bucket, key, versionID string) (*memoryVersion, *ObjectInfo, error) {
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return nil, nil, ErrNoSuchKey
+	}
+	versions := b.versions[key]
+
+	if versionID == "" {
+		if len(versions) == 0 {
+			return nil, nil, ErrNoSuchKey
+		}
+		info := versions[0].info
+		info.IsLatest = true
+		return versions[0], &info, nil
+	}
+
+	for i, v := range versions {
+		if orNull(v.info.VersionID) == versionID {
+			info := v.info
+			info.IsLatest = i == 0
+			if i > 0 {
+				info.VersionID = versionID
+			}
+			return v, &info, nil
+		}
+	}
+	return nil, nil, ErrNoSuchVersion
+}
+
+// GetObjectVersion retrieves a version of an object. An empty versionID
+// means the current version. Delete markers cannot be retrieved
+func (m *Memory) GetObjectVersion(bucket, key, versionID string) (io.ReadCloser, *ObjectInfo, error) {
+	data, info, err := m.readVersion(bucket, key, versionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), info, nil
+}
+
+// GetObjectRangeVersion retrieves length bytes of a version of an object
+// starting at start
+func (m *Memory) GetObjectRangeVersion(bucket, key, versionID string, start, length int64) (io.ReadCloser, *ObjectInfo, error) {
+	data, info, err := m.readVersion(bucket, key, versionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	start = min(start, int64(len(data)))
+	end := min(start+length, int64(len(data)))
+	return io.NopCloser(bytes.NewReader(data[start:end])), info, nil
+}
+
+// readVersion returns the data of a version of an object
+func (m *Memory) readVersion(bucket, key, versionID string) ([]byte, *ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	v, info, err := m.resolve(bucket, key, versionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.DeleteMarker {
+		return nil, nil, ErrNoSuchKey
+	}
+
+	// Stored data is never modified in place, so it can be shared
+	return v.data, info, nil
+}
+
+// HeadObject retrieves the metadata of an object's current version
+func (m *Memory) HeadObject(bucket, key string) (*ObjectInfo, error) {
+	info, err := m.HeadObjectVersion(bucket, key, "")
+	if err != nil {
+		return nil, err
+	}
+	if info.DeleteMarker {
+		return nil, ErrNoSuchKey
+	}
+	return info, nil
+}
+
+// HeadObjectVersion retrieves the metadata of a version of an object,
+// including delete markers
+func (m *Memory) HeadObjectVersion(bucket, key, versionID string) (*ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, info, err := m.resolve(bucket, key, versionID)
+	return info, err
+}
+
+// CopyObjectWithMetadata copies an object server-side, see
+// Storage.CopyObjectWithMetadata
+func (m *Memory) CopyObjectWithMetadata(srcBucket, srcKey, srcVersionID, dstBucket, dstKey string, replaceMetadata, replaceTags bool, metadata ObjectMetadata) (*ObjectInfo, error) {
+	data, srcInfo, err := m.readVersion(srcBucket, srcKey, srcVersionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !replaceMetadata {
+		metadata.ContentType = srcInfo.ContentType
+		metadata.UserMetadata = srcInfo.UserMetadata
+	}
+	if !replaceTags {
+		metadata.Tags = srcInfo.Tags
+	}
+
+	hash := newContentHash(copyChecksumAlgorithm(srcInfo, metadata.ChecksumAlgorithm))
+	hash.Write(data)
+	return m.commit(dstBucket, dstKey, data, &objectMetadata{
+		ETag:         hash.etag(),
+		ContentType:  metadata.ContentType,
+		UserMetadata: metadata.UserMetadata,
+		Tags:         metadata.Tags,
+		Checksum:     hash.sum(),
+	}, WriteConditions{})
+}
+
+// DeleteObject deletes an object. In a versioned bucket this creates a delete
+// marker
+func (m *Memory) DeleteObject(bucket, key string) error {
+	_, _, err := m.DeleteObjectVersion(bucket, key, "")
+	return err
+}
+
+// DeleteObjectVersion deletes an object or one of its versions, see
+// Storage.DeleteObjectVersion
+func (m *Memory) DeleteObjectVersion(bucket, key, versionID string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// As with the filesystem, deleting from a missing bucket deletes nothing
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return versionID, false, nil
+	}
+
+	if versionID != "" {
+		versions := b.versions[key]
+		for i, v := range versions {
+			if orNull(v.info.VersionID) != versionID {
+				continue
+			}
+			versions = append(versions[:i:i], versions[i+1:]...)
+			if len(versions) == 0 {
+				delete(b.versions, key)
+			} else {
+				b.versions[key] = versions
+			}
+			return versionID, v.info.DeleteMarker, nil
+		}
+		// Deleting a nonexistent version succeeds, as in S3
+		return versionID, false, nil
+	}
+
+	if _, versioned := b.config[versioningConfig]; !versioned {
+		delete(b.versions, key)
+		return "", false, nil
+	}
+
+	marker := &memoryVersion{info: ObjectInfo{Key: key, LastModified: time.Now(), DeleteMarker: true}}
+	b.addVersion(key, marker)
+
+	return marker.info.VersionID, true, nil
+}
+
+// GetObjectTagging returns the tags of a version of an object and the
+// version's ID
+func (m *Memory) GetObjectTagging(bucket, key, versionID string) (map[string]string, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, info, err := m.resolve(bucket, key, versionID)
+	if err != nil {
+		return nil, "", err
+	}
+	if info.DeleteMarker {
+		return nil, "", ErrNoSuchKey
+	}
+
+	return info.Tags, info.VersionID, nil
+}
+
+// PutObjectTagging replaces the tags of a version of an object, returning the
+// version's ID. Nil tags remove all tags
+func (m *Memory) PutObjectTagging(bucket, key, versionID string, tags map[string]string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, info, err := m.resolve(bucket, key, versionID)
+	if err != nil {
+		return "", err
+	}
+	if info.DeleteMarker {
+		return "", ErrNoSuchKey
+	}
+	v.info.Tags = tags
+
+	return info.VersionID, nil
+}
+
+// ListObjectsPage lists current objects in a bucket, see
+// Storage.ListObjectsPage
+func (m *Memory) ListObjectsPage(bucket, prefix, delimiter, marker string, maxKeys int) ([]ObjectInfo, []string, bool, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return nil, nil, false, "", err
+	}
+
+	var keys []string
+	for key, versions := range b.versions {
+		if strings.HasPrefix(key, prefix) && !versions[0].info.DeleteMarker {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	objectKeys, commonPrefixes, truncated, nextMarker := pageKeys(keys, prefix, delimiter, marker, maxKeys)
+
+	var objects []ObjectInfo
+	for _, key := range objectKeys {
+		objects = append(objects, b.versions[key][0].info)
+	}
+
+	return objects, commonPrefixes, truncated, nextMarker, nil
+}
+
+// ListObjectVersions lists every version and delete marker of the objects in
+// a bucket, see Storage.ListObjectVersions
+func (m *Memory) ListObjectVersions(bucket, prefix, delimiter, keyMarker, versionIDMarker string, maxKeys int) (*VersionListing, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for key := range b.versions {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return pageVersions(keys, prefix, delimiter, keyMarker, versionIDMarker, maxKeys, func(key string) []ObjectInfo {
+		versions := make([]ObjectInfo, 0, len(b.versions[key]))
+		for i, v := range b.versions[key] {
+			info := v.info
+			info.VersionID = orNull(info.VersionID)
+			info.IsLatest = i == 0
+			versions = append(versions, info)
+		}
+		return versions
+	}), nil
+}
+
+// InitiateMultipartUploadWithMetadata starts a new multipart upload
+func (m *Memory) InitiateMultipartUploadWithMetadata(bucket, key string, metadata ObjectMetadata) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.bucket(bucket); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	uploadID := generateUploadID()
+	upload := &MultipartUpload{
+		UploadID:     uploadID,
+		Bucket:       bucket,
+		Key:          key,
+		ContentType:  metadata.ContentType,
+		UserMetadata: metadata.UserMetadata,
+		Tags:         metadata.Tags,
+		Initiated:    now,
+		LastActivity: now,
+		Parts:        make(map[int]*UploadPart),
+	}
+	if metadata.ChecksumAlgorithm != "" {
+		upload.ChecksumAlgorithm = metadata.ChecksumAlgorithm
+		upload.ChecksumType = metadata.ChecksumType
+		if upload.ChecksumType == "" {
+			upload.ChecksumType = DefaultChecksumType(metadata.ChecksumAlgorithm)
+		}
+	}
+	m.uploads[uploadID] = &memoryUpload{
+		upload: upload,
+		data:   make(map[int][]byte),
+	}
+
+	return uploadID, nil
+}
+
+// UploadPart stores a part of a multipart upload, returning its quoted ETag
+func (m *Memory) UploadPart(uploadID string, partNumber int, reader io.Reader, size int64) (string, error) {
+	part, err := m.UploadPartWithDigests(uploadID, partNumber, reader, size, "", Digests{})
+	if err != nil {
+		return "", err
+	}
+	return part.ETag, nil
+}
+
+// UploadPartWithDigests stores a part of a multipart upload, checking it
+// against the digests sent with it, see Storage.UploadPartWithDigests
+func (m *Memory) UploadPartWithDigests(uploadID string, partNumber int, reader io.Reader, size int64, checksumAlgorithm string, digests Digests) (*UploadPart, error) {
+	m.mu.RLock()
+	u, ok := m.uploads[uploadID]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrNoSuchUpload
+	}
+	algorithm, err := partChecksumAlgorithm(u.upload, checksumAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write part: %w", err)
+	}
+	hash := newContentHash(algorithm)
+	hash.Write(data)
+	if err := hash.verify(digests); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok = m.uploads[uploadID]
+	if !ok {
+		return nil, ErrNoSuchUpload
+	}
+
+	now := time.Now()
+	part := &UploadPart{
+		PartNumber:   partNumber,
+		Size:         int64(len(data)),
+		ETag:         fmt.Sprintf("\"%s\"", hash.etag()),
+		LastModified: now,
+		Checksum:     hash.sum(),
+	}
+	u.upload.Parts[partNumber] = part
+	u.upload.LastActivity = now
+	u.data[partNumber] = data
+
+	p := *part
+	return &p, nil
+}
+
+// UploadPartCopy copies an object, or the inclusive byte range rangeStart to
+// rangeEnd of it, into a part of a multipart upload. Pass rangeStart = -1 to
+// copy the whole object
+func (m *Memory) UploadPartCopy(uploadID string, partNumber int, srcBucket, srcKey, srcVersionID string, rangeStart, rangeEnd int64) (string, error) {
+	data, info, err := m.readVersion(srcBucket, srcKey, srcVersionID)
+	if err != nil {
+		return "", err
+	}
+
+	if rangeStart >= 0 {
+		if rangeStart > rangeEnd || rangeEnd >= info.Size {
+			return "", ErrInvalidRange
+		}
+		data = data[rangeStart : rangeEnd+1]
+	}
+
+	return m.UploadPart(uploadID, partNumber, bytes.NewReader(data), int64(len(data)))
+}
+
+// CompleteMultipartUpload assembles the listed parts into the final object
+func (m *Memory) CompleteMultipartUpload(uploadID string, parts []CompletePart) (*ObjectInfo, error) {
+	return m.CompleteMultipartUploadWithConditions(uploadID, parts, WriteConditions{})
+}
+
+// CompleteMultipartUploadWithConditions completes a multipart upload if the
+// object it replaces meets conditions, see
+// Storage.CompleteMultipartUploadWithConditions
+func (m *Memory) CompleteMultipartUploadWithConditions(uploadID string, parts []CompletePart, conditions WriteConditions) (*ObjectInfo, error) {
+	m.mu.Lock()
+	u, ok := m.uploads[uploadID]
+	if !ok {
+		m.mu.Unlock()
+		return nil, ErrNoSuchUpload
+	}
+	if err := m.checkConditions(u.upload.Bucket, u.upload.Key, conditions); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	for _, cp := range parts {
+		part, ok := u.upload.Parts[cp.PartNumber]
+		if !ok {
+			m.mu.Unlock()
+			return nil, &PartError{PartNumber: cp.PartNumber, Reason: "not found"}
+		}
+		if part.ETag != cp.ETag {
+			m.mu.Unlock()
+			return nil, &PartError{PartNumber: cp.PartNumber, Reason: "etag mismatch"}
+		}
+		if !cp.checksumMatches(part) {
+			m.mu.Unlock()
+			return nil, &PartError{PartNumber: cp.PartNumber, Reason: "checksum mismatch"}
+		}
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+
+	// The ETag is in S3 multipart format: MD5-of-MD5s + part count
+	var data []byte
+	var partChecksums []*Checksum
+	var objectParts []ObjectPart
+	hash := md5.New()
+	for _, cp := range parts {
+		part := u.upload.Parts[cp.PartNumber]
+		partMD5, _ := hex.DecodeString(strings.Trim(part.ETag, "\""))
+		hash.Write(partMD5)
+		partChecksums = append(partChecksums, part.Checksum)
+		objectParts = append(objectParts, ObjectPart{
+			PartNumber: cp.PartNumber,
+			Offset:     int64(len(data)),
+			Size:       int64(len(u.data[cp.PartNumber])),
+			Checksum:   part.Checksum,
+		})
+		data = append(data, u.data[cp.PartNumber]...)
+	}
+	delete(m.uploads, uploadID)
+	m.mu.Unlock()
+
+	upload := u.upload
+	meta := &objectMetadata{
+		ETag:         fmt.Sprintf("%s-%d", hex.EncodeToString(hash.Sum(nil)), len(parts)),
+		ContentType:  upload.ContentType,
+		UserMetadata: upload.UserMetadata,
+		Tags:         upload.Tags,
+		Parts:        objectParts,
+	}
+	algorithm, checksumType := objectChecksum(upload)
+	meta.Checksum = compositeChecksum(algorithm, partChecksums)
+	if checksumType == ChecksumTypeFullObject {
+		contentHash := newChecksumHash(algorithm)
+		contentHash.Write(data)
+		meta.Checksum = fullObjectChecksum(algorithm, contentHash)
+	}
+	info, err := m.commit(upload.Bucket, upload.Key, data, meta, conditions)
+	if err != nil {
+		// Keep the upload so the client can retry
+		m.mu.Lock()
+		m.uploads[uploadID] = u
+		m.mu.Unlock()
+		return nil, err
+	}
+	return info, nil
+}
+
+// GetMultipartUpload returns an in-progress multipart upload
+func (m *Memory) GetMultipartUpload(uploadID string) (*MultipartUpload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.uploads[uploadID]
+	if !ok {
+		return nil, ErrNoSuchUpload
+	}
+	return u.upload, nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (m *Memory) AbortMultipartUpload(uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.uploads[uploadID]; !ok {
+		return ErrNoSuchUpload
+	}
+	delete(m.uploads, uploadID)
+
+	return nil
+}
+
+// ListMultipartUploadParts lists the parts of a multipart upload in part
+// number order
+func (m *Memory) ListMultipartUploadParts(uploadID string) ([]*UploadPart, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.uploads[uploadID]
+	if !ok {
+		return nil, ErrNoSuchUpload
+	}
+
+	parts := make([]*UploadPart, 0, len(u.upload.Parts))
+	for _, part := range u.upload.Parts {
+		p := *part
+		parts = append(parts, &p)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+
+	return parts, nil
+}
+
+// ListMultipartUploads lists in-progress multipart uploads for a bucket
+func (m *Memory) ListMultipartUploads(bucket string) []*MultipartUpload {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	uploads := make([]*MultipartUpload, 0)
+	for _, u := range m.uploads {
+		if u.upload.Bucket == bucket {
+			uploads = append(uploads, u.upload)
+		}
+	}
+
+	return uploads
+}
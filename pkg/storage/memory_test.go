@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func memoryPut(t *testing.T, m *Memory, bucket, key, content string) *ObjectInfo {
+	t.Helper()
+	info, err := m.PutObjectWithMetadata(bucket, key, strings.NewReader(content), int64(len(content)), ObjectMetadata{})
+	if err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	return info
+}
+
+func memoryRead(t *testing.T, m *Memory, bucket, key, versionID string) string {
+	t.Helper()
+	reader, _, err := m.GetObjectVersion(bucket, key, versionID)
+	if err != nil {
+		t.Fatalf("Failed to get version %q: %v", versionID, err)
+	}
+	defer reader.Close()
+	data, _ := io.ReadAll(reader)
+	return string(data)
+}
+
+func TestMemoryBuckets(t *testing.T) {
+	m := NewMemory()
+
+	if err := m.CreateBucket("b"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if err := m.CreateBucket("b"); !errors.Is(err, ErrBucketExists) {
+		t.Errorf("Expected already exists error, got %v", err)
+	}
+	if err := m.HeadBucket("missing"); !errors.Is(err, ErrNoSuchBucket) {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+
+	memoryPut(t, m, "b", "key", "data")
+	if err := m.DeleteBucket("b"); !errors.Is(err, ErrBucketNotEmpty) {
+		t.Errorf("Expected not empty error, got %v", err)
+	}
+	if err := m.DeleteObject("b", "key"); err != nil {
+		t.Fatalf("Failed to delete object: %v", err)
+	}
+	if err := m.DeleteBucket("b"); err != nil {
+		t.Errorf("Failed to delete empty bucket: %v", err)
+	}
+}
+
+func TestMemoryVersioning(t *testing.T) {
+	m := NewMemory()
+	m.CreateBucket("b")
+
+	memoryPut(t, m, "b", "doc", "v0")
+	m.PutBucketVersioning("b", VersioningEnabled)
+	v1 := memoryPut(t, m, "b", "doc", "v1")
+
+	if got := memoryRead(t, m, "b", "doc", NullVersionID); got != "v0" {
+		t.Errorf("Expected null version v0, got %q", got)
+	}
+
+	// Deleting the current version promotes the previous one
+	if _, _, err := m.DeleteObjectVersion("b", "doc", v1.VersionID); err != nil {
+		t.Fatalf("Failed to delete version: %v", err)
+	}
+	if got := memoryRead(t, m, "b", "doc", ""); got != "v0" {
+		t.Errorf("Expected v0 to become current, got %q", got)
+	}
+
+	// While suspended, writes replace the null version
+	m.PutBucketVersioning("b", VersioningSuspended)
+	memoryPut(t, m, "b", "doc", "v2")
+	listing, err := m.ListObjectVersions("b", "", "", "", "", 0)
+	if err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	if len(listing.Versions) != 1 || listing.Versions[0].VersionID != NullVersionID {
+		t.Errorf("Expected a single null version, got %+v", listing.Versions)
+	}
+
+	versionID, marker, err := m.DeleteObjectVersion("b", "doc", "")
+	if err != nil || !marker || versionID != NullVersionID {
+		t.Errorf("Expected null delete marker, got %q %v %v", versionID, marker, err)
+	}
+	if _, err := m.HeadObject("b", "doc"); err == nil {
+		t.Error("Expected deleted object to be missing")
+	}
+}
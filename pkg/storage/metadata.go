@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // metadataDirName is the directory under baseDir holding object metadata
@@ -16,17 +17,30 @@ type objectMetadata struct {
 	ETag         string            `json:"etag,omitempty"`
 	ContentType  string            `json:"contentType,omitempty"`
 	UserMetadata map[string]string `json:"userMetadata,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	// VersionID is empty for objects written while the bucket was unversioned
+	VersionID string `json:"versionId,omitempty"`
+	// DeleteMarker and LastModified are only set on noncurrent versions,
+	// which have no file whose modification time could be used
+	DeleteMarker bool      `json:"deleteMarker,omitempty"`
+	LastModified time.Time `json:"lastModified,omitzero"`
+	Checksum     *Checksum `json:"checksum,omitempty"`
+	// Parts is only set on objects completed from a multipart upload
+	Parts []ObjectPart `json:"parts,omitempty"`
 }
 
 func objectMetadataPath(baseDir, bucket, key string) string {
-	return filepath.Join(baseDir, metadataDirName, bucket, filepath.FromSlash(key)+".json")
+	return filePath(filepath.Join(baseDir, metadataDirName, bucket, keyPath(key)+".json"))
 }
 
 // writeObjectMetadataFile persists the metadata sidecar for an object
 func writeObjectMetadataFile(baseDir, bucket, key string, meta *objectMetadata) error {
-	path := objectMetadataPath(baseDir, bucket, key)
+	return writeMetadataFile(objectMetadataPath(baseDir, bucket, key), meta)
+}
 
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+// writeMetadataFile persists object metadata at path
+func writeMetadataFile(path string, meta *objectMetadata) error {
+	if err := makeDirs(filepath.Dir(path)); err != nil {
 		return err
 	}
 
@@ -41,7 +55,13 @@ func writeObjectMetadataFile(baseDir, bucket, key string, meta *objectMetadata)
 // readObjectMetadataFile loads the metadata sidecar for an object, returning
 // nil if no sidecar exists (e.g. objects created before metadata support)
 func readObjectMetadataFile(baseDir, bucket, key string) *objectMetadata {
-	data, err := os.ReadFile(objectMetadataPath(baseDir, bucket, key))
+	return readMetadataFile(objectMetadataPath(baseDir, bucket, key))
+}
+
+// readMetadataFile loads object metadata from path, returning nil if the file
+// does not exist or cannot be parsed
+func readMetadataFile(path string) *objectMetadata {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil
 	}
@@ -2,15 +2,20 @@ package storage
 
 import (
 	"crypto/md5"
+	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,10 +26,17 @@ type MultipartUpload struct {
 	Key          string
 	ContentType  string
 	UserMetadata map[string]string
-	Initiated    time.Time
-	LastActivity time.Time
-	Parts        map[int]*UploadPart
-	mu           sync.RWMutex
+	Tags         map[string]string
+	// ChecksumAlgorithm and ChecksumType are the checksum chosen for the
+	// completed object, if any
+	ChecksumAlgorithm string
+	ChecksumType      string
+	Initiated         time.Time
+	LastActivity      time.Time
+	Parts             map[int]*UploadPart
+	mu                sync.RWMutex
+	// saveMu serializes writes of the upload's metadata file
+	saveMu sync.Mutex
 }
 
 // UploadPart represents a single part of a multipart upload
@@ -34,6 +46,17 @@ type UploadPart struct {
 	ETag         string
 	Path         string
 	LastModified time.Time
+	// Checksum is set when the upload or the client chose an algorithm
+	Checksum *Checksum
+}
+
+// ObjectPart records where one of the parts a multipart object was
+// assembled from lies in the object's content
+type ObjectPart struct {
+	PartNumber int       `json:"partNumber"`
+	Offset     int64     `json:"offset"`
+	Size       int64     `json:"size"`
+	Checksum   *Checksum `json:"checksum,omitempty"`
 }
 
 // MultipartManager manages multipart uploads
@@ -43,6 +66,16 @@ type MultipartManager struct {
 	mu            sync.RWMutex
 	cleanupTicker *time.Ticker
 	stopCleanup   chan struct{}
+	stopOnce      sync.Once
+	// staleRemoved counts uploads removed by the stale upload cleanup
+	staleRemoved atomic.Int64
+	// commit moves an assembled object into place. Storage sets it so
+	// completed uploads respect bucket versioning
+	commit func(bucket, key, tmpPath string, meta *objectMetadata, conditions WriteConditions) (*ObjectInfo, error)
+	// abortAfter returns how long after initiation an upload should be
+	// aborted under the bucket's lifecycle rules, and false if no rule
+	// covers it
+	abortAfter func(bucket, key string) (time.Duration, bool)
 }
 
 // NewMultipartManager creates a new multipart upload manager
@@ -52,9 +85,10 @@ func NewMultipartManager(baseDir string) *MultipartManager {
 		baseDir:     baseDir,
 		stopCleanup: make(chan struct{}),
 	}
+	m.commit = m.commitObject
 
-	// Clean up orphaned uploads from previous runs on startup
-	m.cleanupOrphanedUploads()
+	// Resume uploads left by a previous run
+	m.loadUploads()
 
 	// Start background cleanup goroutine (runs every hour)
 	m.cleanupTicker = time.NewTicker(1 * time.Hour)
@@ -64,7 +98,7 @@ func NewMultipartManager(baseDir string) *MultipartManager {
 }
 
 // InitiateUpload starts a new multipart upload
-func (m *MultipartManager) InitiateUpload(bucket, key, contentType string, userMetadata map[string]string) (string, error) {
+func (m *MultipartManager) InitiateUpload(bucket, key string, metadata ObjectMetadata) (string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -76,12 +110,20 @@ func (m *MultipartManager) InitiateUpload(bucket, key, contentType string, userM
 		UploadID:     uploadID,
 		Bucket:       bucket,
 		Key:          key,
-		ContentType:  contentType,
-		UserMetadata: userMetadata,
+		ContentType:  metadata.ContentType,
+		UserMetadata: metadata.UserMetadata,
+		Tags:         metadata.Tags,
 		Initiated:    now,
 		LastActivity: now,
 		Parts:        make(map[int]*UploadPart),
 	}
+	if metadata.ChecksumAlgorithm != "" {
+		upload.ChecksumAlgorithm = metadata.ChecksumAlgorithm
+		upload.ChecksumType = metadata.ChecksumType
+		if upload.ChecksumType == "" {
+			upload.ChecksumType = DefaultChecksumType(metadata.ChecksumAlgorithm)
+		}
+	}
 
 	m.uploads[uploadID] = upload
 
@@ -99,50 +141,70 @@ func (m *MultipartManager) InitiateUpload(bucket, key, contentType string, userM
 	return uploadID, nil
 }
 
-// UploadPart uploads a single part
+// UploadPart uploads a single part, returning its quoted ETag
 func (m *MultipartManager) UploadPart(uploadID string, partNumber int, reader io.Reader, size int64) (string, error) {
+	part, err := m.UploadPartWithDigests(uploadID, partNumber, reader, size, "", Digests{})
+	if err != nil {
+		return "", err
+	}
+	return part.ETag, nil
+}
+
+// UploadPartWithDigests uploads a single part, checking it against the
+// digests sent with it. checksumAlgorithm is the algorithm of their
+// checksum, if any
+func (m *MultipartManager) UploadPartWithDigests(uploadID string, partNumber int, reader io.Reader, size int64, checksumAlgorithm string, digests Digests) (*UploadPart, error) {
 	m.mu.RLock()
 	upload, exists := m.uploads[uploadID]
 	m.mu.RUnlock()
 
 	if !exists {
-		return "", fmt.Errorf("upload not found")
+		return nil, ErrNoSuchUpload
+	}
+
+	algorithm, err := partChecksumAlgorithm(upload, checksumAlgorithm)
+	if err != nil {
+		return nil, err
 	}
 
 	// Ensure parts directory exists (defensive, in case of race conditions)
 	partsDir := m.getPartsDir(uploadID)
 	if err := os.MkdirAll(partsDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create parts directory: %w", err)
+		return nil, fmt.Errorf("failed to create parts directory: %w", err)
 	}
 
 	// Create part file
 	partPath := m.getPartPath(uploadID, partNumber)
 	partFile, err := os.Create(partPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to create part file: %w", err)
+		return nil, fmt.Errorf("failed to create part file: %w", err)
 	}
 	defer partFile.Close()
 
-	// Calculate MD5 while writing using a fixed-size buffer to limit memory usage
-	hash := md5.New()
+	// Calculate MD5 and checksum while writing using a fixed-size buffer to
+	// limit memory usage
+	hash := newContentHash(algorithm)
 	writer := io.MultiWriter(partFile, hash)
 
 	buffer := make([]byte, 32*1024) // 32KB buffer for streaming
 	written, err := io.CopyBuffer(writer, reader, buffer)
 	if err != nil {
 		os.Remove(partPath)
-		return "", fmt.Errorf("failed to write part: %w", err)
+		return nil, fmt.Errorf("failed to write part: %w", err)
+	}
+	if err := hash.verify(digests); err != nil {
+		os.Remove(partPath)
+		return nil, err
 	}
-
-	etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(hash.Sum(nil)))
 
 	// Store part info
 	part := &UploadPart{
 		PartNumber:   partNumber,
 		Size:         written,
-		ETag:         etag,
+		ETag:         fmt.Sprintf("\"%s\"", hash.etag()),
 		Path:         partPath,
 		LastModified: time.Now(),
+		Checksum:     hash.sum(),
 	}
 
 	upload.mu.Lock()
@@ -152,20 +214,35 @@ func (m *MultipartManager) UploadPart(uploadID string, partNumber int, reader io
 
 	// Update metadata
 	if err := m.saveUploadMetadata(upload); err != nil {
-		return "", fmt.Errorf("failed to update metadata: %w", err)
+		return nil, fmt.Errorf("failed to update metadata: %w", err)
 	}
 
-	return etag, nil
+	return part, nil
 }
 
-// CompleteUpload assembles all parts into final object
-func (m *MultipartManager) CompleteUpload(uploadID string, parts []CompletePart) (string, error) {
+// upload returns an in-progress upload
+func (m *MultipartManager) upload(uploadID string) (*MultipartUpload, error) {
 	m.mu.RLock()
 	upload, exists := m.uploads[uploadID]
 	m.mu.RUnlock()
 
 	if !exists {
-		return "", fmt.Errorf("upload not found")
+		return nil, ErrNoSuchUpload
+	}
+	return upload, nil
+}
+
+// CompleteUpload assembles all parts into final object
+func (m *MultipartManager) CompleteUpload(uploadID string, parts []CompletePart) (*ObjectInfo, error) {
+	return m.CompleteUploadWithConditions(uploadID, parts, WriteConditions{})
+}
+
+// CompleteUploadWithConditions assembles all parts into the final object if
+// the object it replaces meets conditions when it is moved into place
+func (m *MultipartManager) CompleteUploadWithConditions(uploadID string, parts []CompletePart, conditions WriteConditions) (*ObjectInfo, error) {
+	upload, err := m.upload(uploadID)
+	if err != nil {
+		return nil, err
 	}
 
 	// Validate all parts are present
@@ -174,11 +251,15 @@ func (m *MultipartManager) CompleteUpload(uploadID string, parts []CompletePart)
 		part, ok := upload.Parts[cp.PartNumber]
 		if !ok {
 			upload.mu.RUnlock()
-			return "", fmt.Errorf("part %d not found", cp.PartNumber)
+			return nil, &PartError{PartNumber: cp.PartNumber, Reason: "not found"}
 		}
 		if part.ETag != cp.ETag {
 			upload.mu.RUnlock()
-			return "", fmt.Errorf("part %d etag mismatch", cp.PartNumber)
+			return nil, &PartError{PartNumber: cp.PartNumber, Reason: "etag mismatch"}
+		}
+		if !cp.checksumMatches(part) {
+			upload.mu.RUnlock()
+			return nil, &PartError{PartNumber: cp.PartNumber, Reason: "checksum mismatch"}
 		}
 	}
 	upload.mu.RUnlock()
@@ -189,15 +270,15 @@ func (m *MultipartManager) CompleteUpload(uploadID string, parts []CompletePart)
 	})
 
 	// Create final object path
-	objectPath := filepath.Join(m.baseDir, upload.Bucket, filepath.FromSlash(upload.Key))
-	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
-		return "", fmt.Errorf("failed to create object directory: %w", err)
+	objectPath := filePath(filepath.Join(m.baseDir, upload.Bucket, keyPath(upload.Key)))
+	if err := makeDirs(filepath.Dir(objectPath)); err != nil {
+		return nil, fmt.Errorf("failed to create object directory: %w", err)
 	}
 
 	// Create temporary file for assembly
-	tmpFile, err := os.CreateTemp(filepath.Dir(objectPath), ".s3dir-multipart-*")
+	tmpFile, err := os.CreateTemp(filepath.Dir(objectPath), tempFilePrefix+"multipart-*")
 	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
 	}
 	tmpPath := tmpFile.Name()
 	defer os.Remove(tmpPath)
@@ -206,6 +287,19 @@ func (m *MultipartManager) CompleteUpload(uploadID string, parts []CompletePart)
 	// Use 1MB buffer instead of 32KB to speed up assembly of multi-GB files
 	buffer := make([]byte, 1024*1024)
 
+	// The object's checksum is either composed from the parts' checksums or
+	// computed over the content as the parts are assembled
+	algorithm, checksumType := objectChecksum(upload)
+	var writer io.Writer = tmpFile
+	var contentHash hash.Hash
+	if checksumType == ChecksumTypeFullObject {
+		contentHash = newChecksumHash(algorithm)
+		writer = io.MultiWriter(tmpFile, contentHash)
+	}
+	partChecksums := make([]*Checksum, 0, len(parts))
+	objectParts := make([]ObjectPart, 0, len(parts))
+	var offset int64
+
 	// Calculate ETag as MD5 of concatenated part ETags (S3 multipart ETag format)
 	// This is much faster than hashing the entire assembled file
 	hash := md5.New()
@@ -213,6 +307,7 @@ func (m *MultipartManager) CompleteUpload(uploadID string, parts []CompletePart)
 		upload.mu.RLock()
 		part := upload.Parts[cp.PartNumber]
 		upload.mu.RUnlock()
+		partChecksums = append(partChecksums, part.Checksum)
 
 		// Write part's MD5 to hash (for multipart ETag calculation)
 		// Extract hex MD5 from ETag (remove quotes)
@@ -224,36 +319,40 @@ func (m *MultipartManager) CompleteUpload(uploadID string, parts []CompletePart)
 		partFile, err := os.Open(part.Path)
 		if err != nil {
 			tmpFile.Close()
-			return "", fmt.Errorf("failed to open part %d: %w", cp.PartNumber, err)
+			return nil, fmt.Errorf("failed to open part %d: %w", cp.PartNumber, err)
 		}
 
-		if _, err := io.CopyBuffer(tmpFile, partFile, buffer); err != nil {
+		n, err := io.CopyBuffer(writer, partFile, buffer)
+		if err != nil {
 			partFile.Close()
 			tmpFile.Close()
-			return "", fmt.Errorf("failed to copy part %d: %w", cp.PartNumber, err)
+			return nil, fmt.Errorf("failed to copy part %d: %w", cp.PartNumber, err)
 		}
 
 		partFile.Close()
+		objectParts = append(objectParts, ObjectPart{PartNumber: cp.PartNumber, Offset: offset, Size: n, Checksum: part.Checksum})
+		offset += n
 	}
 
 	tmpFile.Close()
 
-	// Move to final location
-	if err := os.Rename(tmpPath, objectPath); err != nil {
-		return "", fmt.Errorf("failed to move object: %w", err)
-	}
-
-	// Generate ETag in S3 multipart format: MD5-of-MD5s + part count
-	etag := fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(hash.Sum(nil)), len(parts))
-
-	// Persist the object's metadata sidecar
+	// Move to final location along with the object's metadata sidecar. The
+	// ETag is in S3 multipart format: MD5-of-MD5s + part count
 	meta := &objectMetadata{
-		ETag:         strings.Trim(etag, "\""),
+		ETag:         fmt.Sprintf("%s-%d", hex.EncodeToString(hash.Sum(nil)), len(parts)),
 		ContentType:  upload.ContentType,
 		UserMetadata: upload.UserMetadata,
+		Tags:         upload.Tags,
+		Parts:        objectParts,
+	}
+	if contentHash != nil {
+		meta.Checksum = fullObjectChecksum(algorithm, contentHash)
+	} else {
+		meta.Checksum = compositeChecksum(algorithm, partChecksums)
 	}
-	if err := writeObjectMetadataFile(m.baseDir, upload.Bucket, upload.Key, meta); err != nil {
-		return "", fmt.Errorf("failed to write metadata: %w", err)
+	info, err := m.commit(upload.Bucket, upload.Key, tmpPath, meta, conditions)
+	if err != nil {
+		return nil, err
 	}
 
 	// Cleanup - remove from uploads map and delete parts directory
@@ -265,7 +364,51 @@ func (m *MultipartManager) CompleteUpload(uploadID string, parts []CompletePart)
 	partsDir := m.getPartsDir(uploadID)
 	os.RemoveAll(partsDir)
 
-	return etag, nil
+	return info, nil
+}
+
+// commitObject moves an assembled object into place without regard to
+// versioning, for managers used outside a Storage
+func (m *MultipartManager) commitObject(bucket, key, tmpPath string, meta *objectMetadata, conditions WriteConditions) (*ObjectInfo, error) {
+	objectPath := filePath(filepath.Join(m.baseDir, bucket, keyPath(key)))
+	if !conditions.empty() {
+		var current *ObjectInfo
+		if stat, err := os.Stat(objectPath); err == nil && !stat.IsDir() {
+			current = &ObjectInfo{}
+			if existing := readObjectMetadataFile(m.baseDir, bucket, key); existing != nil {
+				current.ETag = existing.ETag
+			}
+		}
+		if err := conditions.check(current); err != nil {
+			return nil, err
+		}
+	}
+	if err := makeDirs(filepath.Dir(objectPath)); err != nil {
+		return nil, fmt.Errorf("failed to create object directory: %w", err)
+	}
+	if err := os.Rename(tmpPath, objectPath); err != nil {
+		return nil, fmt.Errorf("failed to move object: %w", err)
+	}
+	if err := writeObjectMetadataFile(m.baseDir, bucket, key, meta); err != nil {
+		return nil, fmt.Errorf("failed to write metadata: %w", err)
+	}
+
+	stat, err := os.Stat(objectPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	return &ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		LastModified: stat.ModTime(),
+		ETag:         fmt.Sprintf("\"%s\"", meta.ETag),
+		ContentType:  meta.ContentType,
+		UserMetadata: meta.UserMetadata,
+		Tags:         meta.Tags,
+		Checksum:     meta.Checksum,
+		Parts:        meta.Parts,
+	}, nil
 }
 
 // AbortUpload cancels a multipart upload and cleans up
@@ -278,7 +421,7 @@ func (m *MultipartManager) AbortUpload(uploadID string) error {
 	m.mu.Unlock()
 
 	if !exists {
-		return fmt.Errorf("upload not found")
+		return ErrNoSuchUpload
 	}
 
 	// Remove parts directory
@@ -297,7 +440,7 @@ func (m *MultipartManager) ListParts(uploadID string) ([]*UploadPart, error) {
 	m.mu.RUnlock()
 
 	if !exists {
-		return nil, fmt.Errorf("upload not found")
+		return nil, ErrNoSuchUpload
 	}
 
 	upload.mu.RLock()
@@ -335,6 +478,14 @@ func (m *MultipartManager) ListUploads(bucket string) []*MultipartUpload {
 type CompletePart struct {
 	PartNumber int
 	ETag       string
+	// Checksum is the part's checksum as the client recorded it, if sent
+	Checksum string
+}
+
+// checksumMatches reports whether a part's checksum is the one the client
+// recorded for it
+func (cp CompletePart) checksumMatches(part *UploadPart) bool {
+	return cp.Checksum == "" || part.Checksum == nil || cp.Checksum == part.Checksum.Value
 }
 
 // Helper functions
@@ -351,34 +502,48 @@ func (m *MultipartManager) getMetadataPath(uploadID string) string {
 	return filepath.Join(m.getPartsDir(uploadID), "metadata.json")
 }
 
+// uploadMetadata is the form in which an upload is saved to its parts
+// directory, from which it is restored on startup
+type uploadMetadata struct {
+	UploadID          string
+	Bucket            string
+	Key               string
+	ContentType       string
+	UserMetadata      map[string]string
+	Tags              map[string]string
+	ChecksumAlgorithm string `json:",omitempty"`
+	ChecksumType      string `json:",omitempty"`
+	Initiated         time.Time
+	LastActivity      time.Time
+	Parts             map[int]*UploadPart
+}
+
+// saveUploadMetadata writes the upload's metadata file. The file is replaced
+// atomically so a crash cannot leave it half-written, and saves of the same
+// upload are serialized so the last one written reflects the latest state
 func (m *MultipartManager) saveUploadMetadata(upload *MultipartUpload) error {
-	metadataPath := m.getMetadataPath(upload.UploadID)
+	upload.saveMu.Lock()
+	defer upload.saveMu.Unlock()
 
-	// Create a serializable version without the mutex
-	// Make a deep copy of the parts map to avoid concurrent access during JSON marshaling
+	// Make a copy of the parts map to avoid concurrent access during JSON
+	// marshaling
 	upload.mu.RLock()
 	partsCopy := make(map[int]*UploadPart, len(upload.Parts))
 	for k, v := range upload.Parts {
 		partsCopy[k] = v
 	}
-	metadata := struct {
-		UploadID     string
-		Bucket       string
-		Key          string
-		ContentType  string
-		UserMetadata map[string]string
-		Initiated    time.Time
-		LastActivity time.Time
-		Parts        map[int]*UploadPart
-	}{
-		UploadID:     upload.UploadID,
-		Bucket:       upload.Bucket,
-		Key:          upload.Key,
-		ContentType:  upload.ContentType,
-		UserMetadata: upload.UserMetadata,
-		Initiated:    upload.Initiated,
-		LastActivity: upload.LastActivity,
-		Parts:        partsCopy,
+	metadata := uploadMetadata{
+		UploadID:          upload.UploadID,
+		Bucket:            upload.Bucket,
+		Key:               upload.Key,
+		ContentType:       upload.ContentType,
+		UserMetadata:      upload.UserMetadata,
+		Tags:              upload.Tags,
+		ChecksumAlgorithm: upload.ChecksumAlgorithm,
+		ChecksumType:      upload.ChecksumType,
+		Initiated:         upload.Initiated,
+		LastActivity:      upload.LastActivity,
+		Parts:             partsCopy,
 	}
 	upload.mu.RUnlock()
 
@@ -387,21 +552,124 @@ func (m *MultipartManager) saveUploadMetadata(upload *MultipartUpload) error {
 		return err
 	}
 
-	return os.WriteFile(metadataPath, data, 0644)
+	metadataPath := m.getMetadataPath(upload.UploadID)
+	tmpFile, err := os.CreateTemp(filepath.Dir(metadataPath), "metadata-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, metadataPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
 }
 
-func generateUploadID() string {
-	// Simple upload ID generation
-	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), randomString(16))
+// loadUploads restores the uploads a previous run left in the .multipart
+// directory. Uploads whose metadata is missing or unreadable are removed
+func (m *MultipartManager) loadUploads() {
+	multipartDir := filepath.Join(m.baseDir, ".multipart")
+	entries, err := os.ReadDir(multipartDir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		uploadDir := filepath.Join(multipartDir, entry.Name())
+		if !entry.IsDir() {
+			os.Remove(uploadDir)
+			continue
+		}
+
+		upload, err := m.loadUpload(entry.Name())
+		if err != nil {
+			log.Printf("Multipart: removing upload %s: %v", entry.Name(), err)
+			os.RemoveAll(uploadDir)
+			continue
+		}
+		m.uploads[upload.UploadID] = upload
+	}
 }
 
-func randomString(n int) string {
-	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letters[time.Now().UnixNano()%int64(len(letters))]
+// loadUpload restores an upload from its parts directory. Parts whose files
+// are missing or have a different size than recorded are dropped, so the
+// client can upload them again, and files the metadata does not account for,
+// such as parts whose upload was interrupted, are removed
+func (m *MultipartManager) loadUpload(uploadID string) (*MultipartUpload, error) {
+	data, err := os.ReadFile(m.getMetadataPath(uploadID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	var metadata uploadMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	if metadata.UploadID != uploadID || metadata.Bucket == "" || metadata.Key == "" || metadata.Initiated.IsZero() {
+		return nil, fmt.Errorf("incomplete metadata")
 	}
-	return string(b)
+
+	upload := &MultipartUpload{
+		UploadID:          metadata.UploadID,
+		Bucket:            metadata.Bucket,
+		Key:               metadata.Key,
+		ContentType:       metadata.ContentType,
+		UserMetadata:      metadata.UserMetadata,
+		Tags:              metadata.Tags,
+		ChecksumAlgorithm: metadata.ChecksumAlgorithm,
+		ChecksumType:      metadata.ChecksumType,
+		Initiated:         metadata.Initiated,
+		LastActivity:      metadata.LastActivity,
+		Parts:             make(map[int]*UploadPart, len(metadata.Parts)),
+	}
+	if upload.LastActivity.IsZero() {
+		upload.LastActivity = upload.Initiated
+	}
+
+	keep := map[string]bool{filepath.Base(m.getMetadataPath(uploadID)): true}
+	dropped := false
+	for partNumber, part := range metadata.Parts {
+		partPath := m.getPartPath(uploadID, partNumber)
+		info, err := os.Stat(partPath)
+		if part == nil || part.PartNumber != partNumber || err != nil || info.Size() != part.Size {
+			dropped = true
+			continue
+		}
+		// The data directory may have moved since the part was written
+		part.Path = partPath
+		upload.Parts[partNumber] = part
+		keep[filepath.Base(partPath)] = true
+	}
+
+	files, err := os.ReadDir(m.getPartsDir(uploadID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read parts: %w", err)
+	}
+	for _, file := range files {
+		if !keep[file.Name()] {
+			os.RemoveAll(filepath.Join(m.getPartsDir(uploadID), file.Name()))
+		}
+	}
+
+	if dropped {
+		if err := m.saveUploadMetadata(upload); err != nil {
+			return nil, fmt.Errorf("failed to update metadata: %w", err)
+		}
+	}
+
+	return upload, nil
+}
+
+// generateUploadID returns a new upload ID. Uploads are found by ID alone, so
+// the ID must not be guessable
+func generateUploadID() string {
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), rand.Text())
 }
 
 // backgroundCleanup runs periodically to clean up stale uploads
@@ -417,29 +685,71 @@ func (m *MultipartManager) backgroundCleanup() {
 	}
 }
 
-// cleanupStaleUploads removes uploads with no activity for more than 24 hours
+// defaultStaleUploadAge is how long an upload no lifecycle rule covers may go
+// without activity before it is removed
+const defaultStaleUploadAge = 24 * time.Hour
+
+// cleanupStaleUploads removes stale uploads as of now
 func (m *MultipartManager) cleanupStaleUploads() {
-	const staleThreshold = 24 * time.Hour
-	now := time.Now()
+	m.expireUploads(time.Now())
+}
 
-	m.mu.Lock()
-	var staleUploads []string
+// expireUploads removes uploads that a lifecycle AbortIncompleteMultipartUpload
+// rule says to abort at now, and uploads no rule covers that have had no
+// activity for defaultStaleUploadAge
+func (m *MultipartManager) expireUploads(now time.Time) {
+	type candidate struct {
+		uploadID, bucket, key   string
+		initiated, lastActivity time.Time
+	}
+
+	m.mu.RLock()
+	candidates := make([]candidate, 0, len(m.uploads))
 	for uploadID, upload := range m.uploads {
 		upload.mu.RLock()
-		lastActivity := upload.LastActivity
+		candidates = append(candidates, candidate{uploadID, upload.Bucket, upload.Key, upload.Initiated, upload.LastActivity})
 		upload.mu.RUnlock()
+	}
+	m.mu.RUnlock()
 
-		if now.Sub(lastActivity) > staleThreshold {
-			staleUploads = append(staleUploads, uploadID)
+	// Look up lifecycle rules outside the lock, as they are read from disk
+	var expired []candidate
+	for _, c := range candidates {
+		if m.abortAfter != nil {
+			if after, ok := m.abortAfter(c.bucket, c.key); ok {
+				if now.Sub(c.initiated) >= after {
+					expired = append(expired, c)
+				}
+				continue
+			}
+		}
+		if now.Sub(c.lastActivity) > defaultStaleUploadAge {
+			expired = append(expired, c)
 		}
 	}
 
-	// Remove stale uploads from map
-	for _, uploadID := range staleUploads {
-		delete(m.uploads, uploadID)
+	// Remove stale uploads from map, skipping any that became active or
+	// finished meanwhile
+	var staleUploads []string
+	m.mu.Lock()
+	for _, c := range expired {
+		upload, ok := m.uploads[c.uploadID]
+		if !ok {
+			continue
+		}
+		upload.mu.RLock()
+		active := !upload.LastActivity.Equal(c.lastActivity)
+		upload.mu.RUnlock()
+		if active {
+			continue
+		}
+		delete(m.uploads, c.uploadID)
+		staleUploads = append(staleUploads, c.uploadID)
 	}
 	m.mu.Unlock()
 
+	m.staleRemoved.Add(int64(len(staleUploads)))
+
 	// Clean up filesystem (outside of lock)
 	for _, uploadID := range staleUploads {
 		partsDir := m.getPartsDir(uploadID)
@@ -447,32 +757,40 @@ func (m *MultipartManager) cleanupStaleUploads() {
 	}
 }
 
-// cleanupOrphanedUploads removes leftover .multipart directories from previous runs
-func (m *MultipartManager) cleanupOrphanedUploads() {
-	multipartDir := filepath.Join(m.baseDir, ".multipart")
+// ActiveUploads returns the number of uploads in progress
+func (m *MultipartManager) ActiveUploads() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.uploads)
+}
 
-	// Check if .multipart directory exists
-	if _, err := os.Stat(multipartDir); os.IsNotExist(err) {
-		return
-	}
+// StaleUploadsRemoved returns the number of uploads the stale upload cleanup
+// has removed since startup
+func (m *MultipartManager) StaleUploadsRemoved() int64 {
+	return m.staleRemoved.Load()
+}
 
-	// Read all upload directories
-	entries, err := os.ReadDir(multipartDir)
-	if err != nil {
-		return
+// Stop stops the background cleanup goroutine. It is safe to call more
+// than once
+func (m *MultipartManager) Stop() {
+	m.stopOnce.Do(func() { close(m.stopCleanup) })
+}
+
+// Flush writes the metadata of every in-progress upload to disk, so uploads
+// are recorded as of the last part received
+func (m *MultipartManager) Flush() error {
+	m.mu.RLock()
+	uploads := make([]*MultipartUpload, 0, len(m.uploads))
+	for _, upload := range m.uploads {
+		uploads = append(uploads, upload)
 	}
+	m.mu.RUnlock()
 
-	// Remove all orphaned upload directories
-	// Since this runs at startup, all .multipart directories are orphaned
-	for _, entry := range entries {
-		if entry.IsDir() {
-			uploadDir := filepath.Join(multipartDir, entry.Name())
-			os.RemoveAll(uploadDir)
+	var errs []error
+	for _, upload := range uploads {
+		if err := m.saveUploadMetadata(upload); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("upload %s: %w", upload.UploadID, err))
 		}
 	}
-}
-
-// Stop stops the background cleanup goroutine
-func (m *MultipartManager) Stop() {
-	close(m.stopCleanup)
+	return errors.Join(errs...)
 }
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
@@ -101,11 +102,11 @@ func TestMultipartUploadWorkflow(t *testing.T) {
 		{PartNumber: 3, ETag: etag3},
 	}
 
-	finalETag, err := storage.CompleteMultipartUpload(uploadID, completeParts)
+	completed, err := storage.CompleteMultipartUpload(uploadID, completeParts)
 	if err != nil {
 		t.Fatalf("Failed to complete multipart upload: %v", err)
 	}
-	if finalETag == "" {
+	if completed.ETag == "" {
 		t.Fatal("Final ETag should not be empty")
 	}
 
@@ -252,8 +253,8 @@ func TestMultipartUploadErrors(t *testing.T) {
 		if err == nil {
 			t.Error("Expected error when completing with missing part")
 		}
-		if !strings.Contains(err.Error(), "part") {
-			t.Errorf("Expected error message to contain 'part', got: %v", err)
+		if !errors.Is(err, ErrInvalidPart) {
+			t.Errorf("Expected ErrInvalidPart, got: %v", err)
 		}
 	})
 
@@ -579,6 +580,8 @@ func TestCleanupStaleUploads(t *testing.T) {
 	}
 }
 
+// TestCleanupOrphanedUploads checks that upload directories without usable
+// metadata are removed on startup
 func TestCleanupOrphanedUploads(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "s3dir-multipart-test-*")
 	if err != nil {
@@ -602,6 +605,9 @@ func TestCleanupOrphanedUploads(t *testing.T) {
 	if err := os.WriteFile(filepath.Join(orphanedUpload1, "part-1"), []byte("data"), 0644); err != nil {
 		t.Fatalf("Failed to create orphaned file: %v", err)
 	}
+	if err := os.WriteFile(filepath.Join(orphanedUpload2, "metadata.json"), []byte("{corrupt"), 0644); err != nil {
+		t.Fatalf("Failed to create orphaned file: %v", err)
+	}
 
 	// Verify orphaned directories exist
 	if _, err := os.Stat(orphanedUpload1); os.IsNotExist(err) {
@@ -684,3 +690,255 @@ func TestLastActivityTracking(t *testing.T) {
 		t.Error("LastActivity should be updated after uploading a part")
 	}
 }
+
+func TestShutdownFlushesUploads(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "s3dir-multipart-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := New(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	bucket := "test-bucket"
+	if err := storage.CreateBucket(bucket); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	uploadID, err := storage.InitiateMultipartUpload(bucket, "test.txt")
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %v", err)
+	}
+	data := []byte("test data")
+	if _, err := storage.UploadPart(uploadID, 1, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Failed to upload part: %v", err)
+	}
+
+	// Lose the metadata file, as if a write had been interrupted
+	metadataPath := storage.multipart.getMetadataPath(uploadID)
+	if err := os.Remove(metadataPath); err != nil {
+		t.Fatalf("Failed to remove metadata: %v", err)
+	}
+
+	if err := storage.Shutdown(); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	contents, err := os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatalf("Expected upload metadata to be flushed: %v", err)
+	}
+	if !strings.Contains(string(contents), uploadID) || !strings.Contains(string(contents), "part-1") {
+		t.Errorf("Flushed metadata is incomplete: %s", contents)
+	}
+
+	// Stopping again is harmless
+	storage.Stop()
+}
+
+func TestResumeUploadsAfterRestart(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "s3dir-multipart-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := New(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	bucket := "test-bucket"
+	if err := storage.CreateBucket(bucket); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	uploadID, err := storage.InitiateMultipartUploadWithMetadata(bucket, "big.bin", ObjectMetadata{
+		ContentType:  "application/octet-stream",
+		UserMetadata: map[string]string{"owner": "ci"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %v", err)
+	}
+	var parts []CompletePart
+	for i, data := range []string{"part one,", "part two,", "part three"} {
+		etag, err := storage.UploadPart(uploadID, i+1, strings.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("Failed to upload part %d: %v", i+1, err)
+		}
+		parts = append(parts, CompletePart{PartNumber: i + 1, ETag: etag})
+	}
+
+	// Part 2 is truncated and part 4 was interrupted before its metadata was
+	// saved
+	partsDir := storage.multipart.getPartsDir(uploadID)
+	if err := os.WriteFile(filepath.Join(partsDir, "part-2"), []byte("part"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(partsDir, "part-4"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Shutdown(); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	// Restart
+	storage, err = New(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to restart storage: %v", err)
+	}
+	defer storage.Stop()
+
+	uploads := storage.ListMultipartUploads(bucket)
+	if len(uploads) != 1 || uploads[0].UploadID != uploadID || uploads[0].Key != "big.bin" {
+		t.Fatalf("Expected the upload to be resumed, got %+v", uploads)
+	}
+
+	listed, err := storage.ListMultipartUploadParts(uploadID)
+	if err != nil {
+		t.Fatalf("Failed to list parts: %v", err)
+	}
+	if len(listed) != 2 || listed[0].PartNumber != 1 || listed[1].PartNumber != 3 {
+		t.Errorf("Expected parts 1 and 3, got %+v", listed)
+	}
+	if _, err := os.Stat(filepath.Join(partsDir, "part-4")); !os.IsNotExist(err) {
+		t.Error("Expected the unrecorded part file to be removed")
+	}
+
+	// The client uploads the dropped part again and completes
+	etag, err := storage.UploadPart(uploadID, 2, strings.NewReader("part two,"), 9)
+	if err != nil {
+		t.Fatalf("Failed to upload part 2 again: %v", err)
+	}
+	parts[1].ETag = etag
+
+	info, err := storage.CompleteMultipartUpload(uploadID, parts)
+	if err != nil {
+		t.Fatalf("Failed to complete upload: %v", err)
+	}
+	if info.ContentType != "application/octet-stream" || info.UserMetadata["owner"] != "ci" {
+		t.Errorf("Expected metadata to survive the restart, got %+v", info)
+	}
+
+	content := readVersion(t, storage, bucket, "big.bin", "")
+	if content != "part one,part two,part three" {
+		t.Errorf("Unexpected content: %q", content)
+	}
+}
+
+func TestStaleUploadsExpireOnRestart(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "s3dir-multipart-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := New(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	bucket := "test-bucket"
+	if err := storage.CreateBucket(bucket); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	staleID, err := storage.InitiateMultipartUpload(bucket, "stale.txt")
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %v", err)
+	}
+	activeID, err := storage.InitiateMultipartUpload(bucket, "active.txt")
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %v", err)
+	}
+
+	// The stale upload saw its last activity before the server went down
+	upload := storage.multipart.uploads[staleID]
+	upload.LastActivity = time.Now().Add(-defaultStaleUploadAge - time.Hour)
+	if err := storage.Shutdown(); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	storage, err = New(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to restart storage: %v", err)
+	}
+	defer storage.Stop()
+
+	uploads := storage.ListMultipartUploads(bucket)
+	if len(uploads) != 1 || uploads[0].UploadID != activeID {
+		t.Errorf("Expected only the active upload to be resumed, got %+v", uploads)
+	}
+	if _, err := os.Stat(storage.multipart.getPartsDir(staleID)); !os.IsNotExist(err) {
+		t.Error("Expected the stale upload's directory to be removed")
+	}
+	if active, removed := storage.Multipart().ActiveUploads(), storage.Multipart().StaleUploadsRemoved(); active != 1 || removed != 1 {
+		t.Errorf("Expected 1 active and 1 removed upload, got %d and %d", active, removed)
+	}
+}
+
+func TestCompleteUploadRecordsParts(t *testing.T) {
+	tmpDir := t.TempDir()
+	storage, err := New(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	bucket := "test-bucket"
+	if err := storage.CreateBucket(bucket); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	uploadID, err := storage.InitiateMultipartUpload(bucket, "object.txt")
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %v", err)
+	}
+
+	// Parts are recorded in part number order, not upload order
+	contents := map[int]string{2: "second-part", 1: "first-part-", 5: "fifth"}
+	var parts []CompletePart
+	for _, number := range []int{2, 1, 5} {
+		etag, err := storage.UploadPart(uploadID, number, strings.NewReader(contents[number]), int64(len(contents[number])))
+		if err != nil {
+			t.Fatalf("Failed to upload part %d: %v", number, err)
+		}
+		parts = append(parts, CompletePart{PartNumber: number, ETag: etag})
+	}
+	if _, err := storage.CompleteMultipartUpload(uploadID, parts); err != nil {
+		t.Fatalf("Failed to complete upload: %v", err)
+	}
+
+	// The layout is read back from the metadata sidecar after a restart
+	storage.Stop()
+	storage, err = New(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to restart storage: %v", err)
+	}
+	defer storage.Stop()
+
+	info, err := storage.HeadObject(bucket, "object.txt")
+	if err != nil {
+		t.Fatalf("Failed to head object: %v", err)
+	}
+	expected := []ObjectPart{
+		{PartNumber: 1, Offset: 0, Size: 11},
+		{PartNumber: 2, Offset: 11, Size: 11},
+		{PartNumber: 5, Offset: 22, Size: 5},
+	}
+	if len(info.Parts) != len(expected) {
+		t.Fatalf("Expected %d parts, got %+v", len(expected), info.Parts)
+	}
+	for i, part := range info.Parts {
+		if part.PartNumber != expected[i].PartNumber || part.Offset != expected[i].Offset || part.Size != expected[i].Size {
+			t.Errorf("Part %d: expected %+v, got %+v", i, expected[i], part)
+		}
+	}
+
+	// Objects written in one piece have no parts
+	storage.PutObject(bucket, "single.txt", strings.NewReader("data"), 4)
+	if info, _ := storage.HeadObject(bucket, "single.txt"); len(info.Parts) != 0 {
+		t.Errorf("Expected no parts for a single PUT, got %+v", info.Parts)
+	}
+}
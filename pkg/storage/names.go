@@ -0,0 +1,53 @@
+package storage
+
+import "unicode/utf8"
+
+// MaxKeyLength is the longest object key, in bytes, S3 allows
+const MaxKeyLength = 1024
+
+// tempFilePrefix starts the names of the temporary files written alongside
+// objects while they are stored
+const tempFilePrefix = ".s3dir-"
+
+// ValidBucketName reports whether a bucket name can be stored as a directory
+// without escaping the base directory or colliding with the internal
+// directories, whose names start with a dot. It only checks safety: servers
+// apply the stricter S3 naming rules when buckets are created
+func ValidBucketName(bucket string) bool {
+	if bucket == "" || len(bucket) > 255 {
+		return false
+	}
+	for i := 0; i < len(bucket); i++ {
+		c := bucket[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case (c == '.' || c == '-' || c == '_') && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ValidKey checks that an object key is one S3 accepts. Keys longer than
+// MaxKeyLength return ErrKeyTooLong and keys that are not UTF-8 return
+// ErrInvalidKey. Every other key can be stored, escaped where the filesystem
+// could not hold it as it is
+func ValidKey(key string) error {
+	if len(key) > MaxKeyLength {
+		return ErrKeyTooLong
+	}
+	if !utf8.ValidString(key) {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// checkObjectName rejects object names that cannot be stored, including
+// bucket names that would resolve outside the bucket's directory
+func checkObjectName(bucket, key string) error {
+	if !ValidBucketName(bucket) {
+		return ErrNoSuchBucket
+	}
+	return ValidKey(key)
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want error
+	}{
+		{"key", nil},
+		{"dir/sub/key.txt", nil},
+		{"dir/", nil},
+		{".hidden", nil},
+		{"a..b", nil},
+		{strings.Repeat("k", MaxKeyLength), nil},
+		{strings.Repeat("k", MaxKeyLength+1), ErrKeyTooLong},
+		{"../escape", nil},
+		{"nul\x00byte", nil},
+		{"invalid\xffutf8", ErrInvalidKey},
+	}
+
+	for _, tt := range tests {
+		if err := ValidKey(tt.key); !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("ValidKey(%q) = %v, want %v", tt.key, err, tt.want)
+		}
+	}
+}
+
+func TestStorageRejectsEscapingNames(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	storage.CreateBucket("bucket")
+
+	if err := storage.CreateBucket(".metadata"); !errors.Is(err, ErrInvalidBucketName) {
+		t.Errorf("Expected ErrInvalidBucketName, got %v", err)
+	}
+	if err := storage.HeadBucket(".."); !errors.Is(err, ErrNoSuchBucket) {
+		t.Errorf("Expected ErrNoSuchBucket, got %v", err)
+	}
+	if err := storage.PutObject("..", "escape", strings.NewReader("x"), 1); !errors.Is(err, ErrNoSuchBucket) {
+		t.Errorf("Expected ErrNoSuchBucket, got %v", err)
+	}
+	if err := storage.PutObject("bucket", "invalid\xff", strings.NewReader("x"), 1); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+	if _, _, err := storage.GetObject("bucket", "../../etc/passwd"); !errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("Expected ErrNoSuchKey, got %v", err)
+	}
+}
@@ -1,14 +1,13 @@
 package storage
 
 import (
-	"crypto/md5"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,12 +19,45 @@ type ObjectInfo struct {
 	ETag         string
 	ContentType  string
 	UserMetadata map[string]string
+	Tags         map[string]string
+	// VersionID is empty for objects in buckets that have never been
+	// versioned
+	VersionID    string
+	IsLatest     bool
+	DeleteMarker bool
+	// Checksum is nil for objects stored before checksums were recorded
+	Checksum *Checksum
+	// Parts lists the parts of an object completed from a multipart upload,
+	// in part number order
+	Parts []ObjectPart
+}
+
+// ObjectMetadata is the client-supplied metadata stored with an object
+type ObjectMetadata struct {
+	ContentType  string
+	UserMetadata map[string]string
+	Tags         map[string]string
+	// ChecksumAlgorithm chooses the additional checksum stored with the
+	// object, DefaultChecksumAlgorithm if empty. Multipart uploads also take
+	// a ChecksumType, DefaultChecksumType(ChecksumAlgorithm) if empty
+	ChecksumAlgorithm string
+	ChecksumType      string
+	// Digests are checked against the content of PutObjectWithMetadata
+	Digests Digests
+	// Conditions make PutObjectWithMetadata depend on the object it replaces
+	Conditions WriteConditions
 }
 
 // Storage provides filesystem-based storage for S3 objects
 type Storage struct {
 	baseDir   string
 	multipart *MultipartManager
+	// locks serializes commits to the same key
+	locks keyLocks
+	// lifecycleStop stops the lifecycle worker, if started. lifecycleMu
+	// guards it
+	lifecycleStop chan struct{}
+	lifecycleMu   sync.Mutex
 }
 
 // New creates a new Storage instance
@@ -39,112 +71,125 @@ func New(baseDir string) (*Storage, error) {
 		return nil, fmt.Errorf("failed to create base directory: %w", err)
 	}
 
-	return &Storage{
+	s := &Storage{
 		baseDir:   absPath,
 		multipart: NewMultipartManager(absPath),
-	}, nil
+	}
+	s.multipart.commit = s.commitObject
+	s.multipart.abortAfter = s.abortUploadAfter
+
+	// Uploads resumed from a previous run may have gone stale meanwhile
+	s.multipart.cleanupStaleUploads()
+
+	return s, nil
 }
 
 // PutObject stores an object
 func (s *Storage) PutObject(bucket, key string, reader io.Reader, size int64) error {
-	_, err := s.PutObjectWithMetadata(bucket, key, reader, size, "", nil)
+	_, err := s.PutObjectWithMetadata(bucket, key, reader, size, ObjectMetadata{})
 	return err
 }
 
-// PutObjectWithMetadata stores an object along with its content type and user
-// metadata, returning the quoted MD5 ETag of the content
-func (s *Storage) PutObjectWithMetadata(bucket, key string, reader io.Reader, size int64, contentType string, userMetadata map[string]string) (string, error) {
+// PutObjectWithMetadata stores an object along with its content type, user
+// metadata and tags, returning the stored object's info including the quoted
+// MD5 ETag of the content and, in versioned buckets, its version ID
+func (s *Storage) PutObjectWithMetadata(bucket, key string, reader io.Reader, size int64, metadata ObjectMetadata) (*ObjectInfo, error) {
+	if err := checkObjectName(bucket, key); err != nil {
+		return nil, err
+	}
+
+	// Conditions that already fail are reported before the content is read
+	if err := s.checkConditions(bucket, key, metadata.Conditions); err != nil {
+		return nil, err
+	}
+
 	objectPath := s.objectPath(bucket, key)
 
 	// Create parent directories
-	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
-		return "", fmt.Errorf("failed to create directory: %w", err)
+	if err := makeDirs(filepath.Dir(objectPath)); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
 	}
 
 	// Create temporary file
-	tmpFile, err := os.CreateTemp(filepath.Dir(objectPath), ".s3dir-tmp-*")
+	tmpFile, err := os.CreateTemp(filepath.Dir(objectPath), tempFilePrefix+"tmp-*")
 	if err != nil {
-		return "", fmt.Errorf("failed to create temporary file: %w", err)
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
 	}
 	tmpPath := tmpFile.Name()
 	defer os.Remove(tmpPath)
 
 	// Copy data to temporary file using a fixed-size buffer to limit memory usage,
-	// calculating the content MD5 in the same pass
+	// calculating the content MD5 and checksum in the same pass
 	// This ensures we stream data in 32KB chunks rather than allocating large buffers
-	hash := md5.New()
+	algorithm := metadata.ChecksumAlgorithm
+	if algorithm == "" {
+		algorithm = DefaultChecksumAlgorithm
+	}
+	hash := newContentHash(algorithm)
 	buffer := make([]byte, 32*1024) // 32KB buffer
 	_, err = io.CopyBuffer(io.MultiWriter(tmpFile, hash), reader, buffer)
 	closeErr := tmpFile.Close()
 	if err != nil {
-		return "", fmt.Errorf("failed to write object: %w", err)
+		return nil, fmt.Errorf("failed to write object: %w", err)
 	}
 	if closeErr != nil {
-		return "", fmt.Errorf("failed to close temporary file: %w", closeErr)
+		return nil, fmt.Errorf("failed to close temporary file: %w", closeErr)
 	}
-
-	// Move temporary file to final location
-	if err := os.Rename(tmpPath, objectPath); err != nil {
-		return "", fmt.Errorf("failed to move object: %w", err)
+	if err := hash.verify(metadata.Digests); err != nil {
+		return nil, err
 	}
 
-	etag := hex.EncodeToString(hash.Sum(nil))
+	// Move temporary file to final location
 	meta := &objectMetadata{
-		ETag:         etag,
-		ContentType:  contentType,
-		UserMetadata: userMetadata,
-	}
-	if err := writeObjectMetadataFile(s.baseDir, bucket, key, meta); err != nil {
-		return "", fmt.Errorf("failed to write metadata: %w", err)
+		ETag:         hash.etag(),
+		ContentType:  metadata.ContentType,
+		UserMetadata: metadata.UserMetadata,
+		Tags:         metadata.Tags,
+		Checksum:     hash.sum(),
 	}
-
-	return fmt.Sprintf("\"%s\"", etag), nil
+	return s.commitObject(bucket, key, tmpPath, meta, metadata.Conditions)
 }
 
 // GetObject retrieves an object
 func (s *Storage) GetObject(bucket, key string) (io.ReadCloser, *ObjectInfo, error) {
-	objectPath := s.objectPath(bucket, key)
+	return s.GetObjectVersion(bucket, key, "")
+}
+
+// GetObjectVersion retrieves a version of an object. An empty versionID
+// means the current version. Delete markers cannot be retrieved
+func (s *Storage) GetObjectVersion(bucket, key, versionID string) (io.ReadCloser, *ObjectInfo, error) {
+	return s.openVersion(bucket, key, versionID)
+}
 
-	stat, err := os.Stat(objectPath)
+// openVersion opens the data file of a version of an object
+func (s *Storage) openVersion(bucket, key, versionID string) (*os.File, *ObjectInfo, error) {
+	path, info, err := s.resolveVersion(bucket, key, versionID)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil, fmt.Errorf("object not found")
-		}
-		return nil, nil, fmt.Errorf("failed to stat object: %w", err)
+		return nil, nil, err
 	}
-
-	if stat.IsDir() {
-		return nil, nil, fmt.Errorf("cannot get directory as object")
+	if info.DeleteMarker {
+		return nil, nil, ErrNoSuchKey
 	}
 
-	file, err := os.Open(objectPath)
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to open object: %w", err)
 	}
 
-	return file, s.objectInfo(bucket, key, stat), nil
+	return file, info, nil
 }
 
 // GetObjectRange retrieves a byte range of an object. start is the first byte
 // offset and length the number of bytes to read
 func (s *Storage) GetObjectRange(bucket, key string, start, length int64) (io.ReadCloser, *ObjectInfo, error) {
-	objectPath := s.objectPath(bucket, key)
-
-	stat, err := os.Stat(objectPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil, fmt.Errorf("object not found")
-		}
-		return nil, nil, fmt.Errorf("failed to stat object: %w", err)
-	}
-
-	if stat.IsDir() {
-		return nil, nil, fmt.Errorf("cannot get directory as object")
-	}
+	return s.GetObjectRangeVersion(bucket, key, "", start, length)
+}
 
-	file, err := os.Open(objectPath)
+// GetObjectRangeVersion retrieves a byte range of a version of an object
+func (s *Storage) GetObjectRangeVersion(bucket, key, versionID string, start, length int64) (io.ReadCloser, *ObjectInfo, error) {
+	file, info, err := s.openVersion(bucket, key, versionID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open object: %w", err)
+		return nil, nil, err
 	}
 
 	if _, err := file.Seek(start, io.SeekStart); err != nil {
@@ -157,7 +202,7 @@ func (s *Storage) GetObjectRange(bucket, key string, start, length int64) (io.Re
 		file:   file,
 	}
 
-	return reader, s.objectInfo(bucket, key, stat), nil
+	return reader, info, nil
 }
 
 // rangeReadCloser wraps a limited reader over an open file so the file is
@@ -186,6 +231,10 @@ func (s *Storage) objectInfo(bucket, key string, stat os.FileInfo) *ObjectInfo {
 		}
 		info.ContentType = meta.ContentType
 		info.UserMetadata = meta.UserMetadata
+		info.Tags = meta.Tags
+		info.VersionID = meta.VersionID
+		info.Checksum = meta.Checksum
+		info.Parts = meta.Parts
 	}
 
 	if info.ETag == "" {
@@ -199,14 +248,21 @@ func (s *Storage) objectInfo(bucket, key string, stat os.FileInfo) *ObjectInfo {
 // fixed-size buffer while calculating the MD5 of the content. The source
 // object's content type and user metadata are carried over
 func (s *Storage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string) (*ObjectInfo, error) {
-	return s.CopyObjectWithMetadata(srcBucket, srcKey, dstBucket, dstKey, false, "", nil)
+	return s.CopyObjectWithMetadata(srcBucket, srcKey, "", dstBucket, dstKey, false, false, ObjectMetadata{})
 }
 
-// CopyObjectWithMetadata copies an object server-side. When replaceMetadata is
-// true the given content type and user metadata are stored on the destination
-// instead of the source object's metadata
-func (s *Storage) CopyObjectWithMetadata(srcBucket, srcKey, dstBucket, dstKey string, replaceMetadata bool, contentType string, userMetadata map[string]string) (*ObjectInfo, error) {
-	reader, srcInfo, err := s.GetObject(srcBucket, srcKey)
+// CopyObjectWithMetadata copies an object server-side, from srcVersionID of
+// the source or its current version if empty. When replaceMetadata is true the
+// given content type and user metadata are stored on the destination instead
+// of the source object's, and likewise the given tags when replaceTags is true.
+// The copy's checksum uses the given algorithm, or else that of the source's
+// full object checksum
+func (s *Storage) CopyObjectWithMetadata(srcBucket, srcKey, srcVersionID, dstBucket, dstKey string, replaceMetadata, replaceTags bool, metadata ObjectMetadata) (*ObjectInfo, error) {
+	if err := checkObjectName(dstBucket, dstKey); err != nil {
+		return nil, err
+	}
+
+	reader, srcInfo, err := s.GetObjectVersion(srcBucket, srcKey, srcVersionID)
 	if err != nil {
 		return nil, err
 	}
@@ -215,22 +271,23 @@ func (s *Storage) CopyObjectWithMetadata(srcBucket, srcKey, dstBucket, dstKey st
 	dstPath := s.objectPath(dstBucket, dstKey)
 
 	// Create parent directories
-	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+	if err := makeDirs(filepath.Dir(dstPath)); err != nil {
 		return nil, fmt.Errorf("failed to create directory: %w", err)
 	}
 
 	// Create temporary file
-	tmpFile, err := os.CreateTemp(filepath.Dir(dstPath), ".s3dir-tmp-*")
+	tmpFile, err := os.CreateTemp(filepath.Dir(dstPath), tempFilePrefix+"tmp-*")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temporary file: %w", err)
 	}
 	tmpPath := tmpFile.Name()
 	defer os.Remove(tmpPath)
 
-	// Copy data while calculating MD5, using a fixed-size buffer to limit memory usage
-	hash := md5.New()
+	// Copy data while calculating MD5 and checksum, using a fixed-size buffer
+	// to limit memory usage
+	hash := newContentHash(copyChecksumAlgorithm(srcInfo, metadata.ChecksumAlgorithm))
 	buffer := make([]byte, 32*1024) // 32KB buffer
-	written, err := io.CopyBuffer(io.MultiWriter(tmpFile, hash), reader, buffer)
+	_, err = io.CopyBuffer(io.MultiWriter(tmpFile, hash), reader, buffer)
 	closeErr := tmpFile.Close()
 	if err != nil {
 		return nil, fmt.Errorf("failed to copy object: %w", err)
@@ -239,77 +296,51 @@ func (s *Storage) CopyObjectWithMetadata(srcBucket, srcKey, dstBucket, dstKey st
 		return nil, fmt.Errorf("failed to close temporary file: %w", closeErr)
 	}
 
-	// Move temporary file to final location
-	if err := os.Rename(tmpPath, dstPath); err != nil {
-		return nil, fmt.Errorf("failed to move object: %w", err)
-	}
-
-	stat, err := os.Stat(dstPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to stat object: %w", err)
-	}
-
-	etag := hex.EncodeToString(hash.Sum(nil))
 	meta := &objectMetadata{
-		ETag:         etag,
-		ContentType:  contentType,
-		UserMetadata: userMetadata,
+		ETag:         hash.etag(),
+		ContentType:  metadata.ContentType,
+		UserMetadata: metadata.UserMetadata,
+		Tags:         metadata.Tags,
+		Checksum:     hash.sum(),
 	}
 	if !replaceMetadata {
 		// Carry over the source object's metadata (S3 COPY directive)
 		meta.ContentType = srcInfo.ContentType
 		meta.UserMetadata = srcInfo.UserMetadata
 	}
-	if err := writeObjectMetadataFile(s.baseDir, dstBucket, dstKey, meta); err != nil {
-		return nil, fmt.Errorf("failed to write metadata: %w", err)
+	if !replaceTags {
+		meta.Tags = srcInfo.Tags
 	}
 
-	return &ObjectInfo{
-		Key:          dstKey,
-		Size:         written,
-		LastModified: stat.ModTime(),
-		ETag:         fmt.Sprintf("\"%s\"", etag),
-		ContentType:  meta.ContentType,
-		UserMetadata: meta.UserMetadata,
-	}, nil
+	// Move temporary file to final location
+	return s.commitObject(dstBucket, dstKey, tmpPath, meta, WriteConditions{})
 }
 
-// DeleteObject deletes an object
+// DeleteObject deletes an object. In a versioned bucket this creates a delete
+// marker, see DeleteObjectVersion
 func (s *Storage) DeleteObject(bucket, key string) error {
-	objectPath := s.objectPath(bucket, key)
-
-	err := os.Remove(objectPath)
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to delete object: %w", err)
-	}
-
-	removeObjectMetadataFile(s.baseDir, bucket, key)
-
-	// Clean up empty parent directories
-	s.cleanupEmptyDirs(filepath.Dir(objectPath), s.bucketPath(bucket))
-	metadataBucketDir := filepath.Join(s.baseDir, metadataDirName, bucket)
-	s.cleanupEmptyDirs(filepath.Dir(objectMetadataPath(s.baseDir, bucket, key)), metadataBucketDir)
-
-	return nil
+	_, _, err := s.DeleteObjectVersion(bucket, key, "")
+	return err
 }
 
 // HeadObject retrieves object metadata
 func (s *Storage) HeadObject(bucket, key string) (*ObjectInfo, error) {
-	objectPath := s.objectPath(bucket, key)
-
-	stat, err := os.Stat(objectPath)
+	info, err := s.HeadObjectVersion(bucket, key, "")
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("object not found")
-		}
-		return nil, fmt.Errorf("failed to stat object: %w", err)
+		return nil, err
 	}
-
-	if stat.IsDir() {
-		return nil, fmt.Errorf("cannot head directory as object")
+	if info.DeleteMarker {
+		return nil, ErrNoSuchKey
 	}
+	return info, nil
+}
 
-	return s.objectInfo(bucket, key, stat), nil
+// HeadObjectVersion retrieves the metadata of a version of an object. An
+// empty versionID means the latest version. Unlike the other accessors this
+// returns delete markers, with DeleteMarker set, so callers can report them
+func (s *Storage) HeadObjectVersion(bucket, key, versionID string) (*ObjectInfo, error) {
+	_, info, err := s.resolveVersion(bucket, key, versionID)
+	return info, err
 }
 
 // ListObjects lists objects in a bucket with optional prefix and delimiter
@@ -318,27 +349,23 @@ func (s *Storage) ListObjects(bucket, prefix, delimiter string, maxKeys int) ([]
 	return objects, commonPrefixes, err
 }
 
-// listEntry is a single result of a listing: either an object or a rolled-up
-// common prefix
-type listEntry struct {
-	name     string
-	isPrefix bool
-	stat     os.FileInfo
-}
-
 // ListObjectsPage lists objects in a bucket in lexicographic key order,
 // returning entries strictly after marker, up to maxKeys objects and common
 // prefixes combined (maxKeys <= 0 means unlimited). It reports whether the
 // listing was truncated and the marker to resume from
 func (s *Storage) ListObjectsPage(bucket, prefix, delimiter, marker string, maxKeys int) ([]ObjectInfo, []string, bool, string, error) {
+	if !ValidBucketName(bucket) {
+		return nil, nil, false, "", ErrNoSuchBucket
+	}
 	bucketPath := s.bucketPath(bucket)
 
 	if _, err := os.Stat(bucketPath); os.IsNotExist(err) {
-		return nil, nil, false, "", fmt.Errorf("bucket not found")
+		return nil, nil, false, "", ErrNoSuchBucket
 	}
 
 	// Collect all keys matching the prefix
-	var keys []listEntry
+	var keys []string
+	stats := make(map[string]os.FileInfo)
 	err := filepath.Walk(bucketPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Skip files we can't access
@@ -348,28 +375,40 @@ func (s *Storage) ListObjectsPage(bucket, prefix, delimiter, marker string, maxK
 			return nil
 		}
 
+		// Skip files being written
+		if strings.HasPrefix(info.Name(), tempFilePrefix) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		// Get relative path from bucket
-		relPath, err := filepath.Rel(bucketPath, path)
+		relPath, err := filepath.Rel(bucketPath, storedPath(path))
 		if err != nil {
 			return nil
 		}
 
-		// Convert to S3-style key (forward slashes)
-		key := filepath.ToSlash(relPath)
+		// Convert to S3-style key
+		key, partial := decodeKeyPath(filepath.ToSlash(relPath))
 
 		if info.IsDir() {
 			// Skip subtrees that cannot contain keys with the prefix
-			if prefix != "" && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+			if !partial {
+				key += "/"
+			}
+			if prefix != "" && !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) {
 				return filepath.SkipDir
 			}
 			return nil
 		}
 
-		if prefix != "" && !strings.HasPrefix(key, prefix) {
+		if partial || prefix != "" && !strings.HasPrefix(key, prefix) {
 			return nil
 		}
 
-		keys = append(keys, listEntry{name: key, stat: info})
+		keys = append(keys, key)
+		stats[key] = info
 		return nil
 	})
 	if err != nil {
@@ -377,56 +416,14 @@ func (s *Storage) ListObjectsPage(bucket, prefix, delimiter, marker string, maxK
 	}
 
 	// S3 listings are in lexicographic key order; filesystem walk order is not
-	sort.Slice(keys, func(i, j int) bool {
-		return keys[i].name < keys[j].name
-	})
-
-	// Roll up keys containing the delimiter into common prefixes. Keys sharing
-	// a common prefix are contiguous in sorted order, so deduplicating against
-	// the previous entry is sufficient
-	var entries []listEntry
-	for _, k := range keys {
-		if delimiter != "" {
-			remainder := strings.TrimPrefix(k.name, prefix)
-			if idx := strings.Index(remainder, delimiter); idx != -1 {
-				commonPrefix := prefix + remainder[:idx+len(delimiter)]
-				if len(entries) > 0 && entries[len(entries)-1].isPrefix && entries[len(entries)-1].name == commonPrefix {
-					continue
-				}
-				entries = append(entries, listEntry{name: commonPrefix, isPrefix: true})
-				continue
-			}
-		}
-		entries = append(entries, k)
-	}
-
-	// Resume strictly after the marker
-	if marker != "" {
-		start := sort.Search(len(entries), func(i int) bool {
-			return entries[i].name > marker
-		})
-		entries = entries[start:]
-	}
+	sort.Strings(keys)
 
-	truncated := maxKeys > 0 && len(entries) > maxKeys
-	if truncated {
-		entries = entries[:maxKeys]
-	}
-
-	nextMarker := ""
-	if truncated {
-		nextMarker = entries[len(entries)-1].name
-	}
+	objectKeys, commonPrefixes, truncated, nextMarker := pageKeys(keys, prefix, delimiter, marker, maxKeys)
 
 	// Build results, reading metadata sidecars only for the returned page
 	var objects []ObjectInfo
-	var commonPrefixes []string
-	for _, e := range entries {
-		if e.isPrefix {
-			commonPrefixes = append(commonPrefixes, e.name)
-		} else {
-			objects = append(objects, *s.objectInfo(bucket, e.name, e.stat))
-		}
+	for _, key := range objectKeys {
+		objects = append(objects, *s.objectInfo(bucket, key, stats[key]))
 	}
 
 	return objects, commonPrefixes, truncated, nextMarker, nil
@@ -434,10 +431,13 @@ func (s *Storage) ListObjectsPage(bucket, prefix, delimiter, marker string, maxK
 
 // CreateBucket creates a new bucket (directory)
 func (s *Storage) CreateBucket(bucket string) error {
+	if !ValidBucketName(bucket) {
+		return ErrInvalidBucketName
+	}
 	bucketPath := s.bucketPath(bucket)
 
 	if _, err := os.Stat(bucketPath); err == nil {
-		return fmt.Errorf("bucket already exists")
+		return ErrBucketExists
 	}
 
 	if err := os.MkdirAll(bucketPath, 0755); err != nil {
@@ -449,44 +449,58 @@ func (s *Storage) CreateBucket(bucket string) error {
 
 // DeleteBucket deletes a bucket (directory)
 func (s *Storage) DeleteBucket(bucket string) error {
+	if !ValidBucketName(bucket) {
+		return ErrNoSuchBucket
+	}
 	bucketPath := s.bucketPath(bucket)
 
 	entries, err := os.ReadDir(bucketPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("bucket not found")
+			return ErrNoSuchBucket
 		}
 		return fmt.Errorf("failed to read bucket: %w", err)
 	}
 
 	if len(entries) > 0 {
-		return fmt.Errorf("bucket not empty")
+		return ErrBucketNotEmpty
+	}
+
+	// Noncurrent versions and delete markers also keep a bucket from being
+	// deleted
+	if versions, err := os.ReadDir(s.versionsBucketDir(bucket)); err == nil && len(versions) > 0 {
+		return ErrBucketNotEmpty
 	}
 
 	if err := os.Remove(bucketPath); err != nil {
 		return fmt.Errorf("failed to delete bucket: %w", err)
 	}
 
-	// Remove any leftover metadata sidecars for the bucket
+	// Remove any leftover metadata sidecars and configuration for the bucket
 	os.RemoveAll(filepath.Join(s.baseDir, metadataDirName, bucket))
+	os.RemoveAll(filepath.Join(s.baseDir, bucketConfigDirName, bucket))
+	os.RemoveAll(s.versionsBucketDir(bucket))
 
 	return nil
 }
 
 // HeadBucket checks if a bucket exists
 func (s *Storage) HeadBucket(bucket string) error {
+	if !ValidBucketName(bucket) {
+		return ErrNoSuchBucket
+	}
 	bucketPath := s.bucketPath(bucket)
 
 	stat, err := os.Stat(bucketPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("bucket not found")
+			return ErrNoSuchBucket
 		}
 		return fmt.Errorf("failed to stat bucket: %w", err)
 	}
 
 	if !stat.IsDir() {
-		return fmt.Errorf("bucket is not a directory")
+		return fmt.Errorf("%w: not a directory", ErrNoSuchBucket)
 	}
 
 	return nil
@@ -517,7 +531,7 @@ func (s *Storage) bucketPath(bucket string) string {
 
 // objectPath returns the filesystem path for an object
 func (s *Storage) objectPath(bucket, key string) string {
-	return filepath.Join(s.baseDir, bucket, filepath.FromSlash(key))
+	return filePath(filepath.Join(s.baseDir, bucket, keyPath(key)))
 }
 
 // cleanupEmptyDirs removes empty parent directories up to the stop path
@@ -536,17 +550,20 @@ func (s *Storage) cleanupEmptyDirs(path, stopPath string) {
 
 // InitiateMultipartUpload starts a new multipart upload
 func (s *Storage) InitiateMultipartUpload(bucket, key string) (string, error) {
-	return s.InitiateMultipartUploadWithMetadata(bucket, key, "", nil)
+	return s.InitiateMultipartUploadWithMetadata(bucket, key, ObjectMetadata{})
 }
 
 // InitiateMultipartUploadWithMetadata starts a new multipart upload, recording
-// the content type and user metadata to store on the completed object
-func (s *Storage) InitiateMultipartUploadWithMetadata(bucket, key, contentType string, userMetadata map[string]string) (string, error) {
+// the content type, user metadata and tags to store on the completed object
+func (s *Storage) InitiateMultipartUploadWithMetadata(bucket, key string, metadata ObjectMetadata) (string, error) {
 	// Verify bucket exists
 	if err := s.HeadBucket(bucket); err != nil {
 		return "", err
 	}
-	return s.multipart.InitiateUpload(bucket, key, contentType, userMetadata)
+	if err := ValidKey(key); err != nil {
+		return "", err
+	}
+	return s.multipart.InitiateUpload(bucket, key, metadata)
 }
 
 // UploadPart uploads a part of a multipart upload
@@ -554,34 +571,28 @@ func (s *Storage) UploadPart(uploadID string, partNumber int, reader io.Reader,
 	return s.multipart.UploadPart(uploadID, partNumber, reader, size)
 }
 
-// UploadPartCopy copies data from an existing object into a part of a
-// multipart upload. rangeStart/rangeEnd are inclusive byte offsets; pass
-// rangeStart = -1 to copy the whole source object
-func (s *Storage) UploadPartCopy(uploadID string, partNumber int, srcBucket, srcKey string, rangeStart, rangeEnd int64) (string, error) {
-	srcPath := s.objectPath(srcBucket, srcKey)
-
-	stat, err := os.Stat(srcPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return "", fmt.Errorf("object not found")
-		}
-		return "", fmt.Errorf("failed to stat object: %w", err)
-	}
-	if stat.IsDir() {
-		return "", fmt.Errorf("object not found")
-	}
+// UploadPartWithDigests uploads a part of a multipart upload, checking it
+// against the digests sent with it. checksumAlgorithm is the algorithm of
+// their checksum, if any
+func (s *Storage) UploadPartWithDigests(uploadID string, partNumber int, reader io.Reader, size int64, checksumAlgorithm string, digests Digests) (*UploadPart, error) {
+	return s.multipart.UploadPartWithDigests(uploadID, partNumber, reader, size, checksumAlgorithm, digests)
+}
 
-	file, err := os.Open(srcPath)
+// UploadPartCopy copies data from an existing object, or srcVersionID of it if
+// not empty, into a part of a multipart upload. rangeStart/rangeEnd are
+// inclusive byte offsets; pass rangeStart = -1 to copy the whole source object
+func (s *Storage) UploadPartCopy(uploadID string, partNumber int, srcBucket, srcKey, srcVersionID string, rangeStart, rangeEnd int64) (string, error) {
+	file, info, err := s.openVersion(srcBucket, srcKey, srcVersionID)
 	if err != nil {
-		return "", fmt.Errorf("failed to open object: %w", err)
+		return "", err
 	}
 	defer file.Close()
 
 	var reader io.Reader = file
-	size := stat.Size()
+	size := info.Size
 	if rangeStart >= 0 {
-		if rangeStart > rangeEnd || rangeEnd >= stat.Size() {
-			return "", fmt.Errorf("invalid range")
+		if rangeStart > rangeEnd || rangeEnd >= info.Size {
+			return "", ErrInvalidRange
 		}
 		if _, err := file.Seek(rangeStart, io.SeekStart); err != nil {
 			return "", fmt.Errorf("failed to seek object: %w", err)
@@ -593,9 +604,28 @@ func (s *Storage) UploadPartCopy(uploadID string, partNumber int, srcBucket, src
 	return s.multipart.UploadPart(uploadID, partNumber, reader, size)
 }
 
-// CompleteMultipartUpload completes a multipart upload
-func (s *Storage) CompleteMultipartUpload(uploadID string, parts []CompletePart) (string, error) {
-	return s.multipart.CompleteUpload(uploadID, parts)
+// CompleteMultipartUpload completes a multipart upload, returning the info of
+// the assembled object
+func (s *Storage) CompleteMultipartUpload(uploadID string, parts []CompletePart) (*ObjectInfo, error) {
+	return s.CompleteMultipartUploadWithConditions(uploadID, parts, WriteConditions{})
+}
+
+// CompleteMultipartUploadWithConditions completes a multipart upload if the
+// object it replaces meets conditions. The upload is kept when they fail
+func (s *Storage) CompleteMultipartUploadWithConditions(uploadID string, parts []CompletePart, conditions WriteConditions) (*ObjectInfo, error) {
+	upload, err := s.multipart.upload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkConditions(upload.Bucket, upload.Key, conditions); err != nil {
+		return nil, err
+	}
+	return s.multipart.CompleteUploadWithConditions(uploadID, parts, conditions)
+}
+
+// GetMultipartUpload returns an in-progress multipart upload
+func (s *Storage) GetMultipartUpload(uploadID string) (*MultipartUpload, error) {
+	return s.multipart.upload(uploadID)
 }
 
 // AbortMultipartUpload aborts a multipart upload
@@ -608,6 +638,11 @@ func (s *Storage) ListMultipartUploadParts(uploadID string) ([]*UploadPart, erro
 	return s.multipart.ListParts(uploadID)
 }
 
+// Multipart returns the manager of in-progress multipart uploads
+func (s *Storage) Multipart() *MultipartManager {
+	return s.multipart
+}
+
 // ListMultipartUploads lists in-progress multipart uploads
 func (s *Storage) ListMultipartUploads(bucket string) []*MultipartUpload {
 	return s.multipart.ListUploads(bucket)
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// GetObjectTagging returns the tags of a version of an object (the current
+// version if versionID is empty) along with the version's ID
+func (s *Storage) GetObjectTagging(bucket, key, versionID string) (map[string]string, string, error) {
+	_, info, err := s.resolveVersion(bucket, key, versionID)
+	if err != nil {
+		return nil, "", err
+	}
+	if info.DeleteMarker {
+		return nil, "", ErrNoSuchKey
+	}
+
+	return info.Tags, info.VersionID, nil
+}
+
+// PutObjectTagging replaces the tags of a version of an object (the current
+// version if versionID is empty), returning the version's ID. Nil tags remove
+// all tags
+func (s *Storage) PutObjectTagging(bucket, key, versionID string, tags map[string]string) (string, error) {
+	path, info, err := s.resolveVersion(bucket, key, versionID)
+	if err != nil {
+		return "", err
+	}
+	if info.DeleteMarker {
+		return "", ErrNoSuchKey
+	}
+
+	metaPath := objectMetadataPath(s.baseDir, bucket, key)
+	if path != s.objectPath(bucket, key) {
+		metaPath = s.versionMetadataPath(bucket, key, info.VersionID)
+	}
+
+	meta := readMetadataFile(metaPath)
+	if meta == nil {
+		if _, err := os.Stat(metaPath); err == nil {
+			return "", fmt.Errorf("failed to read metadata for %s", key)
+		}
+		// Objects created before metadata support have no sidecar yet
+		meta = &objectMetadata{ETag: strings.Trim(info.ETag, "\"")}
+	}
+	meta.Tags = tags
+
+	if err := writeMetadataFile(metaPath, meta); err != nil {
+		return "", fmt.Errorf("failed to write metadata: %w", err)
+	}
+
+	return info.VersionID, nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestObjectTagging(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	storage.CreateBucket("test-bucket")
+
+	content := []byte("tagged")
+	_, err := storage.PutObjectWithMetadata("test-bucket", "doc.txt", bytes.NewReader(content), int64(len(content)), ObjectMetadata{
+		Tags: map[string]string{"team": "web"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	tags, _, err := storage.GetObjectTagging("test-bucket", "doc.txt", "")
+	if err != nil || tags["team"] != "web" {
+		t.Fatalf("Expected team=web, got %v, %v", tags, err)
+	}
+
+	if _, err := storage.PutObjectTagging("test-bucket", "doc.txt", "", map[string]string{"env": "prod"}); err != nil {
+		t.Fatalf("Failed to put tagging: %v", err)
+	}
+	info, err := storage.HeadObject("test-bucket", "doc.txt")
+	if err != nil || len(info.Tags) != 1 || info.Tags["env"] != "prod" {
+		t.Errorf("Expected tags replaced with env=prod, got %v, %v", info.Tags, err)
+	}
+
+	// Copies keep the source tags unless told to replace them
+	copied, err := storage.CopyObject("test-bucket", "doc.txt", "test-bucket", "copy.txt")
+	if err != nil || copied.Tags["env"] != "prod" {
+		t.Errorf("Expected copied tags, got %v, %v", copied, err)
+	}
+
+	if _, err := storage.PutObjectTagging("test-bucket", "doc.txt", "", nil); err != nil {
+		t.Fatalf("Failed to delete tagging: %v", err)
+	}
+	if tags, _, _ := storage.GetObjectTagging("test-bucket", "doc.txt", ""); len(tags) != 0 {
+		t.Errorf("Expected no tags, got %v", tags)
+	}
+
+	if _, err := storage.PutObjectTagging("test-bucket", "missing.txt", "", nil); err == nil {
+		t.Error("Expected error tagging a nonexistent object")
+	}
+
+	// Noncurrent versions keep their own tags
+	storage.PutBucketVersioning("test-bucket", VersioningEnabled)
+	v1, _ := storage.PutObjectWithMetadata("test-bucket", "doc.txt", bytes.NewReader(content), int64(len(content)), ObjectMetadata{})
+	storage.PutObject("test-bucket", "doc.txt", bytes.NewReader(content), int64(len(content)))
+	if _, err := storage.PutObjectTagging("test-bucket", "doc.txt", v1.VersionID, map[string]string{"old": "yes"}); err != nil {
+		t.Fatalf("Failed to tag noncurrent version: %v", err)
+	}
+	if tags, _, _ := storage.GetObjectTagging("test-bucket", "doc.txt", v1.VersionID); tags["old"] != "yes" {
+		t.Errorf("Expected noncurrent version tags, got %v", tags)
+	}
+	if tags, _, _ := storage.GetObjectTagging("test-bucket", "doc.txt", ""); len(tags) != 0 {
+		t.Errorf("Expected current version untagged, got %v", tags)
+	}
+}
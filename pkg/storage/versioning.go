@@ -0,0 +1,530 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Bucket versioning states. A bucket that has never had versioning
+// configured has an empty state
+const (
+	VersioningEnabled   = "Enabled"
+	VersioningSuspended = "Suspended"
+)
+
+// NullVersionID is the version ID of objects written while versioning was
+// not enabled
+const NullVersionID = "null"
+
+// versionsDirName is the directory under baseDir holding noncurrent object
+// versions and delete markers. Each key has a directory containing a data
+// file named after the version ID and a <versionID>.json metadata file;
+// delete markers have only the metadata file
+const versionsDirName = ".versions"
+
+// versioningConfig is the bucket configuration document holding the
+// versioning state
+const versioningConfig = "versioning"
+
+// GetBucketVersioning returns the versioning state of a bucket:
+// VersioningEnabled, VersioningSuspended or "" if never configured
+func (s *Storage) GetBucketVersioning(bucket string) (string, error) {
+	data, err := s.GetBucketConfig(bucket, versioningConfig)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// PutBucketVersioning enables or suspends versioning on a bucket. Once
+// enabled, versioning can only be suspended, never turned off
+func (s *Storage) PutBucketVersioning(bucket, status string) error {
+	if status != VersioningEnabled && status != VersioningSuspended {
+		return fmt.Errorf("%w %q", ErrInvalidVersioningStatus, status)
+	}
+	return s.PutBucketConfig(bucket, versioningConfig, []byte(status))
+}
+
+// versioningStatus returns the versioning state of a bucket, treating an
+// unreadable configuration as unversioned
+func (s *Storage) versioningStatus(bucket string) string {
+	status, _ := s.GetBucketVersioning(bucket)
+	return status
+}
+
+// newVersionID generates a version ID. IDs begin with the creation time so
+// they sort in creation order
+func newVersionID() string {
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	return fmt.Sprintf("%016x%s", time.Now().UnixNano(), hex.EncodeToString(suffix))
+}
+
+// orNull returns the version ID of an object, mapping objects written before
+// versioning was configured to the null version
+func orNull(versionID string) string {
+	if versionID == "" {
+		return NullVersionID
+	}
+	return versionID
+}
+
+func (s *Storage) versionsBucketDir(bucket string) string {
+	return filepath.Join(s.baseDir, versionsDirName, bucket)
+}
+
+func (s *Storage) versionsDir(bucket, key string) string {
+	return filepath.Join(s.versionsBucketDir(bucket), keyPath(key))
+}
+
+func (s *Storage) versionDataPath(bucket, key, versionID string) string {
+	return filePath(filepath.Join(s.versionsDir(bucket, key), versionID))
+}
+
+func (s *Storage) versionMetadataPath(bucket, key, versionID string) string {
+	return filePath(filepath.Join(s.versionsDir(bucket, key), versionID+".json"))
+}
+
+// validVersionID reports whether a requested version ID could name a stored
+// version, so it is safe to use as a file name
+func validVersionID(versionID string) bool {
+	return versionID != "" && !strings.ContainsAny(versionID, `/\.`)
+}
+
+// commitObject moves a fully written temporary file into place as the current
+// version of an object, preserving the previous version first if the bucket
+// is versioned. Conditions are checked against the version being replaced
+// while the key is locked
+func (s *Storage) commitObject(bucket, key, tmpPath string, meta *objectMetadata, conditions WriteConditions) (*ObjectInfo, error) {
+	unlock := s.locks.lock(bucket, key)
+	defer unlock()
+
+	if err := s.checkConditions(bucket, key, conditions); err != nil {
+		return nil, conflict(err)
+	}
+
+	objectPath := s.objectPath(bucket, key)
+
+	status := s.versioningStatus(bucket)
+	switch status {
+	case VersioningEnabled:
+		meta.VersionID = newVersionID()
+	case VersioningSuspended:
+		meta.VersionID = NullVersionID
+	}
+
+	if status != "" {
+		if err := s.archiveCurrentVersion(bucket, key, status); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := makeDirs(filepath.Dir(objectPath)); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Rename(tmpPath, objectPath); err != nil {
+		return nil, fmt.Errorf("failed to move object: %w", err)
+	}
+
+	if err := writeObjectMetadataFile(s.baseDir, bucket, key, meta); err != nil {
+		return nil, fmt.Errorf("failed to write metadata: %w", err)
+	}
+
+	stat, err := os.Stat(objectPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	return s.objectInfo(bucket, key, stat), nil
+}
+
+// archiveCurrentVersion makes room for a new current version of an object.
+// The current version is moved to the versions directory, except that while
+// versioning is suspended a null current version is left to be replaced and
+// any noncurrent null version is removed, as only one null version may exist
+func (s *Storage) archiveCurrentVersion(bucket, key, status string) error {
+	if status == VersioningSuspended {
+		s.removeVersionFiles(bucket, key, NullVersionID)
+	}
+
+	objectPath := s.objectPath(bucket, key)
+	stat, err := os.Stat(objectPath)
+	if err != nil || stat.IsDir() {
+		return nil
+	}
+
+	meta := readObjectMetadataFile(s.baseDir, bucket, key)
+	if meta == nil {
+		meta = &objectMetadata{ETag: strings.Trim(s.objectInfo(bucket, key, stat).ETag, "\"")}
+	}
+	meta.VersionID = orNull(meta.VersionID)
+	if status == VersioningSuspended && meta.VersionID == NullVersionID {
+		return nil
+	}
+	meta.LastModified = stat.ModTime()
+
+	if err := makeDirs(s.versionsDir(bucket, key)); err != nil {
+		return fmt.Errorf("failed to create versions directory: %w", err)
+	}
+	if err := writeMetadataFile(s.versionMetadataPath(bucket, key, meta.VersionID), meta); err != nil {
+		return fmt.Errorf("failed to write version metadata: %w", err)
+	}
+	if err := os.Rename(objectPath, s.versionDataPath(bucket, key, meta.VersionID)); err != nil {
+		os.Remove(s.versionMetadataPath(bucket, key, meta.VersionID))
+		return fmt.Errorf("failed to archive object version: %w", err)
+	}
+	removeObjectMetadataFile(s.baseDir, bucket, key)
+
+	return nil
+}
+
+// removeCurrentVersion deletes the current version of an object, if any
+func (s *Storage) removeCurrentVersion(bucket, key string) error {
+	objectPath := s.objectPath(bucket, key)
+
+	err := os.Remove(objectPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+
+	removeObjectMetadataFile(s.baseDir, bucket, key)
+
+	// Clean up empty parent directories
+	s.cleanupEmptyDirs(filepath.Dir(objectPath), s.bucketPath(bucket))
+	metadataBucketDir := filepath.Join(s.baseDir, metadataDirName, bucket)
+	s.cleanupEmptyDirs(filepath.Dir(objectMetadataPath(s.baseDir, bucket, key)), metadataBucketDir)
+
+	return nil
+}
+
+// removeVersionFiles deletes a noncurrent version or delete marker, if it
+// exists
+func (s *Storage) removeVersionFiles(bucket, key, versionID string) {
+	os.Remove(s.versionDataPath(bucket, key, versionID))
+	os.Remove(s.versionMetadataPath(bucket, key, versionID))
+	s.cleanupEmptyDirs(s.versionsDir(bucket, key), s.versionsBucketDir(bucket))
+}
+
+// noncurrentVersions returns the noncurrent versions and delete markers of a
+// key, newest first
+func (s *Storage) noncurrentVersions(bucket, key string) []ObjectInfo {
+	entries, err := os.ReadDir(s.versionsDir(bucket, key))
+	if err != nil {
+		return nil
+	}
+
+	var versions []ObjectInfo
+	for _, entry := range entries {
+		// A version's metadata file may have become a directory holding it,
+		// see filePath
+		versionID, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if info := s.noncurrentVersion(bucket, key, versionID); info != nil {
+			versions = append(versions, *info)
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		if !versions[i].LastModified.Equal(versions[j].LastModified) {
+			return versions[i].LastModified.After(versions[j].LastModified)
+		}
+		return versions[i].VersionID > versions[j].VersionID
+	})
+
+	return versions
+}
+
+// noncurrentVersion loads a noncurrent version or delete marker, returning
+// nil if it does not exist
+func (s *Storage) noncurrentVersion(bucket, key, versionID string) *ObjectInfo {
+	meta := readMetadataFile(s.versionMetadataPath(bucket, key, versionID))
+	if meta == nil {
+		return nil
+	}
+
+	info := &ObjectInfo{
+		Key:          key,
+		LastModified: meta.LastModified,
+		ETag:         fmt.Sprintf("\"%s\"", meta.ETag),
+		ContentType:  meta.ContentType,
+		UserMetadata: meta.UserMetadata,
+		Tags:         meta.Tags,
+		VersionID:    versionID,
+		DeleteMarker: meta.DeleteMarker,
+		Checksum:     meta.Checksum,
+		Parts:        meta.Parts,
+	}
+	if meta.DeleteMarker {
+		info.ETag = ""
+		return info
+	}
+
+	stat, err := os.Stat(s.versionDataPath(bucket, key, versionID))
+	if err != nil {
+		return nil
+	}
+	info.Size = stat.Size()
+
+	return info
+}
+
+// resolveVersion locates a version of an object, returning the path of its
+// data and its metadata. An empty versionID means the latest version. Delete
+// markers are returned with an empty path
+func (s *Storage) resolveVersion(bucket, key, versionID string) (string, *ObjectInfo, error) {
+	if err := checkObjectName(bucket, key); err != nil {
+		return "", nil, err
+	}
+
+	objectPath := s.objectPath(bucket, key)
+
+	stat, err := os.Stat(objectPath)
+	if err == nil && !stat.IsDir() {
+		info := s.objectInfo(bucket, key, stat)
+		if versionID == "" || versionID == orNull(info.VersionID) {
+			info.IsLatest = true
+			return objectPath, info, nil
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return "", nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	if versionID == "" {
+		// Without a current version the object is absent, or its latest
+		// version is a delete marker
+		if versions := s.noncurrentVersions(bucket, key); len(versions) > 0 && versions[0].DeleteMarker {
+			latest := versions[0]
+			latest.IsLatest = true
+			return "", &latest, nil
+		}
+		return "", nil, ErrNoSuchKey
+	}
+
+	if !validVersionID(versionID) {
+		return "", nil, ErrNoSuchVersion
+	}
+	info := s.noncurrentVersion(bucket, key, versionID)
+	if info == nil {
+		return "", nil, ErrNoSuchVersion
+	}
+	if info.DeleteMarker {
+		versions := s.noncurrentVersions(bucket, key)
+		info.IsLatest = err != nil && len(versions) > 0 && versions[0].VersionID == versionID
+		return "", info, nil
+	}
+
+	return s.versionDataPath(bucket, key, versionID), info, nil
+}
+
+// DeleteObjectVersion deletes an object. With an empty versionID this deletes
+// the current object: in a versioned bucket the object is kept as a
+// noncurrent version and a delete marker created. Otherwise the given version
+// is removed permanently. It returns the ID of the created or removed version
+// (empty for unversioned buckets) and whether that version is a delete marker
+func (s *Storage) DeleteObjectVersion(bucket, key, versionID string) (string, bool, error) {
+	if err := checkObjectName(bucket, key); err != nil {
+		return "", false, err
+	}
+
+	unlock := s.locks.lock(bucket, key)
+	defer unlock()
+
+	if versionID != "" {
+		return s.deleteVersion(bucket, key, versionID)
+	}
+
+	status := s.versioningStatus(bucket)
+	if status == "" {
+		return "", false, s.removeCurrentVersion(bucket, key)
+	}
+
+	if err := s.archiveCurrentVersion(bucket, key, status); err != nil {
+		return "", false, err
+	}
+	// A suspended bucket's null current version is not archived
+	if err := s.removeCurrentVersion(bucket, key); err != nil {
+		return "", false, err
+	}
+
+	marker := &objectMetadata{
+		VersionID:    NullVersionID,
+		DeleteMarker: true,
+		LastModified: time.Now(),
+	}
+	if status == VersioningEnabled {
+		marker.VersionID = newVersionID()
+	}
+
+	if err := makeDirs(s.versionsDir(bucket, key)); err != nil {
+		return "", false, fmt.Errorf("failed to create versions directory: %w", err)
+	}
+	if err := writeMetadataFile(s.versionMetadataPath(bucket, key, marker.VersionID), marker); err != nil {
+		return "", false, fmt.Errorf("failed to write delete marker: %w", err)
+	}
+
+	return marker.VersionID, true, nil
+}
+
+// deleteVersion permanently removes one version of an object. If that was the
+// current version, the newest remaining version becomes current
+func (s *Storage) deleteVersion(bucket, key, versionID string) (string, bool, error) {
+	path, info, err := s.resolveVersion(bucket, key, versionID)
+	if err != nil {
+		if errors.Is(err, ErrNoSuchKey) || errors.Is(err, ErrNoSuchVersion) {
+			// Deleting a nonexistent version succeeds, as in S3
+			return versionID, false, nil
+		}
+		return "", false, err
+	}
+
+	if path == s.objectPath(bucket, key) {
+		if err := s.removeCurrentVersion(bucket, key); err != nil {
+			return "", false, err
+		}
+	} else {
+		s.removeVersionFiles(bucket, key, versionID)
+	}
+
+	if err := s.promoteLatestVersion(bucket, key); err != nil {
+		return "", false, err
+	}
+
+	return versionID, info.DeleteMarker, nil
+}
+
+// promoteLatestVersion restores the newest noncurrent version of an object as
+// its current version when the object has no current version and that
+// version is not a delete marker
+func (s *Storage) promoteLatestVersion(bucket, key string) error {
+	objectPath := s.objectPath(bucket, key)
+	if _, err := os.Stat(objectPath); err == nil {
+		return nil
+	}
+
+	versions := s.noncurrentVersions(bucket, key)
+	if len(versions) == 0 || versions[0].DeleteMarker {
+		return nil
+	}
+	latest := versions[0]
+
+	meta := readMetadataFile(s.versionMetadataPath(bucket, key, latest.VersionID))
+	meta.LastModified = time.Time{}
+
+	if err := makeDirs(filepath.Dir(objectPath)); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Rename(s.versionDataPath(bucket, key, latest.VersionID), objectPath); err != nil {
+		return fmt.Errorf("failed to restore object version: %w", err)
+	}
+	if err := writeObjectMetadataFile(s.baseDir, bucket, key, meta); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	s.removeVersionFiles(bucket, key, latest.VersionID)
+
+	return nil
+}
+
+// VersionListing is a page of a ListObjectVersions listing
+type VersionListing struct {
+	// Versions holds object versions and delete markers in key order, newest
+	// first for each key
+	Versions            []ObjectInfo
+	CommonPrefixes      []string
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIDMarker string
+}
+
+// ListObjectVersions lists every version and delete marker of the objects in
+// a bucket. Listing resumes after keyMarker, or after the version
+// versionIDMarker of keyMarker if given. maxKeys limits the number of
+// versions and common prefixes returned (<= 0 means unlimited)
+func (s *Storage) ListObjectVersions(bucket, prefix, delimiter, keyMarker, versionIDMarker string, maxKeys int) (*VersionListing, error) {
+	if err := s.HeadBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	// Collect keys with a current version and keys with noncurrent versions
+	keySet := make(map[string]bool)
+	collect := func(root string, noncurrent bool) error {
+		return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || path == root {
+				return nil
+			}
+			if strings.HasPrefix(info.Name(), tempFilePrefix) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			relPath, err := filepath.Rel(root, storedPath(path))
+			if err != nil {
+				return nil
+			}
+			if noncurrent && !info.IsDir() {
+				// Versions are files in their key's directory
+				if !strings.HasSuffix(relPath, ".json") {
+					return nil
+				}
+				relPath = filepath.Dir(relPath)
+			}
+			key, partial := decodeKeyPath(filepath.ToSlash(relPath))
+			if info.IsDir() {
+				if !partial {
+					key += "/"
+				}
+				if prefix != "" && !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !partial && strings.HasPrefix(key, prefix) {
+				keySet[key] = true
+			}
+			return nil
+		})
+	}
+	if err := collect(s.bucketPath(bucket), false); err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	if _, err := os.Stat(s.versionsBucketDir(bucket)); err == nil {
+		if err := collect(s.versionsBucketDir(bucket), true); err != nil {
+			return nil, fmt.Errorf("failed to list object versions: %w", err)
+		}
+	}
+
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return pageVersions(keys, prefix, delimiter, keyMarker, versionIDMarker, maxKeys, func(key string) []ObjectInfo {
+		return s.keyVersions(bucket, key)
+	}), nil
+}
+
+// keyVersions returns every version of a key, newest first
+func (s *Storage) keyVersions(bucket, key string) []ObjectInfo {
+	var versions []ObjectInfo
+	if stat, err := os.Stat(s.objectPath(bucket, key)); err == nil && !stat.IsDir() {
+		current := s.objectInfo(bucket, key, stat)
+		current.VersionID = orNull(current.VersionID)
+		versions = append(versions, *current)
+	}
+	versions = append(versions, s.noncurrentVersions(bucket, key)...)
+
+	if len(versions) > 0 {
+		versions[0].IsLatest = true
+	}
+	return versions
+}
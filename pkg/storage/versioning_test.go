@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func readVersion(t *testing.T, s *Storage, bucket, key, versionID string) string {
+	t.Helper()
+	reader, _, err := s.GetObjectVersion(bucket, key, versionID)
+	if err != nil {
+		t.Fatalf("Failed to get version %q: %v", versionID, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read version %q: %v", versionID, err)
+	}
+	return string(data)
+}
+
+func putString(t *testing.T, s *Storage, bucket, key, content string) *ObjectInfo {
+	t.Helper()
+	info, err := s.PutObjectWithMetadata(bucket, key, bytes.NewReader([]byte(content)), int64(len(content)), ObjectMetadata{})
+	if err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	return info
+}
+
+func TestBucketVersioning(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	storage.CreateBucket("test-bucket")
+
+	if status, err := storage.GetBucketVersioning("test-bucket"); err != nil || status != "" {
+		t.Fatalf("Expected unversioned bucket, got %q, %v", status, err)
+	}
+	if err := storage.PutBucketVersioning("test-bucket", "Off"); err == nil {
+		t.Error("Expected error for invalid status")
+	}
+	if err := storage.PutBucketVersioning("missing", VersioningEnabled); err == nil {
+		t.Error("Expected error for nonexistent bucket")
+	}
+
+	// Objects written before versioning become the null version
+	putString(t, storage, "test-bucket", "doc.txt", "v0")
+
+	if err := storage.PutBucketVersioning("test-bucket", VersioningEnabled); err != nil {
+		t.Fatalf("Failed to enable versioning: %v", err)
+	}
+	if status, _ := storage.GetBucketVersioning("test-bucket"); status != VersioningEnabled {
+		t.Fatalf("Expected Enabled, got %q", status)
+	}
+
+	v1 := putString(t, storage, "test-bucket", "doc.txt", "v1")
+	v2 := putString(t, storage, "test-bucket", "doc.txt", "v2")
+	if v1.VersionID == "" || v1.VersionID == v2.VersionID {
+		t.Fatalf("Expected distinct version IDs, got %q and %q", v1.VersionID, v2.VersionID)
+	}
+
+	if got := readVersion(t, storage, "test-bucket", "doc.txt", ""); got != "v2" {
+		t.Errorf("Expected current version v2, got %q", got)
+	}
+	if got := readVersion(t, storage, "test-bucket", "doc.txt", v1.VersionID); got != "v1" {
+		t.Errorf("Expected v1, got %q", got)
+	}
+	if got := readVersion(t, storage, "test-bucket", "doc.txt", NullVersionID); got != "v0" {
+		t.Errorf("Expected null version v0, got %q", got)
+	}
+	if _, _, err := storage.GetObjectVersion("test-bucket", "doc.txt", "nonexistent"); err == nil {
+		t.Error("Expected error for nonexistent version")
+	}
+
+	// Deleting without a version creates a delete marker
+	markerID, isMarker, err := storage.DeleteObjectVersion("test-bucket", "doc.txt", "")
+	if err != nil || !isMarker || markerID == "" {
+		t.Fatalf("Expected delete marker, got %q, %v, %v", markerID, isMarker, err)
+	}
+	if _, err := storage.HeadObject("test-bucket", "doc.txt"); err == nil {
+		t.Error("Expected deleted object to be absent")
+	}
+	info, err := storage.HeadObjectVersion("test-bucket", "doc.txt", "")
+	if err != nil || !info.DeleteMarker || info.VersionID != markerID {
+		t.Errorf("Expected latest version to be the delete marker, got %+v, %v", info, err)
+	}
+	if got := readVersion(t, storage, "test-bucket", "doc.txt", v2.VersionID); got != "v2" {
+		t.Errorf("Expected v2 to survive the delete, got %q", got)
+	}
+
+	listing, err := storage.ListObjectVersions("test-bucket", "", "", "", "", 0)
+	if err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	var ids []string
+	for _, v := range listing.Versions {
+		ids = append(ids, v.VersionID)
+	}
+	expected := []string{markerID, v2.VersionID, v1.VersionID, NullVersionID}
+	if len(ids) != len(expected) {
+		t.Fatalf("Expected versions %v, got %v", expected, ids)
+	}
+	for i := range expected {
+		if ids[i] != expected[i] {
+			t.Fatalf("Expected versions %v, got %v", expected, ids)
+		}
+	}
+	if !listing.Versions[0].IsLatest || !listing.Versions[0].DeleteMarker || listing.Versions[1].IsLatest {
+		t.Errorf("Expected only the delete marker to be latest: %+v", listing.Versions)
+	}
+
+	// A bucket holding only noncurrent versions is not empty
+	if err := storage.DeleteBucket("test-bucket"); err == nil {
+		t.Error("Expected DeleteBucket to fail while versions exist")
+	}
+
+	// Removing the delete marker restores the newest version
+	if _, isMarker, err := storage.DeleteObjectVersion("test-bucket", "doc.txt", markerID); err != nil || !isMarker {
+		t.Fatalf("Failed to remove delete marker: %v", err)
+	}
+	if got := readVersion(t, storage, "test-bucket", "doc.txt", ""); got != "v2" {
+		t.Errorf("Expected v2 restored, got %q", got)
+	}
+
+	// Removing the current version promotes the previous one
+	if _, _, err := storage.DeleteObjectVersion("test-bucket", "doc.txt", v2.VersionID); err != nil {
+		t.Fatalf("Failed to delete version: %v", err)
+	}
+	if got := readVersion(t, storage, "test-bucket", "doc.txt", ""); got != "v1" {
+		t.Errorf("Expected v1 promoted, got %q", got)
+	}
+}
+
+func TestSuspendedVersioning(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	storage.CreateBucket("test-bucket")
+	storage.PutBucketVersioning("test-bucket", VersioningEnabled)
+	v1 := putString(t, storage, "test-bucket", "doc.txt", "v1")
+
+	storage.PutBucketVersioning("test-bucket", VersioningSuspended)
+
+	// While suspended, writes replace the single null version and keep
+	// earlier versions
+	if info := putString(t, storage, "test-bucket", "doc.txt", "n1"); info.VersionID != NullVersionID {
+		t.Errorf("Expected null version ID, got %q", info.VersionID)
+	}
+	putString(t, storage, "test-bucket", "doc.txt", "n2")
+
+	listing, err := storage.ListObjectVersions("test-bucket", "", "", "", "", 0)
+	if err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	if len(listing.Versions) != 2 || listing.Versions[0].VersionID != NullVersionID || listing.Versions[1].VersionID != v1.VersionID {
+		t.Fatalf("Expected null and v1 versions, got %+v", listing.Versions)
+	}
+	if got := readVersion(t, storage, "test-bucket", "doc.txt", ""); got != "n2" {
+		t.Errorf("Expected n2, got %q", got)
+	}
+
+	// A delete replaces the null version with a null delete marker
+	markerID, isMarker, err := storage.DeleteObjectVersion("test-bucket", "doc.txt", "")
+	if err != nil || !isMarker || markerID != NullVersionID {
+		t.Fatalf("Expected null delete marker, got %q, %v, %v", markerID, isMarker, err)
+	}
+	listing, _ = storage.ListObjectVersions("test-bucket", "", "", "", "", 0)
+	if len(listing.Versions) != 2 || !listing.Versions[0].DeleteMarker || listing.Versions[1].VersionID != v1.VersionID {
+		t.Fatalf("Expected null delete marker and v1, got %+v", listing.Versions)
+	}
+}
+
+func TestListObjectVersionsPagination(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	storage.CreateBucket("test-bucket")
+	storage.PutBucketVersioning("test-bucket", VersioningEnabled)
+	for _, key := range []string{"a.txt", "b.txt", "dir/c.txt", "dir/d.txt"} {
+		putString(t, storage, "test-bucket", key, "1")
+		putString(t, storage, "test-bucket", key, "2")
+	}
+
+	// Page through two entries at a time, rolling dir/ up into a prefix
+	var keys []string
+	var prefixes []string
+	keyMarker, versionIDMarker := "", ""
+	for page := 0; page < 10; page++ {
+		listing, err := storage.ListObjectVersions("test-bucket", "", "/", keyMarker, versionIDMarker, 2)
+		if err != nil {
+			t.Fatalf("Failed to list versions: %v", err)
+		}
+		for _, v := range listing.Versions {
+			keys = append(keys, v.Key)
+		}
+		prefixes = append(prefixes, listing.CommonPrefixes...)
+		if !listing.IsTruncated {
+			break
+		}
+		keyMarker, versionIDMarker = listing.NextKeyMarker, listing.NextVersionIDMarker
+	}
+
+	expected := []string{"a.txt", "a.txt", "b.txt", "b.txt"}
+	if len(keys) != len(expected) {
+		t.Fatalf("Expected keys %v, got %v", expected, keys)
+	}
+	for i := range expected {
+		if keys[i] != expected[i] {
+			t.Fatalf("Expected keys %v, got %v", expected, keys)
+		}
+	}
+	if len(prefixes) != 1 || prefixes[0] != "dir/" {
+		t.Errorf("Expected common prefix dir/, got %v", prefixes)
+	}
+}
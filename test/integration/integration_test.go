@@ -5,46 +5,17 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/http/httptest"
-	"os"
 	"testing"
 
-	"github.com/stut/s3dir/pkg/auth"
-	"github.com/stut/s3dir/pkg/s3"
-	"github.com/stut/s3dir/pkg/storage"
+	"github.com/stut/s3dir/pkg/s3dirtest"
 )
 
-func setupIntegrationTest(t *testing.T) (*httptest.Server, func()) {
-	tmpDir, err := os.MkdirTemp("", "s3dir-integration-*")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
-	}
-
-	store, err := storage.New(tmpDir)
-	if err != nil {
-		os.RemoveAll(tmpDir)
-		t.Fatalf("Failed to create storage: %v", err)
-	}
-
-	handler := s3.NewHandler(store, false, false)
-	authenticator := auth.New("", "", false)
-
-	var httpHandler http.Handler = handler
-	httpHandler = authenticator.Middleware(httpHandler)
-
-	server := httptest.NewServer(httpHandler)
-
-	cleanup := func() {
-		server.Close()
-		os.RemoveAll(tmpDir)
-	}
-
-	return server, cleanup
+func setupIntegrationTest(t *testing.T) *s3dirtest.Server {
+	return s3dirtest.New(t, s3dirtest.WithoutAuth())
 }
 
 func TestFullWorkflow(t *testing.T) {
-	server, cleanup := setupIntegrationTest(t)
-	defer cleanup()
+	server := setupIntegrationTest(t)
 
 	client := &http.Client{}
 
@@ -190,8 +161,7 @@ func TestFullWorkflow(t *testing.T) {
 }
 
 func TestMultipleObjects(t *testing.T) {
-	server, cleanup := setupIntegrationTest(t)
-	defer cleanup()
+	server := setupIntegrationTest(t)
 
 	client := &http.Client{}
 
@@ -266,8 +236,7 @@ func TestMultipleObjects(t *testing.T) {
 }
 
 func TestErrorCases(t *testing.T) {
-	server, cleanup := setupIntegrationTest(t)
-	defer cleanup()
+	server := setupIntegrationTest(t)
 
 	client := &http.Client{}
 
@@ -337,8 +306,7 @@ func TestErrorCases(t *testing.T) {
 }
 
 func TestLargeObject(t *testing.T) {
-	server, cleanup := setupIntegrationTest(t)
-	defer cleanup()
+	server := setupIntegrationTest(t)
 
 	client := &http.Client{}
 
@@ -387,8 +355,7 @@ func TestLargeObject(t *testing.T) {
 }
 
 func TestSpecialCharactersInKeys(t *testing.T) {
-	server, cleanup := setupIntegrationTest(t)
-	defer cleanup()
+	server := setupIntegrationTest(t)
 
 	client := &http.Client{}
 